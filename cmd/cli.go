@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/adapters/repository"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/a-berahman/shopping-cart/migrations"
+)
+
+const usage = `usage: shopping-cart [-config-path path] <command> [args]
+
+commands:
+  serve                   run what the configured mode needs, api, worker or all (default)
+  worker                  run only the reservation worker and its health endpoints
+  migrate up              apply every pending migration
+  migrate down [steps]    revert the last steps migrations, 1 by default
+  migrate status          print the schema version and the pending migrations
+  queue stats             print the number of jobs per status
+  queue retry-failed [-job-type type] [-item-id id] [-error-class class] [-failed-after time] [-failed-before time]
+                          move the failed jobs that match every given filter back to the pending queue,
+                          times are RFC 3339
+  queue purge <status>    discard every job with the status, e.g. completed
+  config check            validate the configuration and print it with secrets redacted
+`
+
+// errUsage is returned for commands that don't exist or have wrong arguments
+var errUsage = errors.New("invalid usage")
+
+func usageError(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errUsage, fmt.Sprintf(format, args...))
+}
+
+// run dispatches the command in args, commands print their results to out
+func run(ctx context.Context, logger *slog.Logger, cfg *config.Config, args []string, out io.Writer) error {
+	// no command serves, so existing deployments keep working
+	if len(args) == 0 {
+		args = []string{"serve"}
+	}
+
+	switch args[0] {
+	case "serve":
+		return serve(ctx, logger, cfg)
+	case "worker":
+		// a worker instance is the worker mode, whatever the config file says. the rules of the mode
+		// apply too, a memory queue can't be shared with an api instance
+		cfg.Mode = config.ModeWorker
+		if err := cfg.Validate(); err != nil {
+			return err
+		}
+		return serve(ctx, logger, cfg)
+	case "migrate":
+		return runMigrate(ctx, cfg, args[1:], out)
+	case "queue":
+		return runQueue(ctx, cfg, args[1:], out)
+	case "config":
+		if len(args) != 2 || args[1] != "check" {
+			return usageError("config needs one of: check")
+		}
+		// config.Load already validated it
+		printConfig(out, "", reflect.ValueOf(redactConfig(*cfg)))
+		fmt.Fprintln(out, "configuration is valid")
+		return nil
+	default:
+		return usageError("unknown command %q", args[0])
+	}
+}
+
+func runMigrate(ctx context.Context, cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return usageError("migrate needs one of: up, down, status")
+	}
+
+	// arguments are checked before connecting, a typo shouldn't wait for the database
+	steps := 1
+	switch args[0] {
+	case "up", "status":
+		if len(args) != 1 {
+			return usageError("migrate %s takes no arguments", args[0])
+		}
+	case "down":
+		if len(args) > 2 {
+			return usageError("migrate down takes at most one argument")
+		}
+		if len(args) == 2 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return usageError("steps must be a positive number, got %q", args[1])
+			}
+			steps = n
+		}
+	default:
+		return usageError("unknown migrate command %q", args[0])
+	}
+
+	db, err := repository.NewPostgresDB(cfg.DatabaseURL)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+	defer db.Close()
+
+	migrator, err := repository.NewMigrator(db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		printMigrations(out, "applied", applied)
+		return err
+	case "down":
+		reverted, err := migrator.Down(ctx, steps)
+		printMigrations(out, "reverted", reverted)
+		return err
+	default:
+		status, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "version: %d\n", status.Version)
+		fmt.Fprintf(out, "dirty: %t\n", status.Dirty)
+		printMigrations(out, "pending", status.Pending)
+		return nil
+	}
+}
+
+func printMigrations(out io.Writer, action string, migrations []repository.Migration) {
+	if len(migrations) == 0 {
+		fmt.Fprintf(out, "%s: none\n", action)
+		return
+	}
+	for _, m := range migrations {
+		fmt.Fprintf(out, "%s: %d_%s\n", action, m.Version, m.Name)
+	}
+}
+
+func runQueue(ctx context.Context, cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return usageError("queue needs one of: stats, retry-failed, purge")
+	}
+
+	var status domain.JobStatus
+	var filter domain.DeadLetterFilter
+	switch args[0] {
+	case "stats":
+		if len(args) != 1 {
+			return usageError("queue %s takes no arguments", args[0])
+		}
+	case "retry-failed":
+		var err error
+		if filter, err = parseDeadLetterFilter(args[1:]); err != nil {
+			return err
+		}
+	case "purge":
+		if len(args) != 2 {
+			return usageError("queue purge needs a job status")
+		}
+		status = domain.JobStatus(strings.ToUpper(args[1]))
+		if !isJobStatus(status) {
+			return usageError("unknown job status %q", args[1])
+		}
+	default:
+		return usageError("unknown queue command %q", args[0])
+	}
+
+	// the memory queue lives in the process that serves it, there's nothing to reach from here
+	if cfg.Queue.Backend == config.QueueBackendMemory {
+		return fmt.Errorf("queue commands can't reach the memory queue of a running process")
+	}
+
+	var db *sql.DB
+	if cfg.Queue.Backend == config.QueueBackendPostgres {
+		var err error
+		if db, err = repository.NewPostgresDB(cfg.DatabaseURL); err != nil {
+			return fmt.Errorf("failed to initialize database: %w", err)
+		}
+		defer db.Close()
+	}
+	jobs, redisClient, err := newQueue(cfg, db)
+	if err != nil {
+		return fmt.Errorf("failed to initialize %s queue: %w", cfg.Queue.Backend, err)
+	}
+	if redisClient != nil {
+		defer redisClient.Close()
+	}
+
+	switch args[0] {
+	case "stats":
+		depths, err := jobs.QueueDepths(ctx)
+		if err != nil {
+			return err
+		}
+		for _, status := range domain.JobStatuses {
+			fmt.Fprintf(out, "%s: %d\n", strings.ToLower(string(status)), depths[status])
+		}
+		return nil
+	case "retry-failed":
+		retried, err := jobs.RetryFailedJobs(ctx, filter)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "moved %d failed jobs back to the pending queue\n", retried)
+		return nil
+	default:
+		purged, err := jobs.PurgeJobs(ctx, status)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "purged %d %s jobs\n", purged, strings.ToLower(string(status)))
+		return nil
+	}
+}
+
+// parseDeadLetterFilter parses the flags of queue retry-failed
+func parseDeadLetterFilter(args []string) (domain.DeadLetterFilter, error) {
+	var filter domain.DeadLetterFilter
+	var jobType, errorClass, failedAfter, failedBefore string
+	flags := flag.NewFlagSet("queue retry-failed", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&jobType, "job-type", "", "")
+	flags.Int64Var(&filter.ItemID, "item-id", 0, "")
+	flags.StringVar(&errorClass, "error-class", "", "")
+	flags.StringVar(&failedAfter, "failed-after", "", "")
+	flags.StringVar(&failedBefore, "failed-before", "", "")
+	if err := flags.Parse(args); err != nil {
+		return filter, usageError("%v", err)
+	}
+	if flags.NArg() != 0 {
+		return filter, usageError("queue retry-failed takes no arguments besides its flags")
+	}
+
+	filter.JobType = domain.JobType(strings.ToUpper(jobType))
+	if filter.JobType != "" && filter.JobType != domain.JobTypeAvailabilityCheck && filter.JobType != domain.JobTypeReservation {
+		return filter, usageError("unknown job type %q", jobType)
+	}
+	filter.ErrorClass = domain.JobErrorClass(strings.ToUpper(errorClass))
+
+	var err error
+	if filter.FailedAfter, err = parseFilterTime(failedAfter); err != nil {
+		return filter, err
+	}
+	if filter.FailedBefore, err = parseFilterTime(failedBefore); err != nil {
+		return filter, err
+	}
+	return filter, nil
+}
+
+// parseFilterTime parses an RFC 3339 time, an empty value is the zero time
+func parseFilterTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, usageError("invalid time %q, use RFC 3339 like 2024-01-02T15:04:05Z", value)
+	}
+	return t, nil
+}
+
+func isJobStatus(status domain.JobStatus) bool {
+	for _, s := range domain.JobStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// redactConfig hides secrets, the output of config check ends up in terminals and CI logs
+func redactConfig(cfg config.Config) config.Config {
+	cfg.DatabaseURL = redactURL(cfg.DatabaseURL)
+	cfg.RedisURL = redactURL(cfg.RedisURL)
+	if cfg.Auth.HS256Secret != "" {
+		cfg.Auth.HS256Secret = "REDACTED"
+	}
+	apiKeys := make([]config.APIKeyConfig, len(cfg.RateLimit.APIKeys))
+	for i, apiKey := range cfg.RateLimit.APIKeys {
+		apiKeys[i] = config.APIKeyConfig{Client: apiKey.Client, Key: "REDACTED"}
+	}
+	cfg.RateLimit.APIKeys = apiKeys
+	return cfg
+}
+
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "REDACTED"
+	}
+	return u.Redacted()
+}
+
+// printConfig prints one line per setting, named by the mapstructure keys used in the config file
+func printConfig(out io.Writer, prefix string, v reflect.Value) {
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		key := prefix + field.Tag.Get("mapstructure")
+		if field.Type.Kind() == reflect.Struct {
+			printConfig(out, key+".", v.Field(i))
+			continue
+		}
+		fmt.Fprintf(out, "%s = %v\n", key, v.Field(i).Interface())
+	}
+}
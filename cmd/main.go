@@ -2,9 +2,12 @@ package main
 
 import (
 	"context"
+	"database/sql"
+	"errors"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"sync"
@@ -14,13 +17,21 @@ import (
 	"github.com/go-playground/validator"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
+	"github.com/redis/go-redis/v9"
+	"google.golang.org/grpc"
 
 	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/adapters/auth"
 	"github.com/a-berahman/shopping-cart/internal/adapters/handler"
+	"github.com/a-berahman/shopping-cart/internal/adapters/handler/openapi"
+	"github.com/a-berahman/shopping-cart/internal/adapters/health"
 	"github.com/a-berahman/shopping-cart/internal/adapters/queue"
+	"github.com/a-berahman/shopping-cart/internal/adapters/ratelimit"
 	"github.com/a-berahman/shopping-cart/internal/adapters/repository"
 	"github.com/a-berahman/shopping-cart/internal/adapters/reservation"
 	"github.com/a-berahman/shopping-cart/internal/adapters/reservation/mock"
+	"github.com/a-berahman/shopping-cart/internal/adapters/rpc"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
 	"github.com/a-berahman/shopping-cart/internal/core/ports"
 
 	"github.com/a-berahman/shopping-cart/internal/service"
@@ -30,8 +41,9 @@ import (
 func main() {
 	logger := slog.Default()
 
-	// Load configuration
+	// Load configuration, every command shares it
 	configPath := flag.String("config-path", "config.yaml", "path to config file")
+	flag.Usage = func() { fmt.Fprint(flag.CommandLine.Output(), usage) }
 	flag.Parse()
 	cfg, err := config.Load(*configPath)
 	if err != nil {
@@ -42,70 +54,287 @@ func main() {
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
 	defer stop()
 
+	if err := run(ctx, logger, cfg, flag.Args(), os.Stdout); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(os.Stderr, "%v\n\n%s", err, usage)
+			os.Exit(2)
+		}
+		logger.Error("command failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// serve runs what the mode of cfg needs until ctx is cancelled
+func serve(ctx context.Context, logger *slog.Logger, cfg *config.Config) error {
 	app, err := initializeApplication(ctx, logger, cfg)
 	if err != nil {
-		logger.Error("failed to initialize application", "error", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to initialize application: %w", err)
 	}
 
 	wg := &sync.WaitGroup{}
 	app.startServer(wg, cfg.Server.Host, cfg.Server.Port)
+	if err := app.startGRPCServer(wg, cfg.GRPC.Host, cfg.GRPC.Port); err != nil {
+		return fmt.Errorf("failed to start grpc server: %w", err)
+	}
 
 	<-ctx.Done()
-	stop()
 	logger.Info("shutting down application")
 
 	if err := app.shutdown(ctx, wg); err != nil {
-		logger.Error("failed to shut down gracefully", "error", err)
-		os.Exit(1)
+		return fmt.Errorf("failed to shut down gracefully: %w", err)
 	}
+	return nil
 }
 
-type application struct {
-	server *echo.Echo
-	worker *worker.ReservationWorker
+// dependencies are the adapters both the API and the worker need
+type dependencies struct {
+	db              *sql.DB
+	queue           jobQueue
+	redis           *redis.Client
+	repo            ports.Repository
+	outbox          ports.Outbox // nil for the postgres queue
+	reservationSvc  ports.ReservationService
+	reservationPing func(context.Context) error
+	// reservationState is the state of the circuit breaker around the reservation service, nil without one
+	reservationState func() string
+}
+
+// jobQueue is what the API, the worker and the admin endpoints need of a queue, every backend is both
+type jobQueue interface {
+	ports.Queue
+	ports.QueueAdmin
 }
 
-func initializeApplication(ctx context.Context, logger *slog.Logger, cfg *config.Config) (*application, error) {
+// newQueue creates the queue of the configured backend and returns the redis client it uses,
+// other adapters share its connection pool. the postgres queue uses db and the memory queue
+// neither, both come without a redis client
+func newQueue(cfg *config.Config, db *sql.DB) (jobQueue, *redis.Client, error) {
+	switch cfg.Queue.Backend {
+	case config.QueueBackendMemory:
+		return queue.NewMemoryQueue(cfg.Queue), nil, nil
+	case config.QueueBackendPostgres:
+		return queue.NewPostgresQueue(db, cfg.Queue), nil, nil
+	case config.QueueBackendStreams:
+		streamQueue, err := queue.NewStreamQueue(cfg.RedisURL, cfg.Queue)
+		if err != nil {
+			return nil, nil, err
+		}
+		return streamQueue, streamQueue.Client(), nil
+	default:
+		redisQueue, err := queue.NewRedisQueue(cfg.RedisURL, cfg.Queue)
+		if err != nil {
+			return nil, nil, err
+		}
+		return redisQueue, redisQueue.Client(), nil
+	}
+}
+
+func newDependencies(ctx context.Context, logger *slog.Logger, cfg *config.Config) (*dependencies, error) {
 	// Setup database
 	db, err := repository.NewPostgresDB(cfg.DatabaseURL)
 	if err != nil {
 		return nil, fmt.Errorf("failed to initialize database: %w", err)
 	}
 
-	// Setup Redis queue
-	redisQueue, err := queue.NewRedisQueue(cfg.RedisURL)
+	// Setup queue
+	jobs, redisClient, err := newQueue(cfg, db)
 	if err != nil {
-		return nil, fmt.Errorf("failed to initialize Redis queue: %w", err)
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize %s queue: %w", cfg.Queue.Backend, err)
+	}
+
+	// jobs of the old key layout of the list queue are moved once, before anything reads the queue
+	if redisQueue, ok := jobs.(*queue.RedisQueue); ok {
+		migrated, err := redisQueue.MigrateLegacyJobs(ctx)
+		if err != nil {
+			redisClient.Close()
+			db.Close()
+			return nil, fmt.Errorf("failed to migrate queued jobs: %w", err)
+		}
+		if migrated > 0 {
+			logger.Info("migrated queued jobs to the new key layout", "jobs", migrated)
+		}
 	}
 
-	// Setup repository
-	repo := repository.NewRepository(db)
+	deps := &dependencies{
+		db:    db,
+		queue: jobs,
+		redis: redisClient,
+		repo:  repository.NewRepository(db),
+	}
+
+	// jobs kept next to the items are created in the transaction of their items,
+	// other queues get them through the outbox written in that transaction
+	if postgresQueue, ok := jobs.(*queue.PostgresQueue); ok {
+		deps.repo = repository.NewJobRepository(repository.NewRepository(db), postgresQueue)
+	} else {
+		outbox := repository.NewOutbox(db)
+		deps.repo, deps.outbox = repository.NewJobRepository(repository.NewRepository(db), outbox), outbox
+	}
+
+	// without a redis queue only the rate limiter needs redis
+	if deps.redis == nil && cfg.RunsAPI() && cfg.RateLimit.Enabled {
+		opt, err := redis.ParseURL(cfg.RedisURL)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialize redis: %w", err)
+		}
+		deps.redis = redis.NewClient(opt)
+	}
 
 	// Setup reservation service
-	var reservationSvc ports.ReservationService
 	if cfg.Env == "production" {
-		reservationSvc = reservation.NewService(cfg.Reservation.ServiceURL)
+		externalSvc := reservation.NewService(cfg.Reservation.ServiceURL)
+		deps.reservationSvc, deps.reservationPing = externalSvc, externalSvc.Ping
 	} else {
 		mockConfig := mock.MockConfig{
 			LatencyRange: 2 * time.Second,
 			FailureRate:  0.1,
 		}
-		reservationSvc = mock.NewMockReservationService(mockConfig)
+		mockSvc := mock.NewMockReservationService(mockConfig)
+		deps.reservationSvc, deps.reservationPing = mockSvc, mockSvc.Ping
 	}
 
-	// Setup service and worker
-	cartService := service.NewCartService(repo, redisQueue, reservationSvc)
-	worker := worker.NewReservationWorker(redisQueue, reservationSvc, repo)
+	// the breaker stops the worker from calling a reservation service that keeps failing
+	if cfg.Reservation.CircuitBreaker.Enabled {
+		breaker := reservation.NewCircuitBreaker(deps.reservationSvc, cfg.Reservation.CircuitBreaker, logger)
+		deps.reservationSvc = breaker
+		deps.reservationState = func() string { return string(breaker.State()) }
+	}
+	return deps, nil
+}
 
-	// Start worker
-	go worker.Start(ctx)
+// close closes the connections of the dependencies
+func (d *dependencies) close() {
+	if d.redis != nil {
+		d.redis.Close()
+	}
+	d.db.Close()
+}
 
-	// Setup server
-	server := setupEcho(logger)
-	handler.NewHandler(cartService).Register(server)
+type application struct {
+	server     *echo.Echo
+	grpcServer *grpc.Server              // nil in worker mode or with gRPC disabled
+	worker     *worker.ReservationWorker // nil in api mode
+	reaper     *worker.Reaper            // nil in api mode
+	promoter   *worker.Promoter          // nil in api mode
+	relay      *worker.Relay             // nil in api mode or without an outbox
+	health     *health.Checker
 
-	return &application{server: server, worker: worker}, nil
+	// shutdownDelay is how long readiness fails before the servers stop
+	shutdownDelay time.Duration
+}
+
+func initializeApplication(ctx context.Context, logger *slog.Logger, cfg *config.Config) (_ *application, err error) {
+	deps, err := newDependencies(ctx, logger, cfg)
+	if err != nil {
+		return nil, err
+	}
+	// an application that can't be set up leaves no connections open
+	defer func() {
+		if err != nil {
+			deps.close()
+		}
+	}()
+	jobs := deps.queue
+
+	// Setup health checks, readiness fails while a dependency of the mode can't be reached.
+	// only the worker calls the reservation service, an API instance shouldn't go unready with it
+	checks := []health.Check{
+		{Name: "postgres", Check: deps.db.PingContext},
+	}
+	if deps.redis != nil {
+		checks = append(checks, health.Check{Name: "redis", Check: func(ctx context.Context) error {
+			return deps.redis.Ping(ctx).Err()
+		}})
+	}
+	if cfg.RunsWorker() {
+		checks = append(checks, health.Check{Name: "reservation", Check: deps.reservationPing, State: deps.reservationState})
+	}
+	checker := health.NewChecker(cfg.Health.CheckTimeout, cfg.Health.CacheTTL, checks...)
+
+	// Setup auth, with auth disabled every request acts as the anonymous user
+	authenticator, err := auth.New(cfg.Auth)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize auth: %w", err)
+	}
+	if !authenticator.Enabled() {
+		logger.Warn("auth is disabled, every request is served as the anonymous admin")
+	}
+
+	// Setup server, in worker mode it only serves the health endpoints
+	server := setupEcho(logger, cfg.Server)
+	server.Use(auth.Middleware(authenticator, openapi.SpecPath, openapi.DocsPath, health.LivenessPath, health.ReadinessPath))
+
+	app := &application{
+		server: server,
+		health: checker,
+	}
+
+	if cfg.RunsAPI() {
+		// responses are only validated against the spec in test mode, it buffers every response
+		specValidator, err := openapi.NewValidator(logger, cfg.Env == "test")
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize openapi validator: %w", err)
+		}
+
+		if cfg.RateLimit.Enabled {
+			// the limiter shares the redis client of a redis queue, counters are shared by every instance
+			limiter := ratelimit.NewLimiter(deps.redis)
+			server.Use(ratelimit.Middleware(limiter, cfg.RateLimit, logger, health.LivenessPath, health.ReadinessPath))
+		}
+		server.Use(specValidator.Middleware())
+
+		cartService := service.NewCartService(deps.repo, jobs, deps.reservationSvc)
+		handler.NewHandler(cartService).Register(server)
+		handler.NewAdminHandler(jobs, deps.outbox).Register(server, auth.RequireRole(domain.RoleAdmin))
+
+		// Setup grpc server, it shares the cart service with the HTTP API
+		if cfg.GRPC.Port > 0 {
+			app.grpcServer = grpc.NewServer(
+				grpc.ChainUnaryInterceptor(auth.UnaryServerInterceptor(authenticator)),
+				grpc.ChainStreamInterceptor(auth.StreamServerInterceptor(authenticator)),
+			)
+			rpc.NewServer(cartService, cfg.GRPC.WatchInterval).Register(app.grpcServer)
+		}
+
+		// only instances taking traffic need to wait for the orchestrator on shutdown
+		app.shutdownDelay = cfg.Health.ShutdownDelay
+	}
+	health.NewHandler(checker).Register(server, auth.RequireRole(domain.RoleAdmin))
+
+	if cfg.RunsWorker() {
+		app.worker = worker.NewReservationWorker(jobs, deps.reservationSvc, deps.repo, worker.Config{
+			MaxAttempts:       cfg.Reservation.RetryAttempts,
+			Backoff:           worker.NewExponentialBackoff(cfg.Reservation.RetryDelay, cfg.Reservation.RetryMaxDelay),
+			HeartbeatInterval: cfg.Queue.HeartbeatInterval,
+			Concurrency:       cfg.Worker.Concurrency,
+			DrainTimeout:      cfg.Worker.DrainTimeout,
+			BatchSize:         cfg.Worker.BatchSize,
+			BatchWait:         cfg.Worker.BatchWait,
+		})
+		// the worker drains on shutdown, the signal mustn't cut off the jobs in progress
+		app.worker.Start(context.WithoutCancel(ctx))
+
+		// the reaper requeues jobs of workers that died mid-job, the promoter requeues delayed retries
+		app.reaper = worker.NewReaper(jobs, cfg.Queue.ReaperInterval)
+		app.reaper.Start(ctx)
+		app.promoter = worker.NewPromoter(jobs, cfg.Queue.PromoteInterval)
+		app.promoter.Start(ctx)
+
+		// the relay publishes the jobs the API wrote to the outbox
+		if deps.outbox != nil {
+			app.relay = worker.NewRelay(deps.outbox, jobs, worker.RelayConfig{
+				Interval:  cfg.Queue.OutboxInterval,
+				BatchSize: cfg.Queue.OutboxBatchSize,
+				Retention: cfg.Queue.OutboxRetention,
+			})
+			app.relay.Start(ctx)
+		}
+	}
+
+	logger.Info("application initialized", "mode", cfg.Mode)
+	return app, nil
 }
 
 func (app *application) startServer(wg *sync.WaitGroup, host string, port int) {
@@ -118,29 +347,105 @@ func (app *application) startServer(wg *sync.WaitGroup, host string, port int) {
 	}()
 }
 
+func (app *application) startGRPCServer(wg *sync.WaitGroup, host string, port int) error {
+	if app.grpcServer == nil {
+		return nil
+	}
+
+	listener, err := net.Listen("tcp", fmt.Sprintf("%s:%d", host, port))
+	if err != nil {
+		return fmt.Errorf("failed to listen on grpc port: %w", err)
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if err := app.grpcServer.Serve(listener); err != nil {
+			slog.Default().Error("grpc server failed", "error", err)
+		}
+	}()
+	return nil
+}
+
 func (app *application) shutdown(ctx context.Context, wg *sync.WaitGroup) error {
-	shutdownCtx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	// the worker takes no traffic, it stops dequeuing and drains while the servers wind down
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		if app.worker != nil {
+			app.worker.Stop()
+		}
+	}()
+
+	// readiness fails first, so the orchestrator stops sending traffic before we stop serving it
+	app.health.ShutDown()
+	time.Sleep(app.shutdownDelay)
+
+	// ctx is already cancelled by the signal, the deadline must not inherit that
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
 	defer cancel()
 
 	if err := app.server.Shutdown(shutdownCtx); err != nil {
 		return fmt.Errorf("server shutdown failed: %w", err)
 	}
 
-	app.worker.Stop()
+	if app.grpcServer != nil {
+		// GracefulStop waits for open WatchItem streams, so we cut them off at the deadline
+		stopped := make(chan struct{})
+		go func() {
+			app.grpcServer.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-shutdownCtx.Done():
+			app.grpcServer.Stop()
+		}
+	}
+
+	<-drained
+	// the periodic tasks finish the run in progress, the connections they use close once we return
+	if app.worker != nil {
+		app.reaper.Stop()
+		app.promoter.Stop()
+		if app.relay != nil {
+			app.relay.Stop()
+		}
+	}
 	wg.Wait()
 	return nil
 }
 
-func setupEcho(logger *slog.Logger) *echo.Echo {
+func setupEcho(logger *slog.Logger, cfg config.ServerConfig) *echo.Echo {
 	e := echo.New()
+	e.IPExtractor = ipExtractor(cfg.TrustedProxies)
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
 	e.Use(middleware.CORS())
 
-	e.Validator = &CustomValidator{Validator: validator.New()}
+	// validation errors name fields the way clients send them
+	v := validator.New()
+	v.RegisterTagNameFunc(handler.JSONTagName)
+	e.Validator = &CustomValidator{Validator: v}
+	e.HTTPErrorHandler = handler.NewErrorHandler(logger)
 	return e
 }
 
+// ipExtractor only believes X-Forwarded-For when it was set by one of the trusted proxies,
+// otherwise any client could pick the IP the rate limiter sees. config validation parsed the CIDRs
+func ipExtractor(trustedProxies []string) echo.IPExtractor {
+	if len(trustedProxies) == 0 {
+		return echo.ExtractIPDirect()
+	}
+	options := []echo.TrustOption{echo.TrustLoopback(false), echo.TrustLinkLocal(false), echo.TrustPrivateNet(false)}
+	for _, proxy := range trustedProxies {
+		if _, ipRange, err := net.ParseCIDR(proxy); err == nil {
+			options = append(options, echo.TrustIPRange(ipRange))
+		}
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
 type CustomValidator struct {
 	Validator *validator.Validate
 }
@@ -3,10 +3,13 @@ package main
 import (
 	"context"
 	"log/slog"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
 	"github.com/a-berahman/shopping-cart/config"
 	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -107,3 +110,26 @@ func TestCustomValidator(t *testing.T) {
 		})
 	}
 }
+
+func TestIPExtractor(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		remoteAddr     string
+		want           string
+	}{
+		{name: "no trusted proxies ignores the header", remoteAddr: "10.0.0.5:1234", want: "10.0.0.5"},
+		{name: "trusted proxy forwards the client", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "10.0.0.5:1234", want: "203.0.113.7"},
+		{name: "untrusted peer can't forward", trustedProxies: []string{"10.0.0.0/8"}, remoteAddr: "192.0.2.9:1234", want: "192.0.2.9"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = tt.remoteAddr
+			req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.7")
+
+			assert.Equal(t, tt.want, ipExtractor(tt.trustedProxies)(req))
+		})
+	}
+}
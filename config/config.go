@@ -7,28 +7,165 @@ import (
 	"github.com/spf13/viper"
 )
 
+// Run modes, api serves HTTP and gRPC, worker processes reservation jobs, all does both
+const (
+	ModeAPI    = "api"
+	ModeWorker = "worker"
+	ModeAll    = "all"
+)
+
+// Queue backends, list keeps job IDs in redis lists, streams reads a redis stream in a consumer group,
+// postgres keeps jobs in a table of the items database and memory keeps them in the process
+const (
+	QueueBackendList     = "list"
+	QueueBackendStreams  = "streams"
+	QueueBackendPostgres = "postgres"
+	QueueBackendMemory   = "memory"
+)
+
 // Config is the configuration for the application
 type Config struct {
+	Mode        string            `mapstructure:"mode"`
 	Server      ServerConfig      `mapstructure:"server"`
+	GRPC        GRPCConfig        `mapstructure:"grpc"`
+	Auth        AuthConfig        `mapstructure:"auth"`
+	RateLimit   RateLimitConfig   `mapstructure:"rate_limit"`
+	Health      HealthConfig      `mapstructure:"health"`
+	Queue       QueueConfig       `mapstructure:"queue"`
 	DatabaseURL string            `mapstructure:"database_url"`
 	RedisURL    string            `mapstructure:"redis_url"`
 	Reservation ReservationConfig `mapstructure:"reservation"`
+	Worker      WorkerConfig      `mapstructure:"worker"`
 	Env         string            `mapstructure:"env"`
 }
 
+// RunsAPI reports whether the mode serves the HTTP and gRPC APIs
+func (c *Config) RunsAPI() bool {
+	return c.Mode == ModeAPI || c.Mode == ModeAll
+}
+
+// RunsWorker reports whether the mode processes reservation jobs
+func (c *Config) RunsWorker() bool {
+	return c.Mode == ModeWorker || c.Mode == ModeAll
+}
+
 type ServerConfig struct {
 	Host string `mapstructure:"host"`
 	Port int    `mapstructure:"port"`
+	// TrustedProxies are the CIDRs of the proxies whose X-Forwarded-For is believed,
+	// without any the client IP is the address of the connection
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
 }
 
+// GRPCConfig is the configuration of the gRPC API, a zero port disables it
+type GRPCConfig struct {
+	Host          string        `mapstructure:"host"`
+	Port          int           `mapstructure:"port"`
+	WatchInterval time.Duration `mapstructure:"watch_interval"`
+}
+
+// AuthConfig is the configuration of JWT authentication.
+// HS256 tokens are verified with the shared secret, RS256 tokens with the PEM public key
+// or with the key of the JWKS file that matches the token kid
+type AuthConfig struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	HS256Secret        string `mapstructure:"hs256_secret"`
+	RS256PublicKeyFile string `mapstructure:"rs256_public_key_file"`
+	JWKSFile           string `mapstructure:"jwks_file"`
+	Issuer             string `mapstructure:"issuer"`
+	Audience           string `mapstructure:"audience"`
+	RolesClaim         string `mapstructure:"roles_claim"`
+	AdminRole          string `mapstructure:"admin_role"`
+}
+
+// RateLimitConfig limits requests per caller, Limit and Window apply to every route without its own rule.
+// a zero limit means unlimited
+type RateLimitConfig struct {
+	Enabled bool                   `mapstructure:"enabled"`
+	Limit   int                    `mapstructure:"limit"`
+	Window  time.Duration          `mapstructure:"window"`
+	Routes  []RouteRateLimitConfig `mapstructure:"routes"`
+	APIKeys []APIKeyConfig         `mapstructure:"api_keys"`
+}
+
+// APIKeyConfig is a known API client, callers sending Key in X-API-Key share the budget of Client.
+// keys nobody configured are ignored so made up keys don't get a budget of their own
+type APIKeyConfig struct {
+	Client string `mapstructure:"client"`
+	Key    string `mapstructure:"key"`
+}
+
+// RouteRateLimitConfig overrides the default rule for one route, Path is the route as registered
+type RouteRateLimitConfig struct {
+	Method string        `mapstructure:"method"`
+	Path   string        `mapstructure:"path"`
+	Limit  int           `mapstructure:"limit"`
+	Window time.Duration `mapstructure:"window"`
+}
+
+// HealthConfig configures the readiness checks.
+// ShutdownDelay is how long readiness fails before the servers stop, so the orchestrator
+// notices and stops routing traffic to us first
+type HealthConfig struct {
+	CheckTimeout  time.Duration `mapstructure:"check_timeout"`
+	CacheTTL      time.Duration `mapstructure:"cache_ttl"`
+	ShutdownDelay time.Duration `mapstructure:"shutdown_delay"`
+}
+
+// QueueConfig configures the queue backend and job leases. a dequeued job is leased for VisibilityTimeout, its worker
+// extends the lease every HeartbeatInterval and the reaper requeues jobs whose lease expired.
+// delayed retries are moved to the pending queue every PromoteInterval once they are due.
+// failed jobs are kept up to DeadLetterMaxJobs jobs for DeadLetterMaxAge and completed ones up to
+// CompletedMaxJobs jobs for CompletedMaxAge, zero lifts the limit.
+// queues outside of the items database get their jobs through the outbox, the relay publishes up to
+// OutboxBatchSize jobs at once every OutboxInterval and keeps sent jobs for OutboxRetention, zero keeps them
+type QueueConfig struct {
+	Backend           string        `mapstructure:"backend"`
+	VisibilityTimeout time.Duration `mapstructure:"visibility_timeout"`
+	HeartbeatInterval time.Duration `mapstructure:"heartbeat_interval"`
+	ReaperInterval    time.Duration `mapstructure:"reaper_interval"`
+	PromoteInterval   time.Duration `mapstructure:"promote_interval"`
+	DeadLetterMaxJobs int           `mapstructure:"dead_letter_max_jobs"`
+	DeadLetterMaxAge  time.Duration `mapstructure:"dead_letter_max_age"`
+	CompletedMaxJobs  int           `mapstructure:"completed_max_jobs"`
+	CompletedMaxAge   time.Duration `mapstructure:"completed_max_age"`
+	OutboxInterval    time.Duration `mapstructure:"outbox_interval"`
+	OutboxBatchSize   int           `mapstructure:"outbox_batch_size"`
+	OutboxRetention   time.Duration `mapstructure:"outbox_retention"`
+}
+
+// ReservationConfig configures the reservation service and the retries of its jobs.
+// a job is attempted RetryAttempts times, the backoff before a retry doubles from RetryDelay up to RetryMaxDelay
 type ReservationConfig struct {
 	ServiceURL      string        `mapstructure:"service_url"`
 	Timeout         time.Duration `mapstructure:"timeout"`
 	RetryAttempts   int           `mapstructure:"retry_attempts"`
 	RetryDelay      time.Duration `mapstructure:"retry_delay"`
+	RetryMaxDelay   time.Duration `mapstructure:"retry_max_delay"`
 	MockEnabled     bool          `mapstructure:"mock_enabled"`
 	MockLatency     time.Duration `mapstructure:"mock_latency"`
 	MockFailureRate float64       `mapstructure:"mock_failure_rate"`
+	// CircuitBreaker stops calling the service while it keeps failing
+	CircuitBreaker CircuitBreakerConfig `mapstructure:"circuit_breaker"`
+}
+
+// CircuitBreakerConfig configures the circuit breaker around the reservation service.
+// the circuit opens once FailureRate of the last WindowSize calls failed, and lets a trial call through after CoolDown
+type CircuitBreakerConfig struct {
+	Enabled     bool          `mapstructure:"enabled"`
+	FailureRate float64       `mapstructure:"failure_rate"`
+	WindowSize  int           `mapstructure:"window_size"`
+	CoolDown    time.Duration `mapstructure:"cool_down"`
+}
+
+// WorkerConfig configures the worker pool, Concurrency workers process one job at a time each.
+// on shutdown the pool waits DrainTimeout for the jobs in progress and puts back the ones still running.
+// the reservations of the pool are sent in batches of up to BatchSize, a batch waits BatchWait at most to fill up
+type WorkerConfig struct {
+	Concurrency  int           `mapstructure:"concurrency"`
+	DrainTimeout time.Duration `mapstructure:"drain_timeout"`
+	BatchSize    int           `mapstructure:"batch_size"`
+	BatchWait    time.Duration `mapstructure:"batch_wait"`
 }
 
 type LoggerConfig struct {
@@ -70,9 +207,16 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("error unmarshaling config: %w", err)
 	}
 
+	if config.Queue.Backend == "" {
+		config.Queue.Backend = defaultQueueBackend(config.Env)
+	}
+	if !v.IsSet("rate_limit.enabled") {
+		config.RateLimit.Enabled = defaultRateLimitEnabled(config.Queue.Backend)
+	}
+
 	// validate the configuration
-	if err := validateConfig(&config); err != nil {
-		return nil, fmt.Errorf("invalid configuration: %w", err)
+	if err := config.Validate(); err != nil {
+		return nil, err
 	}
 
 	return &config, nil
@@ -2,11 +2,28 @@ package config
 
 import (
 	"fmt"
+	"net"
 )
 
+// Validate checks the configuration again, for callers that changed it after Load
+func (c *Config) Validate() error {
+	if err := validateConfig(c); err != nil {
+		return fmt.Errorf("invalid configuration: %w", err)
+	}
+	return nil
+}
+
 func validateConfig(cfg *Config) error {
 	validators := []func(*Config) error{
+		validateMode,
 		validateServer,
+		validateGRPC,
+		validateAuth,
+		validateRateLimit,
+		validateHealth,
+		validateQueue,
+		validateReservation,
+		validateWorker,
 	}
 
 	for _, validate := range validators {
@@ -18,7 +35,8 @@ func validateConfig(cfg *Config) error {
 	if cfg.DatabaseURL == "" {
 		return fmt.Errorf("database URL is required")
 	}
-	if cfg.RedisURL == "" {
+	// with a queue outside of redis, redis only backs the rate limiter
+	if cfg.RedisURL == "" && (usesRedisQueue(cfg) || cfg.RateLimit.Enabled) {
 		return fmt.Errorf("redis URL is required")
 	}
 	if cfg.Env != "development" && cfg.Env != "production" && cfg.Env != "test" {
@@ -28,9 +46,173 @@ func validateConfig(cfg *Config) error {
 	return nil
 }
 
+func validateMode(cfg *Config) error {
+	switch cfg.Mode {
+	case ModeAPI, ModeWorker, ModeAll:
+		return nil
+	default:
+		return fmt.Errorf("invalid mode: %q, must be api, worker or all", cfg.Mode)
+	}
+}
+
 func validateServer(cfg *Config) error {
 	if cfg.Server.Port < 1 || cfg.Server.Port > 65535 {
 		return fmt.Errorf("invalid server port: %d", cfg.Server.Port)
 	}
+	for _, proxy := range cfg.Server.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil {
+			return fmt.Errorf("invalid trusted proxy %q, must be a CIDR: %w", proxy, err)
+		}
+	}
+	return nil
+}
+
+func validateGRPC(cfg *Config) error {
+	if cfg.GRPC.Port < 0 || cfg.GRPC.Port > 65535 {
+		return fmt.Errorf("invalid grpc port: %d", cfg.GRPC.Port)
+	}
+	if cfg.GRPC.Port > 0 && cfg.GRPC.WatchInterval <= 0 {
+		return fmt.Errorf("invalid grpc watch interval: %s", cfg.GRPC.WatchInterval)
+	}
+	return nil
+}
+
+func validateAuth(cfg *Config) error {
+	if !cfg.Auth.Enabled {
+		// every request would act as the same admin user, that is only fine on a laptop
+		if cfg.Env == "production" {
+			return fmt.Errorf("auth must be enabled in production")
+		}
+		return nil
+	}
+	if cfg.Auth.HS256Secret == "" && cfg.Auth.RS256PublicKeyFile == "" && cfg.Auth.JWKSFile == "" {
+		return fmt.Errorf("auth requires an hs256 secret, an rs256 public key file or a jwks file")
+	}
+	if cfg.Auth.RolesClaim == "" {
+		return fmt.Errorf("auth roles claim is required")
+	}
+	return nil
+}
+
+func validateRateLimit(cfg *Config) error {
+	if !cfg.RateLimit.Enabled {
+		return nil
+	}
+	if cfg.RateLimit.Limit < 0 || (cfg.RateLimit.Limit > 0 && cfg.RateLimit.Window <= 0) {
+		return fmt.Errorf("invalid rate limit: %d per %s", cfg.RateLimit.Limit, cfg.RateLimit.Window)
+	}
+	for _, route := range cfg.RateLimit.Routes {
+		if route.Method == "" || route.Path == "" {
+			return fmt.Errorf("rate limit route requires a method and a path")
+		}
+		if route.Limit < 0 || (route.Limit > 0 && route.Window <= 0) {
+			return fmt.Errorf("invalid rate limit for %s %s: %d per %s", route.Method, route.Path, route.Limit, route.Window)
+		}
+	}
+	keys := make(map[string]bool, len(cfg.RateLimit.APIKeys))
+	for _, apiKey := range cfg.RateLimit.APIKeys {
+		if apiKey.Client == "" || apiKey.Key == "" {
+			return fmt.Errorf("rate limit api key requires a client and a key")
+		}
+		if keys[apiKey.Key] {
+			return fmt.Errorf("rate limit api key of %s is used twice", apiKey.Client)
+		}
+		keys[apiKey.Key] = true
+	}
+	return nil
+}
+
+func validateHealth(cfg *Config) error {
+	if cfg.Health.CheckTimeout <= 0 {
+		return fmt.Errorf("invalid health check timeout: %s", cfg.Health.CheckTimeout)
+	}
+	if cfg.Health.CacheTTL < 0 || cfg.Health.ShutdownDelay < 0 {
+		return fmt.Errorf("health cache ttl and shutdown delay can't be negative")
+	}
+	return nil
+}
+
+func validateQueue(cfg *Config) error {
+	switch cfg.Queue.Backend {
+	case QueueBackendList, QueueBackendStreams, QueueBackendPostgres:
+	case QueueBackendMemory:
+		// the jobs only exist in the process, the worker must run where the API enqueues them
+		if cfg.Mode != ModeAll {
+			return fmt.Errorf("the memory queue needs mode all, got %q", cfg.Mode)
+		}
+	default:
+		return fmt.Errorf("invalid queue backend: %q, must be list, streams, postgres or memory", cfg.Queue.Backend)
+	}
+	if cfg.Queue.VisibilityTimeout <= 0 || cfg.Queue.ReaperInterval <= 0 || cfg.Queue.PromoteInterval <= 0 {
+		return fmt.Errorf("queue visibility timeout, reaper interval and promote interval must be positive")
+	}
+	// a lease must survive at least one missed heartbeat
+	if cfg.Queue.HeartbeatInterval <= 0 || cfg.Queue.HeartbeatInterval*2 > cfg.Queue.VisibilityTimeout {
+		return fmt.Errorf("queue heartbeat interval must be positive and at most half the visibility timeout, got %s for %s",
+			cfg.Queue.HeartbeatInterval, cfg.Queue.VisibilityTimeout)
+	}
+	if cfg.Queue.DeadLetterMaxJobs < 0 || cfg.Queue.DeadLetterMaxAge < 0 {
+		return fmt.Errorf("queue dead letter max jobs and max age can't be negative")
+	}
+	if cfg.Queue.CompletedMaxJobs < 0 || cfg.Queue.CompletedMaxAge < 0 {
+		return fmt.Errorf("queue completed max jobs and max age can't be negative")
+	}
+	// the postgres queue writes jobs with their items, every other backend is fed by the outbox relay
+	if cfg.Queue.Backend != QueueBackendPostgres {
+		if cfg.Queue.OutboxInterval <= 0 || cfg.Queue.OutboxBatchSize < 1 {
+			return fmt.Errorf("queue outbox interval and batch size must be positive, got %s and %d",
+				cfg.Queue.OutboxInterval, cfg.Queue.OutboxBatchSize)
+		}
+		if cfg.Queue.OutboxRetention < 0 {
+			return fmt.Errorf("queue outbox retention can't be negative")
+		}
+	}
+	return nil
+}
+
+// usesRedisQueue reports whether the queue backend keeps its jobs in redis
+func usesRedisQueue(cfg *Config) bool {
+	return cfg.Queue.Backend == QueueBackendList || cfg.Queue.Backend == QueueBackendStreams
+}
+
+func validateReservation(cfg *Config) error {
+	if cfg.Reservation.RetryAttempts < 1 {
+		return fmt.Errorf("reservation retry attempts must be at least 1, got %d", cfg.Reservation.RetryAttempts)
+	}
+	if cfg.Reservation.RetryDelay <= 0 || cfg.Reservation.RetryMaxDelay < cfg.Reservation.RetryDelay {
+		return fmt.Errorf("reservation retry delay must be positive and at most the max delay, got %s and %s",
+			cfg.Reservation.RetryDelay, cfg.Reservation.RetryMaxDelay)
+	}
+
+	breaker := cfg.Reservation.CircuitBreaker
+	if !breaker.Enabled {
+		return nil
+	}
+	if breaker.FailureRate <= 0 || breaker.FailureRate > 1 {
+		return fmt.Errorf("reservation circuit breaker failure rate must be above 0 and at most 1, got %v", breaker.FailureRate)
+	}
+	if breaker.WindowSize < 1 {
+		return fmt.Errorf("reservation circuit breaker window size must be at least 1, got %d", breaker.WindowSize)
+	}
+	if breaker.CoolDown <= 0 {
+		return fmt.Errorf("reservation circuit breaker cool down must be positive, got %s", breaker.CoolDown)
+	}
+	return nil
+}
+
+func validateWorker(cfg *Config) error {
+	if cfg.Worker.Concurrency < 0 {
+		return fmt.Errorf("worker concurrency can't be negative, got %d", cfg.Worker.Concurrency)
+	}
+	if cfg.Worker.DrainTimeout < 0 {
+		return fmt.Errorf("worker drain timeout can't be negative, got %s", cfg.Worker.DrainTimeout)
+	}
+	if cfg.Worker.BatchSize < 0 || cfg.Worker.BatchWait < 0 {
+		return fmt.Errorf("worker batch size and wait can't be negative, got %d and %s", cfg.Worker.BatchSize, cfg.Worker.BatchWait)
+	}
+	// a batch holds the reservations of the jobs the pool processes at once, a bigger one never fills up
+	if cfg.Worker.BatchSize > max(cfg.Worker.Concurrency, 1) {
+		return fmt.Errorf("worker batch size can't exceed its concurrency, got %d and %d", cfg.Worker.BatchSize, cfg.Worker.Concurrency)
+	}
 	return nil
 }
@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+// AnonymousSubject owns every item while auth is disabled
+const AnonymousSubject = "anonymous"
+
+// Authenticator verifies bearer tokens and turns them into a principal
+type Authenticator struct {
+	cfg        config.AuthConfig
+	secret     []byte
+	publicKey  *rsa.PublicKey
+	jwks       *keySet
+	parserOpts []jwt.ParserOption
+}
+
+// New creates an Authenticator from the auth config, the key files are read once here
+func New(cfg config.AuthConfig) (*Authenticator, error) {
+	a := &Authenticator{cfg: cfg}
+	if !cfg.Enabled {
+		return a, nil
+	}
+
+	if cfg.HS256Secret != "" {
+		a.secret = []byte(cfg.HS256Secret)
+	}
+	if cfg.RS256PublicKeyFile != "" {
+		pem, err := os.ReadFile(cfg.RS256PublicKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading rs256 public key: %w", err)
+		}
+		if a.publicKey, err = jwt.ParseRSAPublicKeyFromPEM(pem); err != nil {
+			return nil, fmt.Errorf("error parsing rs256 public key: %w", err)
+		}
+	}
+	if cfg.JWKSFile != "" {
+		keys, err := loadKeySet(cfg.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		a.jwks = keys
+	}
+
+	a.parserOpts = []jwt.ParserOption{
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg(), jwt.SigningMethodRS256.Alg()}),
+		jwt.WithExpirationRequired(),
+	}
+	if cfg.Issuer != "" {
+		a.parserOpts = append(a.parserOpts, jwt.WithIssuer(cfg.Issuer))
+	}
+	if cfg.Audience != "" {
+		a.parserOpts = append(a.parserOpts, jwt.WithAudience(cfg.Audience))
+	}
+	return a, nil
+}
+
+// Enabled reports whether tokens are verified at all
+func (a *Authenticator) Enabled() bool {
+	return a.cfg.Enabled
+}
+
+// Authenticate verifies the value of an Authorization header.
+// While auth is disabled every caller is the anonymous admin, so development works without tokens
+func (a *Authenticator) Authenticate(authorization string) (domain.Principal, error) {
+	if !a.cfg.Enabled {
+		return domain.Principal{Subject: AnonymousSubject, Roles: []string{domain.RoleAdmin}}, nil
+	}
+
+	scheme, token, ok := strings.Cut(authorization, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return domain.Principal{}, domain.ErrUnauthenticated
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, a.key, a.parserOpts...); err != nil {
+		return domain.Principal{}, &domain.Error{
+			Kind:    domain.ErrUnauthenticated.Kind,
+			Code:    domain.ErrUnauthenticated.Code,
+			Message: "invalid bearer token",
+			Err:     err,
+		}
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return domain.Principal{}, domain.ErrUnauthenticated
+	}
+	return domain.Principal{Subject: subject, Roles: a.roles(claims)}, nil
+}
+
+// key picks the verification key by the signing method, RS256 keys of the JWKS file are matched by kid
+func (a *Authenticator) key(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		if a.jwks != nil && kid != "" {
+			if secret, ok := a.jwks.secrets[kid]; ok {
+				return secret, nil
+			}
+		}
+		if a.secret != nil {
+			return a.secret, nil
+		}
+	case jwt.SigningMethodRS256.Alg():
+		if a.jwks != nil && kid != "" {
+			if key, ok := a.jwks.rsa[kid]; ok {
+				return key, nil
+			}
+		}
+		if a.publicKey != nil {
+			return a.publicKey, nil
+		}
+	}
+	return nil, errors.New("no key for token")
+}
+
+// roles reads the roles claim, identity providers send it either as a list or a space separated string.
+// only the configured admin role becomes domain.RoleAdmin so the core doesn't depend on the provider,
+// a provider role that happens to be named like an internal role is dropped, it must not grant that role
+func (a *Authenticator) roles(claims jwt.MapClaims) []string {
+	var raw []string
+	switch v := claims[a.cfg.RolesClaim].(type) {
+	case string:
+		raw = strings.Fields(v)
+	case []interface{}:
+		for _, r := range v {
+			if s, ok := r.(string); ok {
+				raw = append(raw, s)
+			}
+		}
+	}
+
+	var roles []string
+	for _, r := range raw {
+		switch {
+		case r == a.cfg.AdminRole:
+			roles = append(roles, domain.RoleAdmin)
+		case r == domain.RoleAdmin:
+			// not the configured admin role, so it's the provider's own role of that name
+		default:
+			roles = append(roles, r)
+		}
+	}
+	return roles
+}
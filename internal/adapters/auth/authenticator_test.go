@@ -0,0 +1,211 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+const testSecret = "test-secret"
+
+// claims returns valid claims for user-1, tests change what they need
+func claims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"sub":   "user-1",
+		"iss":   "https://issuer.test",
+		"aud":   "shopping-cart",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"customer"},
+	}
+}
+
+func signHS256(t *testing.T, c jwt.MapClaims) string {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, c).SignedString([]byte(testSecret))
+	require.NoError(t, err)
+	return token
+}
+
+func signRS256(t *testing.T, key *rsa.PrivateKey, kid string, c jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, c)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+func writePublicKey(t *testing.T, key *rsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600))
+	return path
+}
+
+func writeJWKS(t *testing.T, kid string, key *rsa.PrivateKey) string {
+	doc := map[string]interface{}{
+		"keys": []map[string]string{{
+			"kid": kid,
+			"kty": "RSA",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}},
+	}
+	data, err := json.Marshal(doc)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0600))
+	return path
+}
+
+func TestAuthenticate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	a, err := New(config.AuthConfig{
+		Enabled:            true,
+		HS256Secret:        testSecret,
+		RS256PublicKeyFile: writePublicKey(t, rsaKey),
+		JWKSFile:           writeJWKS(t, "key-2", otherKey),
+		Issuer:             "https://issuer.test",
+		Audience:           "shopping-cart",
+		RolesClaim:         "roles",
+		AdminRole:          "shop-admin",
+	})
+	require.NoError(t, err)
+
+	admin := claims()
+	admin["roles"] = "customer shop-admin"
+	providerAdmin := claims()
+	providerAdmin["roles"] = []string{"customer", domain.RoleAdmin}
+	expired := claims()
+	expired["exp"] = time.Now().Add(-time.Minute).Unix()
+	noSubject := claims()
+	delete(noSubject, "sub")
+	wrongAudience := claims()
+	wrongAudience["aud"] = "someone-else"
+	none, err := jwt.NewWithClaims(jwt.SigningMethodNone, claims()).SignedString(jwt.UnsafeAllowNoneSignatureType)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name          string
+		authorization string
+		want          domain.Principal
+		wantErr       bool
+	}{
+		{
+			name:          "hs256 token",
+			authorization: "Bearer " + signHS256(t, claims()),
+			want:          domain.Principal{Subject: "user-1", Roles: []string{"customer"}},
+		},
+		{
+			name:          "rs256 token with the pem key",
+			authorization: "Bearer " + signRS256(t, rsaKey, "", claims()),
+			want:          domain.Principal{Subject: "user-1", Roles: []string{"customer"}},
+		},
+		{
+			name:          "rs256 token with a jwks key",
+			authorization: "Bearer " + signRS256(t, otherKey, "key-2", claims()),
+			want:          domain.Principal{Subject: "user-1", Roles: []string{"customer"}},
+		},
+		{
+			name:          "admin role is mapped",
+			authorization: "Bearer " + signHS256(t, admin),
+			want:          domain.Principal{Subject: "user-1", Roles: []string{"customer", domain.RoleAdmin}},
+		},
+		{
+			name:          "provider role named like the admin role is dropped",
+			authorization: "Bearer " + signHS256(t, providerAdmin),
+			want:          domain.Principal{Subject: "user-1", Roles: []string{"customer"}},
+		},
+		{
+			name:          "missing header",
+			authorization: "",
+			wantErr:       true,
+		},
+		{
+			name:          "wrong scheme",
+			authorization: "Basic dXNlcjpwYXNz",
+			wantErr:       true,
+		},
+		{
+			name:          "expired token",
+			authorization: "Bearer " + signHS256(t, expired),
+			wantErr:       true,
+		},
+		{
+			name:          "missing subject",
+			authorization: "Bearer " + signHS256(t, noSubject),
+			wantErr:       true,
+		},
+		{
+			name:          "wrong audience",
+			authorization: "Bearer " + signHS256(t, wrongAudience),
+			wantErr:       true,
+		},
+		{
+			name:          "unsigned token",
+			authorization: "Bearer " + none,
+			wantErr:       true,
+		},
+		{
+			name:          "unknown key",
+			authorization: "Bearer " + signRS256(t, otherKey, "", claims()),
+			wantErr:       true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := a.Authenticate(tt.authorization)
+			if tt.wantErr {
+				assert.ErrorIs(t, err, domain.ErrUnauthenticated)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestAuthenticateDisabled(t *testing.T) {
+	a, err := New(config.AuthConfig{Enabled: false})
+	require.NoError(t, err)
+
+	got, err := a.Authenticate("")
+	require.NoError(t, err)
+	assert.Equal(t, AnonymousSubject, got.Subject)
+	assert.True(t, got.HasRole(domain.RoleAdmin))
+}
+
+func TestNewInvalidKeys(t *testing.T) {
+	_, err := New(config.AuthConfig{Enabled: true, RS256PublicKeyFile: "missing.pem"})
+	assert.Error(t, err)
+
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"keys":[]}`), 0600))
+	_, err = New(config.AuthConfig{Enabled: true, JWKSFile: path})
+	assert.Error(t, err)
+}
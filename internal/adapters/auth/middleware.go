@@ -0,0 +1,104 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/labstack/echo/v4"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+// Middleware authenticates every request except the public paths and puts the principal
+// into the request context, the cart service reads it from there
+func Middleware(a *Authenticator, publicPaths ...string) echo.MiddlewareFunc {
+	public := make(map[string]bool, len(publicPaths))
+	for _, p := range publicPaths {
+		public[p] = true
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if public[c.Request().URL.Path] {
+				return next(c)
+			}
+
+			principal, err := a.Authenticate(c.Request().Header.Get(echo.HeaderAuthorization))
+			if err != nil {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="shopping-cart"`)
+				return err
+			}
+
+			req := c.Request()
+			c.SetRequest(req.WithContext(domain.ContextWithPrincipal(req.Context(), principal)))
+			return next(c)
+		}
+	}
+}
+
+// RequireRole only lets principals with the role through, it must run after Middleware
+func RequireRole(role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			principal, ok := domain.PrincipalFromContext(c.Request().Context())
+			if !ok {
+				return domain.ErrUnauthenticated
+			}
+			if !principal.HasRole(role) {
+				return domain.ErrForbidden
+			}
+			return next(c)
+		}
+	}
+}
+
+// UnaryServerInterceptor authenticates unary gRPC calls with the authorization metadata
+func UnaryServerInterceptor(a *Authenticator) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, _ *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		ctx, err := authenticateContext(ctx, a)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// StreamServerInterceptor authenticates streaming gRPC calls with the authorization metadata
+func StreamServerInterceptor(a *Authenticator) grpc.StreamServerInterceptor {
+	return func(srv interface{}, ss grpc.ServerStream, _ *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := authenticateContext(ss.Context(), a)
+		if err != nil {
+			return err
+		}
+		return handler(srv, &principalStream{ServerStream: ss, ctx: ctx})
+	}
+}
+
+// authenticateContext fails with a status error, interceptors run before the rpc server could map domain errors
+func authenticateContext(ctx context.Context, a *Authenticator) (context.Context, error) {
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("authorization"); len(values) > 0 {
+			authorization = values[0]
+		}
+	}
+
+	principal, err := a.Authenticate(authorization)
+	if err != nil {
+		return nil, status.Error(codes.Unauthenticated, domain.ErrUnauthenticated.Message)
+	}
+	return domain.ContextWithPrincipal(ctx, principal), nil
+}
+
+// principalStream overrides the stream context, grpc has no other way to pass values to stream handlers
+type principalStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *principalStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+func newTestAuthenticator(t *testing.T) *Authenticator {
+	a, err := New(config.AuthConfig{Enabled: true, HS256Secret: testSecret, RolesClaim: "roles", AdminRole: "admin"})
+	require.NoError(t, err)
+	return a
+}
+
+func TestMiddleware(t *testing.T) {
+	a := newTestAuthenticator(t)
+	admin := claims()
+	admin["roles"] = []string{"admin"}
+
+	e := echo.New()
+	subject := func(c echo.Context) error {
+		p, _ := domain.PrincipalFromContext(c.Request().Context())
+		return c.String(http.StatusOK, p.Subject)
+	}
+	e.Use(Middleware(a, "/public"))
+	e.GET("/public", subject)
+	e.GET("/items", subject)
+	e.GET("/admin", subject, RequireRole(domain.RoleAdmin))
+
+	tests := []struct {
+		name           string
+		path           string
+		token          string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{name: "public path", path: "/public", expectedStatus: http.StatusOK},
+		{name: "missing token", path: "/items", expectedStatus: http.StatusUnauthorized},
+		{name: "valid token", path: "/items", token: signHS256(t, claims()), expectedStatus: http.StatusOK, expectedBody: "user-1"},
+		{name: "admin without role", path: "/admin", token: signHS256(t, claims()), expectedStatus: http.StatusForbidden},
+		{name: "admin with role", path: "/admin", token: signHS256(t, admin), expectedStatus: http.StatusOK, expectedBody: "user-1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var handled error
+			e.HTTPErrorHandler = func(err error, c echo.Context) {
+				handled = err
+				switch domain.KindOf(err) {
+				case domain.ErrorKindUnauthenticated:
+					_ = c.NoContent(http.StatusUnauthorized)
+				case domain.ErrorKindForbidden:
+					_ = c.NoContent(http.StatusForbidden)
+				default:
+					e.DefaultHTTPErrorHandler(err, c)
+				}
+			}
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			if tt.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+tt.token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code, handled)
+			if tt.expectedStatus == http.StatusUnauthorized {
+				assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), "Bearer")
+			}
+			if tt.expectedBody != "" {
+				assert.Equal(t, tt.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	interceptor := UnaryServerInterceptor(newTestAuthenticator(t))
+	handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		p, _ := domain.PrincipalFromContext(ctx)
+		return p.Subject, nil
+	}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+signHS256(t, claims())))
+	got, err := interceptor(ctx, nil, &grpc.UnaryServerInfo{}, handler)
+	require.NoError(t, err)
+	assert.Equal(t, "user-1", got)
+
+	_, err = interceptor(context.Background(), nil, &grpc.UnaryServerInfo{}, handler)
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
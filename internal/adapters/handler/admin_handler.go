@@ -0,0 +1,192 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/a-berahman/shopping-cart/internal/core/ports"
+
+	"github.com/labstack/echo/v4"
+)
+
+const defaultJobPageLimit = 50
+
+// AdminHandler serves the operational endpoints, they must only be reachable by admins
+type AdminHandler struct {
+	queue  ports.QueueAdmin
+	outbox ports.Outbox
+}
+
+type ListJobsRequest struct {
+	Status string `query:"status" json:"status" validate:"required,oneof=pending delayed processing completed failed"`
+	Offset int    `query:"offset" json:"offset" validate:"min=0"`
+	Limit  int    `query:"limit" json:"limit" validate:"min=0,max=500"`
+}
+
+// RetryFailedJobsRequest selects the failed jobs to replay, an empty request replays every one
+type RetryFailedJobsRequest struct {
+	JobType      string    `json:"job_type" validate:"omitempty,oneof=AVAILABILITY_CHECK RESERVATION"`
+	ItemID       int64     `json:"item_id" validate:"min=0"`
+	ErrorClass   string    `json:"error_class"`
+	FailedAfter  time.Time `json:"failed_after"`
+	FailedBefore time.Time `json:"failed_before"`
+}
+
+type RetryFailedJobsResponse struct {
+	Retried int `json:"retried"`
+}
+
+type JobPage struct {
+	Status domain.JobStatus         `json:"status"`
+	Offset int                      `json:"offset"`
+	Limit  int                      `json:"limit"`
+	Total  int64                    `json:"total"`
+	Jobs   []*domain.ReservationJob `json:"jobs"`
+}
+
+type QueueDepthsResponse struct {
+	Pending    int64 `json:"pending"`
+	Delayed    int64 `json:"delayed"`
+	Processing int64 `json:"processing"`
+	Completed  int64 `json:"completed"`
+	Failed     int64 `json:"failed"`
+}
+
+// OutboxStatsResponse tells how far the relay is behind, LagMS is how long the oldest unsent job has waited
+type OutboxStatsResponse struct {
+	Pending int64 `json:"pending"`
+	LagMS   int64 `json:"lag_ms"`
+}
+
+// NewAdminHandler creates the admin handler, outbox is nil for queues that don't need one
+func NewAdminHandler(queue ports.QueueAdmin, outbox ports.Outbox) *AdminHandler {
+	return &AdminHandler{
+		queue:  queue,
+		outbox: outbox,
+	}
+}
+
+// Register registers the admin routes, middleware guards every one of them
+func (h *AdminHandler) Register(e *echo.Echo, middleware ...echo.MiddlewareFunc) {
+	g := e.Group("/api/v1/admin", middleware...)
+	g.GET("/queue", h.QueueDepths)
+	g.GET("/jobs", h.ListJobs)
+	g.POST("/jobs/retry", h.RetryFailedJobs)
+	g.GET("/jobs/:id", h.GetJob)
+	g.POST("/jobs/:id/retry", h.RetryJob)
+	g.DELETE("/jobs/:id", h.DiscardJob)
+	if h.outbox != nil {
+		g.GET("/outbox", h.OutboxStats)
+	}
+}
+
+func (h *AdminHandler) QueueDepths(c echo.Context) error {
+	depths, err := h.queue.QueueDepths(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, QueueDepthsResponse{
+		Pending:    depths[domain.JobStatusPending],
+		Delayed:    depths[domain.JobStatusDelayed],
+		Processing: depths[domain.JobStatusProcessing],
+		Completed:  depths[domain.JobStatusCompleted],
+		Failed:     depths[domain.JobStatusFailed],
+	})
+}
+
+// ListJobs pages through the jobs with a status, newest first
+func (h *AdminHandler) ListJobs(c echo.Context) error {
+	var req ListJobsRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+	if req.Limit == 0 {
+		req.Limit = defaultJobPageLimit
+	}
+
+	status := domain.JobStatus(strings.ToUpper(req.Status))
+	jobs, total, err := h.queue.ListJobs(c.Request().Context(), status, req.Offset, req.Limit)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, JobPage{
+		Status: status,
+		Offset: req.Offset,
+		Limit:  req.Limit,
+		Total:  total,
+		Jobs:   jobs,
+	})
+}
+
+func (h *AdminHandler) GetJob(c echo.Context) error {
+	job, err := h.queue.GetJob(c.Request().Context(), c.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, job)
+}
+
+// RetryJob moves a failed job back to the pending queue
+func (h *AdminHandler) RetryJob(c echo.Context) error {
+	if err := h.queue.RetryJob(c.Request().Context(), c.Param("id")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// RetryFailedJobs moves the failed jobs the request selects back to the pending queue
+func (h *AdminHandler) RetryFailedJobs(c echo.Context) error {
+	var req RetryFailedJobsRequest
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	retried, err := h.queue.RetryFailedJobs(c.Request().Context(), domain.DeadLetterFilter{
+		JobType:      domain.JobType(req.JobType),
+		ItemID:       req.ItemID,
+		ErrorClass:   domain.JobErrorClass(strings.ToUpper(req.ErrorClass)),
+		FailedAfter:  req.FailedAfter,
+		FailedBefore: req.FailedBefore,
+	})
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, RetryFailedJobsResponse{Retried: retried})
+}
+
+// DiscardJob removes a job for good, its item keeps the status it has
+func (h *AdminHandler) DiscardJob(c echo.Context) error {
+	if err := h.queue.DiscardJob(c.Request().Context(), c.Param("id")); err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// OutboxStats returns the number of jobs waiting to be relayed to the queue and how long the oldest has waited
+func (h *AdminHandler) OutboxStats(c echo.Context) error {
+	stats, err := h.outbox.OutboxStats(c.Request().Context())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, OutboxStatsResponse{
+		Pending: stats.Pending,
+		LagMS:   stats.Lag.Milliseconds(),
+	})
+}
@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockQueueAdmin struct {
+	mock.Mock
+}
+
+func (m *MockQueueAdmin) QueueDepths(ctx context.Context) (map[domain.JobStatus]int64, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(map[domain.JobStatus]int64), args.Error(1)
+}
+
+func (m *MockQueueAdmin) ListJobs(ctx context.Context, status domain.JobStatus, offset, limit int) ([]*domain.ReservationJob, int64, error) {
+	args := m.Called(ctx, status, offset, limit)
+	if args.Get(0) == nil {
+		return nil, 0, args.Error(2)
+	}
+	return args.Get(0).([]*domain.ReservationJob), args.Get(1).(int64), args.Error(2)
+}
+
+func (m *MockQueueAdmin) GetJob(ctx context.Context, id string) (*domain.ReservationJob, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.ReservationJob), args.Error(1)
+}
+
+func (m *MockQueueAdmin) RetryJob(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockQueueAdmin) RetryFailedJobs(ctx context.Context, filter domain.DeadLetterFilter) (int, error) {
+	args := m.Called(ctx, filter)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockQueueAdmin) DiscardJob(ctx context.Context, id string) error {
+	return m.Called(ctx, id).Error(0)
+}
+
+func (m *MockQueueAdmin) PurgeJobs(ctx context.Context, status domain.JobStatus) (int64, error) {
+	args := m.Called(ctx, status)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+type MockOutbox struct {
+	mock.Mock
+}
+
+func (m *MockOutbox) RelayJobs(ctx context.Context, limit int, publish func(ctx context.Context, jobs []*domain.ReservationJob) error) (int, error) {
+	args := m.Called(ctx, limit, publish)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockOutbox) TrimSentJobs(ctx context.Context, maxAge time.Duration) (int, error) {
+	args := m.Called(ctx, maxAge)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockOutbox) OutboxStats(ctx context.Context) (domain.OutboxStats, error) {
+	args := m.Called(ctx)
+	return args.Get(0).(domain.OutboxStats), args.Error(1)
+}
+
+func setupAdminTest(middleware ...echo.MiddlewareFunc) (*echo.Echo, *MockQueueAdmin, *MockOutbox) {
+	e := echo.New()
+	v := validator.New()
+	v.RegisterTagNameFunc(JSONTagName)
+	e.Validator = &CustomValidator{validator: v}
+	e.HTTPErrorHandler = NewErrorHandler(slog.Default())
+	queue, outbox := new(MockQueueAdmin), new(MockOutbox)
+	NewAdminHandler(queue, outbox).Register(e, middleware...)
+	return e, queue, outbox
+}
+
+func TestAdminHandler(t *testing.T) {
+	job := &domain.ReservationJob{ID: "job-1", ItemID: 1, ItemName: "Item", Quantity: 1, Status: domain.JobStatusFailed,
+		JobType: domain.JobTypeAvailabilityCheck}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		setupMock      func(*MockQueueAdmin)
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:   "queue depths",
+			method: http.MethodGet,
+			path:   "/api/v1/admin/queue",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("QueueDepths", mock.Anything).Return(map[domain.JobStatus]int64{
+					domain.JobStatusPending: 3, domain.JobStatusFailed: 1,
+				}, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"pending":3,"delayed":0,"processing":0,"completed":0,"failed":1}`,
+		},
+		{
+			name:   "list jobs with the default page",
+			method: http.MethodGet,
+			path:   "/api/v1/admin/jobs?status=failed",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("ListJobs", mock.Anything, domain.JobStatusFailed, 0, 50).
+					Return([]*domain.ReservationJob{job}, int64(1), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"status":"FAILED","offset":0,"limit":50,"total":1,"jobs":[{"id":"job-1","item_id":1,"item_name":"Item",
+				"quantity":1,"status":"FAILED","attempts":0,"last_attempted":"0001-01-01T00:00:00Z",
+				"created_at":"0001-01-01T00:00:00Z","job_type":"AVAILABILITY_CHECK"}]}`,
+		},
+		{
+			name:   "list jobs with a page",
+			method: http.MethodGet,
+			path:   "/api/v1/admin/jobs?status=pending&offset=10&limit=5",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("ListJobs", mock.Anything, domain.JobStatusPending, 10, 5).
+					Return([]*domain.ReservationJob{}, int64(10), nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"PENDING","offset":10,"limit":5,"total":10,"jobs":[]}`,
+		},
+		{
+			name:           "list jobs with an unknown status",
+			method:         http.MethodGet,
+			path:           "/api/v1/admin/jobs?status=lost",
+			setupMock:      func(q *MockQueueAdmin) {},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: `{"type":"urn:shopping-cart:problem:validation_failed","title":"Bad Request","status":400,
+				"detail":"the request has invalid fields","instance":"/api/v1/admin/jobs","code":"validation_failed",
+				"errors":[{"field":"status","code":"oneof","message":"must be one of pending, delayed, processing, completed, failed"}]}`,
+		},
+		{
+			name:   "get job",
+			method: http.MethodGet,
+			path:   "/api/v1/admin/jobs/job-1",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("GetJob", mock.Anything, "job-1").Return(job, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody: `{"id":"job-1","item_id":1,"item_name":"Item","quantity":1,"status":"FAILED","attempts":0,
+				"last_attempted":"0001-01-01T00:00:00Z","created_at":"0001-01-01T00:00:00Z","job_type":"AVAILABILITY_CHECK"}`,
+		},
+		{
+			name:   "get missing job",
+			method: http.MethodGet,
+			path:   "/api/v1/admin/jobs/job-2",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("GetJob", mock.Anything, "job-2").Return(nil, domain.ErrJobNotFound)
+			},
+			expectedStatus: http.StatusNotFound,
+			expectedBody: `{"type":"urn:shopping-cart:problem:job_not_found","title":"Not Found","status":404,
+				"detail":"job not found","instance":"/api/v1/admin/jobs/job-2","code":"job_not_found"}`,
+		},
+		{
+			name:   "retry job",
+			method: http.MethodPost,
+			path:   "/api/v1/admin/jobs/job-1/retry",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("RetryJob", mock.Anything, "job-1").Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+		{
+			name:   "retry job that didn't fail",
+			method: http.MethodPost,
+			path:   "/api/v1/admin/jobs/job-1/retry",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("RetryJob", mock.Anything, "job-1").Return(domain.ErrJobNotFailed)
+			},
+			expectedStatus: http.StatusConflict,
+			expectedBody: `{"type":"urn:shopping-cart:problem:job_not_failed","title":"Conflict","status":409,
+				"detail":"only failed jobs can be retried","instance":"/api/v1/admin/jobs/job-1/retry","code":"job_not_failed"}`,
+		},
+		{
+			name:   "retry failed jobs",
+			method: http.MethodPost,
+			path:   "/api/v1/admin/jobs/retry",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("RetryFailedJobs", mock.Anything, domain.DeadLetterFilter{}).Return(2, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"retried":2}`,
+		},
+		{
+			name:   "retry failed jobs of an item that timed out",
+			method: http.MethodPost,
+			path:   "/api/v1/admin/jobs/retry",
+			body: `{"job_type":"RESERVATION","item_id":7,"error_class":"timeout",
+				"failed_after":"2024-01-01T00:00:00Z","failed_before":"2024-01-02T00:00:00Z"}`,
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("RetryFailedJobs", mock.Anything, domain.DeadLetterFilter{
+					JobType:      domain.JobTypeReservation,
+					ItemID:       7,
+					ErrorClass:   domain.JobErrorClassTimeout,
+					FailedAfter:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+					FailedBefore: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC),
+				}).Return(1, nil)
+			},
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"retried":1}`,
+		},
+		{
+			name:           "retry failed jobs of an unknown job type",
+			method:         http.MethodPost,
+			path:           "/api/v1/admin/jobs/retry",
+			body:           `{"job_type":"SHIPPING"}`,
+			setupMock:      func(q *MockQueueAdmin) {},
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:   "retry failed jobs with redis down",
+			method: http.MethodPost,
+			path:   "/api/v1/admin/jobs/retry",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("RetryFailedJobs", mock.Anything, domain.DeadLetterFilter{}).Return(0, errors.New("connection refused"))
+			},
+			expectedStatus: http.StatusInternalServerError,
+			expectedBody: `{"type":"urn:shopping-cart:problem:internal_error","title":"Internal Server Error","status":500,
+				"detail":"internal server error","instance":"/api/v1/admin/jobs/retry","code":"internal_error"}`,
+		},
+		{
+			name:   "discard job",
+			method: http.MethodDelete,
+			path:   "/api/v1/admin/jobs/job-1",
+			setupMock: func(q *MockQueueAdmin) {
+				q.On("DiscardJob", mock.Anything, "job-1").Return(nil)
+			},
+			expectedStatus: http.StatusNoContent,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, queue, _ := setupAdminTest()
+			tt.setupMock(queue)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, rec.Body.String())
+			}
+			queue.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAdminOutboxStats(t *testing.T) {
+	e, _, outbox := setupAdminTest()
+	outbox.On("OutboxStats", mock.Anything).Return(domain.OutboxStats{Pending: 12, Lag: 1500 * time.Millisecond}, nil)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/outbox", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"pending":12,"lag_ms":1500}`, rec.Body.String())
+
+	// queues that keep their jobs with the items have no outbox to report on
+	e = echo.New()
+	NewAdminHandler(new(MockQueueAdmin), nil).Register(e)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/admin/outbox", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAdminRoutesAreGuarded(t *testing.T) {
+	deny := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			return domain.ErrForbidden
+		}
+	}
+	e, queue, _ := setupAdminTest(deny)
+
+	for _, route := range e.Routes() {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(route.Method, route.Path, nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code, "%s %s", route.Method, route.Path)
+	}
+	queue.AssertNotCalled(t, "RetryFailedJobs", mock.Anything, mock.Anything)
+}
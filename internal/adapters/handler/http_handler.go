@@ -3,6 +3,8 @@ package handler
 import (
 	"net/http"
 
+	"github.com/a-berahman/shopping-cart/internal/adapters/handler/openapi"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
 	"github.com/a-berahman/shopping-cart/internal/core/ports"
 
 	"github.com/labstack/echo/v4"
@@ -17,6 +19,24 @@ type AddItemRequest struct {
 	Quantity int    `json:"quantity" validate:"required,min=1"`
 }
 
+type AddItemsBatchRequest struct {
+	Items []AddItemRequest `json:"items" validate:"required,min=1"`
+}
+
+// BatchItemResult is the outcome of a single line, Status is the HTTP status the line would have had on its own
+type BatchItemResult struct {
+	Index  int          `json:"index"`
+	Status int          `json:"status"`
+	Item   *domain.Item `json:"item,omitempty"`
+	Error  *Problem     `json:"error,omitempty"`
+}
+
+type AddItemsBatchResponse struct {
+	Results []BatchItemResult `json:"results"`
+	Created int               `json:"created"`
+	Failed  int               `json:"failed"`
+}
+
 func NewHandler(service ports.CartService) *Handler {
 	return &Handler{
 		service: service,
@@ -27,6 +47,10 @@ func NewHandler(service ports.CartService) *Handler {
 func (h *Handler) Register(e *echo.Echo) {
 	e.POST("api/v1/items", h.AddItem)
 	e.GET("api/v1/items", h.ListItems)
+	// the colon is escaped, otherwise echo treats ":batch" as a path parameter
+	e.POST("api/v1/items\\:batch", h.AddItemsBatch)
+
+	openapi.Register(e)
 }
 
 func (h *Handler) AddItem(c echo.Context) error {
@@ -34,27 +58,87 @@ func (h *Handler) AddItem(c echo.Context) error {
 	var req AddItemRequest
 
 	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	if err := c.Validate(req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		return err
 	}
 
 	item, err := h.service.AddItemToCart(ctx, req.Name, req.Quantity)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
 	}
 
 	return c.JSON(http.StatusCreated, item)
 }
 
+// AddItemsBatch adds many items at once. Every line is validated on its own, invalid lines are reported
+// and the valid ones are still added, so the response is 207 as soon as one line failed. the valid lines
+// are added all or nothing, an error adding them fails the whole request
+func (h *Handler) AddItemsBatch(c echo.Context) error {
+	ctx := c.Request().Context()
+	var req AddItemsBatchRequest
+
+	if err := c.Bind(&req); err != nil {
+		return err
+	}
+
+	if err := c.Validate(req); err != nil {
+		return err
+	}
+
+	if len(req.Items) > domain.MaxBatchSize {
+		return domain.ErrBatchTooLarge
+	}
+
+	resp := AddItemsBatchResponse{Results: make([]BatchItemResult, len(req.Items))}
+	lines := make([]domain.CartLine, 0, len(req.Items))
+	indexes := make([]int, 0, len(req.Items))
+	for i, item := range req.Items {
+		resp.Results[i].Index = i
+		// the lines are checked by the domain rules the service applies, so nothing it rejects gets through
+		line := domain.CartLine{Name: item.Name, Quantity: item.Quantity}
+		if err := line.Validate(); err != nil {
+			resp.Results[i].Error = NewProblem(err)
+			resp.Results[i].Status = resp.Results[i].Error.Status
+			continue
+		}
+		lines = append(lines, line)
+		indexes = append(indexes, i)
+	}
+
+	if len(lines) > 0 {
+		items, err := h.service.AddItemsToCart(ctx, lines)
+		if err != nil {
+			return err
+		}
+		for j, i := range indexes {
+			resp.Results[i].Status = http.StatusCreated
+			resp.Results[i].Item = items[j]
+		}
+	}
+
+	for _, result := range resp.Results {
+		if result.Status == http.StatusCreated {
+			resp.Created++
+		} else {
+			resp.Failed++
+		}
+	}
+
+	if resp.Failed > 0 {
+		return c.JSON(http.StatusMultiStatus, resp)
+	}
+	return c.JSON(http.StatusCreated, resp)
+}
+
 func (h *Handler) ListItems(c echo.Context) error {
 	ctx := c.Request().Context()
 
 	items, err := h.service.ListCartItems(ctx)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		return err
 	}
 
 	return c.JSON(http.StatusOK, items)
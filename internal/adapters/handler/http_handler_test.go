@@ -4,13 +4,17 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
-	"fmt"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 
 	"context"
 
+	"github.com/a-berahman/shopping-cart/internal/adapters/handler/openapi"
 	"github.com/a-berahman/shopping-cart/internal/core/domain"
 	"github.com/go-playground/validator"
 	"github.com/labstack/echo/v4"
@@ -31,11 +35,27 @@ func (m *MockCartService) AddItemToCart(ctx context.Context, name string, quanti
 	return args.Get(0).(*domain.Item), args.Error(1)
 }
 
+func (m *MockCartService) AddItemsToCart(ctx context.Context, lines []domain.CartLine) ([]*domain.Item, error) {
+	args := m.Called(ctx, lines)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Item), args.Error(1)
+}
+
 func (m *MockCartService) ListCartItems(ctx context.Context) ([]domain.Item, error) {
 	args := m.Called(ctx)
 	return args.Get(0).([]domain.Item), args.Error(1)
 }
 
+func (m *MockCartService) GetCartItem(ctx context.Context, id int64) (*domain.Item, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Item), args.Error(1)
+}
+
 // create a custom validator here to support failed validation scneario
 type CustomValidator struct {
 	validator *validator.Validate
@@ -47,13 +67,27 @@ func (cv *CustomValidator) Validate(i interface{}) error {
 
 func setupTest() (*echo.Echo, *MockCartService, *Handler) {
 	e := echo.New()
-	e.Validator = &CustomValidator{validator: validator.New()}
+	v := validator.New()
+	v.RegisterTagNameFunc(JSONTagName)
+	e.Validator = &CustomValidator{validator: v}
+	e.HTTPErrorHandler = NewErrorHandler(slog.Default())
 	mockService := new(MockCartService)
 	handler := NewHandler(mockService)
 	handler.Register(e)
 	return e, mockService, handler
 }
 
+// setupSpecTest puts the spec validator in front of the handler like the server does,
+// responses are validated too so a status the spec doesn't allow fails the test
+func setupSpecTest(t *testing.T) (*echo.Echo, *MockCartService) {
+	specValidator, err := openapi.NewValidator(slog.Default(), true)
+	require.NoError(t, err)
+
+	e, mockService, _ := setupTest()
+	e.Use(specValidator.Middleware())
+	return e, mockService
+}
+
 func TestAddItem(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -89,20 +123,51 @@ func TestAddItem(t *testing.T) {
 				// no mock setup needed as validation should fail
 			},
 			expectedStatus: http.StatusBadRequest,
-			expectedBody:   `{"message":"Key: 'AddItemRequest.Name' Error:Field validation for 'Name' failed on the 'required' tag"}`,
+			expectedBody: `{"type":"urn:shopping-cart:problem:validation_failed","title":"Bad Request","status":400,
+				"detail":"the request has invalid fields","instance":"/api/v1/items","code":"validation_failed",
+				"errors":[{"field":"name","code":"required","message":"is required"}]}`,
 		},
 		{
-			name: "service error",
+			name: "invalid request - name too short",
+			requestBody: AddItemRequest{
+				Name:     "T",
+				Quantity: 1,
+			},
+			setupMock: func(_ *MockCartService) {
+				// no mock setup needed as validation should fail
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectedBody: `{"type":"urn:shopping-cart:problem:validation_failed","title":"Bad Request","status":400,
+				"detail":"the request has invalid fields","instance":"/api/v1/items","code":"validation_failed",
+				"errors":[{"field":"name","code":"min","message":"must be at least 2 characters"}]}`,
+		},
+		{
+			name: "service error doesn't leak details",
 			requestBody: AddItemRequest{
 				Name:     "Test Item",
 				Quantity: 1,
 			},
 			setupMock: func(ms *MockCartService) {
 				ms.On("AddItemToCart", mock.Anything, "Test Item", 1).
-					Return(nil, errors.New("internal server error"))
+					Return(nil, errors.New("pq: relation \"items\" does not exist"))
 			},
 			expectedStatus: http.StatusInternalServerError,
-			expectedBody:   `{"message":"internal server error"}`,
+			expectedBody: `{"type":"urn:shopping-cart:problem:internal_error","title":"Internal Server Error","status":500,
+				"detail":"internal server error","instance":"/api/v1/items","code":"internal_error"}`,
+		},
+		{
+			name: "dependency unavailable",
+			requestBody: AddItemRequest{
+				Name:     "Test Item",
+				Quantity: 1,
+			},
+			setupMock: func(ms *MockCartService) {
+				ms.On("AddItemToCart", mock.Anything, "Test Item", 1).
+					Return(nil, domain.NewDependencyUnavailableError("queue_unavailable", "reservation queue is unavailable", errors.New("dial tcp: connection refused")))
+			},
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody: `{"type":"urn:shopping-cart:problem:queue_unavailable","title":"Service Unavailable","status":503,
+				"detail":"reservation queue is unavailable","instance":"/api/v1/items","code":"queue_unavailable"}`,
 		},
 	}
 
@@ -124,11 +189,10 @@ func TestAddItem(t *testing.T) {
 			err = h.AddItem(c)
 			if tt.expectedStatus >= http.StatusBadRequest {
 				assert.Error(t, err)
-				he, ok := err.(*echo.HTTPError)
-				if ok {
-					assert.Equal(t, tt.expectedStatus, he.Code)
-					assert.JSONEq(t, tt.expectedBody, fmt.Sprintf(`{"message":%q}`, he.Message))
-				}
+				e.HTTPErrorHandler(err, c)
+				assert.Equal(t, tt.expectedStatus, rec.Code)
+				assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+				assert.JSONEq(t, tt.expectedBody, rec.Body.String())
 				return
 			}
 
@@ -151,6 +215,168 @@ func TestAddItem(t *testing.T) {
 	}
 }
 
+func TestAddItemsBatch(t *testing.T) {
+	tests := []struct {
+		name            string
+		requestBody     string
+		setupMock       func(*MockCartService)
+		expectedStatus  int
+		expectedResults []BatchItemResult
+	}{
+		{
+			name:        "every line created",
+			requestBody: `{"items":[{"name":"laptop","quantity":1},{"name":"phone","quantity":2}]}`,
+			setupMock: func(ms *MockCartService) {
+				ms.On("AddItemsToCart", mock.Anything, []domain.CartLine{{Name: "laptop", Quantity: 1}, {Name: "phone", Quantity: 2}}).
+					Return([]*domain.Item{{ID: 1, Name: "laptop"}, {ID: 2, Name: "phone"}}, nil)
+			},
+			expectedStatus: http.StatusCreated,
+			expectedResults: []BatchItemResult{
+				{Index: 0, Status: http.StatusCreated, Item: &domain.Item{ID: 1, Name: "laptop"}},
+				{Index: 1, Status: http.StatusCreated, Item: &domain.Item{ID: 2, Name: "phone"}},
+			},
+		},
+		{
+			name:        "invalid line is reported and the others are created",
+			requestBody: `{"items":[{"name":"laptop","quantity":0},{"name":"phone","quantity":2}]}`,
+			setupMock: func(ms *MockCartService) {
+				ms.On("AddItemsToCart", mock.Anything, []domain.CartLine{{Name: "phone", Quantity: 2}}).
+					Return([]*domain.Item{{ID: 2, Name: "phone"}}, nil)
+			},
+			expectedStatus: http.StatusMultiStatus,
+			expectedResults: []BatchItemResult{
+				{Index: 0, Status: http.StatusBadRequest, Error: &Problem{
+					Type: "urn:shopping-cart:problem:validation_failed", Title: "Bad Request", Status: http.StatusBadRequest,
+					Detail: "the request has invalid fields", Code: CodeValidationFailed,
+					Errors: []FieldError{{Field: "quantity", Code: "required", Message: "is required"}},
+				}},
+				{Index: 1, Status: http.StatusCreated, Item: &domain.Item{ID: 2, Name: "phone"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, mockService, h := setupTest()
+			tt.setupMock(mockService)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/items:batch", strings.NewReader(tt.requestBody))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			c := e.NewContext(req, rec)
+
+			err := h.AddItemsBatch(c)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+
+			var resp AddItemsBatchResponse
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+			for i := range resp.Results {
+				if resp.Results[i].Item != nil {
+					resp.Results[i].Item.CreatedAt = tt.expectedResults[i].Item.CreatedAt
+					resp.Results[i].Item.UpdatedAt = tt.expectedResults[i].Item.UpdatedAt
+				}
+			}
+			assert.Equal(t, tt.expectedResults, resp.Results)
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAddItemsBatchServiceError(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "unauthenticated",
+			err:            domain.ErrUnauthenticated,
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "unauthenticated",
+		},
+		{
+			name:           "queue unavailable",
+			err:            domain.NewDependencyUnavailableError("queue_unavailable", "reservation queue is unavailable", errors.New("queue error")),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "queue_unavailable",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// nothing was added, the request fails with the status of the error rather than a 207
+			e, mockService := setupSpecTest(t)
+			mockService.On("AddItemsToCart", mock.Anything, []domain.CartLine{{Name: "laptop", Quantity: 1}}).Return(nil, tt.err)
+
+			body := `{"items":[{"name":"laptop","quantity":1},{"name":"p","quantity":1}]}`
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/items:batch", strings.NewReader(body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			var problem Problem
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Equal(t, tt.expectedCode, problem.Code)
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAddItemsBatchSize(t *testing.T) {
+	tests := []struct {
+		name           string
+		requestBody    string
+		expectedStatus int
+		expectedCode   string
+	}{
+		{
+			name:           "empty batch",
+			requestBody:    `{"items":[]}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   CodeValidationFailed,
+		},
+		{
+			name:           "batch too large",
+			requestBody:    `{"items":[` + strings.Repeat(`{"name":"laptop","quantity":1},`, domain.MaxBatchSize) + `{"name":"laptop","quantity":1}]}`,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "batch_too_large",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the spec validator runs first, an oversized batch must still reach the handler
+			e, _ := setupSpecTest(t)
+
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/items:batch", strings.NewReader(tt.requestBody))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			var problem Problem
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Equal(t, tt.expectedCode, problem.Code)
+		})
+	}
+}
+
+func TestBatchRouteIsNotAParameter(t *testing.T) {
+	e, mockService, _ := setupTest()
+	mockService.On("AddItemsToCart", mock.Anything, mock.Anything).Return([]*domain.Item{{ID: 1}}, nil)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/items:batch", strings.NewReader(`{"items":[{"name":"laptop","quantity":1}]}`))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	assert.Equal(t, http.StatusCreated, rec.Code)
+}
+
 func TestListItems(t *testing.T) {
 	tests := []struct {
 		name           string
@@ -199,7 +425,8 @@ func TestListItems(t *testing.T) {
 					Return([]domain.Item{}, errors.New("internal server error"))
 			},
 			expectedStatus: http.StatusInternalServerError,
-			expectedBody:   `{"message":"internal server error"}`,
+			expectedBody: `{"type":"urn:shopping-cart:problem:internal_error","title":"Internal Server Error","status":500,
+				"detail":"internal server error","instance":"/api/v1/items","code":"internal_error"}`,
 		},
 	}
 
@@ -215,11 +442,9 @@ func TestListItems(t *testing.T) {
 			err := h.ListItems(c)
 			if tt.expectedStatus >= http.StatusBadRequest {
 				assert.Error(t, err)
-				he, ok := err.(*echo.HTTPError)
-				if ok {
-					assert.Equal(t, tt.expectedStatus, he.Code)
-					assert.JSONEq(t, tt.expectedBody, fmt.Sprintf(`{"message":%q}`, he.Message))
-				}
+				e.HTTPErrorHandler(err, c)
+				assert.Equal(t, tt.expectedStatus, rec.Code)
+				assert.JSONEq(t, tt.expectedBody, rec.Body.String())
 				return
 			}
 
@@ -250,3 +475,30 @@ func TestListItems(t *testing.T) {
 		})
 	}
 }
+
+// TestRoutesAreDocumented makes sure the OpenAPI spec can't drift from the registered routes
+func TestRoutesAreDocumented(t *testing.T) {
+	e, _, _ := setupTest()
+	NewAdminHandler(new(MockQueueAdmin), new(MockOutbox)).Register(e)
+
+	doc, err := openapi.Load()
+	require.NoError(t, err)
+
+	// echo writes path parameters as :id, OpenAPI as {id}
+	param := regexp.MustCompile(`/:(\w+)`)
+	for _, route := range e.Routes() {
+		path := doc.Paths.Find(param.ReplaceAllString(strings.ReplaceAll(route.Path, `\:`, ":"), "/{$1}"))
+		if assert.NotNil(t, path, "route %s is not documented", route.Path) {
+			assert.NotNil(t, path.GetOperation(route.Method), "route %s %s is not documented", route.Method, route.Path)
+		}
+	}
+
+	// every AddItemRequest field must be in the request schema
+	schema := doc.Components.Schemas["AddItemRequest"].Value
+	requestType := reflect.TypeOf(AddItemRequest{})
+	for i := 0; i < requestType.NumField(); i++ {
+		name := strings.Split(requestType.Field(i).Tag.Get("json"), ",")[0]
+		assert.Contains(t, schema.Properties, name)
+		assert.Contains(t, schema.Required, name)
+	}
+}
@@ -0,0 +1,200 @@
+package openapi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/labstack/echo/v4"
+)
+
+// RequestValidationError is the reason a request was rejected, Field is empty when the error isn't about a single field
+type RequestValidationError struct {
+	Field  string
+	Reason string
+	Err    error
+}
+
+func (e *RequestValidationError) Error() string {
+	if e.Field != "" {
+		return fmt.Sprintf("invalid field %q: %s", e.Field, e.Reason)
+	}
+	return e.Reason
+}
+
+func (e *RequestValidationError) Unwrap() error {
+	return e.Err
+}
+
+// ResponseValidationError is returned in place of a response that doesn't match the spec
+type ResponseValidationError struct {
+	Status int
+	Err    error
+}
+
+func (e *ResponseValidationError) Error() string {
+	return fmt.Sprintf("response with status %d does not match the API specification: %s", e.Status, validationReason(e.Err))
+}
+
+func (e *ResponseValidationError) Unwrap() error {
+	return e.Err
+}
+
+// Validator checks requests, and optionally responses, against the OpenAPI document
+type Validator struct {
+	router            routers.Router
+	validateResponses bool
+	logger            *slog.Logger
+}
+
+// NewValidator creates a validator for the embedded spec.
+// Response validation buffers every response, so it is meant for tests and not for production traffic.
+func NewValidator(logger *slog.Logger, validateResponses bool) (*Validator, error) {
+	doc, err := Load()
+	if err != nil {
+		return nil, err
+	}
+
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("error creating openapi router: %w", err)
+	}
+
+	return &Validator{
+		router:            router,
+		validateResponses: validateResponses,
+		logger:            logger,
+	}, nil
+}
+
+// Middleware returns the echo middleware, routes that are not in the spec are passed through untouched
+func (v *Validator) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+
+			route, pathParams, err := v.router.FindRoute(req)
+			if err != nil {
+				return next(c)
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: pathParams,
+				Route:      route,
+				Options: &openapi3filter.Options{
+					AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+				},
+			}
+			if err := openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+				validationErr := newRequestValidationError(err)
+				return echo.NewHTTPError(http.StatusBadRequest, validationErr.Error()).SetInternal(validationErr)
+			}
+
+			if !v.validateResponses {
+				return next(c)
+			}
+
+			return v.validateResponse(c, next, input)
+		}
+	}
+}
+
+// validateResponse captures the response written by the handler (or by the error handler)
+// and only forwards it to the client once it matches the spec
+func (v *Validator) validateResponse(c echo.Context, next echo.HandlerFunc, input *openapi3filter.RequestValidationInput) error {
+	res := c.Response()
+	original := res.Writer
+	recorder := &responseRecorder{header: original.Header(), status: http.StatusOK}
+	res.Writer = recorder
+
+	if err := next(c); err != nil {
+		c.Error(err)
+	}
+	res.Writer = original
+
+	responseInput := &openapi3filter.ResponseValidationInput{
+		RequestValidationInput: input,
+		Status:                 recorder.status,
+		Header:                 recorder.header,
+		Options: &openapi3filter.Options{
+			IncludeResponseStatus: true,
+		},
+	}
+	responseInput.SetBodyBytes(recorder.body.Bytes())
+
+	if err := openapi3filter.ValidateResponse(c.Request().Context(), responseInput); err != nil {
+		validationErr := &ResponseValidationError{Status: recorder.status, Err: err}
+		v.logger.Error("response does not match the openapi spec",
+			"method", c.Request().Method,
+			"path", c.Path(),
+			"error", validationErr.Error(),
+		)
+
+		// nothing reached the client yet, so the error handler can still write its own response
+		res.Committed = false
+		res.Status = http.StatusOK
+		res.Size = 0
+		return echo.NewHTTPError(http.StatusInternalServerError, validationErr.Error()).SetInternal(validationErr)
+	}
+
+	original.WriteHeader(recorder.status)
+	_, err := original.Write(recorder.body.Bytes())
+	return err
+}
+
+// newRequestValidationError keeps the reason and the offending field but drops the schema dump kin-openapi appends
+func newRequestValidationError(err error) *RequestValidationError {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		return &RequestValidationError{
+			Field:  strings.Join(schemaErr.JSONPointer(), "."),
+			Reason: schemaErr.Reason,
+			Err:    err,
+		}
+	}
+
+	return &RequestValidationError{Reason: validationReason(err), Err: err}
+}
+
+func validationReason(err error) string {
+	var schemaErr *openapi3.SchemaError
+	if errors.As(err, &schemaErr) {
+		if field := strings.Join(schemaErr.JSONPointer(), "."); field != "" {
+			return fmt.Sprintf("invalid field %q: %s", field, schemaErr.Reason)
+		}
+		return schemaErr.Reason
+	}
+
+	var requestErr *openapi3filter.RequestError
+	if errors.As(err, &requestErr) && requestErr.Err == nil {
+		return requestErr.Reason
+	}
+
+	return err.Error()
+}
+
+type responseRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	return r.body.Write(b)
+}
@@ -0,0 +1,198 @@
+package openapi
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestServer(t *testing.T, validateResponses bool, handler echo.HandlerFunc) *echo.Echo {
+	validator, err := NewValidator(slog.Default(), validateResponses)
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.Use(validator.Middleware())
+	e.POST("/api/v1/items", handler)
+	e.GET("/api/v1/items", handler)
+	e.GET("/unknown", handler)
+	Register(e)
+	return e
+}
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	require.NoError(t, err)
+	assert.NotNil(t, doc.Paths.Find("/api/v1/items"))
+}
+
+func TestValidatorRequests(t *testing.T) {
+	created := func(c echo.Context) error {
+		return c.JSON(http.StatusCreated, domain.Item{ID: 1, Name: "laptop", Quantity: 1, Status: domain.StatusReservationPending})
+	}
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "valid request",
+			method:         http.MethodPost,
+			path:           "/api/v1/items",
+			body:           `{"name":"laptop","quantity":1}`,
+			expectedStatus: http.StatusCreated,
+		},
+		{
+			name:           "name too short",
+			method:         http.MethodPost,
+			path:           "/api/v1/items",
+			body:           `{"name":"l","quantity":1}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"message":"invalid field \"name\": minimum string length is 2"}`,
+		},
+		{
+			name:           "quantity has wrong type",
+			method:         http.MethodPost,
+			path:           "/api/v1/items",
+			body:           `{"name":"laptop","quantity":"one"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"message":"invalid field \"quantity\": value must be an integer"}`,
+		},
+		{
+			name:           "missing body",
+			method:         http.MethodPost,
+			path:           "/api/v1/items",
+			expectedStatus: http.StatusBadRequest,
+			expectedBody:   `{"message":"request body has an error: value is required but missing"}`,
+		},
+		{
+			name:           "route not in spec is passed through",
+			method:         http.MethodGet,
+			path:           "/unknown",
+			expectedStatus: http.StatusCreated,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := setupTestServer(t, false, created)
+
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			if tt.body != "" {
+				req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			if tt.expectedBody != "" {
+				assert.JSONEq(t, tt.expectedBody, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestValidatorResponses(t *testing.T) {
+	reservationID := "RSV-1"
+	now := time.Now()
+
+	tests := []struct {
+		name           string
+		handler        echo.HandlerFunc
+		expectedStatus int
+	}{
+		{
+			name: "item matches the spec",
+			handler: func(c echo.Context) error {
+				return c.JSON(http.StatusOK, []domain.Item{{
+					ID:            1,
+					Name:          "laptop",
+					Quantity:      1,
+					ReservationID: &reservationID,
+					Status:        domain.StatusReservationReserved,
+					CreatedAt:     now,
+					UpdatedAt:     now,
+				}})
+			},
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name: "undocumented field",
+			handler: func(c echo.Context) error {
+				return c.JSON(http.StatusOK, []map[string]interface{}{{
+					"id": 1, "name": "laptop", "quantity": 1, "status": "PENDING",
+					"created_at": now, "updated_at": now, "price": 10,
+				}})
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "unknown status",
+			handler: func(c echo.Context) error {
+				return c.JSON(http.StatusOK, []domain.Item{{ID: 1, Name: "laptop", Quantity: 1, Status: "LOST", CreatedAt: now, UpdatedAt: now}})
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "documented error",
+			handler: func(c echo.Context) error {
+				return c.Blob(http.StatusInternalServerError, "application/problem+json",
+					[]byte(`{"type":"urn:shopping-cart:problem:internal_error","title":"Internal Server Error","status":500,"code":"internal_error"}`))
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "error in the wrong format",
+			handler: func(c echo.Context) error {
+				return echo.NewHTTPError(http.StatusInternalServerError, "db error")
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+		{
+			name: "undocumented status",
+			handler: func(c echo.Context) error {
+				return c.NoContent(http.StatusAccepted)
+			},
+			expectedStatus: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := setupTestServer(t, true, tt.handler)
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/items", nil)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+		})
+	}
+}
+
+func TestServeSpecAndDocs(t *testing.T) {
+	e := setupTestServer(t, true, nil)
+
+	req := httptest.NewRequest(http.MethodGet, SpecPath, nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, string(Spec()), rec.Body.String())
+
+	req = httptest.NewRequest(http.MethodGet, DocsPath, nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), SpecPath)
+}
@@ -0,0 +1,67 @@
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"fmt"
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	SpecPath = "/api/v1/openapi.json"
+	DocsPath = "/api/v1/docs"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+// docsPage renders the embedded spec with Redoc so humans don't have to read raw JSON
+const docsPage = `<!DOCTYPE html>
+<html>
+  <head>
+    <title>Shopping Cart API</title>
+    <meta charset="utf-8"/>
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+  </head>
+  <body>
+    <redoc spec-url="` + SpecPath + `"></redoc>
+    <script src="https://cdn.redoc.ly/redoc/latest/bundles/redoc.standalone.js"></script>
+  </body>
+</html>`
+
+// Spec returns the raw OpenAPI document
+func Spec() []byte {
+	return spec
+}
+
+// Load parses and validates the embedded OpenAPI document
+func Load() (*openapi3.T, error) {
+	loader := openapi3.NewLoader()
+	doc, err := loader.LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("error loading openapi spec: %w", err)
+	}
+
+	if err := doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("invalid openapi spec: %w", err)
+	}
+
+	return doc, nil
+}
+
+// Register registers the spec and the docs page
+func Register(e *echo.Echo) {
+	e.GET(SpecPath, ServeSpec)
+	e.GET(DocsPath, ServeDocs)
+}
+
+func ServeSpec(c echo.Context) error {
+	return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, spec)
+}
+
+func ServeDocs(c echo.Context) error {
+	return c.HTML(http.StatusOK, docsPage)
+}
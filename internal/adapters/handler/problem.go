@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator"
+	"github.com/labstack/echo/v4"
+
+	"github.com/a-berahman/shopping-cart/internal/adapters/handler/openapi"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+// MIMEApplicationProblemJSON is the media type of RFC 7807 problem details
+const MIMEApplicationProblemJSON = "application/problem+json"
+
+// problemTypePrefix makes the stable error code a URI, as RFC 7807 expects for the type member
+const problemTypePrefix = "urn:shopping-cart:problem:"
+
+const (
+	CodeValidationFailed = "validation_failed"
+	CodeInternalError    = "internal_error"
+)
+
+// Problem is an RFC 7807 problem details object, Code is the stable identifier clients should switch on
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError is a validation failure of a single request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+var domainStatuses = map[domain.ErrorKind]int{
+	domain.ErrorKindNotFound:              http.StatusNotFound,
+	domain.ErrorKindInvalidTransition:     http.StatusConflict,
+	domain.ErrorKindPolicyViolation:       http.StatusUnprocessableEntity,
+	domain.ErrorKindDependencyUnavailable: http.StatusServiceUnavailable,
+	domain.ErrorKindUnauthenticated:       http.StatusUnauthorized,
+	domain.ErrorKindForbidden:             http.StatusForbidden,
+	domain.ErrorKindInvalidInput:          http.StatusBadRequest,
+}
+
+// NewProblem maps an error to a problem, details of unexpected errors are never exposed
+func NewProblem(err error) *Problem {
+	var domainErr *domain.Error
+	if errors.As(err, &domainErr) {
+		if status, ok := domainStatuses[domainErr.Kind]; ok {
+			problem := newProblem(status, domainErr.Code, domainErr.Message)
+			for _, violation := range domainErr.Violations {
+				problem.Errors = append(problem.Errors, FieldError(violation))
+			}
+			return problem
+		}
+	}
+
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		problem := newProblem(http.StatusBadRequest, CodeValidationFailed, "the request has invalid fields")
+		for _, fe := range validationErrs {
+			problem.Errors = append(problem.Errors, newFieldError(fe))
+		}
+		return problem
+	}
+
+	var specErr *openapi.RequestValidationError
+	if errors.As(err, &specErr) {
+		problem := newProblem(http.StatusBadRequest, CodeValidationFailed, specErr.Reason)
+		if specErr.Field != "" {
+			problem.Detail = "the request has invalid fields"
+			problem.Errors = []FieldError{{Field: specErr.Field, Code: "schema", Message: specErr.Reason}}
+		}
+		return problem
+	}
+
+	var httpErr *echo.HTTPError
+	if errors.As(err, &httpErr) && httpErr.Code < http.StatusInternalServerError {
+		return newProblem(httpErr.Code, statusCode(httpErr.Code), fmt.Sprint(httpErr.Message))
+	}
+
+	return newProblem(http.StatusInternalServerError, CodeInternalError, "internal server error")
+}
+
+func newProblem(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   problemTypePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// statusCode derives a stable code from the HTTP status, e.g. 405 becomes "method_not_allowed"
+func statusCode(status int) string {
+	return strings.ToLower(strings.ReplaceAll(http.StatusText(status), " ", "_"))
+}
+
+// NewErrorHandler returns the echo error handler that renders every error as problem+json
+func NewErrorHandler(logger *slog.Logger) echo.HTTPErrorHandler {
+	return func(err error, c echo.Context) {
+		if c.Response().Committed {
+			return
+		}
+
+		problem := NewProblem(err)
+		problem.Instance = c.Request().URL.Path
+		if problem.Status >= http.StatusInternalServerError {
+			logger.Error("request failed",
+				"method", c.Request().Method,
+				"path", c.Path(),
+				"status", problem.Status,
+				"error", err,
+			)
+		}
+
+		if writeErr := writeProblem(c, problem); writeErr != nil {
+			logger.Error("failed to write error response", "error", writeErr)
+		}
+	}
+}
+
+func writeProblem(c echo.Context, problem *Problem) error {
+	if c.Request().Method == http.MethodHead {
+		return c.NoContent(problem.Status)
+	}
+
+	body, err := json.Marshal(problem)
+	if err != nil {
+		return err
+	}
+	return c.Blob(problem.Status, MIMEApplicationProblemJSON, body)
+}
+
+// JSONTagName makes the validator report fields by their JSON name, register it with RegisterTagNameFunc
+func JSONTagName(field reflect.StructField) string {
+	name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+	if name == "-" {
+		return ""
+	}
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+func newFieldError(fe validator.FieldError) FieldError {
+	return FieldError{
+		Field:   fe.Field(),
+		Code:    fe.Tag(),
+		Message: fieldErrorMessage(fe),
+	}
+}
+
+func fieldErrorMessage(fe validator.FieldError) string {
+	unit := ""
+	if fe.Kind() == reflect.String {
+		unit = " characters"
+	}
+
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return fmt.Sprintf("must be at least %s%s", fe.Param(), unit)
+	case "max":
+		return fmt.Sprintf("must be at most %s%s", fe.Param(), unit)
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", strings.ReplaceAll(fe.Param(), " ", ", "))
+	default:
+		return fmt.Sprintf("failed on the %q rule", fe.Tag())
+	}
+}
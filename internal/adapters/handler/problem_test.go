@@ -0,0 +1,165 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+
+	"github.com/a-berahman/shopping-cart/internal/adapters/handler/openapi"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+func TestNewProblem(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		expectedStatus int
+		expectedCode   string
+		expectedDetail string
+	}{
+		{
+			name:           "not found",
+			err:            fmt.Errorf("getting item: %w", domain.ErrItemNotFound),
+			expectedStatus: http.StatusNotFound,
+			expectedCode:   "item_not_found",
+			expectedDetail: "item not found",
+		},
+		{
+			name:           "invalid transition",
+			err:            (&domain.Item{Status: domain.StatusReservationReserved}).TransitionTo(domain.StatusReservationPending),
+			expectedStatus: http.StatusConflict,
+			expectedCode:   "invalid_item_transition",
+			expectedDetail: "item can't move from RESERVED to PENDING",
+		},
+		{
+			name:           "policy violation",
+			err:            domain.ErrBatchTooLarge,
+			expectedStatus: http.StatusUnprocessableEntity,
+			expectedCode:   "batch_too_large",
+			expectedDetail: "a batch can't have more than 100 items",
+		},
+		{
+			name:           "dependency unavailable hides the cause",
+			err:            domain.NewDependencyUnavailableError("database_unavailable", "database is unavailable", errors.New("dial tcp 10.0.0.1:5432")),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedCode:   "database_unavailable",
+			expectedDetail: "database is unavailable",
+		},
+		{
+			name:           "unauthenticated",
+			err:            domain.ErrUnauthenticated,
+			expectedStatus: http.StatusUnauthorized,
+			expectedCode:   "unauthenticated",
+			expectedDetail: "authentication is required",
+		},
+		{
+			name:           "forbidden",
+			err:            domain.ErrForbidden,
+			expectedStatus: http.StatusForbidden,
+			expectedCode:   "forbidden",
+			expectedDetail: "you are not allowed to perform this operation",
+		},
+		{
+			name:           "echo error",
+			err:            echo.ErrMethodNotAllowed,
+			expectedStatus: http.StatusMethodNotAllowed,
+			expectedCode:   "method_not_allowed",
+			expectedDetail: "Method Not Allowed",
+		},
+		{
+			name:           "spec violation",
+			err:            echo.NewHTTPError(http.StatusBadRequest).SetInternal(&openapi.RequestValidationError{Field: "quantity", Reason: "value must be an integer"}),
+			expectedStatus: http.StatusBadRequest,
+			expectedCode:   CodeValidationFailed,
+			expectedDetail: "the request has invalid fields",
+		},
+		{
+			name:           "unexpected error",
+			err:            errors.New("pq: syntax error at or near \"SELEC\""),
+			expectedStatus: http.StatusInternalServerError,
+			expectedCode:   CodeInternalError,
+			expectedDetail: "internal server error",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			problem := NewProblem(tt.err)
+			assert.Equal(t, tt.expectedStatus, problem.Status)
+			assert.Equal(t, tt.expectedCode, problem.Code)
+			assert.Equal(t, tt.expectedDetail, problem.Detail)
+			assert.Equal(t, "urn:shopping-cart:problem:"+tt.expectedCode, problem.Type)
+			assert.Equal(t, http.StatusText(tt.expectedStatus), problem.Title)
+		})
+	}
+}
+
+// TestProblemsMatchSpec runs requests through the spec validator in test mode,
+// so every problem the API can return has to match the documented schema
+func TestProblemsMatchSpec(t *testing.T) {
+	e, mockService, _ := setupTest()
+	specValidator, err := openapi.NewValidator(slog.Default(), true)
+	require.NoError(t, err)
+	e.Use(specValidator.Middleware())
+
+	mockService.On("ListCartItems", mock.Anything).
+		Return([]domain.Item{}, domain.NewDependencyUnavailableError("database_unavailable", "database is unavailable", errors.New("timeout")))
+
+	tests := []struct {
+		name           string
+		method         string
+		path           string
+		body           string
+		expectedStatus int
+		expectedErrors []FieldError
+	}{
+		{
+			name:           "spec violation is a field error",
+			method:         http.MethodPost,
+			path:           "/api/v1/items",
+			body:           `{"name":"laptop","quantity":"one"}`,
+			expectedStatus: http.StatusBadRequest,
+			expectedErrors: []FieldError{{Field: "quantity", Code: "schema", Message: "value must be an integer"}},
+		},
+		{
+			name:           "dependency unavailable",
+			method:         http.MethodGet,
+			path:           "/api/v1/items",
+			expectedStatus: http.StatusServiceUnavailable,
+		},
+		{
+			name:           "unknown route",
+			method:         http.MethodGet,
+			path:           "/api/v1/unknown",
+			expectedStatus: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.Equal(t, MIMEApplicationProblemJSON, rec.Header().Get(echo.HeaderContentType))
+
+			var problem Problem
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &problem))
+			assert.Equal(t, tt.expectedStatus, problem.Status)
+			assert.Equal(t, tt.path, problem.Instance)
+			assert.Equal(t, tt.expectedErrors, problem.Errors)
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Status of a single check or of the whole report
+type Status string
+
+const (
+	StatusOK           Status = "ok"
+	StatusFailing      Status = "failing"
+	StatusShuttingDown Status = "shutting_down"
+)
+
+// Check is a named dependency check, it must respect the context deadline
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+	// State optionally describes the dependency beyond passing or failing, like the state of its circuit breaker
+	State func() string
+}
+
+type Result struct {
+	Name       string    `json:"name"`
+	Status     Status    `json:"status"`
+	Error      string    `json:"error,omitempty"`
+	State      string    `json:"state,omitempty"`
+	DurationMS int64     `json:"duration_ms"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+type Report struct {
+	Status    Status    `json:"status"`
+	Checks    []Result  `json:"checks"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Cached is true when the checks didn't run for this report
+	Cached bool `json:"cached"`
+}
+
+// Checker runs the dependency checks behind readiness.
+// results are cached, otherwise every probe of every orchestrator node would ping the database
+type Checker struct {
+	checks   []Check
+	timeout  time.Duration
+	cacheTTL time.Duration
+
+	mu   sync.Mutex
+	last *Report
+	// running is the run of the checks in progress, nil while none runs
+	running *checkRun
+
+	shuttingDown atomic.Bool
+}
+
+// checkRun is a run of the checks, report is set once done is closed
+type checkRun struct {
+	done   chan struct{}
+	report Report
+}
+
+// NewChecker creates a checker, every check gets its own timeout so one slow dependency
+// doesn't hide the state of the others
+func NewChecker(timeout, cacheTTL time.Duration, checks ...Check) *Checker {
+	return &Checker{
+		checks:   checks,
+		timeout:  timeout,
+		cacheTTL: cacheTTL,
+	}
+}
+
+// ShutDown makes readiness fail from now on, so the orchestrator stops routing traffic to us
+// while we drain
+func (c *Checker) ShutDown() {
+	c.shuttingDown.Store(true)
+}
+
+// ShuttingDown reports whether ShutDown was called
+func (c *Checker) ShuttingDown() bool {
+	return c.shuttingDown.Load()
+}
+
+// Ready reports whether every check passes and we aren't shutting down
+func (c *Checker) Ready(ctx context.Context) (Report, bool) {
+	report := c.Report(ctx)
+	if c.ShuttingDown() {
+		report.Status = StatusShuttingDown
+	}
+	return report, report.Status == StatusOK
+}
+
+// Report returns the cached report, or runs the checks concurrently when it is stale.
+// concurrent callers wait for the same run instead of starting their own. the checks don't run
+// under ctx, a caller giving up only stops waiting and gets a failing report that isn't cached
+func (c *Checker) Report(ctx context.Context) Report {
+	c.mu.Lock()
+	if c.last != nil && time.Since(c.last.CheckedAt) < c.cacheTTL {
+		report := *c.last
+		report.Cached = true
+		c.mu.Unlock()
+		return report
+	}
+	run := c.running
+	if run == nil {
+		run = &checkRun{done: make(chan struct{})}
+		c.running = run
+		go c.runChecks(context.WithoutCancel(ctx), run)
+	}
+	c.mu.Unlock()
+
+	select {
+	case <-run.done:
+		return run.report
+	case <-ctx.Done():
+		return c.cancelled(ctx.Err())
+	}
+}
+
+// runChecks runs every check concurrently and caches the report
+func (c *Checker) runChecks(ctx context.Context, run *checkRun) {
+	report := Report{
+		Status:    StatusOK,
+		Checks:    make([]Result, len(c.checks)),
+		CheckedAt: time.Now(),
+	}
+
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			report.Checks[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	for _, result := range report.Checks {
+		if result.Status != StatusOK {
+			report.Status = StatusFailing
+		}
+	}
+
+	c.mu.Lock()
+	c.last = &report
+	c.running = nil
+	c.mu.Unlock()
+	run.report = report
+	close(run.done)
+}
+
+// cancelled is the report of a caller that stopped waiting for the checks with err
+func (c *Checker) cancelled(err error) Report {
+	report := Report{
+		Status:    StatusFailing,
+		Checks:    make([]Result, len(c.checks)),
+		CheckedAt: time.Now(),
+	}
+	for i, check := range c.checks {
+		report.Checks[i] = Result{Name: check.Name, Status: StatusFailing, Error: err.Error(), CheckedAt: report.CheckedAt}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := check.Check(ctx)
+	result := Result{
+		Name:       check.Name,
+		Status:     StatusOK,
+		DurationMS: time.Since(start).Milliseconds(),
+		CheckedAt:  start,
+	}
+	if err != nil {
+		result.Status = StatusFailing
+		result.Error = err.Error()
+	}
+	if check.State != nil {
+		result.State = check.State()
+	}
+	return result
+}
@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func passing(name string, calls *atomic.Int32) Check {
+	return Check{Name: name, Check: func(ctx context.Context) error {
+		calls.Add(1)
+		return nil
+	}}
+}
+
+func TestReport(t *testing.T) {
+	var calls atomic.Int32
+	slow := Check{Name: "slow", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}}
+	broken := Check{Name: "broken", Check: func(ctx context.Context) error {
+		return errors.New("connection refused")
+	}}
+
+	checker := NewChecker(50*time.Millisecond, 0, passing("postgres", &calls), slow, broken)
+
+	start := time.Now()
+	report := checker.Report(context.Background())
+	// the checks run concurrently, so the slow one doesn't add up with the others
+	assert.Less(t, time.Since(start), time.Second)
+
+	assert.Equal(t, StatusFailing, report.Status)
+	assert.Equal(t, "postgres", report.Checks[0].Name)
+	assert.Equal(t, StatusOK, report.Checks[0].Status)
+	assert.Equal(t, StatusFailing, report.Checks[1].Status)
+	assert.Equal(t, context.DeadlineExceeded.Error(), report.Checks[1].Error)
+	assert.Equal(t, "connection refused", report.Checks[2].Error)
+}
+
+func TestReportHasState(t *testing.T) {
+	var calls atomic.Int32
+	withState := passing("reservation", &calls)
+	withState.State = func() string { return "open" }
+	checker := NewChecker(time.Second, 0, withState, passing("redis", &calls))
+
+	report := checker.Report(context.Background())
+	assert.Equal(t, "open", report.Checks[0].State)
+	assert.Empty(t, report.Checks[1].State)
+}
+
+func TestReportIsCached(t *testing.T) {
+	var calls atomic.Int32
+	checker := NewChecker(time.Second, time.Minute, passing("redis", &calls))
+
+	first := checker.Report(context.Background())
+	second := checker.Report(context.Background())
+
+	assert.Equal(t, int32(1), calls.Load())
+	assert.False(t, first.Cached)
+	assert.True(t, second.Cached)
+	assert.Equal(t, first.CheckedAt, second.CheckedAt)
+
+	checker.cacheTTL = 0
+	checker.Report(context.Background())
+	assert.Equal(t, int32(2), calls.Load())
+}
+
+func TestReportOutlivesCancelledCaller(t *testing.T) {
+	var calls atomic.Int32
+	release := make(chan struct{})
+	held := Check{Name: "postgres", Check: func(ctx context.Context) error {
+		calls.Add(1)
+		select {
+		case <-release:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}}
+	checker := NewChecker(time.Second, time.Minute, held)
+
+	// the first caller gives up while the check runs, only its own report fails
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	first := checker.Report(ctx)
+	assert.Equal(t, StatusFailing, first.Status)
+	assert.Equal(t, context.Canceled.Error(), first.Checks[0].Error)
+
+	// the next caller waits for the same run, which wasn't cancelled
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	next := checker.Report(context.Background())
+	assert.Equal(t, StatusOK, next.Status)
+	assert.False(t, next.Cached)
+	assert.Equal(t, int32(1), calls.Load())
+}
+
+func TestReadyWhileShuttingDown(t *testing.T) {
+	var calls atomic.Int32
+	checker := NewChecker(time.Second, 0, passing("redis", &calls))
+
+	_, ready := checker.Ready(context.Background())
+	assert.True(t, ready)
+
+	checker.ShutDown()
+	report, ready := checker.Ready(context.Background())
+	assert.False(t, ready)
+	assert.Equal(t, StatusShuttingDown, report.Status)
+}
@@ -0,0 +1,77 @@
+package health
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+	DebugPath     = "/debug/health"
+)
+
+type Handler struct {
+	checker *Checker
+	started time.Time
+}
+
+// ProbeResponse is all the orchestrator gets, check errors are only shown on the debug route
+type ProbeResponse struct {
+	Status Status            `json:"status"`
+	Checks map[string]Status `json:"checks,omitempty"`
+}
+
+type DebugResponse struct {
+	Report
+	Ready         bool    `json:"ready"`
+	ShuttingDown  bool    `json:"shutting_down"`
+	UptimeSeconds float64 `json:"uptime_seconds"`
+}
+
+func NewHandler(checker *Checker) *Handler {
+	return &Handler{
+		checker: checker,
+		started: time.Now(),
+	}
+}
+
+// Register registers the probes, debugMiddleware guards the debug route only,
+// probes must stay reachable without credentials
+func (h *Handler) Register(e *echo.Echo, debugMiddleware ...echo.MiddlewareFunc) {
+	e.GET(LivenessPath, h.Liveness)
+	e.GET(ReadinessPath, h.Readiness)
+	e.GET(DebugPath, h.Debug, debugMiddleware...)
+}
+
+// Liveness only tells the process serves requests, failing dependencies must not get us restarted
+func (h *Handler) Liveness(c echo.Context) error {
+	return c.JSON(http.StatusOK, ProbeResponse{Status: StatusOK})
+}
+
+func (h *Handler) Readiness(c echo.Context) error {
+	report, ready := h.checker.Ready(c.Request().Context())
+
+	resp := ProbeResponse{Status: report.Status, Checks: make(map[string]Status, len(report.Checks))}
+	for _, result := range report.Checks {
+		resp.Checks[result.Name] = result.Status
+	}
+
+	if !ready {
+		return c.JSON(http.StatusServiceUnavailable, resp)
+	}
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *Handler) Debug(c echo.Context) error {
+	report, ready := h.checker.Ready(c.Request().Context())
+
+	return c.JSON(http.StatusOK, DebugResponse{
+		Report:        report,
+		Ready:         ready,
+		ShuttingDown:  h.checker.ShuttingDown(),
+		UptimeSeconds: time.Since(h.started).Seconds(),
+	})
+}
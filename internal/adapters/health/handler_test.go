@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTest(checkErr error) (*echo.Echo, *Checker) {
+	checker := NewChecker(time.Second, 0,
+		Check{Name: "postgres", Check: func(ctx context.Context) error { return nil }},
+		Check{Name: "redis", Check: func(ctx context.Context) error { return checkErr }},
+	)
+
+	e := echo.New()
+	NewHandler(checker).Register(e)
+	return e, checker
+}
+
+func TestProbes(t *testing.T) {
+	tests := []struct {
+		name           string
+		path           string
+		checkErr       error
+		shutDown       bool
+		expectedStatus int
+		expectedBody   string
+	}{
+		{
+			name:           "alive while a dependency fails",
+			path:           LivenessPath,
+			checkErr:       errors.New("connection refused"),
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"ok"}`,
+		},
+		{
+			name:           "ready",
+			path:           ReadinessPath,
+			expectedStatus: http.StatusOK,
+			expectedBody:   `{"status":"ok","checks":{"postgres":"ok","redis":"ok"}}`,
+		},
+		{
+			name:           "not ready without the error",
+			path:           ReadinessPath,
+			checkErr:       errors.New("dial tcp 10.0.0.1:6379: connection refused"),
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"status":"failing","checks":{"postgres":"ok","redis":"failing"}}`,
+		},
+		{
+			name:           "not ready while shutting down",
+			path:           ReadinessPath,
+			shutDown:       true,
+			expectedStatus: http.StatusServiceUnavailable,
+			expectedBody:   `{"status":"shutting_down","checks":{"postgres":"ok","redis":"ok"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, checker := setupTest(tt.checkErr)
+			if tt.shutDown {
+				checker.ShutDown()
+			}
+
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			assert.Equal(t, tt.expectedStatus, rec.Code)
+			assert.JSONEq(t, tt.expectedBody, rec.Body.String())
+		})
+	}
+}
+
+func TestDebug(t *testing.T) {
+	e, _ := setupTest(errors.New("connection refused"))
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, DebugPath, nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var resp DebugResponse
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	assert.False(t, resp.Ready)
+	assert.Equal(t, StatusFailing, resp.Status)
+	require.Len(t, resp.Checks, 2)
+	assert.Equal(t, "connection refused", resp.Checks[1].Error)
+}
+
+func TestDebugIsGuarded(t *testing.T) {
+	e := echo.New()
+	deny := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error { return echo.ErrForbidden }
+	}
+	NewHandler(NewChecker(time.Second, 0)).Register(e, deny)
+
+	for path, expected := range map[string]int{
+		LivenessPath:  http.StatusOK,
+		ReadinessPath: http.StatusOK,
+		DebugPath:     http.StatusForbidden,
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		assert.Equal(t, expected, rec.Code, path)
+	}
+}
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// dequeueWithin dequeues a job, or fails with the deadline if none is free within a moment
+func dequeueWithin(queue adminQueue) (*domain.ReservationJob, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*itemPollInterval)
+	defer cancel()
+	return queue.DequeueReservation(ctx)
+}
+
+func TestQueueHoldsItemsInFlight(t *testing.T) {
+	for _, adapter := range queueAdapters {
+		t.Run(adapter.name, func(t *testing.T) {
+			queue, advance := adapter.setup(t)
+			ctx := context.Background()
+			require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+				{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 1}, {ID: "job-3", ItemID: 2},
+			}))
+
+			// the second job of item 1 waits for the first, the job of item 2 doesn't
+			first, err := queue.DequeueReservation(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, "job-1", first.ID)
+			other, err := queue.DequeueReservation(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, "job-3", other.ID)
+			_, err = dequeueWithin(queue)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+			// a delayed retry keeps the item, the later job can't overtake it
+			require.NoError(t, queue.RequeueJob(ctx, first, time.Minute))
+			_, err = dequeueWithin(queue)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+			advance(time.Minute)
+			promoted, err := queue.PromoteDueJobs(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, 1, promoted)
+			first, err = queue.DequeueReservation(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, "job-1", first.ID)
+
+			// once the first job is done the next one of its item is free
+			require.NoError(t, queue.CompleteJob(ctx, first))
+			next, err := queue.DequeueReservation(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, "job-2", next.ID)
+		})
+	}
+}
+
+func TestRedisDequeueWaitsForBusyItem(t *testing.T) {
+	client, _ := setupFrozenRedis(t)
+	queue := &RedisQueue{client: client, visibilityTimeout: 30 * time.Second}
+	ctx := context.Background()
+
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1, Priority: domain.JobPriorityInteractive}))
+	first, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+
+	dequeued := make(chan *domain.ReservationJob)
+	go func() {
+		job, err := queue.DequeueReservation(ctx)
+		assert.NoError(t, err)
+		dequeued <- job
+	}()
+
+	// the blocked dequeue picks up the job as it arrives, but puts it back while its item is busy
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-2", ItemID: 1, Priority: domain.JobPriorityInteractive}))
+	select {
+	case job := <-dequeued:
+		t.Fatalf("job %s was dequeued while its item was busy", job.ID)
+	case <-time.After(3 * itemPollInterval):
+	}
+	assert.Equal(t, []string{"job-2"}, client.LRange(ctx, InteractiveQueueKey, 0, -1).Val())
+
+	require.NoError(t, queue.CompleteJob(ctx, first))
+	select {
+	case job := <-dequeued:
+		assert.Equal(t, "job-2", job.ID)
+	case <-time.After(laneBlockTimeout):
+		t.Fatal("the job wasn't dequeued once its item was free")
+	}
+}
@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"sync"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+// laneWeights is how many of every ten dequeues try a lane first. interactive work goes first most
+// of the time, background work still gets a turn however much else is waiting
+var laneWeights = map[domain.JobPriority]int{
+	domain.JobPriorityInteractive: 6,
+	domain.JobPriorityNormal:      3,
+	domain.JobPriorityBackground:  1,
+}
+
+// laneScheduler takes turns between the lanes by their weight with smooth weighted round robin,
+// which spreads the turns of a lane instead of giving them in a row. the zero value is ready to use
+type laneScheduler struct {
+	mu      sync.Mutex
+	current map[domain.JobPriority]int
+}
+
+// next returns the lanes in the order a dequeue tries them, the lane whose turn it is first and
+// the others by priority, so a turn of an empty lane goes to the highest lane with work
+func (s *laneScheduler) next() []domain.JobPriority {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.current == nil {
+		s.current = make(map[domain.JobPriority]int, len(laneWeights))
+	}
+	turn, total := domain.JobPriorities[0], 0
+	for _, lane := range domain.JobPriorities {
+		s.current[lane] += laneWeights[lane]
+		total += laneWeights[lane]
+		if s.current[lane] > s.current[turn] {
+			turn = lane
+		}
+	}
+	s.current[turn] -= total
+
+	lanes := make([]domain.JobPriority, 0, len(domain.JobPriorities))
+	lanes = append(lanes, turn)
+	for _, lane := range domain.JobPriorities {
+		if lane != turn {
+			lanes = append(lanes, lane)
+		}
+	}
+	return lanes
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLaneSchedulerTakesTurnsByWeight(t *testing.T) {
+	var lanes laneScheduler
+
+	turns := make([]domain.JobPriority, 10)
+	for i := range turns {
+		order := lanes.next()
+		require.Len(t, order, len(domain.JobPriorities))
+		turns[i] = order[0]
+	}
+
+	// the turns of a lane are spread over the round instead of given in a row
+	i, n, b := domain.JobPriorityInteractive, domain.JobPriorityNormal, domain.JobPriorityBackground
+	assert.Equal(t, []domain.JobPriority{i, n, i, i, n, i, b, i, n, i}, turns)
+
+	// the next round starts over, the lanes behind the one whose turn it is follow by priority
+	assert.Equal(t, []domain.JobPriority{i, n, b}, lanes.next())
+	assert.Equal(t, []domain.JobPriority{n, i, b}, lanes.next())
+}
+
+// laneAdapters are the adapters with priority lanes, the streams and postgres queues are first in first out
+var laneAdapters = map[string]bool{"list": true, "memory": true}
+
+func TestQueueServesLanesByWeight(t *testing.T) {
+	for _, adapter := range queueAdapters {
+		if !laneAdapters[adapter.name] {
+			continue
+		}
+		t.Run(adapter.name, func(t *testing.T) {
+			queue, _ := adapter.setup(t)
+			ctx := context.Background()
+
+			// a backlog of background work enqueued before the interactive jobs
+			var jobs []*domain.ReservationJob
+			for _, priority := range []domain.JobPriority{domain.JobPriorityBackground, domain.JobPriorityNormal, domain.JobPriorityInteractive} {
+				for i := 0; i < 10; i++ {
+					jobs = append(jobs, &domain.ReservationJob{ID: fmt.Sprintf("%s-%d", priority, i), ItemID: int64(len(jobs) + 1), Priority: priority})
+				}
+			}
+			require.NoError(t, queue.EnqueueReservations(ctx, jobs))
+
+			depths, err := queue.QueueDepths(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, int64(30), depths[domain.JobStatusPending])
+
+			// pending jobs are listed lane by lane, newest first within a lane
+			pending, total, err := queue.ListJobs(ctx, domain.JobStatusPending, 9, 2)
+			require.NoError(t, err)
+			assert.Equal(t, int64(30), total)
+			assert.Equal(t, []string{"INTERACTIVE-0", "NORMAL-9"}, jobIDs(pending))
+
+			served := map[domain.JobPriority]int{}
+			for i := 0; i < 10; i++ {
+				job, err := queue.DequeueReservation(ctx)
+				require.NoError(t, err)
+				served[job.Lane()]++
+			}
+			assert.Equal(t, map[domain.JobPriority]int{
+				domain.JobPriorityInteractive: 6,
+				domain.JobPriorityNormal:      3,
+				domain.JobPriorityBackground:  1,
+			}, served)
+
+			// every lane is served in order, and a requeued job keeps its lane and goes back to its front
+			job, err := queue.DequeueReservation(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, "INTERACTIVE-6", job.ID)
+			require.NoError(t, queue.RequeueJob(ctx, job, 0))
+			pending, _, err = queue.ListJobs(ctx, domain.JobStatusPending, 3, 1)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"INTERACTIVE-6"}, jobIDs(pending))
+
+			purged, err := queue.PurgeJobs(ctx, domain.JobStatusPending)
+			require.NoError(t, err)
+			assert.Equal(t, int64(20), purged)
+		})
+	}
+}
+
+func TestRedisDequeueWaitsForInteractiveJobs(t *testing.T) {
+	client, _ := setupFrozenRedis(t)
+	queue := &RedisQueue{client: client, visibilityTimeout: 30 * time.Second}
+	ctx := context.Background()
+
+	dequeued := make(chan *domain.ReservationJob)
+	go func() {
+		job, err := queue.DequeueReservation(ctx)
+		assert.NoError(t, err)
+		dequeued <- job
+	}()
+
+	// the dequeue blocks on the interactive lane while every lane is empty
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", Priority: domain.JobPriorityInteractive}))
+	select {
+	case job := <-dequeued:
+		assert.Equal(t, "job-1", job.ID)
+	case <-time.After(laneBlockTimeout / 2):
+		t.Fatal("the interactive job wasn't dequeued as it arrived")
+	}
+}
@@ -0,0 +1,468 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/google/uuid"
+)
+
+// memoryJob is a job of the memory queue, at is when it is due while delayed, when its lease
+// expires while processing and when it finished once completed or failed
+type memoryJob struct {
+	job *domain.ReservationJob
+	at  time.Time
+	// seq orders the jobs of a status by when they got it
+	seq uint64
+}
+
+// MemoryQueue is a queue held in the process, for development and tests. it has the semantics of
+// the redis list queue: the lanes of pending jobs take turns and every lane is dequeued in order,
+// processing jobs are leased, a job holds its item until it is done, failed jobs are kept for replay. jobs are lost with the process,
+// and only workers of the process see them
+type MemoryQueue struct {
+	mu   sync.Mutex
+	jobs map[string]*memoryJob
+	// pending holds the IDs of the pending jobs of every lane, the next one to dequeue first
+	pending map[domain.JobPriority][]string
+	lanes   laneScheduler
+	// items maps every item with a job processing or waiting for a retry to that job,
+	// no other job of the item is dequeued meanwhile
+	items map[int64]string
+	// wake is closed when a job becomes pending or frees its item, dequeues waiting for one select on it
+	wake chan struct{}
+	seq  uint64
+	// now is the clock of leases and delays
+	now func() time.Time
+
+	visibilityTimeout time.Duration
+	// deadLetterMaxJobs and deadLetterMaxAge bound the failed jobs that are kept, zero keeps every one
+	deadLetterMaxJobs int
+	deadLetterMaxAge  time.Duration
+	// completedMaxJobs and completedMaxAge bound the completed jobs that are kept the same way
+	completedMaxJobs int
+	completedMaxAge  time.Duration
+}
+
+func NewMemoryQueue(cfg config.QueueConfig) *MemoryQueue {
+	return &MemoryQueue{
+		jobs:              make(map[string]*memoryJob),
+		pending:           make(map[domain.JobPriority][]string, len(domain.JobPriorities)),
+		items:             make(map[int64]string),
+		wake:              make(chan struct{}),
+		now:               time.Now,
+		visibilityTimeout: cfg.VisibilityTimeout,
+		deadLetterMaxJobs: cfg.DeadLetterMaxJobs,
+		deadLetterMaxAge:  cfg.DeadLetterMaxAge,
+		completedMaxJobs:  cfg.CompletedMaxJobs,
+		completedMaxAge:   cfg.CompletedMaxAge,
+	}
+}
+
+func (q *MemoryQueue) EnqueueReservation(ctx context.Context, job *domain.ReservationJob) error {
+	return q.EnqueueReservations(ctx, []*domain.ReservationJob{job})
+}
+
+func (q *MemoryQueue) EnqueueReservations(ctx context.Context, jobs []*domain.ReservationJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	for _, job := range jobs {
+		if job.ID == "" {
+			job.ID = uuid.New().String()
+		}
+		// the outbox relay may publish a job twice, it's queued once
+		if _, ok := q.jobs[job.ID]; ok {
+			continue
+		}
+		job.CreatedAt = now
+		job.Status = domain.JobStatusPending
+		q.file(job, time.Time{}, false)
+	}
+	return nil
+}
+
+// DequeueReservation leases the next pending job whose item has no job in flight, the lanes take turns
+// by their weight. it waits for a job until ctx is done
+func (q *MemoryQueue) DequeueReservation(ctx context.Context) (*domain.ReservationJob, error) {
+	for {
+		q.mu.Lock()
+		for _, lane := range q.lanes.next() {
+			id, ok := q.nextInLane(lane)
+			if !ok {
+				continue
+			}
+			job := cloneJob(q.jobs[id].job)
+			job.Status = domain.JobStatusProcessing
+			job.LastAttempted = time.Now()
+			q.file(job, q.now().Add(q.visibilityTimeout), false)
+			q.mu.Unlock()
+			return job, nil
+		}
+		wake := q.wake
+		q.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wake:
+		}
+	}
+}
+
+// nextInLane returns the first pending job of the lane whose item is free
+func (q *MemoryQueue) nextInLane(lane domain.JobPriority) (string, bool) {
+	for _, id := range q.pending[lane] {
+		if holder, busy := q.items[q.jobs[id].job.ItemID]; !busy || holder == id {
+			return id, true
+		}
+	}
+	return "", false
+}
+
+// CompleteJob files the job as completed, the oldest completed jobs are dropped beyond the retention limit
+func (q *MemoryQueue) CompleteJob(ctx context.Context, job *domain.ReservationJob) error {
+	q.mu.Lock()
+	job.Status = domain.JobStatusCompleted
+	q.file(job, q.now(), false)
+	q.mu.Unlock()
+
+	_, err := q.TrimCompletedJobs(ctx)
+	return err
+}
+
+// FailJob dead-letters the job, the oldest failed jobs are dropped beyond the retention limit
+func (q *MemoryQueue) FailJob(ctx context.Context, job *domain.ReservationJob) error {
+	q.mu.Lock()
+	job.Status = domain.JobStatusFailed
+	q.file(job, q.now(), false)
+	q.mu.Unlock()
+
+	_, err := q.TrimDeadLetters(ctx)
+	return err
+}
+
+// RequeueJob puts the job back to the front of the line, later jobs of its item must not overtake it,
+// or delays it until delay passed. the job keeps its attempts, and its item while delayed
+func (q *MemoryQueue) RequeueJob(ctx context.Context, job *domain.ReservationJob, delay time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if delay <= 0 {
+		job.Status = domain.JobStatusPending
+		q.file(job, time.Time{}, true)
+		return nil
+	}
+	job.Status = domain.JobStatusDelayed
+	q.file(job, q.now().Add(delay), false)
+	return nil
+}
+
+// PromoteDueJobs moves delayed jobs that are due to the pending queue, they are dequeued next
+// since the delay was their wait in line
+func (q *MemoryQueue) PromoteDueJobs(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	promoted := 0
+	for _, stored := range q.sorted(domain.JobStatusDelayed, false) {
+		if stored.at.After(q.now()) {
+			continue
+		}
+		stored.job.Status = domain.JobStatusPending
+		q.file(stored.job, time.Time{}, true)
+		promoted++
+	}
+	return promoted, nil
+}
+
+// ExtendLease moves the lease deadline of the job a visibility timeout from now
+func (q *MemoryQueue) ExtendLease(ctx context.Context, job *domain.ReservationJob) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stored, ok := q.jobs[job.ID]
+	if !ok || stored.job.Status != domain.JobStatusProcessing {
+		return domain.ErrLeaseLost
+	}
+	stored.at = q.now().Add(q.visibilityTimeout)
+	return nil
+}
+
+// RequeueExpiredJobs puts processing jobs whose lease expired back to the pending queue with one more attempt,
+// they are dequeued next since they already waited a visibility timeout
+func (q *MemoryQueue) RequeueExpiredJobs(ctx context.Context) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	requeued := 0
+	for _, stored := range q.sorted(domain.JobStatusProcessing, false) {
+		if stored.at.After(q.now()) {
+			continue
+		}
+		stored.job.Status = domain.JobStatusPending
+		stored.job.Attempts++
+		stored.job.RecordFailure("lease expired", domain.JobErrorClassLeaseExpired, time.Now())
+		q.file(stored.job, time.Time{}, true)
+		requeued++
+	}
+	return requeued, nil
+}
+
+// QueueDepths returns the number of jobs with every status
+func (q *MemoryQueue) QueueDepths(ctx context.Context) (map[domain.JobStatus]int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depths := make(map[domain.JobStatus]int64, len(domain.JobStatuses))
+	for _, status := range domain.JobStatuses {
+		depths[status] = 0
+	}
+	for _, stored := range q.jobs {
+		depths[stored.job.Status]++
+	}
+	return depths, nil
+}
+
+// ListJobs returns a page of the jobs with the status, newest first
+func (q *MemoryQueue) ListJobs(ctx context.Context, status domain.JobStatus, offset, limit int) ([]*domain.ReservationJob, int64, error) {
+	if !isJobStatus(status) {
+		return nil, 0, fmt.Errorf("unknown job status: %s", status)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var ids []string
+	if status == domain.JobStatusPending {
+		// lane by lane, highest priority first
+		for _, lane := range domain.JobPriorities {
+			for i := len(q.pending[lane]) - 1; i >= 0; i-- {
+				ids = append(ids, q.pending[lane][i])
+			}
+		}
+	} else {
+		for _, stored := range q.sorted(status, true) {
+			ids = append(ids, stored.job.ID)
+		}
+	}
+
+	total := int64(len(ids))
+	if offset >= len(ids) {
+		return []*domain.ReservationJob{}, total, nil
+	}
+	ids = ids[offset:min(offset+limit, len(ids))]
+	jobs := make([]*domain.ReservationJob, len(ids))
+	for i, id := range ids {
+		jobs[i] = cloneJob(q.jobs[id].job)
+	}
+	return jobs, total, nil
+}
+
+func (q *MemoryQueue) GetJob(ctx context.Context, id string) (*domain.ReservationJob, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stored, ok := q.jobs[id]
+	if !ok {
+		return nil, domain.ErrJobNotFound
+	}
+	return cloneJob(stored.job), nil
+}
+
+// RetryJob moves a failed job back to the pending queue with its attempts reset, it keeps its history
+func (q *MemoryQueue) RetryJob(ctx context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stored, ok := q.jobs[id]
+	if !ok {
+		return domain.ErrJobNotFound
+	}
+	if stored.job.Status != domain.JobStatusFailed {
+		return domain.ErrJobNotFailed
+	}
+	q.retry(stored.job)
+	return nil
+}
+
+// RetryFailedJobs replays the failed jobs the filter matches, oldest failure first
+func (q *MemoryQueue) RetryFailedJobs(ctx context.Context, filter domain.DeadLetterFilter) (int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	retried := 0
+	for _, stored := range q.sorted(domain.JobStatusFailed, false) {
+		if !filter.Matches(stored.job) ||
+			(!filter.FailedAfter.IsZero() && stored.at.Before(filter.FailedAfter)) ||
+			(!filter.FailedBefore.IsZero() && !stored.at.Before(filter.FailedBefore)) {
+			continue
+		}
+		q.retry(stored.job)
+		retried++
+	}
+	return retried, nil
+}
+
+// TrimDeadLetters drops the failed jobs beyond the retention limits and returns how many
+func (q *MemoryQueue) TrimDeadLetters(ctx context.Context) (int, error) {
+	return q.trim(domain.JobStatusFailed, q.deadLetterMaxJobs, q.deadLetterMaxAge), nil
+}
+
+// TrimCompletedJobs drops the completed jobs beyond the retention limits and returns how many
+func (q *MemoryQueue) TrimCompletedJobs(ctx context.Context) (int, error) {
+	return q.trim(domain.JobStatusCompleted, q.completedMaxJobs, q.completedMaxAge), nil
+}
+
+// trim drops the oldest finished jobs with the status beyond the limits, zero lifts a limit
+func (q *MemoryQueue) trim(status domain.JobStatus, maxJobs int, maxAge time.Duration) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	finished := q.sorted(status, false)
+	trimmed := 0
+	for i, stored := range finished {
+		expired := maxAge > 0 && stored.at.Before(q.now().Add(-maxAge))
+		excess := maxJobs > 0 && len(finished)-i > maxJobs
+		if !expired && !excess {
+			break
+		}
+		delete(q.jobs, stored.job.ID)
+		trimmed++
+	}
+	return trimmed
+}
+
+// DiscardJob removes a job for good, jobs held by a worker can't be discarded
+func (q *MemoryQueue) DiscardJob(ctx context.Context, id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	stored, ok := q.jobs[id]
+	if !ok {
+		return domain.ErrJobNotFound
+	}
+	if stored.job.Status == domain.JobStatusProcessing {
+		return domain.ErrJobProcessing
+	}
+	q.remove(id)
+	return nil
+}
+
+// PurgeJobs removes every job with the status, jobs held by a worker can't be purged
+func (q *MemoryQueue) PurgeJobs(ctx context.Context, status domain.JobStatus) (int64, error) {
+	if status == domain.JobStatusProcessing {
+		return 0, domain.ErrJobProcessing
+	}
+	if !isJobStatus(status) {
+		return 0, fmt.Errorf("unknown job status: %s", status)
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var purged int64
+	for id, stored := range q.jobs {
+		if stored.job.Status == status {
+			q.remove(id)
+			purged++
+		}
+	}
+	return purged, nil
+}
+
+// retry files a failed job as pending with its attempts reset
+func (q *MemoryQueue) retry(job *domain.ReservationJob) {
+	job.Status = domain.JobStatusPending
+	job.Attempts = 0
+	q.file(job, time.Time{}, false)
+}
+
+// file stores a copy of the job under its status, pending jobs join the end of the line of their lane or,
+// with next, the front. processing and delayed jobs hold their item, the others free it.
+// the job is filed even if its lease was lost, delivery is at least once
+func (q *MemoryQueue) file(job *domain.ReservationJob, at time.Time, next bool) {
+	q.remove(job.ID)
+	q.seq++
+	q.jobs[job.ID] = &memoryJob{job: cloneJob(job), at: at, seq: q.seq}
+	switch job.Status {
+	case domain.JobStatusProcessing, domain.JobStatusDelayed:
+		q.items[job.ItemID] = job.ID
+		return
+	case domain.JobStatusPending:
+		lane := job.Lane()
+		if next {
+			q.pending[lane] = append([]string{job.ID}, q.pending[lane]...)
+		} else {
+			q.pending[lane] = append(q.pending[lane], job.ID)
+		}
+	}
+	q.signal()
+}
+
+// signal wakes the dequeues waiting for a job
+func (q *MemoryQueue) signal() {
+	close(q.wake)
+	q.wake = make(chan struct{})
+}
+
+// remove drops a job, its place in line and its hold on its item
+func (q *MemoryQueue) remove(id string) {
+	stored, ok := q.jobs[id]
+	if !ok {
+		return
+	}
+	delete(q.jobs, id)
+	if q.items[stored.job.ItemID] == id {
+		delete(q.items, stored.job.ItemID)
+		q.signal()
+	}
+	if stored.job.Status != domain.JobStatusPending {
+		return
+	}
+	lane := stored.job.Lane()
+	for i, pending := range q.pending[lane] {
+		if pending == id {
+			q.pending[lane] = append(q.pending[lane][:i], q.pending[lane][i+1:]...)
+			return
+		}
+	}
+}
+
+// sorted returns the jobs with the status by their time, and by when they got the status for equal times
+func (q *MemoryQueue) sorted(status domain.JobStatus, newestFirst bool) []*memoryJob {
+	var jobs []*memoryJob
+	for _, stored := range q.jobs {
+		if stored.job.Status == status {
+			jobs = append(jobs, stored)
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		before := jobs[i].at.Before(jobs[j].at) || (jobs[i].at.Equal(jobs[j].at) && jobs[i].seq < jobs[j].seq)
+		return before != newestFirst
+	})
+	return jobs
+}
+
+// cloneJob copies a job, the queue and its callers must not share one
+func cloneJob(job *domain.ReservationJob) *domain.ReservationJob {
+	clone := *job
+	clone.History = append([]domain.JobAttempt(nil), job.History...)
+	return &clone
+}
+
+// isJobStatus reports whether the status is one the queue knows
+func isJobStatus(status domain.JobStatus) bool {
+	for _, known := range domain.JobStatuses {
+		if status == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryDequeueWaitsForJobs(t *testing.T) {
+	queue := NewMemoryQueue(config.QueueConfig{VisibilityTimeout: 30 * time.Second})
+	ctx := context.Background()
+
+	dequeued := make(chan *domain.ReservationJob)
+	go func() {
+		job, err := queue.DequeueReservation(ctx)
+		assert.NoError(t, err)
+		dequeued <- job
+	}()
+
+	// the dequeue blocks until a job is enqueued
+	select {
+	case <-dequeued:
+		t.Fatal("dequeued from an empty queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+	select {
+	case job := <-dequeued:
+		assert.Equal(t, "job-1", job.ID)
+	case <-time.After(time.Second):
+		t.Fatal("the enqueued job wasn't dequeued")
+	}
+}
+
+func TestMemoryDequeueStopsWithContext(t *testing.T) {
+	queue := NewMemoryQueue(config.QueueConfig{VisibilityTimeout: 30 * time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := queue.DequeueReservation(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestMemoryQueueCopiesJobs(t *testing.T) {
+	queue := NewMemoryQueue(config.QueueConfig{VisibilityTimeout: 30 * time.Second})
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	// a worker changing its job doesn't change the queued one until it hands it back
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	job.Attempts = 2
+	job.RecordFailure("timeout", domain.JobErrorClassTimeout, time.Now())
+
+	stored, err := queue.GetJob(ctx, "job-1")
+	require.NoError(t, err)
+	assert.Zero(t, stored.Attempts)
+	assert.Empty(t, stored.History)
+
+	require.NoError(t, queue.FailJob(ctx, job))
+	stored, err = queue.GetJob(ctx, "job-1")
+	require.NoError(t, err)
+	assert.Equal(t, 2, stored.Attempts)
+	assert.Len(t, stored.History, 1)
+}
+
+func TestMemoryTrimsDeadLetters(t *testing.T) {
+	queue := NewMemoryQueue(config.QueueConfig{VisibilityTimeout: 30 * time.Second, DeadLetterMaxJobs: 2})
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+		{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 2}, {ID: "job-3", ItemID: 3},
+	}))
+
+	for i := 0; i < 3; i++ {
+		job, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		require.NoError(t, queue.FailJob(ctx, job))
+	}
+
+	// the oldest failure was dropped
+	failed, total, err := queue.ListJobs(ctx, domain.JobStatusFailed, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, []string{"job-3", "job-2"}, jobIDs(failed))
+}
+
+func TestMemoryTrimsCompletedJobs(t *testing.T) {
+	queue := NewMemoryQueue(config.QueueConfig{VisibilityTimeout: 30 * time.Second, CompletedMaxJobs: 2})
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+		{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 2}, {ID: "job-3", ItemID: 3},
+	}))
+
+	for i := 0; i < 3; i++ {
+		job, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		require.NoError(t, queue.CompleteJob(ctx, job))
+	}
+
+	// the job completed first was dropped
+	completed, total, err := queue.ListJobs(ctx, domain.JobStatusCompleted, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Equal(t, []string{"job-3", "job-2"}, jobIDs(completed))
+	_, err = queue.GetJob(ctx, "job-1")
+	assert.ErrorIs(t, err, domain.ErrJobNotFound)
+}
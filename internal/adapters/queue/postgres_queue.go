@@ -0,0 +1,363 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// dequeuePoll is how long a dequeue that found no due job waits before it looks again
+const dequeuePoll = time.Second
+
+// pgInsertJobs adds pending jobs given as arrays of their columns, seq follows the order of the arrays
+const pgInsertJobs = `
+INSERT INTO jobs (id, item_id, job_type, payload)
+SELECT id, item_id, job_type, payload
+FROM unnest($1::text[], $2::integer[], $3::text[], $4::jsonb[]) WITH ORDINALITY AS j(id, item_id, job_type, payload, n)
+ORDER BY n`
+
+// pgSelectDueJob locks the job that is due first, jobs other workers locked are skipped instead of waited for.
+// a job waits while its item has a job processing or an earlier job not done yet, so the jobs of an item
+// run one at a time in the order they were queued
+const pgSelectDueJob = `
+SELECT payload FROM jobs j
+WHERE status IN ('PENDING', 'DELAYED') AND scheduled_at <= NOW()
+  AND NOT EXISTS (
+    SELECT 1 FROM jobs e
+    WHERE e.item_id = j.item_id AND e.id <> j.id
+      AND (e.status = 'PROCESSING' OR (e.status IN ('PENDING', 'DELAYED') AND e.seq < j.seq))
+  )
+ORDER BY scheduled_at, seq
+LIMIT 1
+FOR UPDATE SKIP LOCKED`
+
+// pgLeaseJob stores a dequeued job as processing, leased for $3 milliseconds
+const pgLeaseJob = `
+UPDATE jobs
+SET status = 'PROCESSING', payload = $2, lease_expires_at = NOW() + $3::bigint * INTERVAL '1 millisecond', updated_at = NOW()
+WHERE id = $1`
+
+// pgFinishJob stores a completed or failed job. the job is stored even if its lease was lost, delivery is at least once
+const pgFinishJob = `
+UPDATE jobs
+SET status = $2, payload = $3, lease_expires_at = NULL, finished_at = NOW(), updated_at = NOW()
+WHERE id = $1`
+
+// pgScheduleJob stores a job as pending or delayed, due $4 milliseconds from now
+const pgScheduleJob = `
+UPDATE jobs
+SET status = $2, payload = $3, lease_expires_at = NULL, scheduled_at = NOW() + $4::bigint * INTERVAL '1 millisecond', updated_at = NOW()
+WHERE id = $1`
+
+// pgExtendLease moves the lease deadline of a job that is still processing
+const pgExtendLease = `
+UPDATE jobs
+SET lease_expires_at = NOW() + $2::bigint * INTERVAL '1 millisecond', updated_at = NOW()
+WHERE id = $1 AND status = 'PROCESSING'`
+
+// pgSelectExpiredJobs locks up to $1 processing jobs whose lease expired
+const pgSelectExpiredJobs = `
+SELECT payload FROM jobs
+WHERE status = 'PROCESSING' AND lease_expires_at <= NOW()
+ORDER BY lease_expires_at
+LIMIT $1
+FOR UPDATE SKIP LOCKED`
+
+// pgRequeueExpiredJob puts a job whose lease expired back in line, it keeps its place
+const pgRequeueExpiredJob = `
+UPDATE jobs
+SET status = 'PENDING', payload = $2, lease_expires_at = NULL, updated_at = NOW()
+WHERE id = $1`
+
+// pgPromoteDueJobs marks delayed jobs that are due as pending, they keep their due time as their place in line
+const pgPromoteDueJobs = `
+UPDATE jobs
+SET status = 'PENDING', payload = jsonb_set(payload, '{status}', '"PENDING"'), updated_at = NOW()
+WHERE status = 'DELAYED' AND scheduled_at <= NOW()`
+
+// execer runs statements on the database or in a transaction of it
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryer runs queries on the database or in a transaction of it
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// PostgresQueue is a queue on the jobs table. workers lock the job due first with SKIP LOCKED, so they
+// never wait for each other, and lease it until lease_expires_at. deadlines use the database clock.
+// it shares the database with the items, so jobs can be created in the transaction of their items
+type PostgresQueue struct {
+	db *sql.DB
+	// visibilityTimeout is how long a dequeued job stays leased without a heartbeat
+	visibilityTimeout time.Duration
+	// deadLetterMaxJobs and deadLetterMaxAge bound the failed jobs that are kept, zero keeps every one
+	deadLetterMaxJobs int
+	deadLetterMaxAge  time.Duration
+	// completedMaxJobs and completedMaxAge bound the completed jobs that are kept the same way
+	completedMaxJobs int
+	completedMaxAge  time.Duration
+}
+
+func NewPostgresQueue(db *sql.DB, cfg config.QueueConfig) *PostgresQueue {
+	return &PostgresQueue{
+		db:                db,
+		visibilityTimeout: cfg.VisibilityTimeout,
+		deadLetterMaxJobs: cfg.DeadLetterMaxJobs,
+		deadLetterMaxAge:  cfg.DeadLetterMaxAge,
+		completedMaxJobs:  cfg.CompletedMaxJobs,
+		completedMaxAge:   cfg.CompletedMaxAge,
+	}
+}
+
+func (q *PostgresQueue) EnqueueReservation(ctx context.Context, job *domain.ReservationJob) error {
+	return q.EnqueueReservations(ctx, []*domain.ReservationJob{job})
+}
+
+func (q *PostgresQueue) EnqueueReservations(ctx context.Context, jobs []*domain.ReservationJob) error {
+	return q.enqueue(ctx, q.db, jobs)
+}
+
+// EnqueueReservationsTx adds the jobs in the transaction tx of the items database,
+// they are only queued if it commits
+func (q *PostgresQueue) EnqueueReservationsTx(ctx context.Context, tx *sql.Tx, jobs []*domain.ReservationJob) error {
+	return q.enqueue(ctx, tx, jobs)
+}
+
+func (q *PostgresQueue) enqueue(ctx context.Context, db execer, jobs []*domain.ReservationJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	ids := make([]string, len(jobs))
+	itemIDs := make([]int64, len(jobs))
+	jobTypes := make([]string, len(jobs))
+	payloads := make([]string, len(jobs))
+	for i, job := range jobs {
+		if job.ID == "" {
+			job.ID = uuid.New().String()
+		}
+		job.CreatedAt = now
+		job.Status = domain.JobStatusPending
+
+		jobData, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		ids[i], itemIDs[i], jobTypes[i], payloads[i] = job.ID, job.ItemID, string(job.JobType), string(jobData)
+	}
+
+	_, err := db.ExecContext(ctx, pgInsertJobs, pq.Array(ids), pq.Array(itemIDs), pq.Array(jobTypes), pq.Array(payloads))
+	return err
+}
+
+// DequeueReservation leases the job that is due first, it polls until a job is due or ctx is done
+func (q *PostgresQueue) DequeueReservation(ctx context.Context) (*domain.ReservationJob, error) {
+	for {
+		job, err := q.dequeueDueJob(ctx)
+		if err != nil || job != nil {
+			return job, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(dequeuePoll):
+		}
+	}
+}
+
+// dequeueDueJob leases the job that is due first, nil if no job is due
+func (q *PostgresQueue) dequeueDueJob(ctx context.Context) (*domain.ReservationJob, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	job, err := scanJob(tx.QueryRowContext(ctx, pgSelectDueJob))
+	if errors.Is(err, domain.ErrJobNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	job.Status = domain.JobStatusProcessing
+	job.LastAttempted = time.Now()
+
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, pgLeaseJob, job.ID, jobData, q.visibilityTimeout.Milliseconds()); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// CompleteJob files the job as completed, the oldest completed jobs are dropped beyond the retention limit
+func (q *PostgresQueue) CompleteJob(ctx context.Context, job *domain.ReservationJob) error {
+	job.Status = domain.JobStatusCompleted
+	if err := q.finishJob(ctx, job); err != nil {
+		return err
+	}
+	_, err := q.TrimCompletedJobs(ctx)
+	return err
+}
+
+// FailJob dead-letters the job, the oldest failed jobs are dropped beyond the retention limit
+func (q *PostgresQueue) FailJob(ctx context.Context, job *domain.ReservationJob) error {
+	job.Status = domain.JobStatusFailed
+	if err := q.finishJob(ctx, job); err != nil {
+		return err
+	}
+	_, err := q.TrimDeadLetters(ctx)
+	return err
+}
+
+func (q *PostgresQueue) finishJob(ctx context.Context, job *domain.ReservationJob) error {
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.ExecContext(ctx, pgFinishJob, job.ID, string(job.Status), jobData)
+	return err
+}
+
+// RequeueJob puts the job back in line, or delays it until delay passed. the job keeps its attempts
+func (q *PostgresQueue) RequeueJob(ctx context.Context, job *domain.ReservationJob, delay time.Duration) error {
+	job.Status = domain.JobStatusPending
+	if delay > 0 {
+		job.Status = domain.JobStatusDelayed
+	} else {
+		delay = 0
+	}
+
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = q.db.ExecContext(ctx, pgScheduleJob, job.ID, string(job.Status), jobData, delay.Milliseconds())
+	return err
+}
+
+// PromoteDueJobs marks delayed jobs that are due as pending. workers dequeue due jobs either way,
+// promoting them keeps their status right for operators
+func (q *PostgresQueue) PromoteDueJobs(ctx context.Context) (int, error) {
+	result, err := q.db.ExecContext(ctx, pgPromoteDueJobs)
+	if err != nil {
+		return 0, err
+	}
+	promoted, err := result.RowsAffected()
+	return int(promoted), err
+}
+
+// ExtendLease moves the lease deadline of the job a visibility timeout from now
+func (q *PostgresQueue) ExtendLease(ctx context.Context, job *domain.ReservationJob) error {
+	result, err := q.db.ExecContext(ctx, pgExtendLease, job.ID, q.visibilityTimeout.Milliseconds())
+	if err != nil {
+		return err
+	}
+	extended, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if extended == 0 {
+		return domain.ErrLeaseLost
+	}
+	return nil
+}
+
+// RequeueExpiredJobs puts processing jobs whose lease expired back in line with one more attempt
+func (q *PostgresQueue) RequeueExpiredJobs(ctx context.Context) (int, error) {
+	requeued := 0
+	for {
+		batch, err := q.requeueExpiredBatch(ctx)
+		requeued += batch
+		if err != nil || batch < reclaimBatch {
+			return requeued, err
+		}
+	}
+}
+
+// requeueExpiredBatch requeues up to reclaimBatch jobs whose lease expired in one transaction
+func (q *PostgresQueue) requeueExpiredBatch(ctx context.Context) (int, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	jobs, err := queryJobs(ctx, tx, pgSelectExpiredJobs, reclaimBatch)
+	if err != nil {
+		return 0, err
+	}
+	for _, job := range jobs {
+		job.Status = domain.JobStatusPending
+		job.Attempts++
+		job.RecordFailure("lease expired", domain.JobErrorClassLeaseExpired, time.Now())
+		jobData, err := json.Marshal(job)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := tx.ExecContext(ctx, pgRequeueExpiredJob, job.ID, jobData); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+	return len(jobs), nil
+}
+
+// scanJob reads the job of a payload row
+func scanJob(row *sql.Row) (*domain.ReservationJob, error) {
+	var payload []byte
+	if err := row.Scan(&payload); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.ErrJobNotFound
+		}
+		return nil, err
+	}
+
+	var job domain.ReservationJob
+	if err := json.Unmarshal(payload, &job); err != nil {
+		return nil, err
+	}
+	return &job, nil
+}
+
+// queryJobs reads the jobs of the payload rows a query returns
+func queryJobs(ctx context.Context, db queryer, query string, args ...interface{}) ([]*domain.ReservationJob, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []*domain.ReservationJob
+	for rows.Next() {
+		var payload []byte
+		if err := rows.Scan(&payload); err != nil {
+			return nil, err
+		}
+		var job domain.ReservationJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, &job)
+	}
+	return jobs, rows.Err()
+}
@@ -0,0 +1,212 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+// pgRetryJobs is the update that moves failed jobs back in line with their attempts reset, they keep their history
+const pgRetryJobs = `
+UPDATE jobs
+SET status = 'PENDING',
+    payload = jsonb_set(jsonb_set(payload, '{status}', '"PENDING"'), '{attempts}', '0'),
+    scheduled_at = NOW(),
+    finished_at = NULL,
+    updated_at = NOW()
+`
+
+// pgRetryFailedJob retries the failed job $1
+const pgRetryFailedJob = pgRetryJobs + `WHERE id = $1 AND status = 'FAILED'`
+
+// pgRetryMatchingJobs retries the failed jobs of the type $1, the item $2 and the error class $3 that failed
+// from $4 until before $5, empty arguments match every job. jobs an operator is retrying meanwhile are skipped
+const pgRetryMatchingJobs = pgRetryJobs + `WHERE id IN (
+    SELECT id FROM jobs
+    WHERE status = 'FAILED'
+      AND ($1 = '' OR job_type = $1)
+      AND ($2 = 0 OR item_id = $2)
+      AND ($3 = '' OR payload->>'error_class' = $3)
+      AND ($4::timestamptz IS NULL OR finished_at >= $4)
+      AND ($5::timestamptz IS NULL OR finished_at < $5)
+    FOR UPDATE SKIP LOCKED
+)`
+
+// pgTrimExpiredJobs drops the jobs with status $1 that finished more than $2 milliseconds ago
+const pgTrimExpiredJobs = `
+DELETE FROM jobs
+WHERE status = $1 AND finished_at < NOW() - $2::bigint * INTERVAL '1 millisecond'`
+
+// pgTrimExcessJobs drops the jobs with status $1 beyond the $2 that finished last
+const pgTrimExcessJobs = `
+DELETE FROM jobs
+WHERE id IN (SELECT id FROM jobs WHERE status = $1 ORDER BY finished_at DESC OFFSET $2)`
+
+// pgDiscardJob drops a job unless a worker holds it
+const pgDiscardJob = `DELETE FROM jobs WHERE id = $1 AND status <> 'PROCESSING'`
+
+// listOrder sorts the jobs of every status newest first, waiting jobs by when they are due
+// and finished ones by when they finished
+var listOrder = map[domain.JobStatus]string{
+	domain.JobStatusPending:    "scheduled_at DESC, seq DESC",
+	domain.JobStatusDelayed:    "scheduled_at DESC, seq DESC",
+	domain.JobStatusProcessing: "updated_at DESC",
+	domain.JobStatusCompleted:  "finished_at DESC",
+	domain.JobStatusFailed:     "finished_at DESC",
+}
+
+// QueueDepths returns the number of jobs with every status
+func (q *PostgresQueue) QueueDepths(ctx context.Context) (map[domain.JobStatus]int64, error) {
+	rows, err := q.db.QueryContext(ctx, `SELECT status, COUNT(*) FROM jobs GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	depths := make(map[domain.JobStatus]int64, len(domain.JobStatuses))
+	for _, status := range domain.JobStatuses {
+		depths[status] = 0
+	}
+	for rows.Next() {
+		var status string
+		var depth int64
+		if err := rows.Scan(&status, &depth); err != nil {
+			return nil, err
+		}
+		depths[domain.JobStatus(status)] = depth
+	}
+	return depths, rows.Err()
+}
+
+// ListJobs returns a page of the jobs with the status, newest first
+func (q *PostgresQueue) ListJobs(ctx context.Context, status domain.JobStatus, offset, limit int) ([]*domain.ReservationJob, int64, error) {
+	order, ok := listOrder[status]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown job status: %s", status)
+	}
+
+	var total int64
+	if err := q.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM jobs WHERE status = $1`, string(status)).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+	query := fmt.Sprintf(`SELECT payload FROM jobs WHERE status = $1 ORDER BY %s OFFSET $2 LIMIT $3`, order)
+	jobs, err := queryJobs(ctx, q.db, query, string(status), offset, limit)
+	if err != nil {
+		return nil, 0, err
+	}
+	return jobs, total, nil
+}
+
+func (q *PostgresQueue) GetJob(ctx context.Context, id string) (*domain.ReservationJob, error) {
+	return scanJob(q.db.QueryRowContext(ctx, `SELECT payload FROM jobs WHERE id = $1`, id))
+}
+
+// RetryJob moves a single failed job back in line
+func (q *PostgresQueue) RetryJob(ctx context.Context, id string) error {
+	result, err := q.db.ExecContext(ctx, pgRetryFailedJob, id)
+	if err != nil {
+		return err
+	}
+	retried, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if retried > 0 {
+		return nil
+	}
+
+	// tell a missing job from one that didn't fail
+	if _, err := q.GetJob(ctx, id); err != nil {
+		return err
+	}
+	return domain.ErrJobNotFailed
+}
+
+// RetryFailedJobs replays the failed jobs the filter matches in one statement,
+// they go back in line in the order they were first queued
+func (q *PostgresQueue) RetryFailedJobs(ctx context.Context, filter domain.DeadLetterFilter) (int, error) {
+	failedAfter := sql.NullTime{Time: filter.FailedAfter, Valid: !filter.FailedAfter.IsZero()}
+	failedBefore := sql.NullTime{Time: filter.FailedBefore, Valid: !filter.FailedBefore.IsZero()}
+	result, err := q.db.ExecContext(ctx, pgRetryMatchingJobs,
+		string(filter.JobType), filter.ItemID, string(filter.ErrorClass), failedAfter, failedBefore)
+	if err != nil {
+		return 0, err
+	}
+	retried, err := result.RowsAffected()
+	return int(retried), err
+}
+
+// TrimDeadLetters drops the failed jobs beyond the retention limits and returns how many
+func (q *PostgresQueue) TrimDeadLetters(ctx context.Context) (int, error) {
+	return q.trim(ctx, domain.JobStatusFailed, q.deadLetterMaxJobs, q.deadLetterMaxAge)
+}
+
+// TrimCompletedJobs drops the completed jobs beyond the retention limits and returns how many
+func (q *PostgresQueue) TrimCompletedJobs(ctx context.Context) (int, error) {
+	return q.trim(ctx, domain.JobStatusCompleted, q.completedMaxJobs, q.completedMaxAge)
+}
+
+// trim drops the oldest finished jobs with the status beyond the limits, zero lifts a limit
+func (q *PostgresQueue) trim(ctx context.Context, status domain.JobStatus, maxJobs int, maxAge time.Duration) (int, error) {
+	var trimmed int64
+	if maxAge > 0 {
+		result, err := q.db.ExecContext(ctx, pgTrimExpiredJobs, string(status), maxAge.Milliseconds())
+		if err != nil {
+			return 0, err
+		}
+		if trimmed, err = result.RowsAffected(); err != nil {
+			return 0, err
+		}
+	}
+	if maxJobs > 0 {
+		result, err := q.db.ExecContext(ctx, pgTrimExcessJobs, string(status), maxJobs)
+		if err != nil {
+			return int(trimmed), err
+		}
+		excess, err := result.RowsAffected()
+		if err != nil {
+			return int(trimmed), err
+		}
+		trimmed += excess
+	}
+	return int(trimmed), nil
+}
+
+// DiscardJob removes a job for good, jobs held by a worker can't be discarded
+func (q *PostgresQueue) DiscardJob(ctx context.Context, id string) error {
+	result, err := q.db.ExecContext(ctx, pgDiscardJob, id)
+	if err != nil {
+		return err
+	}
+	discarded, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if discarded > 0 {
+		return nil
+	}
+
+	if _, err := q.GetJob(ctx, id); err != nil {
+		return err
+	}
+	return domain.ErrJobProcessing
+}
+
+// PurgeJobs removes every job with the status, jobs held by a worker can't be purged
+func (q *PostgresQueue) PurgeJobs(ctx context.Context, status domain.JobStatus) (int64, error) {
+	if status == domain.JobStatusProcessing {
+		return 0, domain.ErrJobProcessing
+	}
+	if _, ok := listOrder[status]; !ok {
+		return 0, fmt.Errorf("unknown job status: %s", status)
+	}
+
+	result, err := q.db.ExecContext(ctx, `DELETE FROM jobs WHERE status = $1`, string(status))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,276 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupPostgresQueue(t *testing.T) (*PostgresQueue, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+
+	return &PostgresQueue{db: db, visibilityTimeout: 30 * time.Second}, mock
+}
+
+// payloadRow returns the payload row of the job
+func payloadRow(t *testing.T, job *domain.ReservationJob) *sqlmock.Rows {
+	payload, err := json.Marshal(job)
+	require.NoError(t, err)
+	return sqlmock.NewRows([]string{"payload"}).AddRow(payload)
+}
+
+func TestPostgresEnqueueAndDequeue(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+	ctx := context.Background()
+
+	mock.ExpectExec(`INSERT INTO jobs`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	jobs := []*domain.ReservationJob{{ItemID: 1}, {ID: "job-2", ItemID: 2}}
+	require.NoError(t, queue.EnqueueReservations(ctx, jobs))
+	assert.NotEmpty(t, jobs[0].ID)
+	assert.Equal(t, domain.JobStatusPending, jobs[1].Status)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT payload FROM jobs .+ FOR UPDATE SKIP LOCKED`).WillReturnRows(payloadRow(t, jobs[1]))
+	mock.ExpectExec(`UPDATE jobs SET status = 'PROCESSING'`).
+		WithArgs("job-2", sqlmock.AnyArg(), int64(30000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "job-2", job.ID)
+	assert.Equal(t, domain.JobStatusProcessing, job.Status)
+	assert.False(t, job.LastAttempted.IsZero())
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresDequeueStopsWithContext(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT payload FROM jobs`).WillReturnRows(sqlmock.NewRows([]string{"payload"}))
+	mock.ExpectRollback()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := queue.DequeueReservation(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresExtendLease(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+	ctx := context.Background()
+	job := &domain.ReservationJob{ID: "job-1"}
+
+	mock.ExpectExec(`UPDATE jobs SET lease_expires_at`).WithArgs("job-1", int64(30000)).WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, queue.ExtendLease(ctx, job))
+
+	// the reaper requeued the job meanwhile
+	mock.ExpectExec(`UPDATE jobs SET lease_expires_at`).WithArgs("job-1", int64(30000)).WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.ErrorIs(t, queue.ExtendLease(ctx, job), domain.ErrLeaseLost)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRequeuesExpiredJobs(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+	expired := &domain.ReservationJob{ID: "job-1", ItemID: 1, Status: domain.JobStatusProcessing}
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT payload FROM jobs WHERE status = 'PROCESSING' AND lease_expires_at <= NOW\(\)`).
+		WithArgs(reclaimBatch).
+		WillReturnRows(payloadRow(t, expired))
+	var requeued domain.ReservationJob
+	mock.ExpectExec(`UPDATE jobs SET status = 'PENDING'`).
+		WithArgs("job-1", jsonArg{&requeued}).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	count, err := queue.RequeueExpiredJobs(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, domain.JobStatusPending, requeued.Status)
+	assert.Equal(t, 1, requeued.Attempts)
+	assert.Equal(t, domain.JobErrorClassLeaseExpired, requeued.ErrorClass)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRequeueJob(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+	ctx := context.Background()
+	job := &domain.ReservationJob{ID: "job-1", Status: domain.JobStatusProcessing}
+
+	mock.ExpectExec(`UPDATE jobs SET status = \$2`).
+		WithArgs("job-1", string(domain.JobStatusDelayed), sqlmock.AnyArg(), int64(60000)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, queue.RequeueJob(ctx, job, time.Minute))
+
+	mock.ExpectExec(`UPDATE jobs SET status = \$2`).
+		WithArgs("job-1", string(domain.JobStatusPending), sqlmock.AnyArg(), int64(0)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	require.NoError(t, queue.RequeueJob(ctx, job, 0))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresRetryJob(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(sqlmock.Sqlmock)
+		wantErr error
+	}{
+		{
+			name: "failed job",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`UPDATE jobs .+ WHERE id = \$1 AND status = 'FAILED'`).WithArgs("job-1").WillReturnResult(sqlmock.NewResult(0, 1))
+			},
+		},
+		{
+			name: "job that didn't fail",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`UPDATE jobs .+ WHERE id = \$1 AND status = 'FAILED'`).WithArgs("job-1").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`SELECT payload FROM jobs WHERE id = \$1`).WithArgs("job-1").
+					WillReturnRows(sqlmock.NewRows([]string{"payload"}).AddRow(`{"id":"job-1","status":"COMPLETED"}`))
+			},
+			wantErr: domain.ErrJobNotFailed,
+		},
+		{
+			name: "missing job",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectExec(`UPDATE jobs .+ WHERE id = \$1 AND status = 'FAILED'`).WithArgs("job-1").WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(`SELECT payload FROM jobs WHERE id = \$1`).WithArgs("job-1").WillReturnError(sql.ErrNoRows)
+			},
+			wantErr: domain.ErrJobNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, mock := setupPostgresQueue(t)
+			tt.setup(mock)
+
+			err := queue.RetryJob(context.Background(), "job-1")
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestPostgresRetryFailedJobs(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+	failedAfter := time.Now().Add(-time.Hour)
+
+	mock.ExpectExec(`UPDATE jobs .+ FOR UPDATE SKIP LOCKED`).
+		WithArgs("", int64(2), string(domain.JobErrorClassTimeout),
+			sql.NullTime{Time: failedAfter, Valid: true}, sql.NullTime{}).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+
+	retried, err := queue.RetryFailedJobs(context.Background(), domain.DeadLetterFilter{
+		ItemID:      2,
+		ErrorClass:  domain.JobErrorClassTimeout,
+		FailedAfter: failedAfter,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 3, retried)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresFailJobTrimsDeadLetters(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+	queue.deadLetterMaxJobs = 100
+	queue.deadLetterMaxAge = time.Hour
+
+	mock.ExpectExec(`UPDATE jobs SET status = \$2, payload = \$3, lease_expires_at = NULL, finished_at = NOW\(\)`).
+		WithArgs("job-1", string(domain.JobStatusFailed), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM jobs WHERE status = \$1 AND finished_at <`).
+		WithArgs(string(domain.JobStatusFailed), time.Hour.Milliseconds()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM jobs WHERE id IN .+ OFFSET \$2`).
+		WithArgs(string(domain.JobStatusFailed), 100).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+
+	job := &domain.ReservationJob{ID: "job-1", Status: domain.JobStatusProcessing}
+	require.NoError(t, queue.FailJob(context.Background(), job))
+	assert.Equal(t, domain.JobStatusFailed, job.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresCompleteJobTrimsCompletedJobs(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+	queue.completedMaxJobs = 100
+
+	mock.ExpectExec(`UPDATE jobs SET status = \$2, payload = \$3, lease_expires_at = NULL, finished_at = NOW\(\)`).
+		WithArgs("job-1", string(domain.JobStatusCompleted), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`DELETE FROM jobs WHERE id IN .+ OFFSET \$2`).
+		WithArgs(string(domain.JobStatusCompleted), 100).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+
+	job := &domain.ReservationJob{ID: "job-1", Status: domain.JobStatusProcessing}
+	require.NoError(t, queue.CompleteJob(context.Background(), job))
+	assert.Equal(t, domain.JobStatusCompleted, job.Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresDiscardJob(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+	ctx := context.Background()
+
+	mock.ExpectExec(`DELETE FROM jobs WHERE id = \$1 AND status <> 'PROCESSING'`).WithArgs("job-1").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT payload FROM jobs WHERE id = \$1`).WithArgs("job-1").
+		WillReturnRows(sqlmock.NewRows([]string{"payload"}).AddRow(`{"id":"job-1","status":"PROCESSING"}`))
+	assert.ErrorIs(t, queue.DiscardJob(ctx, "job-1"), domain.ErrJobProcessing)
+
+	_, err := queue.PurgeJobs(ctx, domain.JobStatusProcessing)
+	assert.ErrorIs(t, err, domain.ErrJobProcessing)
+
+	mock.ExpectExec(`DELETE FROM jobs WHERE status = \$1`).WithArgs(string(domain.JobStatusCompleted)).WillReturnResult(sqlmock.NewResult(0, 4))
+	purged, err := queue.PurgeJobs(ctx, domain.JobStatusCompleted)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), purged)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgresQueueDepths(t *testing.T) {
+	queue, mock := setupPostgresQueue(t)
+
+	mock.ExpectQuery(`SELECT status, COUNT\(\*\) FROM jobs GROUP BY status`).
+		WillReturnRows(sqlmock.NewRows([]string{"status", "count"}).AddRow("PENDING", 3).AddRow("FAILED", 1))
+
+	depths, err := queue.QueueDepths(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[domain.JobStatus]int64{
+		domain.JobStatusPending:    3,
+		domain.JobStatusDelayed:    0,
+		domain.JobStatusProcessing: 0,
+		domain.JobStatusCompleted:  0,
+		domain.JobStatusFailed:     1,
+	}, depths)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+// jsonArg matches a serialized job argument and decodes it into job
+type jsonArg struct {
+	job *domain.ReservationJob
+}
+
+func (a jsonArg) Match(v driver.Value) bool {
+	data, ok := v.([]byte)
+	return ok && json.Unmarshal(data, a.job) == nil
+}
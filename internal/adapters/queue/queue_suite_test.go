@@ -0,0 +1,270 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/a-berahman/shopping-cart/internal/core/ports"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// adminQueue is a queue adapter with its admin operations, every adapter is both
+type adminQueue interface {
+	ports.Queue
+	ports.QueueAdmin
+}
+
+// queueAdapters set up every queue adapter the suite runs against, advance moves the clock
+// their leases and delays use
+var queueAdapters = []struct {
+	name  string
+	setup func(t *testing.T) (queue adminQueue, advance func(time.Duration))
+}{
+	{
+		name: "list",
+		setup: func(t *testing.T) (adminQueue, func(time.Duration)) {
+			client, advance := setupFrozenRedis(t)
+			return &RedisQueue{client: client, visibilityTimeout: 30 * time.Second}, advance
+		},
+	},
+	{
+		name: "streams",
+		setup: func(t *testing.T) (adminQueue, func(time.Duration)) {
+			client, advance := setupFrozenRedis(t)
+			return &StreamQueue{client: client, consumer: "test-consumer", visibilityTimeout: 30 * time.Second}, advance
+		},
+	},
+	{
+		name: "memory",
+		setup: func(t *testing.T) (adminQueue, func(time.Duration)) {
+			now := time.Now()
+			queue := NewMemoryQueue(config.QueueConfig{VisibilityTimeout: 30 * time.Second})
+			queue.now = func() time.Time { return now }
+			return queue, func(d time.Duration) { now = now.Add(d) }
+		},
+	},
+}
+
+// setupFrozenRedis freezes the redis clock, advance moves it forward
+func setupFrozenRedis(t *testing.T) (*redis.Client, func(time.Duration)) {
+	mr := miniredis.RunT(t)
+	now := time.Now().Truncate(time.Millisecond)
+	mr.SetTime(now)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	return client, func(d time.Duration) {
+		now = now.Add(d)
+		mr.SetTime(now)
+	}
+}
+
+// forEachQueue runs the test against every queue adapter
+func forEachQueue(t *testing.T, test func(t *testing.T, queue adminQueue, advance func(time.Duration))) {
+	for _, adapter := range queueAdapters {
+		t.Run(adapter.name, func(t *testing.T) {
+			queue, advance := adapter.setup(t)
+			test(t, queue, advance)
+		})
+	}
+}
+
+// jobIDs returns the IDs of the jobs in order
+func jobIDs(jobs []*domain.ReservationJob) []string {
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+	return ids
+}
+
+func TestQueueProcessesJobsInOrder(t *testing.T) {
+	forEachQueue(t, func(t *testing.T, queue adminQueue, _ func(time.Duration)) {
+		ctx := context.Background()
+		require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+			{ID: "job-1", ItemID: 1, JobType: domain.JobTypeAvailabilityCheck},
+			{ID: "job-2", ItemID: 2, JobType: domain.JobTypeAvailabilityCheck},
+		}))
+		require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-3", ItemID: 3}))
+
+		job, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "job-1", job.ID)
+		assert.Equal(t, domain.JobStatusProcessing, job.Status)
+
+		depths, err := queue.QueueDepths(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), depths[domain.JobStatusPending])
+		assert.Equal(t, int64(1), depths[domain.JobStatusProcessing])
+
+		pending, total, err := queue.ListJobs(ctx, domain.JobStatusPending, 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		assert.Equal(t, []string{"job-3", "job-2"}, jobIDs(pending))
+
+		processing, _, err := queue.ListJobs(ctx, domain.JobStatusProcessing, 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"job-1"}, jobIDs(processing))
+
+		require.NoError(t, queue.CompleteJob(ctx, job))
+		stored, err := queue.GetJob(ctx, "job-1")
+		require.NoError(t, err)
+		assert.Equal(t, domain.JobStatusCompleted, stored.Status)
+
+		job, err = queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "job-2", job.ID)
+
+		depths, err = queue.QueueDepths(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, map[domain.JobStatus]int64{
+			domain.JobStatusPending:    1,
+			domain.JobStatusDelayed:    0,
+			domain.JobStatusProcessing: 1,
+			domain.JobStatusCompleted:  1,
+			domain.JobStatusFailed:     0,
+		}, depths)
+	})
+}
+
+func TestQueueRetriesFailedJobs(t *testing.T) {
+	forEachQueue(t, func(t *testing.T, queue adminQueue, _ func(time.Duration)) {
+		ctx := context.Background()
+		require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+			{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 2}, {ID: "job-3", ItemID: 3},
+		}))
+		for i := 0; i < 2; i++ {
+			job, err := queue.DequeueReservation(ctx)
+			require.NoError(t, err)
+			job.Attempts = 3
+			job.RecordFailure("reservation service timed out", domain.JobErrorClassTimeout, time.Now())
+			require.NoError(t, queue.FailJob(ctx, job))
+		}
+
+		failed, total, err := queue.ListJobs(ctx, domain.JobStatusFailed, 0, 10)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+		require.Len(t, failed, 2)
+		assert.Equal(t, "reservation service timed out", failed[0].LastError)
+
+		retried, err := queue.RetryFailedJobs(ctx, domain.DeadLetterFilter{ItemID: 2})
+		require.NoError(t, err)
+		assert.Equal(t, 1, retried)
+		require.NoError(t, queue.RetryJob(ctx, "job-1"))
+
+		assert.ErrorIs(t, queue.RetryJob(ctx, "job-1"), domain.ErrJobNotFailed)
+		assert.ErrorIs(t, queue.RetryJob(ctx, "job-4"), domain.ErrJobNotFound)
+
+		// replayed jobs start over behind the job that was waiting
+		for _, id := range []string{"job-3", "job-2", "job-1"} {
+			job, err := queue.DequeueReservation(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, id, job.ID)
+			assert.Equal(t, 0, job.Attempts)
+		}
+	})
+}
+
+func TestQueueDelaysRetries(t *testing.T) {
+	forEachQueue(t, func(t *testing.T, queue adminQueue, advance func(time.Duration)) {
+		ctx := context.Background()
+		require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+		job, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		job.Attempts++
+		require.NoError(t, queue.RequeueJob(ctx, job, time.Minute))
+
+		depths, err := queue.QueueDepths(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), depths[domain.JobStatusDelayed])
+		assert.Equal(t, int64(0), depths[domain.JobStatusProcessing])
+
+		promoted, err := queue.PromoteDueJobs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 0, promoted)
+
+		advance(time.Minute)
+		promoted, err = queue.PromoteDueJobs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, promoted)
+
+		job, err = queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "job-1", job.ID)
+		assert.Equal(t, 1, job.Attempts)
+
+		// without a delay the job is pending right away
+		require.NoError(t, queue.RequeueJob(ctx, job, 0))
+		job, err = queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "job-1", job.ID)
+	})
+}
+
+func TestQueueRequeuesExpiredLeases(t *testing.T) {
+	forEachQueue(t, func(t *testing.T, queue adminQueue, advance func(time.Duration)) {
+		ctx := context.Background()
+		require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 2}}))
+
+		expired, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		extended, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+
+		// the heartbeat keeps one job leased, the other one's worker died
+		advance(20 * time.Second)
+		require.NoError(t, queue.ExtendLease(ctx, extended))
+		advance(20 * time.Second)
+
+		requeued, err := queue.RequeueExpiredJobs(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 1, requeued)
+		assert.ErrorIs(t, queue.ExtendLease(ctx, expired), domain.ErrLeaseLost)
+		require.NoError(t, queue.ExtendLease(ctx, extended))
+
+		job, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, "job-1", job.ID)
+		assert.Equal(t, 1, job.Attempts)
+		assert.Equal(t, domain.JobErrorClassLeaseExpired, job.ErrorClass)
+	})
+}
+
+func TestQueueDiscardsJobs(t *testing.T) {
+	forEachQueue(t, func(t *testing.T, queue adminQueue, _ func(time.Duration)) {
+		ctx := context.Background()
+		require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+			{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 2}, {ID: "job-3", ItemID: 3}, {ID: "job-4", ItemID: 4},
+		}))
+
+		processing, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		assert.ErrorIs(t, queue.DiscardJob(ctx, processing.ID), domain.ErrJobProcessing)
+		_, err = queue.PurgeJobs(ctx, domain.JobStatusProcessing)
+		assert.ErrorIs(t, err, domain.ErrJobProcessing)
+
+		require.NoError(t, queue.DiscardJob(ctx, "job-2"))
+		assert.ErrorIs(t, queue.DiscardJob(ctx, "job-2"), domain.ErrJobNotFound)
+
+		purged, err := queue.PurgeJobs(ctx, domain.JobStatusPending)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), purged)
+
+		require.NoError(t, queue.CompleteJob(ctx, processing))
+		purged, err = queue.PurgeJobs(ctx, domain.JobStatusCompleted)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), purged)
+
+		depths, err := queue.QueueDepths(ctx)
+		require.NoError(t, err)
+		for status, depth := range depths {
+			assert.Zero(t, depth, status)
+		}
+	})
+}
@@ -5,15 +5,32 @@ import (
 	"encoding/json"
 	"time"
 
+	"github.com/a-berahman/shopping-cart/config"
 	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
+// jobs are stored once in JobsKey, the lists and sorted sets only hold their IDs
 const (
+	// ReservationQueueKey lists the IDs of pending normal jobs, jobs are pushed to the head and dequeued from the tail
 	ReservationQueueKey = "reservation:queue"
-	ProcessingSetKey    = "reservation:processing"
-	FailedSetKey        = "reservation:failed"
-	CompletedSetKey     = "reservation:completed"
+	// InteractiveQueueKey and BackgroundQueueKey list the IDs of the pending jobs of the other lanes
+	InteractiveQueueKey = "reservation:queue:interactive"
+	BackgroundQueueKey  = "reservation:queue:background"
+	// FailedSetKey scores failed job IDs with the unix milliseconds they failed at
+	FailedSetKey = "reservation:failed"
+	// DelayedSetKey scores the IDs of jobs waiting for a retry with the unix milliseconds they are due at
+	DelayedSetKey = "reservation:delayed"
+	// CompletedSetKey scores completed job IDs with the unix milliseconds they completed at
+	CompletedSetKey = "reservation:completed"
+	// JobsKey maps every job ID to the job
+	JobsKey = "reservation:jobs"
+	// LeasesKey scores the ID of every processing job with its lease deadline in unix milliseconds,
+	// a job is processing exactly while it has a lease
+	LeasesKey = "reservation:leases"
+	// ItemsKey maps every item with a job in flight to the ID of that job, no other job of the item is dequeued meanwhile
+	ItemsKey = "reservation:items"
 )
 
 type ReservationJob struct {
@@ -29,102 +46,152 @@ type ReservationJob struct {
 
 type RedisQueue struct {
 	client *redis.Client
+	// visibilityTimeout is how long a dequeued job stays leased without a heartbeat
+	visibilityTimeout time.Duration
+	// deadLetterMaxJobs and deadLetterMaxAge bound the failed jobs that are kept, zero keeps every one
+	deadLetterMaxJobs int
+	deadLetterMaxAge  time.Duration
+	// completedMaxJobs and completedMaxAge bound the completed jobs that are kept the same way
+	completedMaxJobs int
+	completedMaxAge  time.Duration
+	// lanes takes turns between the pending lists of the priorities
+	lanes laneScheduler
 }
 
-func NewRedisQueue(redisURL string) (*RedisQueue, error) {
+func NewRedisQueue(redisURL string, cfg config.QueueConfig) (*RedisQueue, error) {
 	opt, err := redis.ParseURL(redisURL)
 	if err != nil {
 		return nil, err
 	}
 
 	client := redis.NewClient(opt)
-	return &RedisQueue{client: client}, nil
+	return &RedisQueue{
+		client:            client,
+		visibilityTimeout: cfg.VisibilityTimeout,
+		deadLetterMaxJobs: cfg.DeadLetterMaxJobs,
+		deadLetterMaxAge:  cfg.DeadLetterMaxAge,
+		completedMaxJobs:  cfg.CompletedMaxJobs,
+		completedMaxAge:   cfg.CompletedMaxAge,
+	}, nil
+}
+
+// Client returns the underlying redis client, so other adapters share its connection pool
+func (q *RedisQueue) Client() *redis.Client {
+	return q.client
 }
 
 func (q *RedisQueue) EnqueueReservation(ctx context.Context, job *domain.ReservationJob) error {
-	job.CreatedAt = time.Now()
-	job.Status = domain.JobStatusPending
+	return q.EnqueueReservations(ctx, []*domain.ReservationJob{job})
+}
 
-	jobData, err := json.Marshal(job)
-	if err != nil {
-		return err
+// EnqueueReservations enqueues all jobs in one MULTI/EXEC pipeline, either every job is enqueued or none
+func (q *RedisQueue) EnqueueReservations(ctx context.Context, jobs []*domain.ReservationJob) error {
+	if len(jobs) == 0 {
+		return nil
 	}
 
-	// Add to pending queue
-	return q.client.LPush(ctx, ReservationQueueKey, jobData).Err()
+	now := time.Now()
+	keys := make([]string, 0, len(jobs)+1)
+	keys = append(keys, JobsKey)
+	args := make([]interface{}, 0, 2*len(jobs))
+	for _, job := range jobs {
+		// the job is stored by its ID, it must have one
+		if job.ID == "" {
+			job.ID = uuid.New().String()
+		}
+		job.CreatedAt = now
+		job.Status = domain.JobStatusPending
+
+		jobData, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, laneKeys[job.Lane()])
+		args = append(args, job.ID, jobData)
+	}
+	return enqueueJobs.Run(ctx, q.client, keys, args...).Err()
 }
 
+// DequeueReservation leases the next pending job, jobs whose item has another job in flight are skipped.
+// it waits for one until ctx is done
 func (q *RedisQueue) DequeueReservation(ctx context.Context) (*domain.ReservationJob, error) {
-	// Move job from queue to processing set with BRPOPLPUSH
-	result, err := q.client.BRPopLPush(ctx, ReservationQueueKey, ProcessingSetKey, 0).Result()
+	id, err := q.nextJobID(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	var job domain.ReservationJob
-	if err := json.Unmarshal([]byte(result), &job); err != nil {
+	job, err := q.GetJob(ctx, id)
+	if err != nil {
 		return nil, err
 	}
-
 	job.Status = domain.JobStatusProcessing
 	job.LastAttempted = time.Now()
 
-	return &job, nil
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		return nil, err
+	}
+
+	// the dequeue leased the job already, this stores it as processing
+	leased, err := leaseJob.Run(ctx, q.client, []string{LeasesKey, JobsKey}, job.ID, q.visibilityTimeout.Milliseconds(), jobData).Int()
+	if err != nil {
+		return nil, err
+	}
+	if leased == 0 {
+		return nil, domain.ErrLeaseLost
+	}
+	return job, nil
 }
 
+// CompleteJob files the job as completed, the oldest completed jobs are dropped beyond the retention limit
 func (q *RedisQueue) CompleteJob(ctx context.Context, job *domain.ReservationJob) error {
 	job.Status = domain.JobStatusCompleted
-
-	jobData, err := json.Marshal(job)
-	if err != nil {
+	if err := q.releaseJob(ctx, job); err != nil {
 		return err
 	}
-
-	pipe := q.client.Pipeline()
-	// remove from processing set
-	pipe.LRem(ctx, ProcessingSetKey, 0, jobData)
-	// add to completed set
-	pipe.LPush(ctx, CompletedSetKey, jobData)
-
-	_, err = pipe.Exec(ctx)
+	_, err := q.TrimCompletedJobs(ctx)
 	return err
 }
 
+// FailJob dead-letters the job, the oldest failed jobs are dropped beyond the retention limit
 func (q *RedisQueue) FailJob(ctx context.Context, job *domain.ReservationJob) error {
 	job.Status = domain.JobStatusFailed
-
-	jobData, err := json.Marshal(job)
-	if err != nil {
+	if err := q.releaseJob(ctx, job); err != nil {
 		return err
 	}
-
-	pipe := q.client.Pipeline()
-	// removing from processing set
-	pipe.LRem(ctx, ProcessingSetKey, 0, jobData)
-	// adding to failed set
-	pipe.LPush(ctx, FailedSetKey, jobData)
-
-	_, err = pipe.Exec(ctx)
+	_, err := q.TrimDeadLetters(ctx)
 	return err
 }
 
-// RetryFailedJobs moves failed jobs back to the pending queue
-func (q *RedisQueue) RetryFailedJobs(ctx context.Context) error {
-	pipe := q.client.Pipeline()
-
-	// we move all failed jobs back to pending queue
-	pipe.LRange(ctx, FailedSetKey, 0, -1).Result()
-	pipe.Del(ctx, FailedSetKey)
+// RequeueJob puts the job back to the tail of the pending queue, or to the delayed set until delay passed.
+// the job keeps its attempts, and its item until it is pending again
+func (q *RedisQueue) RequeueJob(ctx context.Context, job *domain.ReservationJob, delay time.Duration) error {
+	if delay <= 0 {
+		job.Status = domain.JobStatusPending
+		return q.releaseJob(ctx, job)
+	}
 
-	cmds, err := pipe.Exec(ctx)
+	job.Status = domain.JobStatusDelayed
+	jobData, err := json.Marshal(job)
 	if err != nil {
 		return err
 	}
+	keys := []string{LeasesKey, JobsKey, DelayedSetKey}
+	return delayJob.Run(ctx, q.client, keys, job.ID, jobData, delay.Milliseconds()).Err()
+}
 
-	jobs := cmds[0].(*redis.StringSliceCmd).Val()
-	if len(jobs) == 0 {
-		return nil
+// releaseJob drops the lease of the job, frees its item and files it under its new status
+func (q *RedisQueue) releaseJob(ctx context.Context, job *domain.ReservationJob) error {
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		return err
 	}
 
-	return q.client.LPush(ctx, ReservationQueueKey, jobs).Err()
+	// pending is a list, the finished statuses are sorted sets scored by when the job got there
+	score := ""
+	if isSortedSet(job.Status) {
+		score = scoreNow()
+	}
+	keys := []string{LeasesKey, JobsKey, jobKey(job), ItemsKey}
+	return releaseJob.Run(ctx, q.client, keys, job.ID, jobData, score).Err()
 }
@@ -0,0 +1,284 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+// statusKeys maps every job status to the key holding the IDs of jobs with it,
+// pending jobs are spread over the lists of their lanes and only normal ones are in its key
+var statusKeys = map[domain.JobStatus]string{
+	domain.JobStatusPending:    ReservationQueueKey,
+	domain.JobStatusDelayed:    DelayedSetKey,
+	domain.JobStatusProcessing: LeasesKey,
+	domain.JobStatusCompleted:  CompletedSetKey,
+	domain.JobStatusFailed:     FailedSetKey,
+}
+
+// isSortedSet reports whether the IDs of the status are a sorted set, only pending jobs are in lists.
+// processing jobs are scored by their lease deadline
+func isSortedSet(status domain.JobStatus) bool {
+	return status != domain.JobStatusPending
+}
+
+// retryFailed moves a job from the failed set to the pending queue, only if it is still failed,
+// a worker or another operator may have moved it since we read it
+var retryFailed = redis.NewScript(`
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+  redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+  redis.call('LPUSH', KEYS[2], ARGV[1])
+  return 1
+end
+return 0
+`)
+
+// discardJob removes a job and its ID from KEYS[1], a sorted set when ARGV[2] is 1, and frees its item
+var discardJob = redis.NewScript(itemLocks + `
+local removed
+if ARGV[2] == '1' then
+  removed = redis.call('ZREM', KEYS[1], ARGV[1])
+else
+  removed = redis.call('LREM', KEYS[1], 1, ARGV[1])
+end
+if removed == 1 then
+  unlockItem(KEYS[2], KEYS[3], ARGV[1])
+  redis.call('HDEL', KEYS[2], ARGV[1])
+end
+return removed
+`)
+
+// purgeJobs removes every job whose ID is in KEYS[1], a sorted set when ARGV[1] is 1, and frees their items
+var purgeJobs = redis.NewScript(itemLocks + `
+local ids
+if ARGV[1] == '1' then
+  ids = redis.call('ZRANGE', KEYS[1], 0, -1)
+else
+  ids = redis.call('LRANGE', KEYS[1], 0, -1)
+end
+for _, id in ipairs(ids) do
+  unlockItem(KEYS[2], KEYS[3], id)
+  redis.call('HDEL', KEYS[2], id)
+end
+redis.call('DEL', KEYS[1])
+return #ids
+`)
+
+// QueueDepths returns the number of jobs with every status
+func (q *RedisQueue) QueueDepths(ctx context.Context) (map[domain.JobStatus]int64, error) {
+	cmds := make(map[domain.JobStatus][]*redis.IntCmd, len(statusKeys))
+	_, err := q.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for status, key := range statusKeys {
+			switch {
+			case status == domain.JobStatusPending:
+				for _, key := range pendingKeys() {
+					cmds[status] = append(cmds[status], pipe.LLen(ctx, key))
+				}
+			case isSortedSet(status):
+				cmds[status] = []*redis.IntCmd{pipe.ZCard(ctx, key)}
+			default:
+				cmds[status] = []*redis.IntCmd{pipe.LLen(ctx, key)}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	depths := make(map[domain.JobStatus]int64, len(cmds))
+	for status, statusCmds := range cmds {
+		for _, cmd := range statusCmds {
+			depths[status] += cmd.Val()
+		}
+	}
+	return depths, nil
+}
+
+// ListJobs returns a page of the jobs with the status, newest first. pending jobs are listed lane by lane,
+// highest priority first
+func (q *RedisQueue) ListJobs(ctx context.Context, status domain.JobStatus, offset, limit int) ([]*domain.ReservationJob, int64, error) {
+	key, ok := statusKeys[status]
+	if !ok {
+		return nil, 0, fmt.Errorf("unknown job status: %s", status)
+	}
+	if status == domain.JobStatusPending {
+		ids, total, err := q.listPendingIDs(ctx, offset, limit)
+		if err != nil {
+			return nil, 0, err
+		}
+		jobs, err := getJobs(ctx, q.client, JobsKey, ids)
+		if err != nil {
+			return nil, 0, err
+		}
+		return jobs, total, nil
+	}
+
+	// lists get new jobs at the head, sorted sets are scored by time
+	var rangeCmd *redis.StringSliceCmd
+	var lenCmd *redis.IntCmd
+	_, err := q.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if isSortedSet(status) {
+			rangeCmd = pipe.ZRevRange(ctx, key, int64(offset), int64(offset+limit-1))
+			lenCmd = pipe.ZCard(ctx, key)
+		} else {
+			rangeCmd = pipe.LRange(ctx, key, int64(offset), int64(offset+limit-1))
+			lenCmd = pipe.LLen(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	jobs, err := getJobs(ctx, q.client, JobsKey, rangeCmd.Val())
+	if err != nil {
+		return nil, 0, err
+	}
+	return jobs, lenCmd.Val(), nil
+}
+
+// GetJob reads the job from the jobs hash
+func (q *RedisQueue) GetJob(ctx context.Context, id string) (*domain.ReservationJob, error) {
+	return getJob(ctx, q.client, JobsKey, id)
+}
+
+// getJob reads a job from the jobs hash jobsKey
+func getJob(ctx context.Context, client *redis.Client, jobsKey, id string) (*domain.ReservationJob, error) {
+	payload, err := client.HGet(ctx, jobsKey, id).Result()
+	if err == redis.Nil {
+		return nil, domain.ErrJobNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeJob(payload)
+}
+
+// RetryJob moves a single failed job back to the pending queue
+func (q *RedisQueue) RetryJob(ctx context.Context, id string) error {
+	job, err := q.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if job.Status != domain.JobStatusFailed {
+		return domain.ErrJobNotFailed
+	}
+	return q.retryJob(ctx, job)
+}
+
+// retryJob moves a failed job to the pending queue with its attempts reset, the worker would fail it
+// right away otherwise. it keeps its history, so a replayed job shows why it failed before
+func (q *RedisQueue) retryJob(ctx context.Context, job *domain.ReservationJob) error {
+	id := job.ID
+	job.Status = domain.JobStatusPending
+	job.Attempts = 0
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+
+	moved, err := retryFailed.Run(ctx, q.client, []string{FailedSetKey, laneKeys[job.Lane()], JobsKey}, id, jobData).Int()
+	if err != nil {
+		return err
+	}
+	if moved == 0 {
+		return domain.ErrJobNotFound
+	}
+	return nil
+}
+
+// DiscardJob removes a job for good, jobs held by a worker can't be discarded
+func (q *RedisQueue) DiscardJob(ctx context.Context, id string) error {
+	job, err := q.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if job.Status == domain.JobStatusProcessing {
+		return domain.ErrJobProcessing
+	}
+
+	removed, err := discardJob.Run(ctx, q.client, []string{jobKey(job), JobsKey, ItemsKey}, id, sortedSetArg(job.Status)).Int()
+	if err != nil {
+		return err
+	}
+	if removed == 0 {
+		return domain.ErrJobNotFound
+	}
+	return nil
+}
+
+// PurgeJobs removes every job with the status, jobs held by a worker can't be purged
+func (q *RedisQueue) PurgeJobs(ctx context.Context, status domain.JobStatus) (int64, error) {
+	key, ok := statusKeys[status]
+	if !ok {
+		return 0, fmt.Errorf("unknown job status: %s", status)
+	}
+	if status == domain.JobStatusProcessing {
+		return 0, domain.ErrJobProcessing
+	}
+	if status != domain.JobStatusPending {
+		return purgeJobs.Run(ctx, q.client, []string{key, JobsKey, ItemsKey}, sortedSetArg(status)).Int64()
+	}
+
+	var purged int64
+	for _, key := range pendingKeys() {
+		n, err := purgeJobs.Run(ctx, q.client, []string{key, JobsKey, ItemsKey}, sortedSetArg(status)).Int64()
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	return purged, nil
+}
+
+// getJobs reads the jobs in the order of ids from the jobs hash jobsKey, IDs whose job is gone are skipped
+func getJobs(ctx context.Context, client *redis.Client, jobsKey string, ids []string) ([]*domain.ReservationJob, error) {
+	jobs := make([]*domain.ReservationJob, 0, len(ids))
+	if len(ids) == 0 {
+		return jobs, nil
+	}
+
+	payloads, err := client.HMGet(ctx, jobsKey, ids...).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, payload := range payloads {
+		data, ok := payload.(string)
+		if !ok {
+			continue
+		}
+		job, err := decodeJob(data)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+// sortedSetArg tells the scripts what kind of key holds the IDs of the status
+func sortedSetArg(status domain.JobStatus) string {
+	if isSortedSet(status) {
+		return "1"
+	}
+	return "0"
+}
+
+func decodeJob(payload string) (*domain.ReservationJob, error) {
+	var job domain.ReservationJob
+	if err := json.Unmarshal([]byte(payload), &job); err != nil {
+		return nil, fmt.Errorf("error decoding job: %w", err)
+	}
+	return &job, nil
+}
+
+// scoreNow is the score of a job filed in a sorted set now
+func scoreNow() string {
+	return strconv.FormatInt(time.Now().UnixMilli(), 10)
+}
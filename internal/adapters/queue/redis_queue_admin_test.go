@@ -0,0 +1,125 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addJobs files jobs with the status like the queue does, the last job is the newest
+func addJobs(t *testing.T, client *redis.Client, status domain.JobStatus, ids ...string) {
+	ctx := context.Background()
+	for i, id := range ids {
+		data, err := json.Marshal(&domain.ReservationJob{ID: id, ItemID: 1, ItemName: "Item", Quantity: 1, Status: status})
+		require.NoError(t, err)
+		require.NoError(t, client.HSet(ctx, JobsKey, id, data).Err())
+
+		if isSortedSet(status) {
+			require.NoError(t, client.ZAdd(ctx, statusKeys[status], redis.Z{Score: float64(i + 1), Member: id}).Err())
+		} else {
+			require.NoError(t, client.LPush(ctx, statusKeys[status], id).Err())
+		}
+	}
+}
+
+func TestQueueDepths(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	addJobs(t, client, domain.JobStatusPending, "job-1", "job-2")
+	addJobs(t, client, domain.JobStatusFailed, "job-3")
+
+	depths, err := queue.QueueDepths(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, map[domain.JobStatus]int64{
+		domain.JobStatusPending:    2,
+		domain.JobStatusDelayed:    0,
+		domain.JobStatusProcessing: 0,
+		domain.JobStatusCompleted:  0,
+		domain.JobStatusFailed:     1,
+	}, depths)
+}
+
+func TestListJobs(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+	addJobs(t, client, domain.JobStatusFailed, "job-1", "job-2", "job-3")
+
+	jobs, total, err := queue.ListJobs(ctx, domain.JobStatusFailed, 0, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), total)
+	require.Len(t, jobs, 2)
+	assert.Equal(t, "job-3", jobs[0].ID)
+	assert.Equal(t, "job-2", jobs[1].ID)
+	assert.Equal(t, domain.JobStatusFailed, jobs[0].Status)
+
+	jobs, _, err = queue.ListJobs(ctx, domain.JobStatusFailed, 2, 2)
+	require.NoError(t, err)
+	require.Len(t, jobs, 1)
+	assert.Equal(t, "job-1", jobs[0].ID)
+
+	_, _, err = queue.ListJobs(ctx, domain.JobStatus("UNKNOWN"), 0, 2)
+	assert.Error(t, err)
+}
+
+func TestGetJob(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	addJobs(t, client, domain.JobStatusCompleted, "job-1")
+
+	job, err := queue.GetJob(context.Background(), "job-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobStatusCompleted, job.Status)
+
+	_, err = queue.GetJob(context.Background(), "job-2")
+	assert.ErrorIs(t, err, domain.ErrJobNotFound)
+}
+
+func TestRetryJob(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+	addJobs(t, client, domain.JobStatusFailed, "job-1", "job-2")
+	addJobs(t, client, domain.JobStatusCompleted, "job-3")
+
+	require.NoError(t, queue.RetryJob(ctx, "job-1"))
+	assert.Equal(t, int64(1), client.ZCard(ctx, FailedSetKey).Val())
+	assert.Equal(t, int64(1), client.LLen(ctx, ReservationQueueKey).Val())
+
+	job, err := queue.GetJob(ctx, "job-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobStatusPending, job.Status)
+
+	assert.ErrorIs(t, queue.RetryJob(ctx, "job-3"), domain.ErrJobNotFailed)
+	assert.ErrorIs(t, queue.RetryJob(ctx, "job-4"), domain.ErrJobNotFound)
+}
+
+func TestDiscardJob(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+	addJobs(t, client, domain.JobStatusFailed, "job-1")
+	addJobs(t, client, domain.JobStatusProcessing, "job-2")
+
+	require.NoError(t, queue.DiscardJob(ctx, "job-1"))
+	assert.Equal(t, int64(0), client.ZCard(ctx, FailedSetKey).Val())
+
+	assert.ErrorIs(t, queue.DiscardJob(ctx, "job-2"), domain.ErrJobProcessing)
+	assert.ErrorIs(t, queue.DiscardJob(ctx, "job-1"), domain.ErrJobNotFound)
+}
+
+func TestPurgeJobs(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+	addJobs(t, client, domain.JobStatusCompleted, "job-1", "job-2")
+	addJobs(t, client, domain.JobStatusFailed, "job-3")
+
+	purged, err := queue.PurgeJobs(ctx, domain.JobStatusCompleted)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), purged)
+	assert.Equal(t, int64(0), client.ZCard(ctx, CompletedSetKey).Val())
+	assert.Equal(t, int64(1), client.ZCard(ctx, FailedSetKey).Val())
+
+	_, err = queue.PurgeJobs(ctx, domain.JobStatusProcessing)
+	assert.ErrorIs(t, err, domain.ErrJobProcessing)
+}
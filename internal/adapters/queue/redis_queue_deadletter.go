@@ -0,0 +1,112 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+// replayBatch is how many failed jobs are read at once while replaying
+const replayBatch = 100
+
+// trimFinished drops the jobs in the completed or failed set KEYS[1] that finished before ARGV[1] and then
+// the oldest beyond ARGV[2] jobs, zero disables either limit. the jobs are removed from the jobs hash KEYS[2]
+var trimFinished = redis.NewScript(`
+local ids = {}
+if tonumber(ARGV[1]) > 0 then
+  ids = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', '(' .. ARGV[1])
+end
+local max = tonumber(ARGV[2])
+if max > 0 then
+  local excess = redis.call('ZCARD', KEYS[1]) - #ids - max
+  if excess > 0 then
+    for _, id in ipairs(redis.call('ZRANGE', KEYS[1], #ids, #ids + excess - 1)) do
+      table.insert(ids, id)
+    end
+  end
+end
+for _, id in ipairs(ids) do
+  redis.call('ZREM', KEYS[1], id)
+  redis.call('HDEL', KEYS[2], id)
+end
+return #ids
+`)
+
+// RetryFailedJobs replays the failed jobs the filter matches, oldest failure first.
+// jobs retried or discarded meanwhile are skipped
+func (q *RedisQueue) RetryFailedJobs(ctx context.Context, filter domain.DeadLetterFilter) (int, error) {
+	return retryFailedJobs(ctx, q.client, FailedSetKey, JobsKey, filter, q.retryJob)
+}
+
+// TrimDeadLetters drops the failed jobs beyond the retention limits and returns how many
+func (q *RedisQueue) TrimDeadLetters(ctx context.Context) (int, error) {
+	return trimFinishedJobs(ctx, q.client, FailedSetKey, JobsKey, q.deadLetterMaxJobs, q.deadLetterMaxAge)
+}
+
+// TrimCompletedJobs drops the completed jobs beyond the retention limits and returns how many
+func (q *RedisQueue) TrimCompletedJobs(ctx context.Context) (int, error) {
+	return trimFinishedJobs(ctx, q.client, CompletedSetKey, JobsKey, q.completedMaxJobs, q.completedMaxAge)
+}
+
+// retryFailedJobs replays the jobs of the failed set failedKey the filter matches with retry
+func retryFailedJobs(
+	ctx context.Context,
+	client *redis.Client,
+	failedKey, jobsKey string,
+	filter domain.DeadLetterFilter,
+	retry func(context.Context, *domain.ReservationJob) error,
+) (int, error) {
+	// failed jobs are scored by when they failed, the time range is a score range
+	failedRange := &redis.ZRangeBy{Min: "-inf", Max: "+inf"}
+	if !filter.FailedAfter.IsZero() {
+		failedRange.Min = strconv.FormatInt(filter.FailedAfter.UnixMilli(), 10)
+	}
+	if !filter.FailedBefore.IsZero() {
+		failedRange.Max = "(" + strconv.FormatInt(filter.FailedBefore.UnixMilli(), 10)
+	}
+	ids, err := client.ZRangeByScore(ctx, failedKey, failedRange).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	retried := 0
+	for start := 0; start < len(ids); start += replayBatch {
+		end := min(start+replayBatch, len(ids))
+		jobs, err := getJobs(ctx, client, jobsKey, ids[start:end])
+		if err != nil {
+			return retried, err
+		}
+
+		for _, job := range jobs {
+			if job.Status != domain.JobStatusFailed || !filter.Matches(job) {
+				continue
+			}
+			err := retry(ctx, job)
+			if errors.Is(err, domain.ErrJobNotFound) {
+				continue
+			}
+			if err != nil {
+				return retried, err
+			}
+			retried++
+		}
+	}
+	return retried, nil
+}
+
+// trimFinishedJobs drops the jobs of the completed or failed set setKey beyond the limits, zero lifts a limit
+func trimFinishedJobs(ctx context.Context, client *redis.Client, setKey, jobsKey string, maxJobs int, maxAge time.Duration) (int, error) {
+	if maxJobs <= 0 && maxAge <= 0 {
+		return 0, nil
+	}
+
+	var cutoff int64
+	if maxAge > 0 {
+		cutoff = time.Now().Add(-maxAge).UnixMilli()
+	}
+	return trimFinished.Run(ctx, client, []string{setKey, jobsKey}, cutoff, maxJobs).Int()
+}
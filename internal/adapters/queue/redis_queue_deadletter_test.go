@@ -0,0 +1,156 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addFailedJob dead-letters a job that failed at the time
+func addFailedJob(t *testing.T, client *redis.Client, job *domain.ReservationJob, failedAt time.Time) {
+	ctx := context.Background()
+	job.Status = domain.JobStatusFailed
+	data, err := json.Marshal(job)
+	require.NoError(t, err)
+	require.NoError(t, client.HSet(ctx, JobsKey, job.ID, data).Err())
+	require.NoError(t, client.ZAdd(ctx, FailedSetKey, redis.Z{Score: float64(failedAt.UnixMilli()), Member: job.ID}).Err())
+}
+
+func TestRetryFailedJobs(t *testing.T) {
+	day := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		filter  domain.DeadLetterFilter
+		retried []string
+	}{
+		{name: "every job", retried: []string{"job-1", "job-2", "job-3", "job-4"}},
+		{name: "by job type", filter: domain.DeadLetterFilter{JobType: domain.JobTypeReservation}, retried: []string{"job-3", "job-4"}},
+		{name: "by item", filter: domain.DeadLetterFilter{ItemID: 2}, retried: []string{"job-2", "job-4"}},
+		{name: "by error class", filter: domain.DeadLetterFilter{ErrorClass: domain.JobErrorClassTimeout}, retried: []string{"job-1", "job-4"}},
+		{
+			name:    "by time range",
+			filter:  domain.DeadLetterFilter{FailedAfter: day.Add(time.Hour), FailedBefore: day.Add(3 * time.Hour)},
+			retried: []string{"job-2", "job-3"},
+		},
+		{
+			name:    "by every filter",
+			filter:  domain.DeadLetterFilter{JobType: domain.JobTypeReservation, ItemID: 2, ErrorClass: domain.JobErrorClassTimeout},
+			retried: []string{"job-4"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue, client := setupTestRedis(t)
+			ctx := context.Background()
+			jobs := []*domain.ReservationJob{
+				{ID: "job-1", ItemID: 1, JobType: domain.JobTypeAvailabilityCheck, ErrorClass: domain.JobErrorClassTimeout},
+				{ID: "job-2", ItemID: 2, JobType: domain.JobTypeAvailabilityCheck, ErrorClass: domain.JobErrorClassUnknown},
+				{ID: "job-3", ItemID: 1, JobType: domain.JobTypeReservation, ErrorClass: domain.JobErrorClassLeaseExpired},
+				{ID: "job-4", ItemID: 2, JobType: domain.JobTypeReservation, ErrorClass: domain.JobErrorClassTimeout},
+			}
+			for i, job := range jobs {
+				job.Attempts = 3
+				addFailedJob(t, client, job, day.Add(time.Duration(i)*time.Hour))
+			}
+
+			retried, err := queue.RetryFailedJobs(ctx, tt.filter)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.retried), retried)
+			assert.ElementsMatch(t, tt.retried, client.LRange(ctx, ReservationQueueKey, 0, -1).Val())
+			assert.Equal(t, int64(len(jobs)-len(tt.retried)), client.ZCard(ctx, FailedSetKey).Val())
+
+			for _, id := range tt.retried {
+				job, err := queue.GetJob(ctx, id)
+				require.NoError(t, err)
+				assert.Equal(t, domain.JobStatusPending, job.Status)
+				assert.Equal(t, 0, job.Attempts)
+			}
+		})
+	}
+}
+
+func TestFailJobKeepsErrorHistory(t *testing.T) {
+	queue, _ := setupTestRedis(t)
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	job.Attempts = 1
+	job.RecordFailure("reservation service timed out", domain.JobErrorClassTimeout, time.Now())
+	require.NoError(t, queue.FailJob(ctx, job))
+
+	failed, err := queue.GetJob(ctx, "job-1")
+	require.NoError(t, err)
+	assert.Equal(t, "reservation service timed out", failed.LastError)
+	assert.Equal(t, domain.JobErrorClassTimeout, failed.ErrorClass)
+	require.Len(t, failed.History, 1)
+	assert.Equal(t, 1, failed.History[0].Attempt)
+
+	// a replayed job remembers why it failed before
+	require.NoError(t, queue.RetryJob(ctx, "job-1"))
+	replayed, err := queue.GetJob(ctx, "job-1")
+	require.NoError(t, err)
+	assert.Equal(t, 0, replayed.Attempts)
+	assert.Len(t, replayed.History, 1)
+}
+
+func TestTrimDeadLetters(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+	queue.deadLetterMaxJobs = 2
+	queue.deadLetterMaxAge = 24 * time.Hour
+
+	now := time.Now()
+	addFailedJob(t, client, &domain.ReservationJob{ID: "job-1"}, now.Add(-48*time.Hour))
+	addFailedJob(t, client, &domain.ReservationJob{ID: "job-2"}, now.Add(-3*time.Hour))
+	addFailedJob(t, client, &domain.ReservationJob{ID: "job-3"}, now.Add(-2*time.Hour))
+	addFailedJob(t, client, &domain.ReservationJob{ID: "job-4"}, now.Add(-time.Hour))
+
+	// job-1 is too old, job-2 the oldest beyond the max
+	trimmed, err := queue.TrimDeadLetters(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 2, trimmed)
+	assert.Equal(t, []string{"job-3", "job-4"}, client.ZRange(ctx, FailedSetKey, 0, -1).Val())
+	assert.False(t, client.HExists(ctx, JobsKey, "job-1").Val())
+	assert.False(t, client.HExists(ctx, JobsKey, "job-2").Val())
+
+	// failing a job trims too
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-5"}))
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	require.NoError(t, queue.FailJob(ctx, job))
+	assert.Equal(t, []string{"job-4", "job-5"}, client.ZRange(ctx, FailedSetKey, 0, -1).Val())
+}
+
+func TestTrimCompletedJobs(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+	queue.completedMaxJobs = 2
+	queue.completedMaxAge = time.Hour
+
+	old, err := json.Marshal(&domain.ReservationJob{ID: "job-1", Status: domain.JobStatusCompleted})
+	require.NoError(t, err)
+	require.NoError(t, client.HSet(ctx, JobsKey, "job-1", old).Err())
+	require.NoError(t, client.ZAdd(ctx, CompletedSetKey, redis.Z{Score: float64(time.Now().Add(-2 * time.Hour).UnixMilli()), Member: "job-1"}).Err())
+
+	// completing a job drops the ones too old and then the oldest beyond the max
+	require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+		{ID: "job-2", ItemID: 2}, {ID: "job-3", ItemID: 3}, {ID: "job-4", ItemID: 4},
+	}))
+	for i := 0; i < 3; i++ {
+		job, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		require.NoError(t, queue.CompleteJob(ctx, job))
+	}
+	assert.Equal(t, []string{"job-3", "job-4"}, client.ZRange(ctx, CompletedSetKey, 0, -1).Val())
+	assert.False(t, client.HExists(ctx, JobsKey, "job-1").Val())
+	assert.False(t, client.HExists(ctx, JobsKey, "job-2").Val())
+}
@@ -0,0 +1,80 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+// promoteBatch caps the jobs promoted per call, a long outage may leave many jobs due at once
+const promoteBatch = 100
+
+// delayJob releases a job like releaseJob and files it in the delayed set, due ARGV[3] milliseconds
+// from now on the redis clock the promoter compares with. the job keeps its item
+var delayJob = redis.NewScript(redisNowMillis + `
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('ZADD', KEYS[3], now + tonumber(ARGV[3]), ARGV[1])
+return 1
+`)
+
+// promoteJob moves a job from the delayed set to the pending queue and frees its item if it is still
+// delayed and due, an operator may have discarded it since we read it
+var promoteJob = redis.NewScript(redisNowMillis + itemLocks + `
+local due = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not due or tonumber(due) > now then
+  return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+unlockItem(KEYS[3], KEYS[4], ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+redis.call('RPUSH', KEYS[2], ARGV[1])
+return 1
+`)
+
+// PromoteDueJobs moves delayed jobs that are due to the pending queue. they go to the tail,
+// the delay was their wait in line
+func (q *RedisQueue) PromoteDueJobs(ctx context.Context) (int, error) {
+	now, err := q.client.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	ids, err := q.client.ZRangeByScore(ctx, DelayedSetKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: promoteBatch,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	promoted := 0
+	for _, id := range ids {
+		job, err := q.GetJob(ctx, id)
+		if errors.Is(err, domain.ErrJobNotFound) {
+			if err := q.client.ZRem(ctx, DelayedSetKey, id).Err(); err != nil {
+				return promoted, err
+			}
+			continue
+		}
+		if err != nil {
+			return promoted, err
+		}
+		job.Status = domain.JobStatusPending
+		jobData, err := json.Marshal(job)
+		if err != nil {
+			return promoted, err
+		}
+
+		moved, err := promoteJob.Run(ctx, q.client, []string{DelayedSetKey, laneKeys[job.Lane()], JobsKey, ItemsKey}, id, jobData).Int()
+		if err != nil {
+			return promoted, err
+		}
+		promoted += moved
+	}
+	return promoted, nil
+}
@@ -0,0 +1,65 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDelayedRetry(t *testing.T) {
+	queue, client, mr, now := setupLeaseTest(t)
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	job.Attempts++
+	require.NoError(t, queue.RequeueJob(ctx, job, 10*time.Second))
+
+	assert.Equal(t, int64(0), client.LLen(ctx, ReservationQueueKey).Val())
+	assert.Equal(t, int64(0), client.ZCard(ctx, LeasesKey).Val())
+	due, err := client.ZScore(ctx, DelayedSetKey, "job-1").Result()
+	require.NoError(t, err)
+	assert.Equal(t, float64(now.Add(10*time.Second).UnixMilli()), due)
+
+	job, err = queue.GetJob(ctx, "job-1")
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobStatusDelayed, job.Status)
+
+	// nothing is due before the backoff passed
+	promoted, err := queue.PromoteDueJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, promoted)
+
+	mr.SetTime(now.Add(10 * time.Second))
+	promoted, err = queue.PromoteDueJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, promoted)
+	assert.Equal(t, int64(0), client.ZCard(ctx, DelayedSetKey).Val())
+
+	job, err = queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "job-1", job.ID)
+	assert.Equal(t, 1, job.Attempts)
+}
+
+func TestPromoteSkipsDiscardedJobs(t *testing.T) {
+	queue, client, mr, now := setupLeaseTest(t)
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	require.NoError(t, queue.RequeueJob(ctx, job, time.Second))
+	require.NoError(t, queue.DiscardJob(ctx, "job-1"))
+
+	mr.SetTime(now.Add(time.Minute))
+	promoted, err := queue.PromoteDueJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, promoted)
+	assert.Equal(t, int64(0), client.LLen(ctx, ReservationQueueKey).Val())
+}
@@ -0,0 +1,42 @@
+package queue
+
+// itemScanDepth is how many of the oldest IDs of a lane a dequeue looks at for a job whose item is free,
+// jobs behind that many jobs of busy items wait until one of those is done
+const itemScanDepth = 100
+
+// itemLocks are the lua helpers that keep one job per item in flight. a job holds its item in ItemsKey
+// from the dequeue until it completes, fails for good or is pending again, a delayed retry keeps holding it,
+// so a later job of the item can't overtake the one waiting for its retry. the scripts the streams queue
+// shares run without an items key and leave items alone
+const itemLocks = `
+local function itemOf(jobs, id)
+  local payload = redis.call('HGET', jobs, id)
+  if not payload then
+    return nil
+  end
+  return tostring(cjson.decode(payload).item_id)
+end
+
+local function lockItem(jobs, items, id)
+  local item = itemOf(jobs, id)
+  if not item then
+    return true
+  end
+  local holder = redis.call('HGET', items, item)
+  if holder and holder ~= id then
+    return false
+  end
+  redis.call('HSET', items, item, id)
+  return true
+end
+
+local function unlockItem(jobs, items, id)
+  if not items then
+    return
+  end
+  local item = itemOf(jobs, id)
+  if item and redis.call('HGET', items, item) == id then
+    redis.call('HDEL', items, item)
+  end
+end
+`
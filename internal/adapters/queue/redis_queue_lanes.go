@@ -0,0 +1,168 @@
+package queue
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+// laneBlockTimeout is how long a dequeue blocks on the interactive lane while every lane is empty,
+// jobs of the other lanes arriving meanwhile wait for it to pass
+const laneBlockTimeout = time.Second
+
+// laneKeys maps every priority to the list of its pending job IDs, normal jobs keep the list
+// all pending jobs had before there were lanes
+var laneKeys = map[domain.JobPriority]string{
+	domain.JobPriorityInteractive: InteractiveQueueKey,
+	domain.JobPriorityNormal:      ReservationQueueKey,
+	domain.JobPriorityBackground:  BackgroundQueueKey,
+}
+
+// itemPollInterval is how long a dequeue waits while every pending job waits for another job of its item
+const itemPollInterval = 100 * time.Millisecond
+
+// dequeueNext takes the oldest ID of the first lane in KEYS whose item is free, leases it for ARGV[2]
+// milliseconds in KEYS[#KEYS-2] and locks its item. it returns false while every lane is empty and
+// an empty string while every job it looked at waits for its item
+var dequeueNext = redis.NewScript(redisNowMillis + itemLocks + `
+local leases, jobs, items = KEYS[#KEYS - 2], KEYS[#KEYS - 1], KEYS[#KEYS]
+local waiting = false
+for i = 1, #KEYS - 3 do
+  local ids = redis.call('LRANGE', KEYS[i], -tonumber(ARGV[1]), -1)
+  for j = #ids, 1, -1 do
+    if lockItem(jobs, items, ids[j]) then
+      redis.call('LREM', KEYS[i], -1, ids[j])
+      redis.call('ZADD', leases, now + tonumber(ARGV[2]), ids[j])
+      return ids[j]
+    end
+    waiting = true
+  end
+end
+if waiting then
+  return ''
+end
+return false
+`)
+
+// enqueueJobs stores the jobs given as ID and payload pairs in ARGV and pushes each to its lane, the lane of
+// the i-th job is KEYS[i + 1]. a job whose ID is stored already is skipped, the outbox relay may publish
+// a job twice
+var enqueueJobs = redis.NewScript(`
+local added = 0
+for i = 1, #ARGV, 2 do
+  if redis.call('HSETNX', KEYS[1], ARGV[i], ARGV[i + 1]) == 1 then
+    redis.call('LPUSH', KEYS[(i + 1) / 2 + 1], ARGV[i])
+    added = added + 1
+  end
+end
+return added
+`)
+
+// wakeUp puts back the job a blocking pop rotated from the tail of its lane KEYS[1] to its head,
+// unless another dequeue took it meanwhile
+var wakeUp = redis.NewScript(`
+if redis.call('LINDEX', KEYS[1], 0) == ARGV[1] then
+  redis.call('LPOP', KEYS[1])
+  redis.call('RPUSH', KEYS[1], ARGV[1])
+end
+return 1
+`)
+
+// nextJobID leases the next pending job whose item has no job in flight and returns its ID,
+// the lanes take turns by their weight. while every lane is empty it blocks on the interactive lane,
+// so a customer waiting isn't kept waiting for a poll
+func (q *RedisQueue) nextJobID(ctx context.Context) (string, error) {
+	for {
+		lanes := q.lanes.next()
+		keys := make([]string, 0, len(lanes)+3)
+		for _, lane := range lanes {
+			keys = append(keys, laneKeys[lane])
+		}
+		keys = append(keys, LeasesKey, JobsKey, ItemsKey)
+		id, err := dequeueNext.Run(ctx, q.client, keys, itemScanDepth, q.visibilityTimeout.Milliseconds()).Text()
+		switch {
+		case err == redis.Nil:
+			if err := q.waitForJob(ctx); err != nil {
+				return "", err
+			}
+		case err != nil:
+			return "", err
+		case id != "":
+			return id, nil
+		default:
+			select {
+			case <-ctx.Done():
+			case <-time.After(itemPollInterval):
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+	}
+}
+
+// waitForJob blocks until an interactive job arrives or laneBlockTimeout passed while every lane is empty.
+// the job stays in its lane, the pop only rotates it and wakeUp puts it back for the next dequeue
+func (q *RedisQueue) waitForJob(ctx context.Context) error {
+	id, err := q.client.BRPopLPush(ctx, InteractiveQueueKey, InteractiveQueueKey, laneBlockTimeout).Result()
+	if err == redis.Nil {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wakeUp.Run(ctx, q.client, []string{InteractiveQueueKey}, id).Err()
+}
+
+// jobKey returns the key holding the ID of the job, pending jobs wait in the list of their lane
+func jobKey(job *domain.ReservationJob) string {
+	if job.Status == domain.JobStatusPending {
+		return laneKeys[job.Lane()]
+	}
+	return statusKeys[job.Status]
+}
+
+// pendingKeys returns the lists of the lanes, highest priority first
+func pendingKeys() []string {
+	keys := make([]string, len(domain.JobPriorities))
+	for i, lane := range domain.JobPriorities {
+		keys[i] = laneKeys[lane]
+	}
+	return keys
+}
+
+// listPendingIDs returns a page of the pending job IDs, lane by lane from the highest priority
+// and newest first within a lane, and the number of pending jobs
+func (q *RedisQueue) listPendingIDs(ctx context.Context, offset, limit int) ([]string, int64, error) {
+	keys := pendingKeys()
+	lens := make([]*redis.IntCmd, len(keys))
+	_, err := q.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, key := range keys {
+			lens[i] = pipe.LLen(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ids []string
+	var total int64
+	start, end := int64(offset), int64(offset+limit)
+	for i, key := range keys {
+		length := lens[i].Val()
+		// the part of the page that falls into this lane
+		from, to := max(start-total, 0), min(end-total, length)
+		if from < to {
+			page, err := q.client.LRange(ctx, key, from, to-1).Result()
+			if err != nil {
+				return nil, 0, err
+			}
+			ids = append(ids, page...)
+		}
+		total += length
+	}
+	return ids, total, nil
+}
@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+// lease deadlines use the clock of redis, workers and reapers on other hosts may disagree on the time
+const redisNowMillis = `
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+`
+
+// leaseJob stores a dequeued job as processing and restarts its lease, unless the reaper
+// requeued it since it was dequeued
+var leaseJob = redis.NewScript(redisNowMillis + `
+if not redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+  return 0
+end
+redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+return 1
+`)
+
+// extendLease moves the deadline of a lease that still exists
+var extendLease = redis.NewScript(redisNowMillis + `
+if redis.call('ZSCORE', KEYS[1], ARGV[1]) then
+  redis.call('ZADD', KEYS[1], now + tonumber(ARGV[2]), ARGV[1])
+  return 1
+end
+return 0
+`)
+
+// releaseJob drops the lease of a job, frees its item in KEYS[4], stores it and files its ID under KEYS[3],
+// a list when ARGV[3] is empty and a sorted set scored by ARGV[3] otherwise.
+// a pending job goes to the tail, later jobs of its item must not overtake it.
+// the job is filed even if its lease was lost, delivery is at least once
+var releaseJob = redis.NewScript(itemLocks + `
+redis.call('ZREM', KEYS[1], ARGV[1])
+unlockItem(KEYS[2], KEYS[4], ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+if ARGV[3] == '' then
+  redis.call('RPUSH', KEYS[3], ARGV[1])
+else
+  redis.call('ZADD', KEYS[3], ARGV[3], ARGV[1])
+end
+return 1
+`)
+
+// requeueExpired moves a job back to the pending queue and frees its item if its lease is still expired,
+// the worker may have sent a heartbeat or finished the job since we read the deadline
+var requeueExpired = redis.NewScript(redisNowMillis + itemLocks + `
+local deadline = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not deadline or tonumber(deadline) > now then
+  return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+unlockItem(KEYS[2], KEYS[4], ARGV[1])
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[2])
+redis.call('RPUSH', KEYS[3], ARGV[1])
+return 1
+`)
+
+// ExtendLease moves the lease deadline of the job a visibility timeout from now
+func (q *RedisQueue) ExtendLease(ctx context.Context, job *domain.ReservationJob) error {
+	extended, err := extendLease.Run(ctx, q.client, []string{LeasesKey}, job.ID, q.visibilityTimeout.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if extended == 0 {
+		return domain.ErrLeaseLost
+	}
+	return nil
+}
+
+// RequeueExpiredJobs puts processing jobs whose lease expired back to the pending queue with one more attempt.
+// requeued jobs go to the tail, they are dequeued next since they already waited a visibility timeout
+func (q *RedisQueue) RequeueExpiredJobs(ctx context.Context) (int, error) {
+	now, err := q.client.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	ids, err := q.client.ZRangeByScore(ctx, LeasesKey, &redis.ZRangeBy{Min: "-inf", Max: strconv.FormatInt(now.UnixMilli(), 10)}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	for _, id := range ids {
+		job, err := q.GetJob(ctx, id)
+		if errors.Is(err, domain.ErrJobNotFound) {
+			// a lease without a job is useless
+			if err := q.client.ZRem(ctx, LeasesKey, id).Err(); err != nil {
+				return requeued, err
+			}
+			continue
+		}
+		if err != nil {
+			return requeued, err
+		}
+		job.Status = domain.JobStatusPending
+		job.Attempts++
+		job.RecordFailure("lease expired", domain.JobErrorClassLeaseExpired, time.Now())
+		jobData, err := json.Marshal(job)
+		if err != nil {
+			return requeued, err
+		}
+
+		keys := []string{LeasesKey, JobsKey, laneKeys[job.Lane()], ItemsKey}
+		moved, err := requeueExpired.Run(ctx, q.client, keys, id, jobData).Int()
+		if err != nil {
+			return requeued, err
+		}
+		requeued += moved
+	}
+	return requeued, nil
+}
@@ -0,0 +1,123 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupLeaseTest freezes the redis clock, lease deadlines are taken from it
+func setupLeaseTest(t *testing.T) (*RedisQueue, *redis.Client, *miniredis.Miniredis, time.Time) {
+	mr := miniredis.RunT(t)
+	now := time.Now().Truncate(time.Millisecond)
+	mr.SetTime(now)
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	queue := &RedisQueue{client: client, visibilityTimeout: 30 * time.Second}
+	return queue, client, mr, now
+}
+
+func TestDequeueLeasesJob(t *testing.T) {
+	queue, client, _, now := setupLeaseTest(t)
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+
+	deadline, err := client.ZScore(ctx, LeasesKey, job.ID).Result()
+	require.NoError(t, err)
+	assert.Equal(t, float64(now.Add(30*time.Second).UnixMilli()), deadline)
+
+	// completing drops the lease
+	require.NoError(t, queue.CompleteJob(ctx, job))
+	assert.Equal(t, int64(1), client.ZCard(ctx, CompletedSetKey).Val())
+	assert.Equal(t, int64(0), client.ZCard(ctx, LeasesKey).Val())
+}
+
+func TestRequeueJob(t *testing.T) {
+	queue, client, _, _ := setupLeaseTest(t)
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	job.Attempts++
+	require.NoError(t, queue.RequeueJob(ctx, job, 0))
+
+	assert.Equal(t, int64(0), client.ZCard(ctx, LeasesKey).Val())
+
+	job, err = queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, job.Attempts)
+}
+
+func TestExtendLease(t *testing.T) {
+	queue, client, mr, now := setupLeaseTest(t)
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+
+	mr.SetTime(now.Add(20 * time.Second))
+	require.NoError(t, queue.ExtendLease(ctx, job))
+
+	// 40s after dequeuing the lease is only 20s old
+	mr.SetTime(now.Add(40 * time.Second))
+	requeued, err := queue.RequeueExpiredJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, requeued)
+	assert.Equal(t, int64(1), client.ZCard(ctx, LeasesKey).Val())
+
+	mr.SetTime(now.Add(51 * time.Second))
+	requeued, err = queue.RequeueExpiredJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+
+	assert.ErrorIs(t, queue.ExtendLease(ctx, job), domain.ErrLeaseLost)
+}
+
+func TestRequeueExpiredJobs(t *testing.T) {
+	queue, client, mr, now := setupLeaseTest(t)
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+		{ID: "job-1", ItemID: 1},
+		{ID: "job-2", ItemID: 2},
+	}))
+
+	_, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+
+	// the lease expires a visibility timeout after the dequeue
+	requeued, err := queue.RequeueExpiredJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, requeued)
+	assert.Equal(t, int64(1), client.ZCard(ctx, LeasesKey).Val())
+
+	mr.SetTime(now.Add(31 * time.Second))
+	requeued, err = queue.RequeueExpiredJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+	assert.Equal(t, int64(0), client.ZCard(ctx, LeasesKey).Val())
+
+	// the requeued job is dequeued before job-2, with one more attempt
+	var ids []string
+	for i := 0; i < 2; i++ {
+		job, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		ids = append(ids, job.ID)
+		if job.ID == "job-2" {
+			assert.Equal(t, 0, job.Attempts)
+		} else {
+			assert.Equal(t, 1, job.Attempts)
+		}
+	}
+	assert.Equal(t, []string{"job-1", "job-2"}, ids)
+}
@@ -0,0 +1,153 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// SchemaKey holds the version of the key layout, version 2 stores jobs in JobsKey and only IDs elsewhere
+	SchemaKey     = "reservation:schema"
+	schemaVersion = 2
+	// legacySuffix is appended to the keys of the old layout, whose lists held serialized jobs, while they are migrated
+	legacySuffix = ":legacy"
+	// legacyProcessingKey is the list the old layout kept processing jobs in, processing jobs are leased now
+	legacyProcessingKey = "reservation:processing"
+)
+
+// legacyKeys maps the statuses the old layout had a list for to that list
+var legacyKeys = map[domain.JobStatus]string{
+	domain.JobStatusPending:    ReservationQueueKey,
+	domain.JobStatusProcessing: legacyProcessingKey,
+	domain.JobStatusCompleted:  CompletedSetKey,
+	domain.JobStatusFailed:     FailedSetKey,
+}
+
+// legacyMigrationOrder migrates processing last. the old layout never removed a job from processing,
+// its list holds every job ever dequeued, so a processing job that is in any other list is stale
+var legacyMigrationOrder = []domain.JobStatus{
+	domain.JobStatusCompleted, domain.JobStatusFailed, domain.JobStatusPending, domain.JobStatusProcessing,
+}
+
+// legacyJobNamespace derives the IDs of old jobs that have none, from what they were enqueued with
+var legacyJobNamespace = uuid.MustParse("6f1c7a52-3f0e-4b8e-9a57-2c1d1f4e8b3a")
+
+// renameLegacy moves a key of the old layout aside, jobs enqueued from then on land in the new layout
+var renameLegacy = redis.NewScript(`
+if redis.call('EXISTS', KEYS[1]) == 1 then
+  return redis.call('RENAMENX', KEYS[1], KEYS[2])
+end
+return 0
+`)
+
+// MigrateLegacyJobs moves the jobs of the old layout to the new one and returns how many it moved.
+// only the first instance running the new layout moves the old lists aside, every instance migrates
+// what was moved aside, so a migration cut short completes on the next start.
+// instances running the old layout must be stopped first, they would keep pushing serialized jobs
+func (q *RedisQueue) MigrateLegacyJobs(ctx context.Context) (int, error) {
+	first, err := q.client.SetNX(ctx, SchemaKey, schemaVersion, 0).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if first {
+		for _, key := range legacyKeys {
+			if err := renameLegacy.Run(ctx, q.client, []string{key, key + legacySuffix}).Err(); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	// a job is migrated once, with the status of the first list it is found in
+	seen := make(map[string]bool)
+	migrated := 0
+	for _, status := range legacyMigrationOrder {
+		n, err := q.migrateLegacyKey(ctx, status, seen)
+		migrated += n
+		if err != nil {
+			return migrated, err
+		}
+	}
+	return migrated, nil
+}
+
+// migrateLegacyKey moves the jobs of one old list to the new layout, jobs in seen or already migrated
+// by an earlier run are dropped. the old list is deleted either way
+func (q *RedisQueue) migrateLegacyKey(ctx context.Context, status domain.JobStatus, seen map[string]bool) (int, error) {
+	key := statusKeys[status]
+	legacy := legacyKeys[status] + legacySuffix
+	payloads, err := q.client.LRange(ctx, legacy, 0, -1).Result()
+	if err != nil || len(payloads) == 0 {
+		return 0, err
+	}
+
+	decoded := make([]*domain.ReservationJob, len(payloads))
+	ids := make([]string, len(payloads))
+	for i, payload := range payloads {
+		job, err := decodeJob(payload)
+		if err != nil {
+			return 0, err
+		}
+		if job.ID == "" {
+			// the same job in two lists must get the same ID, so it's derived from the fields that
+			// don't change while the job is processed
+			job.ID = uuid.NewSHA1(legacyJobNamespace, []byte(fmt.Sprintf("%d|%s|%s|%d|%d",
+				job.ItemID, job.JobType, job.ItemName, job.Quantity, job.CreatedAt.UnixNano()))).String()
+		}
+		decoded[i], ids[i] = job, job.ID
+	}
+
+	// jobs of old lists an earlier, cut short run already migrated are in the jobs hash
+	existing, err := q.client.HMGet(ctx, JobsKey, ids...).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	var jobs []*domain.ReservationJob
+	var data [][]byte
+	for i, job := range decoded {
+		if seen[job.ID] || existing[i] != nil {
+			continue
+		}
+		seen[job.ID] = true
+		// the old layout kept the status the job had when it was pushed, the list it is in is what counts
+		job.Status = status
+
+		payload, err := json.Marshal(job)
+		if err != nil {
+			return 0, err
+		}
+		jobs = append(jobs, job)
+		data = append(data, payload)
+	}
+
+	// the old lists are newest first, scores counting down keep that order in the sorted sets
+	now := time.Now().UnixMilli()
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		for i, job := range jobs {
+			pipe.HSet(ctx, JobsKey, job.ID, data[i])
+			switch {
+			case status == domain.JobStatusProcessing:
+				// processing jobs get a fresh lease, the reaper requeues them if no worker completes them
+				pipe.ZAdd(ctx, key, redis.Z{Score: float64(now + q.visibilityTimeout.Milliseconds()), Member: job.ID})
+			case isSortedSet(status):
+				pipe.ZAdd(ctx, key, redis.Z{Score: float64(now - int64(i)), Member: job.ID})
+			default:
+				// old jobs go to the tail, they are dequeued before anything enqueued since
+				pipe.RPush(ctx, key, job.ID)
+			}
+		}
+		pipe.Del(ctx, legacy)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(jobs), nil
+}
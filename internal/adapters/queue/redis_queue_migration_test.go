@@ -0,0 +1,113 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pushLegacyJobs pushes serialized jobs like the old layout did, the last job ends up at the head
+func pushLegacyJobs(t *testing.T, client *redis.Client, key string, ids ...string) {
+	for _, id := range ids {
+		data, err := json.Marshal(&domain.ReservationJob{ID: id, ItemID: 1, ItemName: "Item", Quantity: 1, Status: domain.JobStatusPending})
+		require.NoError(t, err)
+		require.NoError(t, client.LPush(context.Background(), key, data).Err())
+	}
+}
+
+func TestMigrateLegacyJobs(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+	pushLegacyJobs(t, client, ReservationQueueKey, "job-1", "job-2")
+	pushLegacyJobs(t, client, legacyProcessingKey, "job-3")
+	pushLegacyJobs(t, client, FailedSetKey, "job-4", "job-5")
+
+	migrated, err := queue.MigrateLegacyJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, migrated)
+
+	depths, err := queue.QueueDepths(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[domain.JobStatus]int64{
+		domain.JobStatusPending:    2,
+		domain.JobStatusDelayed:    0,
+		domain.JobStatusProcessing: 1,
+		domain.JobStatusCompleted:  0,
+		domain.JobStatusFailed:     2,
+	}, depths)
+
+	// failed jobs keep their order and get the status of their list
+	failed, _, err := queue.ListJobs(ctx, domain.JobStatusFailed, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, failed, 2)
+	assert.Equal(t, "job-5", failed[0].ID)
+	assert.Equal(t, domain.JobStatusFailed, failed[0].Status)
+
+	// jobs enqueued after the migration are dequeued after the old ones, each once the one before is done
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-6", ItemID: 1}))
+	for _, want := range []string{"job-1", "job-2", "job-6"} {
+		job, err := queue.DequeueReservation(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, want, job.ID)
+		require.NoError(t, queue.CompleteJob(ctx, job))
+	}
+
+	// the lists are only moved aside once, a migration cut short is finished on the next start
+	migrated, err = queue.MigrateLegacyJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, migrated)
+
+	pushLegacyJobs(t, client, CompletedSetKey+legacySuffix, "job-7")
+	migrated, err = queue.MigrateLegacyJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+	// next to the three jobs completed above
+	assert.Equal(t, int64(4), client.ZCard(ctx, CompletedSetKey).Val())
+}
+
+func TestMigrateLegacyJobsDropsStaleProcessingJobs(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+
+	// the old layout never removed jobs from processing: job-1 completed, job-2 failed, job-3 was
+	// retried and is pending again and job-4 was dequeued twice, only job-4 is really in flight
+	pushLegacyJobs(t, client, legacyProcessingKey, "job-1", "job-2", "job-3", "job-4", "job-4")
+	pushLegacyJobs(t, client, CompletedSetKey, "job-1")
+	pushLegacyJobs(t, client, FailedSetKey, "job-2")
+	pushLegacyJobs(t, client, ReservationQueueKey, "job-3")
+
+	// a job without an ID is matched by its item and payload, the completed copy was rewritten
+	dequeued := domain.ReservationJob{ItemID: 2, ItemName: "Other", Quantity: 3, Status: domain.JobStatusPending, CreatedAt: time.Now()}
+	completed := dequeued
+	completed.Status, completed.Attempts = domain.JobStatusCompleted, 1
+	for key, job := range map[string]domain.ReservationJob{legacyProcessingKey: dequeued, CompletedSetKey: completed} {
+		data, err := json.Marshal(&job)
+		require.NoError(t, err)
+		require.NoError(t, client.LPush(ctx, key, data).Err())
+	}
+
+	migrated, err := queue.MigrateLegacyJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 5, migrated)
+
+	depths, err := queue.QueueDepths(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, map[domain.JobStatus]int64{
+		domain.JobStatusPending:    1,
+		domain.JobStatusDelayed:    0,
+		domain.JobStatusProcessing: 1,
+		domain.JobStatusCompleted:  2,
+		domain.JobStatusFailed:     1,
+	}, depths)
+
+	processing, _, err := queue.ListJobs(ctx, domain.JobStatusProcessing, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, processing, 1)
+	assert.Equal(t, "job-4", processing[0].ID)
+}
@@ -3,11 +3,13 @@ package queue
 import (
 	"context"
 	"testing"
+	"time"
 
 	"github.com/a-berahman/shopping-cart/internal/core/domain"
 	"github.com/alicebob/miniredis/v2"
 	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func setupTestRedis(t *testing.T) (*RedisQueue, *redis.Client) {
@@ -21,7 +23,7 @@ func setupTestRedis(t *testing.T) (*RedisQueue, *redis.Client) {
 		Addr: mr.Addr(),
 	})
 
-	queue := &RedisQueue{client: client}
+	queue := &RedisQueue{client: client, visibilityTimeout: 30 * time.Second}
 	return queue, client
 }
 
@@ -64,3 +66,65 @@ func TestEnqueueReservation(t *testing.T) {
 		})
 	}
 }
+
+func TestEnqueueReservations(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+
+	jobs := []*domain.ReservationJob{
+		{ID: "job-1", ItemID: 1, ItemName: "Item 1", Quantity: 1},
+		{ID: "job-2", ItemID: 2, ItemName: "Item 2", Quantity: 2},
+	}
+
+	err := queue.EnqueueReservations(ctx, jobs)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), client.LLen(ctx, ReservationQueueKey).Val())
+
+	// jobs are dequeued in the order of the batch
+	for _, want := range jobs {
+		job, err := queue.DequeueReservation(ctx)
+		assert.NoError(t, err)
+		assert.Equal(t, want.ID, job.ID)
+		assert.Equal(t, domain.JobStatusProcessing, job.Status)
+	}
+}
+
+func TestEnqueueReservationsSkipsQueuedJobs(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	// the relay publishes job-1 again next to a new job, only the new one is queued
+	require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+		{ID: "job-1", ItemID: 1},
+		{ID: "job-2", ItemID: 2, Priority: domain.JobPriorityInteractive},
+	}))
+	assert.Equal(t, []string{"job-1"}, client.LRange(ctx, ReservationQueueKey, 0, -1).Val())
+	assert.Equal(t, []string{"job-2"}, client.LRange(ctx, InteractiveQueueKey, 0, -1).Val())
+	assert.Equal(t, int64(2), client.HLen(ctx, JobsKey).Val())
+}
+
+func TestFinishJobs(t *testing.T) {
+	queue, client := setupTestRedis(t)
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 2}}))
+
+	// the jobs change after dequeuing, they are still found by ID
+	completed, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	completed.Attempts = 2
+	require.NoError(t, queue.CompleteJob(ctx, completed))
+
+	failed, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	failed.Attempts = 3
+	require.NoError(t, queue.FailJob(ctx, failed))
+
+	assert.Equal(t, []string{"job-1"}, client.ZRange(ctx, CompletedSetKey, 0, -1).Val())
+	assert.Equal(t, []string{"job-2"}, client.ZRange(ctx, FailedSetKey, 0, -1).Val())
+
+	job, err := queue.GetJob(ctx, "job-2")
+	require.NoError(t, err)
+	assert.Equal(t, domain.JobStatusFailed, job.Status)
+	assert.Equal(t, 3, job.Attempts)
+}
@@ -0,0 +1,490 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// the stream queue keeps its jobs apart from the list queue, a deployment switching queues
+// drains the old one with a worker of the old kind
+const (
+	// StreamKey has an entry per pending or processing job, its job field is the job ID.
+	// entries are deleted once acknowledged, so the stream only holds unfinished jobs
+	StreamKey = "reservation:stream"
+	// StreamGroup is the consumer group every worker reads StreamKey in, its pending entries are the processing jobs
+	StreamGroup = "reservation-workers"
+	// StreamJobsKey maps every job ID of the stream queue to the job
+	StreamJobsKey = "reservation:stream:jobs"
+	// StreamEntriesKey maps the ID of every job in StreamKey to the ID of its entry
+	StreamEntriesKey = "reservation:stream:entries"
+	// StreamDelayedSetKey scores the IDs of jobs waiting for a retry with the unix milliseconds they are due at
+	StreamDelayedSetKey = "reservation:stream:delayed"
+	// StreamCompletedSetKey scores completed job IDs with the unix milliseconds they completed at
+	StreamCompletedSetKey = "reservation:stream:completed"
+	// StreamFailedSetKey scores failed job IDs with the unix milliseconds they failed at
+	StreamFailedSetKey = "reservation:stream:failed"
+)
+
+const (
+	// streamBlock is how long a dequeue waits for an entry before it checks its context again
+	streamBlock = time.Second
+	// reclaimBatch caps the entries claimed per XAUTOCLAIM call
+	reclaimBatch = 100
+)
+
+// streamStatusKeys maps the statuses the stream queue keeps in sorted sets to their key
+var streamStatusKeys = map[domain.JobStatus]string{
+	domain.JobStatusDelayed:   StreamDelayedSetKey,
+	domain.JobStatusCompleted: StreamCompletedSetKey,
+	domain.JobStatusFailed:    StreamFailedSetKey,
+}
+
+// addStreamJobs stores the jobs given as ID and payload pairs in ARGV and adds an entry per job to the stream.
+// a job whose ID is stored already is skipped, the outbox relay may publish a job twice
+var addStreamJobs = redis.NewScript(`
+local added = 0
+for i = 1, #ARGV, 2 do
+  if redis.call('HSETNX', KEYS[2], ARGV[i], ARGV[i + 1]) == 1 then
+    local entry = redis.call('XADD', KEYS[1], '*', 'job', ARGV[i])
+    redis.call('HSET', KEYS[3], ARGV[i], entry)
+    added = added + 1
+  end
+end
+return added
+`)
+
+// releaseStreamJob acknowledges and deletes the entry of a job, stores it and files it:
+// with ARGV[5] 'stream' in a new entry, with 'set' in the sorted set KEYS[6] scored by ARGV[6]
+// and with 'delay' in KEYS[6] due ARGV[6] milliseconds from now on the redis clock.
+// a job filed in a set for good hands its item on, a retried one keeps holding it.
+// ARGV[4] is the entry to acknowledge, the current entry of the job when empty.
+// the job is filed even if its entry was reclaimed, delivery is at least once
+var releaseStreamJob = redis.NewScript(redisNowMillis + streamItemLocks + `
+local entry = ARGV[4]
+if entry == '' then
+  entry = redis.call('HGET', KEYS[2], ARGV[2])
+end
+if entry then
+  redis.call('XACK', KEYS[1], ARGV[1], entry)
+  redis.call('XDEL', KEYS[1], entry)
+  redis.call('HDEL', KEYS[2], ARGV[2])
+end
+redis.call('HSET', KEYS[3], ARGV[2], ARGV[3])
+if ARGV[5] == 'stream' then
+  local added = redis.call('XADD', KEYS[1], '*', 'job', ARGV[2])
+  redis.call('HSET', KEYS[2], ARGV[2], added)
+elseif ARGV[5] == 'delay' then
+  redis.call('ZADD', KEYS[6], now + tonumber(ARGV[6]), ARGV[2])
+else
+  redis.call('ZADD', KEYS[6], ARGV[6], ARGV[2])
+  releaseStreamItem(ARGV[2])
+end
+return 1
+`)
+
+// extendStreamLease resets the idle time of the entry of a job if the consumer ARGV[3] still holds it,
+// the reaper may have reclaimed it
+var extendStreamLease = redis.NewScript(`
+local entry = redis.call('HGET', KEYS[2], ARGV[1])
+if not entry then
+  return 0
+end
+local pending = redis.call('XPENDING', KEYS[1], ARGV[2], entry, entry, 1, ARGV[3])
+if #pending == 0 then
+  return 0
+end
+redis.call('XCLAIM', KEYS[1], ARGV[2], ARGV[3], 0, entry, 'JUSTID')
+return 1
+`)
+
+// moveToStream moves a job from the sorted set KEYS[1] to a new entry of the stream if it is still there,
+// an operator or another worker may have moved it since we read it. with ARGV[3] set only if it is due
+var moveToStream = redis.NewScript(redisNowMillis + `
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or (ARGV[3] == '1' and tonumber(score) > now) then
+  return 0
+end
+redis.call('ZREM', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[3], ARGV[1], ARGV[2])
+local entry = redis.call('XADD', KEYS[2], '*', 'job', ARGV[1])
+redis.call('HSET', KEYS[4], ARGV[1], entry)
+return 1
+`)
+
+// StreamQueue is a queue on a redis stream read by a consumer group. the group tracks the entries
+// every consumer received and didn't acknowledge, the idle time of an entry is the lease of its job.
+// entries idle for a visibility timeout are reclaimed from their consumer, which likely died.
+// jobs of an item are handed out one at a time in the order they were queued, see streamItemLocks
+type StreamQueue struct {
+	client *redis.Client
+	// consumer names this instance in the group, every instance has its own
+	consumer string
+	// visibilityTimeout is how long a received entry may stay idle before it is reclaimed
+	visibilityTimeout time.Duration
+	// deadLetterMaxJobs and deadLetterMaxAge bound the failed jobs that are kept, zero keeps every one
+	deadLetterMaxJobs int
+	deadLetterMaxAge  time.Duration
+	// completedMaxJobs and completedMaxAge bound the completed jobs that are kept the same way
+	completedMaxJobs int
+	completedMaxAge  time.Duration
+	// groupReady is set once the consumer group is known to exist
+	groupReady atomic.Bool
+}
+
+func NewStreamQueue(redisURL string, cfg config.QueueConfig) (*StreamQueue, error) {
+	opt, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, err
+	}
+
+	return &StreamQueue{
+		client:            redis.NewClient(opt),
+		consumer:          consumerName(),
+		visibilityTimeout: cfg.VisibilityTimeout,
+		deadLetterMaxJobs: cfg.DeadLetterMaxJobs,
+		deadLetterMaxAge:  cfg.DeadLetterMaxAge,
+		completedMaxJobs:  cfg.CompletedMaxJobs,
+		completedMaxAge:   cfg.CompletedMaxAge,
+	}, nil
+}
+
+// consumerName names a consumer after its host and process, the suffix tells restarts apart
+func consumerName() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "worker"
+	}
+	return fmt.Sprintf("%s-%d-%s", host, os.Getpid(), uuid.New().String()[:8])
+}
+
+// Client returns the underlying redis client, so other adapters share its connection pool
+func (q *StreamQueue) Client() *redis.Client {
+	return q.client
+}
+
+// ensureGroup creates the stream and its consumer group unless they exist
+func (q *StreamQueue) ensureGroup(ctx context.Context) error {
+	if q.groupReady.Load() {
+		return nil
+	}
+	// the group reads the stream from its start, jobs enqueued before any worker ran are kept
+	err := q.client.XGroupCreateMkStream(ctx, StreamKey, StreamGroup, "0").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		return err
+	}
+	q.groupReady.Store(true)
+	return nil
+}
+
+func (q *StreamQueue) EnqueueReservation(ctx context.Context, job *domain.ReservationJob) error {
+	return q.EnqueueReservations(ctx, []*domain.ReservationJob{job})
+}
+
+// EnqueueReservations enqueues all jobs in one script, either every job is enqueued or none
+func (q *StreamQueue) EnqueueReservations(ctx context.Context, jobs []*domain.ReservationJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	args := make([]interface{}, 0, 2*len(jobs))
+	for _, job := range jobs {
+		if job.ID == "" {
+			job.ID = uuid.New().String()
+		}
+		job.CreatedAt = now
+		job.Status = domain.JobStatusPending
+
+		jobData, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		args = append(args, job.ID, jobData)
+	}
+	return addStreamJobs.Run(ctx, q.client, []string{StreamKey, StreamJobsKey, StreamEntriesKey}, args...).Err()
+}
+
+// DequeueReservation waits for the next entry of the group until ctx is done
+func (q *StreamQueue) DequeueReservation(ctx context.Context) (*domain.ReservationJob, error) {
+	if err := q.ensureGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		streams, err := q.client.XReadGroup(ctx, &redis.XReadGroupArgs{
+			Group:    StreamGroup,
+			Consumer: q.consumer,
+			Streams:  []string{StreamKey, ">"},
+			Count:    1,
+			Block:    streamBlock,
+		}).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			continue
+		}
+
+		entry := streams[0].Messages[0]
+		id, _ := entry.Values["job"].(string)
+		job, err := q.GetJob(ctx, id)
+		if errors.Is(err, domain.ErrJobNotFound) {
+			// an entry without a job is useless
+			if err := q.dropEntry(ctx, entry.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		// a job whose item is busy is parked behind it, we read on
+		keys := withStreamItemKeys(streamParkedKey(job.ItemID))
+		claimed, err := claimStreamJob.Run(ctx, q.client, keys, StreamGroup, job.ID, entry.ID, job.ItemID).Int()
+		if err != nil {
+			return nil, err
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		job.Status = domain.JobStatusProcessing
+		job.LastAttempted = time.Now()
+		jobData, err := json.Marshal(job)
+		if err != nil {
+			return nil, err
+		}
+		if err := q.client.HSet(ctx, StreamJobsKey, job.ID, jobData).Err(); err != nil {
+			return nil, err
+		}
+		return job, nil
+	}
+}
+
+// CompleteJob files the job as completed, the oldest completed jobs are dropped beyond the retention limit
+func (q *StreamQueue) CompleteJob(ctx context.Context, job *domain.ReservationJob) error {
+	job.Status = domain.JobStatusCompleted
+	if err := q.releaseJob(ctx, job, "", "set", scoreNow()); err != nil {
+		return err
+	}
+	_, err := q.TrimCompletedJobs(ctx)
+	return err
+}
+
+// FailJob dead-letters the job, the oldest failed jobs are dropped beyond the retention limit
+func (q *StreamQueue) FailJob(ctx context.Context, job *domain.ReservationJob) error {
+	job.Status = domain.JobStatusFailed
+	if err := q.releaseJob(ctx, job, "", "set", scoreNow()); err != nil {
+		return err
+	}
+	_, err := q.TrimDeadLetters(ctx)
+	return err
+}
+
+// RequeueJob adds a new entry for the job, or files it in the delayed set until delay passed.
+// the job keeps its attempts
+func (q *StreamQueue) RequeueJob(ctx context.Context, job *domain.ReservationJob, delay time.Duration) error {
+	if delay <= 0 {
+		job.Status = domain.JobStatusPending
+		return q.releaseJob(ctx, job, "", "stream", "")
+	}
+
+	job.Status = domain.JobStatusDelayed
+	return q.releaseJob(ctx, job, "", "delay", strconv.FormatInt(delay.Milliseconds(), 10))
+}
+
+// releaseJob acknowledges the entry of the job and files it as releaseStreamJob describes
+func (q *StreamQueue) releaseJob(ctx context.Context, job *domain.ReservationJob, entry, target, value string) error {
+	if err := q.ensureGroup(ctx); err != nil {
+		return err
+	}
+
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	keys := withStreamItemKeys(streamStatusKeys[job.Status])
+	if target == "stream" {
+		// scripts must name every key they touch, the unused one is harmless
+		keys[5] = StreamKey
+	}
+	return releaseStreamJob.Run(ctx, q.client, keys, StreamGroup, job.ID, jobData, entry, target, value).Err()
+}
+
+// dropEntry acknowledges and deletes an entry whose job is gone
+func (q *StreamQueue) dropEntry(ctx context.Context, entry string) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.XAck(ctx, StreamKey, StreamGroup, entry)
+		pipe.XDel(ctx, StreamKey, entry)
+		return nil
+	})
+	return err
+}
+
+// ExtendLease resets the idle time of the entry of the job, so it isn't reclaimed
+func (q *StreamQueue) ExtendLease(ctx context.Context, job *domain.ReservationJob) error {
+	if err := q.ensureGroup(ctx); err != nil {
+		return err
+	}
+
+	keys := []string{StreamKey, StreamEntriesKey}
+	extended, err := extendStreamLease.Run(ctx, q.client, keys, job.ID, StreamGroup, q.consumer).Int()
+	if err != nil {
+		return err
+	}
+	if extended == 0 {
+		return domain.ErrLeaseLost
+	}
+	return nil
+}
+
+// RequeueExpiredJobs reclaims the entries idle for a visibility timeout from their consumers and adds
+// a new entry for their jobs with one more attempt. consumers that died are removed from the group
+func (q *StreamQueue) RequeueExpiredJobs(ctx context.Context) (int, error) {
+	if err := q.ensureGroup(ctx); err != nil {
+		return 0, err
+	}
+
+	requeued := 0
+	start := "0-0"
+	for {
+		entries, next, err := q.client.XAutoClaim(ctx, &redis.XAutoClaimArgs{
+			Stream:   StreamKey,
+			Group:    StreamGroup,
+			Consumer: q.consumer,
+			MinIdle:  q.visibilityTimeout,
+			Start:    start,
+			Count:    reclaimBatch,
+		}).Result()
+		if err != nil {
+			return requeued, err
+		}
+
+		for _, entry := range entries {
+			moved, err := q.requeueEntry(ctx, entry)
+			if err != nil {
+				return requeued, err
+			}
+			requeued += moved
+		}
+
+		if next == "0-0" || next == "" {
+			break
+		}
+		start = next
+	}
+
+	return requeued, q.removeIdleConsumers(ctx)
+}
+
+// requeueEntry adds a new entry for the job of a reclaimed entry, counting the lost run as an attempt
+func (q *StreamQueue) requeueEntry(ctx context.Context, entry redis.XMessage) (int, error) {
+	id, _ := entry.Values["job"].(string)
+	job, err := q.GetJob(ctx, id)
+	if errors.Is(err, domain.ErrJobNotFound) {
+		return 0, q.dropEntry(ctx, entry.ID)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	job.Status = domain.JobStatusPending
+	job.Attempts++
+	job.RecordFailure("lease expired", domain.JobErrorClassLeaseExpired, time.Now())
+	if err := q.releaseJob(ctx, job, entry.ID, "stream", ""); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+// removeIdleConsumers removes consumers without entries that were idle for a visibility timeout,
+// every restart adds a consumer. a live consumer that was removed is added back by its next read
+func (q *StreamQueue) removeIdleConsumers(ctx context.Context) error {
+	consumers, err := q.client.XInfoConsumers(ctx, StreamKey, StreamGroup).Result()
+	if err != nil {
+		return err
+	}
+
+	for _, consumer := range consumers {
+		if consumer.Name == q.consumer || consumer.Pending > 0 || consumer.Idle < q.visibilityTimeout {
+			continue
+		}
+		if err := q.client.XGroupDelConsumer(ctx, StreamKey, StreamGroup, consumer.Name).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PromoteDueJobs adds an entry for every delayed job that is due
+func (q *StreamQueue) PromoteDueJobs(ctx context.Context) (int, error) {
+	now, err := q.client.Time(ctx).Result()
+	if err != nil {
+		return 0, err
+	}
+	ids, err := q.client.ZRangeByScore(ctx, StreamDelayedSetKey, &redis.ZRangeBy{
+		Min:   "-inf",
+		Max:   strconv.FormatInt(now.UnixMilli(), 10),
+		Count: promoteBatch,
+	}).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	promoted := 0
+	for _, id := range ids {
+		job, err := q.GetJob(ctx, id)
+		if errors.Is(err, domain.ErrJobNotFound) {
+			if err := q.client.ZRem(ctx, StreamDelayedSetKey, id).Err(); err != nil {
+				return promoted, err
+			}
+			continue
+		}
+		if err != nil {
+			return promoted, err
+		}
+
+		job.Status = domain.JobStatusPending
+		moved, err := q.moveToStream(ctx, StreamDelayedSetKey, job, true)
+		if err != nil {
+			return promoted, err
+		}
+		promoted += moved
+	}
+	return promoted, nil
+}
+
+// moveToStream moves a job from the sorted set key to a new entry, only when it is due if onlyDue is set
+func (q *StreamQueue) moveToStream(ctx context.Context, key string, job *domain.ReservationJob, onlyDue bool) (int, error) {
+	jobData, err := json.Marshal(job)
+	if err != nil {
+		return 0, err
+	}
+
+	due := "0"
+	if onlyDue {
+		due = "1"
+	}
+	keys := []string{key, StreamKey, StreamJobsKey, StreamEntriesKey}
+	return moveToStream.Run(ctx, q.client, keys, job.ID, jobData, due).Int()
+}
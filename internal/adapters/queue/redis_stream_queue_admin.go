@@ -0,0 +1,299 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/redis/go-redis/v9"
+)
+
+// discardStreamJob removes a job and its entry ARGV[2] if the entry is still the job's,
+// a worker may have received it since we read it. a retried job still holding its item hands it on
+var discardStreamJob = redis.NewScript(streamItemLocks + `
+if redis.call('HGET', KEYS[2], ARGV[1]) ~= ARGV[2] then
+  return 0
+end
+if #redis.call('XPENDING', KEYS[1], ARGV[3], ARGV[2], ARGV[2], 1) > 0 then
+  return -1
+end
+redis.call('XDEL', KEYS[1], ARGV[2])
+redis.call('HDEL', KEYS[2], ARGV[1])
+releaseStreamItem(ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1
+`)
+
+// QueueDepths returns the number of jobs with every status. entries of the stream that the group
+// delivered and nobody acknowledged are processing, the others and the parked jobs pending
+func (q *StreamQueue) QueueDepths(ctx context.Context) (map[domain.JobStatus]int64, error) {
+	if err := q.ensureGroup(ctx); err != nil {
+		return nil, err
+	}
+
+	var streamLen *redis.IntCmd
+	var delivered *redis.XPendingCmd
+	var parked *redis.IntCmd
+	sets := make(map[domain.JobStatus]*redis.IntCmd, len(streamStatusKeys))
+	_, err := q.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		streamLen = pipe.XLen(ctx, StreamKey)
+		delivered = pipe.XPending(ctx, StreamKey, StreamGroup)
+		parked = pipe.ZCard(ctx, StreamParkedSetKey)
+		for status, key := range streamStatusKeys {
+			sets[status] = pipe.ZCard(ctx, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	depths := map[domain.JobStatus]int64{
+		domain.JobStatusPending:    streamLen.Val() - delivered.Val().Count + parked.Val(),
+		domain.JobStatusProcessing: delivered.Val().Count,
+	}
+	for status, cmd := range sets {
+		depths[status] = cmd.Val()
+	}
+	return depths, nil
+}
+
+// ListJobs returns a page of the jobs with the status, newest first
+func (q *StreamQueue) ListJobs(ctx context.Context, status domain.JobStatus, offset, limit int) ([]*domain.ReservationJob, int64, error) {
+	if status != domain.JobStatusPending && status != domain.JobStatusProcessing {
+		key, ok := streamStatusKeys[status]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown job status: %s", status)
+		}
+		return q.listSortedSet(ctx, key, offset, limit)
+	}
+
+	depths, err := q.QueueDepths(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	lastDelivered, err := q.lastDeliveredEntry(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// entries up to the last one the group delivered are processing, the later ones pending
+	end, start := "+", lastDelivered
+	if status == domain.JobStatusProcessing {
+		end, start = lastDelivered, "-"
+	}
+	entries, err := q.client.XRevRangeN(ctx, StreamKey, end, start, int64(offset+limit+1)).Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if status == domain.JobStatusPending && entry.ID == lastDelivered {
+			continue
+		}
+		if id, ok := entry.Values["job"].(string); ok {
+			ids = append(ids, id)
+		}
+	}
+	if status == domain.JobStatusPending {
+		// parked jobs follow the entries, newest first as well
+		parked, err := q.client.ZRevRange(ctx, StreamParkedSetKey, 0, int64(offset+limit-1)).Result()
+		if err != nil {
+			return nil, 0, err
+		}
+		ids = append(ids, parked...)
+	}
+	if offset >= len(ids) {
+		ids = nil
+	} else {
+		ids = ids[offset:min(offset+limit, len(ids))]
+	}
+
+	jobs, err := getJobs(ctx, q.client, StreamJobsKey, ids)
+	if err != nil {
+		return nil, 0, err
+	}
+	return jobs, depths[status], nil
+}
+
+// listSortedSet returns a page of the jobs in a sorted set, newest first
+func (q *StreamQueue) listSortedSet(ctx context.Context, key string, offset, limit int) ([]*domain.ReservationJob, int64, error) {
+	var rangeCmd *redis.StringSliceCmd
+	var lenCmd *redis.IntCmd
+	_, err := q.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		rangeCmd = pipe.ZRevRange(ctx, key, int64(offset), int64(offset+limit-1))
+		lenCmd = pipe.ZCard(ctx, key)
+		return nil
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	jobs, err := getJobs(ctx, q.client, StreamJobsKey, rangeCmd.Val())
+	if err != nil {
+		return nil, 0, err
+	}
+	return jobs, lenCmd.Val(), nil
+}
+
+// lastDeliveredEntry returns the ID of the last entry the group delivered
+func (q *StreamQueue) lastDeliveredEntry(ctx context.Context) (string, error) {
+	groups, err := q.client.XInfoGroups(ctx, StreamKey).Result()
+	if err != nil {
+		return "", err
+	}
+	for _, group := range groups {
+		if group.Name == StreamGroup {
+			return group.LastDeliveredID, nil
+		}
+	}
+	return "", fmt.Errorf("consumer group %s of %s not found", StreamGroup, StreamKey)
+}
+
+// GetJob reads the job from the jobs hash
+func (q *StreamQueue) GetJob(ctx context.Context, id string) (*domain.ReservationJob, error) {
+	return getJob(ctx, q.client, StreamJobsKey, id)
+}
+
+// RetryJob moves a single failed job back to the stream
+func (q *StreamQueue) RetryJob(ctx context.Context, id string) error {
+	job, err := q.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if job.Status != domain.JobStatusFailed {
+		return domain.ErrJobNotFailed
+	}
+	return q.retryJob(ctx, job)
+}
+
+// retryJob adds an entry for a failed job with its attempts reset, it keeps its history
+func (q *StreamQueue) retryJob(ctx context.Context, job *domain.ReservationJob) error {
+	if err := q.ensureGroup(ctx); err != nil {
+		return err
+	}
+
+	job.Status = domain.JobStatusPending
+	job.Attempts = 0
+	moved, err := q.moveToStream(ctx, StreamFailedSetKey, job, false)
+	if err != nil {
+		return err
+	}
+	if moved == 0 {
+		return domain.ErrJobNotFound
+	}
+	return nil
+}
+
+// RetryFailedJobs replays the failed jobs the filter matches, oldest failure first.
+// jobs retried or discarded meanwhile are skipped
+func (q *StreamQueue) RetryFailedJobs(ctx context.Context, filter domain.DeadLetterFilter) (int, error) {
+	return retryFailedJobs(ctx, q.client, StreamFailedSetKey, StreamJobsKey, filter, q.retryJob)
+}
+
+// TrimDeadLetters drops the failed jobs beyond the retention limits and returns how many
+func (q *StreamQueue) TrimDeadLetters(ctx context.Context) (int, error) {
+	return trimFinishedJobs(ctx, q.client, StreamFailedSetKey, StreamJobsKey, q.deadLetterMaxJobs, q.deadLetterMaxAge)
+}
+
+// TrimCompletedJobs drops the completed jobs beyond the retention limits and returns how many
+func (q *StreamQueue) TrimCompletedJobs(ctx context.Context) (int, error) {
+	return trimFinishedJobs(ctx, q.client, StreamCompletedSetKey, StreamJobsKey, q.completedMaxJobs, q.completedMaxAge)
+}
+
+// DiscardJob removes a job for good, jobs held by a worker can't be discarded
+func (q *StreamQueue) DiscardJob(ctx context.Context, id string) error {
+	if err := q.ensureGroup(ctx); err != nil {
+		return err
+	}
+
+	job, err := q.GetJob(ctx, id)
+	if err != nil {
+		return err
+	}
+	if job.Status == domain.JobStatusProcessing {
+		return domain.ErrJobProcessing
+	}
+
+	if key, ok := streamStatusKeys[job.Status]; ok {
+		removed, err := discardStreamSetJob.Run(ctx, q.client, withStreamItemKeys(key), id).Int()
+		if err != nil {
+			return err
+		}
+		if removed == 0 {
+			return domain.ErrJobNotFound
+		}
+		return nil
+	}
+
+	parked, err := discardParkedJob.Run(ctx, q.client, withStreamItemKeys(streamParkedKey(job.ItemID)), id).Int()
+	if err != nil || parked == 1 {
+		return err
+	}
+	return q.discardPendingJob(ctx, id)
+}
+
+// discardPendingJob removes a job and its entry unless a worker received the entry
+func (q *StreamQueue) discardPendingJob(ctx context.Context, id string) error {
+	entry, err := q.client.HGet(ctx, StreamEntriesKey, id).Result()
+	if err == redis.Nil {
+		return domain.ErrJobNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	removed, err := discardStreamJob.Run(ctx, q.client, withStreamItemKeys(), id, entry, StreamGroup).Int()
+	if err != nil {
+		return err
+	}
+	switch removed {
+	case 0:
+		return domain.ErrJobNotFound
+	case -1:
+		// a worker received the entry since we read the job
+		return domain.ErrJobProcessing
+	}
+	return nil
+}
+
+// PurgeJobs removes every job with the status, jobs held by a worker can't be purged
+func (q *StreamQueue) PurgeJobs(ctx context.Context, status domain.JobStatus) (int64, error) {
+	if status == domain.JobStatusProcessing {
+		return 0, domain.ErrJobProcessing
+	}
+	if key, ok := streamStatusKeys[status]; ok {
+		return purgeStreamSet.Run(ctx, q.client, withStreamItemKeys(key)).Int64()
+	}
+	if status != domain.JobStatusPending {
+		return 0, fmt.Errorf("unknown job status: %s", status)
+	}
+
+	// pending jobs are discarded one by one, workers keep receiving entries meanwhile.
+	// a batch without a discarded job means the rest went to workers
+	var purged int64
+	for {
+		jobs, _, err := q.ListJobs(ctx, domain.JobStatusPending, 0, replayBatch)
+		if err != nil {
+			return purged, err
+		}
+
+		discarded := 0
+		for _, job := range jobs {
+			err := q.DiscardJob(ctx, job.ID)
+			if errors.Is(err, domain.ErrJobNotFound) || errors.Is(err, domain.ErrJobProcessing) {
+				continue
+			}
+			if err != nil {
+				return purged, err
+			}
+			discarded++
+		}
+		purged += int64(discarded)
+		if discarded == 0 {
+			return purged, nil
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package queue
+
+import (
+	"strconv"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// StreamItemsKey maps the ID of every item with a job in flight to the ID of the job holding it
+	StreamItemsKey = "reservation:stream:items"
+	// StreamParkedSetKey scores the IDs of jobs parked behind a busy item with the unix milliseconds they were parked at
+	StreamParkedSetKey = "reservation:stream:parked"
+	// streamParkedPrefix is the prefix of the list of jobs parked behind an item, oldest first
+	streamParkedPrefix = "reservation:stream:parked:"
+)
+
+// streamItemLocks are the lua helpers that keep one job per item in flight on the stream. the group hands
+// every entry to the consumer that reads next, so a job whose item is busy can't be skipped like on the list
+// queue: its entry is dropped and the job parked behind the item until the holder is done. the holder then
+// hands the item to the oldest parked job and adds an entry for it. a job holds its item from the dequeue
+// until it completes or fails for good, retries keep holding it.
+// KEYS[1] to KEYS[5] are the stream, entries, jobs, items and parked set keys of every script using them
+const streamItemLocks = `
+local function streamItemOf(id)
+  local payload = redis.call('HGET', KEYS[3], id)
+  if not payload then
+    return nil
+  end
+  return tostring(cjson.decode(payload).item_id)
+end
+
+local function releaseStreamItem(id)
+  local item = streamItemOf(id)
+  if not item or redis.call('HGET', KEYS[4], item) ~= id then
+    return
+  end
+  local parked = '` + streamParkedPrefix + `' .. item
+  while true do
+    local next = redis.call('LPOP', parked)
+    if not next then
+      redis.call('HDEL', KEYS[4], item)
+      return
+    end
+    redis.call('ZREM', KEYS[5], next)
+    -- a parked job discarded meanwhile is skipped
+    if redis.call('HEXISTS', KEYS[3], next) == 1 then
+      redis.call('HSET', KEYS[4], item, next)
+      local entry = redis.call('XADD', KEYS[1], '*', 'job', next)
+      redis.call('HSET', KEYS[2], next, entry)
+      return
+    end
+  end
+end
+`
+
+// streamItemKeys are the keys streamItemLocks expects first
+var streamItemKeys = []string{StreamKey, StreamEntriesKey, StreamJobsKey, StreamItemsKey, StreamParkedSetKey}
+
+// claimStreamJob locks the item ARGV[4] for the job ARGV[2] a consumer received in the entry ARGV[3].
+// while another job holds the item the entry is acknowledged and dropped and the job parked in KEYS[6]
+var claimStreamJob = redis.NewScript(redisNowMillis + `
+local holder = redis.call('HGET', KEYS[4], ARGV[4])
+if not holder or holder == ARGV[2] then
+  redis.call('HSET', KEYS[4], ARGV[4], ARGV[2])
+  return 1
+end
+redis.call('XACK', KEYS[1], ARGV[1], ARGV[3])
+redis.call('XDEL', KEYS[1], ARGV[3])
+redis.call('HDEL', KEYS[2], ARGV[2])
+redis.call('RPUSH', KEYS[6], ARGV[2])
+redis.call('ZADD', KEYS[5], now, ARGV[2])
+return 0
+`)
+
+// discardParkedJob removes a parked job, it returns 0 if the job isn't parked
+var discardParkedJob = redis.NewScript(`
+if redis.call('ZREM', KEYS[5], ARGV[1]) == 0 then
+  return 0
+end
+redis.call('LREM', KEYS[6], 1, ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1
+`)
+
+// discardStreamSetJob removes a job and its ID from the sorted set KEYS[6] and hands its item on
+var discardStreamSetJob = redis.NewScript(streamItemLocks + `
+if redis.call('ZREM', KEYS[6], ARGV[1]) == 0 then
+  return 0
+end
+releaseStreamItem(ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1
+`)
+
+// purgeStreamSet removes every job whose ID is in the sorted set KEYS[6] and hands their items on
+var purgeStreamSet = redis.NewScript(streamItemLocks + `
+local ids = redis.call('ZRANGE', KEYS[6], 0, -1)
+for _, id in ipairs(ids) do
+  releaseStreamItem(id)
+  redis.call('HDEL', KEYS[3], id)
+end
+redis.call('DEL', KEYS[6])
+return #ids
+`)
+
+// streamParkedKey is the list of the jobs parked behind the item
+func streamParkedKey(itemID int64) string {
+	return streamParkedPrefix + strconv.FormatInt(itemID, 10)
+}
+
+// withStreamItemKeys returns streamItemKeys followed by keys
+func withStreamItemKeys(keys ...string) []string {
+	return append(append(make([]string, 0, len(streamItemKeys)+len(keys)), streamItemKeys...), keys...)
+}
@@ -0,0 +1,119 @@
+package queue
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamDequeueStopsWithContext(t *testing.T) {
+	client, _ := setupFrozenRedis(t)
+	queue := &StreamQueue{client: client, consumer: "worker-1", visibilityTimeout: 30 * time.Second}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := queue.DequeueReservation(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestStreamReclaimsEntriesOfDeadConsumers(t *testing.T) {
+	client, advance := setupFrozenRedis(t)
+	dead := &StreamQueue{client: client, consumer: "worker-1", visibilityTimeout: 30 * time.Second}
+	live := &StreamQueue{client: client, consumer: "worker-2", visibilityTimeout: 30 * time.Second}
+	ctx := context.Background()
+	require.NoError(t, dead.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	job, err := dead.DequeueReservation(ctx)
+	require.NoError(t, err)
+
+	advance(31 * time.Second)
+	requeued, err := live.RequeueExpiredJobs(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, requeued)
+	assert.ErrorIs(t, dead.ExtendLease(ctx, job), domain.ErrLeaseLost)
+
+	// the reclaimed entry was replaced, the stream only holds the new one
+	assert.Equal(t, int64(1), client.XLen(ctx, StreamKey).Val())
+	job, err = live.DequeueReservation(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "job-1", job.ID)
+	assert.Equal(t, 1, job.Attempts)
+
+	require.NoError(t, live.CompleteJob(ctx, job))
+	assert.Equal(t, int64(0), client.XLen(ctx, StreamKey).Val())
+	assert.Equal(t, int64(0), client.HLen(ctx, StreamEntriesKey).Val())
+}
+
+func TestStreamEnqueueSkipsQueuedJobs(t *testing.T) {
+	client, _ := setupFrozenRedis(t)
+	queue := &StreamQueue{client: client, consumer: "worker-1", visibilityTimeout: 30 * time.Second}
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-1", ItemID: 1}))
+
+	// the relay publishes job-1 again next to a new job, only the new one gets an entry
+	require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 2}}))
+	assert.Equal(t, int64(2), client.XLen(ctx, StreamKey).Val())
+	assert.Equal(t, int64(2), client.HLen(ctx, StreamJobsKey).Val())
+}
+
+func TestStreamSkipsEntriesOfDiscardedJobs(t *testing.T) {
+	client, _ := setupFrozenRedis(t)
+	queue := &StreamQueue{client: client, consumer: "worker-1", visibilityTimeout: 30 * time.Second}
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 2}}))
+
+	// a job whose hash entry is gone, its stream entry is dropped on dequeue
+	require.NoError(t, client.HDel(ctx, StreamJobsKey, "job-1").Err())
+
+	job, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "job-2", job.ID)
+	assert.Equal(t, int64(1), client.XLen(ctx, StreamKey).Val())
+}
+
+func TestStreamParksJobsOfBusyItems(t *testing.T) {
+	client, _ := setupFrozenRedis(t)
+	queue := &StreamQueue{client: client, consumer: "worker-1", visibilityTimeout: 30 * time.Second}
+	ctx := context.Background()
+	require.NoError(t, queue.EnqueueReservations(ctx, []*domain.ReservationJob{
+		{ID: "job-1", ItemID: 1}, {ID: "job-2", ItemID: 1}, {ID: "job-3", ItemID: 1},
+	}))
+
+	first, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	_, err = dequeueWithin(queue)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	// only the first job kept its entry, the later ones wait behind the item and still count as pending
+	assert.Equal(t, int64(1), client.XLen(ctx, StreamKey).Val())
+	assert.Equal(t, []string{"job-2", "job-3"}, client.LRange(ctx, streamParkedKey(1), 0, -1).Val())
+	depths, err := queue.QueueDepths(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), depths[domain.JobStatusPending])
+	pending, total, err := queue.ListJobs(ctx, domain.JobStatusPending, 0, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), total)
+	assert.Len(t, pending, 2)
+
+	// a discarded parked job is skipped, the item goes to the next one once the first is done
+	require.NoError(t, queue.DiscardJob(ctx, "job-2"))
+	require.NoError(t, queue.CompleteJob(ctx, first))
+	next, err := queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "job-3", next.ID)
+
+	// a delayed job that is discarded frees its item
+	require.NoError(t, queue.RequeueJob(ctx, next, time.Minute))
+	require.NoError(t, queue.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-4", ItemID: 1}))
+	_, err = dequeueWithin(queue)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	require.NoError(t, queue.DiscardJob(ctx, "job-3"))
+	next, err = queue.DequeueReservation(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, "job-4", next.ID)
+	assert.Equal(t, "job-4", client.HGet(ctx, StreamItemsKey, "1").Val())
+}
@@ -0,0 +1,83 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const keyPrefix = "ratelimit:"
+
+// slidingWindow keeps a sorted set of request timestamps per key and only admits a request while
+// fewer than limit requests happened in the last window.
+// why a script? the check and the insert must be atomic, otherwise concurrent instances both
+// see room for the last request. the clock is the redis clock, so app instances with skewed
+// clocks still agree on the window
+var slidingWindow = redis.NewScript(`
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local limit = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - window)
+local count = redis.call('ZCARD', KEYS[1])
+local allowed = 0
+if count < limit then
+  redis.call('ZADD', KEYS[1], now, ARGV[3])
+  count = count + 1
+  allowed = 1
+end
+redis.call('PEXPIRE', KEYS[1], window)
+
+local reset = window
+local oldest = redis.call('ZRANGE', KEYS[1], 0, 0, 'WITHSCORES')
+if oldest[2] then
+  reset = tonumber(oldest[2]) + window - now
+end
+return {allowed, limit - count, reset}
+`)
+
+// Rule is the number of requests allowed per window
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Result is the outcome of a single check
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset is the time until the oldest request leaves the window and frees a slot
+	Reset time.Duration
+}
+
+// Limiter is a sliding window rate limiter stored in redis, so every app instance shares the same counters
+type Limiter struct {
+	client *redis.Client
+}
+
+// NewLimiter creates a limiter on an existing client, it doesn't open its own connections
+func NewLimiter(client *redis.Client) *Limiter {
+	return &Limiter{client: client}
+}
+
+// Allow records a request for key and reports whether it is within the rule
+func (l *Limiter) Allow(ctx context.Context, key string, rule Rule) (Result, error) {
+	res, err := slidingWindow.Run(ctx, l.client, []string{keyPrefix + key},
+		rule.Limit, rule.Window.Milliseconds(), uuid.New().String(),
+	).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("error checking rate limit: %w", err)
+	}
+
+	return Result{
+		Allowed:   res[0] == 1,
+		Limit:     rule.Limit,
+		Remaining: int(res[1]),
+		Reset:     time.Duration(res[2]) * time.Millisecond,
+	}, nil
+}
@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupTestRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr, err := miniredis.Run()
+	require.NoError(t, err)
+	t.Cleanup(mr.Close)
+
+	// the script reads the redis clock, a fixed time makes the window deterministic
+	mr.SetTime(time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+func TestAllow(t *testing.T) {
+	mr, client := setupTestRedis(t)
+	limiter := NewLimiter(client)
+	ctx := context.Background()
+	rule := Rule{Limit: 3, Window: time.Minute}
+
+	for i := 0; i < 3; i++ {
+		res, err := limiter.Allow(ctx, "user:1", rule)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 2-i, res.Remaining)
+	}
+
+	res, err := limiter.Allow(ctx, "user:1", rule)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, time.Minute, res.Reset)
+
+	// other callers have their own budget
+	res, err = limiter.Allow(ctx, "user:2", rule)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	// the window slides, the first requests leave it after a minute
+	mr.SetTime(time.Date(2024, 1, 1, 12, 1, 0, 1e6, time.UTC))
+	res, err = limiter.Allow(ctx, "user:1", rule)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 2, res.Remaining)
+}
+
+func TestAllowAcrossInstances(t *testing.T) {
+	_, client := setupTestRedis(t)
+	ctx := context.Background()
+	rule := Rule{Limit: 2, Window: time.Minute}
+
+	// two app instances with their own clients share the counters in redis
+	other := redis.NewClient(&redis.Options{Addr: client.Options().Addr})
+	defer other.Close()
+	first, second := NewLimiter(client), NewLimiter(other)
+
+	res, err := first.Allow(ctx, "ip:10.0.0.1", rule)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	res, err = second.Allow(ctx, "ip:10.0.0.1", rule)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+
+	res, err = first.Allow(ctx, "ip:10.0.0.1", rule)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+}
+
+func TestAllowRedisDown(t *testing.T) {
+	mr, client := setupTestRedis(t)
+	mr.Close()
+
+	_, err := NewLimiter(client).Allow(context.Background(), "user:1", Rule{Limit: 1, Window: time.Minute})
+	assert.Error(t, err)
+}
@@ -0,0 +1,116 @@
+package ratelimit
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/adapters/auth"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+// HeaderAPIKey identifies API clients, only keys of the config count and an authenticated user takes precedence
+const HeaderAPIKey = "X-API-Key"
+
+// headers of draft-ietf-httpapi-ratelimit-headers
+const (
+	HeaderRateLimitLimit     = "RateLimit-Limit"
+	HeaderRateLimitRemaining = "RateLimit-Remaining"
+	HeaderRateLimitReset     = "RateLimit-Reset"
+	HeaderRateLimitPolicy    = "RateLimit-Policy"
+)
+
+// Middleware limits requests per route and caller, it must run after the auth middleware so
+// authenticated users get their own budget instead of sharing the one of their IP.
+// the IP comes from echo's IPExtractor, which must only trust the forwarded headers of known proxies.
+// exempt paths are never limited, e.g. the probes of the orchestrator
+func Middleware(limiter *Limiter, cfg config.RateLimitConfig, logger *slog.Logger, exemptPaths ...string) echo.MiddlewareFunc {
+	exempt := make(map[string]bool, len(exemptPaths))
+	for _, p := range exemptPaths {
+		exempt[p] = true
+	}
+
+	defaultRule := Rule{Limit: cfg.Limit, Window: cfg.Window}
+	routes := make(map[string]Rule, len(cfg.Routes))
+	for _, r := range cfg.Routes {
+		routes[routeKey(r.Method, r.Path)] = Rule{Limit: r.Limit, Window: r.Window}
+	}
+	clients := make(map[string]string, len(cfg.APIKeys))
+	for _, k := range cfg.APIKeys {
+		clients[hashKey(k.Key)] = k.Client
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if exempt[c.Request().URL.Path] {
+				return next(c)
+			}
+
+			route := routeKey(c.Request().Method, c.Path())
+			rule, ok := routes[route]
+			if !ok {
+				rule = defaultRule
+			}
+			if rule.Limit == 0 {
+				return next(c)
+			}
+
+			res, err := limiter.Allow(c.Request().Context(), route+":"+identity(c, clients), rule)
+			if err != nil {
+				// a redis outage must not take the API down with it, so we fail open
+				logger.Error("rate limit check failed", "route", route, "error", err)
+				return next(c)
+			}
+
+			setHeaders(c.Response().Header(), rule, res)
+			if !res.Allowed {
+				c.Response().Header().Set(echo.HeaderRetryAfter, c.Response().Header().Get(HeaderRateLimitReset))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit exceeded")
+			}
+			return next(c)
+		}
+	}
+}
+
+// routeKey uses the registered route, not the URL, so /items/1 and /items/2 share a budget.
+// escaped colons of custom methods like items\:batch are written unescaped in the config
+func routeKey(method, path string) string {
+	return strings.ToUpper(method) + " " + strings.ReplaceAll(path, `\:`, ":")
+}
+
+// identity picks the most specific verified caller identity: the authenticated user, then a configured
+// API key, then the IP. the anonymous user of disabled auth is everybody so it doesn't count as a user,
+// and unknown API keys are ignored, otherwise every made up key would get a fresh budget
+func identity(c echo.Context, clients map[string]string) string {
+	if p, ok := domain.PrincipalFromContext(c.Request().Context()); ok && p.Subject != auth.AnonymousSubject {
+		return "user:" + p.Subject
+	}
+	if apiKey := c.Request().Header.Get(HeaderAPIKey); apiKey != "" {
+		if client, ok := clients[hashKey(apiKey)]; ok {
+			return "key:" + client
+		}
+	}
+	return "ip:" + c.RealIP()
+}
+
+// hashKey compares keys by their hash, so the map isn't searched with the secret itself
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func setHeaders(h http.Header, rule Rule, res Result) {
+	h.Set(HeaderRateLimitLimit, strconv.Itoa(res.Limit))
+	h.Set(HeaderRateLimitRemaining, strconv.Itoa(res.Remaining))
+	// reset is in whole seconds, rounding down would tell clients to retry too early
+	h.Set(HeaderRateLimitReset, strconv.Itoa(int(math.Ceil(res.Reset.Seconds()))))
+	h.Set(HeaderRateLimitPolicy, fmt.Sprintf("%d;w=%d", rule.Limit, int(rule.Window.Seconds())))
+}
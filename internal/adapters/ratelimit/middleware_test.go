@@ -0,0 +1,155 @@
+package ratelimit
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+func setupTestServer(t *testing.T) (*echo.Echo, func()) {
+	mr, client := setupTestRedis(t)
+
+	cfg := config.RateLimitConfig{
+		Enabled: true,
+		Limit:   2,
+		Window:  time.Minute,
+		Routes: []config.RouteRateLimitConfig{
+			{Method: "POST", Path: "/api/v1/items:batch", Limit: 1, Window: time.Minute},
+			{Method: "GET", Path: "/api/v1/openapi.json", Limit: 0},
+		},
+		APIKeys: []config.APIKeyConfig{{Client: "client-1", Key: "key-1"}},
+	}
+
+	e := echo.New()
+	e.IPExtractor = echo.ExtractIPDirect()
+	// a stand in for the auth middleware
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if user := c.Request().Header.Get("X-Test-User"); user != "" {
+				ctx := domain.ContextWithPrincipal(c.Request().Context(), domain.Principal{Subject: user})
+				c.SetRequest(c.Request().WithContext(ctx))
+			}
+			return next(c)
+		}
+	})
+	e.Use(Middleware(NewLimiter(client), cfg, slog.Default(), "/healthz"))
+
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/api/v1/items", ok)
+	e.POST("api/v1/items\\:batch", ok)
+	e.GET("/api/v1/openapi.json", ok)
+	e.GET("/healthz", ok)
+	return e, mr.Close
+}
+
+func TestMiddleware(t *testing.T) {
+	tests := []struct {
+		name     string
+		method   string
+		path     string
+		header   map[string]string
+		statuses []int
+	}{
+		{
+			name:     "default rule",
+			method:   http.MethodGet,
+			path:     "/api/v1/items",
+			statuses: []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "route rule",
+			method:   http.MethodPost,
+			path:     "/api/v1/items:batch",
+			statuses: []int{http.StatusOK, http.StatusTooManyRequests},
+		},
+		{
+			name:     "exempt path",
+			method:   http.MethodGet,
+			path:     "/healthz",
+			statuses: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+		{
+			name:     "unlimited route",
+			method:   http.MethodGet,
+			path:     "/api/v1/openapi.json",
+			statuses: []int{http.StatusOK, http.StatusOK, http.StatusOK},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, _ := setupTestServer(t)
+
+			for i, expected := range tt.statuses {
+				rec := httptest.NewRecorder()
+				e.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+				assert.Equal(t, expected, rec.Code, "request %d", i+1)
+			}
+		})
+	}
+}
+
+func TestMiddlewareHeaders(t *testing.T) {
+	e, _ := setupTestServer(t)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/items", nil))
+	assert.Equal(t, "2", rec.Header().Get(HeaderRateLimitLimit))
+	assert.Equal(t, "1", rec.Header().Get(HeaderRateLimitRemaining))
+	assert.Equal(t, "60", rec.Header().Get(HeaderRateLimitReset))
+	assert.Equal(t, "2;w=60", rec.Header().Get(HeaderRateLimitPolicy))
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/items", nil))
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/items", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get(HeaderRateLimitRemaining))
+	assert.Equal(t, "60", rec.Header().Get(echo.HeaderRetryAfter))
+}
+
+func TestMiddlewareIdentity(t *testing.T) {
+	e, _ := setupTestServer(t)
+
+	// every caller has its own budget of 1 batch request, the user goes before the key
+	// and unknown keys share the budget of their IP
+	callers := []map[string]string{
+		{},
+		{"X-Test-User": "user-1"},
+		{"X-Test-User": "user-2"},
+		{HeaderAPIKey: "key-1"},
+		{HeaderAPIKey: "key-1", "X-Test-User": "user-1"},
+		{HeaderAPIKey: "made-up"},
+		{"X-Forwarded-For": "203.0.113.7"},
+	}
+	expected := []int{http.StatusOK, http.StatusOK, http.StatusOK, http.StatusOK, http.StatusTooManyRequests,
+		http.StatusTooManyRequests, http.StatusTooManyRequests}
+
+	for i, header := range callers {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/items:batch", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, expected[i], rec.Code, "caller %v", header)
+	}
+}
+
+func TestMiddlewareFailsOpen(t *testing.T) {
+	e, closeRedis := setupTestServer(t)
+	closeRedis()
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/items", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get(HeaderRateLimitLimit))
+}
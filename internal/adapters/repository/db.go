@@ -3,7 +3,10 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"net"
 
 	db "github.com/a-berahman/shopping-cart/internal/adapters/repository/postgres"
 	"github.com/a-berahman/shopping-cart/internal/core/domain"
@@ -12,13 +15,15 @@ import (
 )
 
 type Repository struct {
-	db *db.Queries
+	conn *sql.DB
+	db   *db.Queries
 }
 
 // NewRepository creates a new instance of Repository
 func NewRepository(in *sql.DB) *Repository {
 	return &Repository{
-		db: db.New(in),
+		conn: in,
+		db:   db.New(in),
 	}
 }
 func NewPostgresDB(dbURL string) (*sql.DB, error) {
@@ -39,9 +44,10 @@ func (r *Repository) CreateItem(ctx context.Context, item *domain.Item) error {
 		Name:     item.Name,
 		Quantity: int32(item.Quantity),
 		Status:   db.ItemStatus(item.Status),
+		OwnerID:  item.OwnerID,
 	})
 	if err != nil {
-		return fmt.Errorf("error creating item: %w", err)
+		return wrapError(err, "error creating item")
 	}
 
 	item.ID = int64(dbItem.ID)
@@ -50,10 +56,48 @@ func (r *Repository) CreateItem(ctx context.Context, item *domain.Item) error {
 	return nil
 }
 
-func (r *Repository) ListItems(ctx context.Context) ([]domain.Item, error) {
-	dbItems, err := r.db.ListItems(ctx)
+// CreateItems creates all items in one transaction, either every item is created or none
+func (r *Repository) CreateItems(ctx context.Context, items []*domain.Item) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, fmt.Errorf("error listing items: %w", err)
+		return wrapError(err, "error starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := r.createItems(ctx, tx, items); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return wrapError(err, "error committing items")
+	}
+	return nil
+}
+
+// createItems creates the items in the transaction tx
+func (r *Repository) createItems(ctx context.Context, tx *sql.Tx, items []*domain.Item) error {
+	queries := r.db.WithTx(tx)
+	for _, item := range items {
+		dbItem, err := queries.CreateItem(ctx, db.CreateItemParams{
+			Name:     item.Name,
+			Quantity: int32(item.Quantity),
+			Status:   db.ItemStatus(item.Status),
+			OwnerID:  item.OwnerID,
+		})
+		if err != nil {
+			return wrapError(err, "error creating item")
+		}
+
+		item.ID = int64(dbItem.ID)
+		item.CreatedAt = dbItem.CreatedAt
+		item.UpdatedAt = dbItem.UpdatedAt
+	}
+	return nil
+}
+
+func (r *Repository) ListItems(ctx context.Context, ownerID string) ([]domain.Item, error) {
+	dbItems, err := r.db.ListItems(ctx, ownerID)
+	if err != nil {
+		return nil, wrapError(err, "error listing items")
 	}
 
 	items := make([]domain.Item, len(dbItems))
@@ -66,6 +110,8 @@ func (r *Repository) ListItems(ctx context.Context) ([]domain.Item, error) {
 			Status:        domain.ItemStatus(dbItem.Status),
 			CreatedAt:     dbItem.CreatedAt,
 			UpdatedAt:     dbItem.UpdatedAt,
+			OwnerID:       dbItem.OwnerID,
+			FailureReason: nullString(dbItem.FailureReason),
 		}
 	}
 	return items, nil
@@ -77,15 +123,18 @@ func (r *Repository) UpdateItemReservation(ctx context.Context, id int64, reserv
 		ReservationID: sql.NullString{String: reservationID, Valid: true},
 		Status:        db.ItemStatus(domain.StatusReservationReserved),
 	}); err != nil {
-		return fmt.Errorf("error updating item reservation: %w", err)
+		return wrapError(err, "error updating item reservation")
 	}
 	return nil
 }
 
 func (r *Repository) GetItem(ctx context.Context, id int64) (*domain.Item, error) {
 	dbItem, err := r.db.GetItem(ctx, int32(id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, domain.ErrItemNotFound
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error getting item: %w", err)
+		return nil, wrapError(err, "error getting item")
 	}
 
 	return &domain.Item{
@@ -96,6 +145,8 @@ func (r *Repository) GetItem(ctx context.Context, id int64) (*domain.Item, error
 		Status:        domain.ItemStatus(dbItem.Status),
 		CreatedAt:     dbItem.CreatedAt,
 		UpdatedAt:     dbItem.UpdatedAt,
+		OwnerID:       dbItem.OwnerID,
+		FailureReason: nullString(dbItem.FailureReason),
 	}, nil
 }
 
@@ -105,7 +156,40 @@ func (r *Repository) UpdateItemStatus(ctx context.Context, id int64, status doma
 		Status: db.ItemStatus(status),
 	})
 	if err != nil {
-		return fmt.Errorf("error updating item status: %w", err)
+		return wrapError(err, "error updating item status")
 	}
 	return nil
 }
+
+// FailItem marks the item as failed, reason is shown to its owner
+func (r *Repository) FailItem(ctx context.Context, id int64, reason string) error {
+	_, err := r.db.FailItem(ctx, db.FailItemParams{
+		ID:            int32(id),
+		Status:        db.ItemStatus(domain.StatusReservationFailed),
+		FailureReason: sql.NullString{String: reason, Valid: true},
+	})
+	if err != nil {
+		return wrapError(err, "error failing item")
+	}
+	return nil
+}
+
+// nullString returns nil for a NULL column
+func nullString(s sql.NullString) *string {
+	if !s.Valid {
+		return nil
+	}
+	return &s.String
+}
+
+// wrapError adds context to database errors, errors that mean the database can't be reached
+// become a domain error so the API can tell them apart from bugs
+func wrapError(err error, msg string) error {
+	wrapped := fmt.Errorf("%s: %w", msg, err)
+
+	var netErr net.Error
+	if errors.Is(err, sql.ErrConnDone) || errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		return domain.NewDependencyUnavailableError("database_unavailable", "database is unavailable", wrapped)
+	}
+	return wrapped
+}
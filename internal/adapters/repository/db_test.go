@@ -37,14 +37,15 @@ func TestCreateItem(t *testing.T) {
 				Name:     "Test Item",
 				Quantity: 1,
 				Status:   domain.StatusReservationPending,
+				OwnerID:  "user-1",
 			},
 			setup: func(mock sqlmock.Sqlmock, item *domain.Item) {
-				columns := []string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at"}
+				columns := []string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at", "owner_id", "failure_reason"}
 				mock.ExpectQuery(`INSERT INTO items (.+) RETURNING *`).
-					WithArgs(item.Name, int32(item.Quantity), string(item.Status)).
+					WithArgs(item.Name, int32(item.Quantity), string(item.Status), item.OwnerID).
 					WillReturnRows(
 						sqlmock.NewRows(columns).
-							AddRow(1, item.Name, int32(item.Quantity), sql.NullString{}, string(item.Status), now, now),
+							AddRow(1, item.Name, int32(item.Quantity), sql.NullString{}, string(item.Status), now, now, "user-1", sql.NullString{}),
 					)
 			},
 			wantErr: false,
@@ -55,10 +56,11 @@ func TestCreateItem(t *testing.T) {
 				Name:     "Test Item",
 				Quantity: 1,
 				Status:   domain.StatusReservationPending,
+				OwnerID:  "user-1",
 			},
 			setup: func(mock sqlmock.Sqlmock, item *domain.Item) {
 				mock.ExpectQuery(`INSERT INTO items (.+) RETURNING *`).
-					WithArgs(item.Name, int32(item.Quantity), string(item.Status)).
+					WithArgs(item.Name, int32(item.Quantity), string(item.Status), item.OwnerID).
 					WillReturnError(sql.ErrConnDone)
 			},
 			wantErr: true,
@@ -83,6 +85,75 @@ func TestCreateItem(t *testing.T) {
 	}
 }
 
+func TestCreateItems(t *testing.T) {
+	repo, mock := setupTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	columns := []string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at", "owner_id", "failure_reason"}
+
+	tests := []struct {
+		name    string
+		items   []*domain.Item
+		setup   func(sqlmock.Sqlmock, []*domain.Item)
+		wantErr bool
+	}{
+		{
+			name: "items are created in one transaction",
+			items: []*domain.Item{
+				{Name: "Item 1", Quantity: 1, Status: domain.StatusReservationPending, OwnerID: "user-1"},
+				{Name: "Item 2", Quantity: 2, Status: domain.StatusReservationPending, OwnerID: "user-1"},
+			},
+			setup: func(mock sqlmock.Sqlmock, items []*domain.Item) {
+				mock.ExpectBegin()
+				for i, item := range items {
+					mock.ExpectQuery(`INSERT INTO items (.+) RETURNING *`).
+						WithArgs(item.Name, int32(item.Quantity), string(item.Status), item.OwnerID).
+						WillReturnRows(sqlmock.NewRows(columns).
+							AddRow(i+1, item.Name, int32(item.Quantity), sql.NullString{}, string(item.Status), now, now, "user-1", sql.NullString{}))
+				}
+				mock.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name: "failing item rolls back the batch",
+			items: []*domain.Item{
+				{Name: "Item 1", Quantity: 1, Status: domain.StatusReservationPending, OwnerID: "user-1"},
+				{Name: "Item 2", Quantity: 2, Status: domain.StatusReservationPending, OwnerID: "user-1"},
+			},
+			setup: func(mock sqlmock.Sqlmock, items []*domain.Item) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO items (.+) RETURNING *`).
+					WithArgs(items[0].Name, int32(items[0].Quantity), string(items[0].Status), items[0].OwnerID).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(1, items[0].Name, int32(items[0].Quantity), sql.NullString{}, string(items[0].Status), now, now, "user-1", sql.NullString{}))
+				mock.ExpectQuery(`INSERT INTO items (.+) RETURNING *`).
+					WithArgs(items[1].Name, int32(items[1].Quantity), string(items[1].Status), items[1].OwnerID).
+					WillReturnError(sql.ErrConnDone)
+				mock.ExpectRollback()
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(mock, tt.items)
+
+			err := repo.CreateItems(ctx, tt.items)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				for i, item := range tt.items {
+					assert.Equal(t, int64(i+1), item.ID)
+				}
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
 func TestListItems(t *testing.T) {
 	repo, mock := setupTestDB(t)
 	ctx := context.Background()
@@ -97,10 +168,12 @@ func TestListItems(t *testing.T) {
 		{
 			name: "successful list",
 			setup: func(mock sqlmock.Sqlmock) {
-				rows := sqlmock.NewRows([]string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at"}).
-					AddRow(1, "Item 1", 1, sql.NullString{String: "res1", Valid: true}, "PENDING", now, now).
-					AddRow(2, "Item 2", 2, sql.NullString{String: "res2", Valid: true}, "RESERVED", now, now)
-				mock.ExpectQuery("SELECT (.+) FROM items").WillReturnRows(rows)
+				rows := sqlmock.NewRows([]string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at", "owner_id", "failure_reason"}).
+					AddRow(1, "Item 1", 1, sql.NullString{String: "res1", Valid: true}, "PENDING", now, now, "user-1", sql.NullString{}).
+					AddRow(2, "Item 2", 2, sql.NullString{String: "res2", Valid: true}, "RESERVED", now, now, "user-1", sql.NullString{})
+				mock.ExpectQuery("SELECT (.+) FROM items WHERE owner_id = (.+)").
+					WithArgs("user-1").
+					WillReturnRows(rows)
 			},
 			want: []domain.Item{
 				{
@@ -111,6 +184,7 @@ func TestListItems(t *testing.T) {
 					Status:        domain.StatusReservationPending,
 					CreatedAt:     now,
 					UpdatedAt:     now,
+					OwnerID:       "user-1",
 				},
 				{
 					ID:            2,
@@ -120,6 +194,7 @@ func TestListItems(t *testing.T) {
 					Status:        domain.StatusReservationReserved,
 					CreatedAt:     now,
 					UpdatedAt:     now,
+					OwnerID:       "user-1",
 				},
 			},
 			wantErr: false,
@@ -137,7 +212,7 @@ func TestListItems(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			tt.setup(mock)
 
-			got, err := repo.ListItems(ctx)
+			got, err := repo.ListItems(ctx, "user-1")
 			if tt.wantErr {
 				assert.Error(t, err)
 				return
@@ -166,12 +241,12 @@ func TestUpdateItemReservation(t *testing.T) {
 			id:            1,
 			reservationID: "res1",
 			setup: func(mock sqlmock.Sqlmock, id int64, resID string) {
-				columns := []string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at"}
+				columns := []string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at", "owner_id", "failure_reason"}
 				mock.ExpectQuery(`UPDATE items SET (.+) RETURNING (.+)`).
 					WithArgs(int32(id), resID, string(domain.StatusReservationReserved)).
 					WillReturnRows(sqlmock.NewRows(columns).
 						AddRow(id, "Test Item", 1, sql.NullString{String: resID, Valid: true},
-							string(domain.StatusReservationReserved), now, now))
+							string(domain.StatusReservationReserved), now, now, "user-1", sql.NullString{}))
 			},
 			wantErr: false,
 		},
@@ -203,6 +278,134 @@ func TestUpdateItemReservation(t *testing.T) {
 	}
 }
 
+func TestGetItem(t *testing.T) {
+	repo, mock := setupTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+	columns := []string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at", "owner_id", "failure_reason"}
+
+	tests := []struct {
+		name    string
+		id      int64
+		setup   func(sqlmock.Sqlmock, int64)
+		want    *domain.Item
+		wantErr error
+	}{
+		{
+			name: "item found",
+			id:   1,
+			setup: func(mock sqlmock.Sqlmock, id int64) {
+				mock.ExpectQuery("SELECT (.+) FROM items WHERE id = (.+)").
+					WithArgs(int32(id)).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(id, "Item 1", 1, sql.NullString{String: "res1", Valid: true}, "RESERVED", now, now, "user-1", sql.NullString{}))
+			},
+			want: &domain.Item{
+				ID:            1,
+				Name:          "Item 1",
+				Quantity:      1,
+				ReservationID: stringPtr("res1"),
+				Status:        domain.StatusReservationReserved,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+				OwnerID:       "user-1",
+			},
+		},
+		{
+			name: "failed item has its reason",
+			id:   3,
+			setup: func(mock sqlmock.Sqlmock, id int64) {
+				mock.ExpectQuery("SELECT (.+) FROM items WHERE id = (.+)").
+					WithArgs(int32(id)).
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(id, "Item 3", 1, sql.NullString{}, "FAILED", now, now, "user-1", sql.NullString{String: "item not found", Valid: true}))
+			},
+			want: &domain.Item{
+				ID:            3,
+				Name:          "Item 3",
+				Quantity:      1,
+				ReservationID: stringPtr(""),
+				Status:        domain.StatusReservationFailed,
+				CreatedAt:     now,
+				UpdatedAt:     now,
+				OwnerID:       "user-1",
+				FailureReason: stringPtr("item not found"),
+			},
+		},
+		{
+			name: "item not found",
+			id:   2,
+			setup: func(mock sqlmock.Sqlmock, id int64) {
+				mock.ExpectQuery("SELECT (.+) FROM items WHERE id = (.+)").
+					WithArgs(int32(id)).
+					WillReturnError(sql.ErrNoRows)
+			},
+			wantErr: domain.ErrItemNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(mock, tt.id)
+
+			got, err := repo.GetItem(ctx, tt.id)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+				return
+			}
+
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestFailItem(t *testing.T) {
+	repo, mock := setupTestDB(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	tests := []struct {
+		name    string
+		setup   func(sqlmock.Sqlmock)
+		wantErr bool
+	}{
+		{
+			name: "item is failed with its reason",
+			setup: func(mock sqlmock.Sqlmock) {
+				columns := []string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at", "owner_id", "failure_reason"}
+				mock.ExpectQuery(`UPDATE items SET (.+) RETURNING (.+)`).
+					WithArgs(int32(1), string(domain.StatusReservationFailed), "insufficient inventory").
+					WillReturnRows(sqlmock.NewRows(columns).
+						AddRow(1, "Test Item", 1, sql.NullString{}, string(domain.StatusReservationFailed), now, now, "user-1",
+							sql.NullString{String: "insufficient inventory", Valid: true}))
+			},
+		},
+		{
+			name: "database error",
+			setup: func(mock sqlmock.Sqlmock) {
+				mock.ExpectQuery(`UPDATE items SET (.+) RETURNING (.+)`).
+					WithArgs(int32(1), string(domain.StatusReservationFailed), "insufficient inventory").
+					WillReturnError(sql.ErrConnDone)
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup(mock)
+
+			err := repo.FailItem(ctx, 1, "insufficient inventory")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
 func stringPtr(s string) *string {
 	return &s
 }
@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+// TxQueue is a queue that keeps its jobs in the items database and can enqueue in a transaction of it
+type TxQueue interface {
+	EnqueueReservationsTx(ctx context.Context, tx *sql.Tx, jobs []*domain.ReservationJob) error
+}
+
+// JobRepository is a repository that creates items in one transaction with their jobs
+type JobRepository struct {
+	*Repository
+	queue TxQueue
+}
+
+// NewJobRepository creates a repository whose items are created with their jobs in queue
+func NewJobRepository(repo *Repository, queue TxQueue) *JobRepository {
+	return &JobRepository{Repository: repo, queue: queue}
+}
+
+// CreateItemsWithJobs creates the items and their jobs in one transaction, either all of them or none
+func (r *JobRepository) CreateItemsWithJobs(ctx context.Context, items []*domain.Item, newJobs func([]*domain.Item) []*domain.ReservationJob) error {
+	tx, err := r.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapError(err, "error starting transaction")
+	}
+	defer tx.Rollback()
+
+	if err := r.createItems(ctx, tx, items); err != nil {
+		return err
+	}
+	if err := r.queue.EnqueueReservationsTx(ctx, tx, newJobs(items)); err != nil {
+		return wrapError(err, "error enqueueing jobs")
+	}
+	if err := tx.Commit(); err != nil {
+		return wrapError(err, "error committing items")
+	}
+	return nil
+}
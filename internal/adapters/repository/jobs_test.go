@@ -0,0 +1,87 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeTxQueue records the jobs enqueued and the transaction they were enqueued in
+type fakeTxQueue struct {
+	tx   *sql.Tx
+	jobs []*domain.ReservationJob
+	err  error
+}
+
+func (q *fakeTxQueue) EnqueueReservationsTx(ctx context.Context, tx *sql.Tx, jobs []*domain.ReservationJob) error {
+	q.tx, q.jobs = tx, jobs
+	return q.err
+}
+
+func TestCreateItemsWithJobs(t *testing.T) {
+	now := time.Now()
+	columns := []string{"id", "name", "quantity", "reservation_id", "status", "created_at", "updated_at", "owner_id", "failure_reason"}
+	newJobs := func(items []*domain.Item) []*domain.ReservationJob {
+		jobs := make([]*domain.ReservationJob, len(items))
+		for i, item := range items {
+			jobs[i] = &domain.ReservationJob{ItemID: item.ID}
+		}
+		return jobs
+	}
+
+	tests := []struct {
+		name    string
+		queue   *fakeTxQueue
+		commit  bool
+		wantErr bool
+	}{
+		{
+			name:   "items and jobs are committed together",
+			queue:  &fakeTxQueue{},
+			commit: true,
+		},
+		{
+			name:    "failing enqueue rolls back the items",
+			queue:   &fakeTxQueue{err: errors.New("jobs table is missing")},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, mock := setupTestDB(t)
+			items := []*domain.Item{{Name: "Item 1", Quantity: 1, Status: domain.StatusReservationPending, OwnerID: "user-1"}}
+
+			mock.ExpectBegin()
+			mock.ExpectQuery(`INSERT INTO items (.+) RETURNING *`).
+				WithArgs("Item 1", int32(1), string(domain.StatusReservationPending), "user-1").
+				WillReturnRows(sqlmock.NewRows(columns).
+					AddRow(7, "Item 1", int32(1), sql.NullString{}, string(domain.StatusReservationPending), now, now, "user-1", sql.NullString{}))
+			if tt.commit {
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			err := NewJobRepository(repo, tt.queue).CreateItemsWithJobs(context.Background(), items, newJobs)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+
+			// the jobs were built for the created items and enqueued in their transaction
+			require.Len(t, tt.queue.jobs, 1)
+			assert.Equal(t, int64(7), tt.queue.jobs[0].ItemID)
+			assert.NotNil(t, tt.queue.tx)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
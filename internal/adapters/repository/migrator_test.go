@@ -0,0 +1,166 @@
+package repository
+
+import (
+	"context"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testMigrations() fstest.MapFS {
+	return fstest.MapFS{
+		"001_initial.up.sql":  {Data: []byte("CREATE TABLE a (id int)")},
+		"002_second.up.sql":   {Data: []byte("CREATE TABLE b (id int)")},
+		"002_second.down.sql": {Data: []byte("DROP TABLE b")},
+		"003_third.up.sql":    {Data: []byte("CREATE TABLE c (id int)")},
+		"003_third.down.sql":  {Data: []byte("DROP TABLE c")},
+		"migrations.go":       {Data: []byte("package migrations")},
+	}
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrations, err := LoadMigrations(testMigrations())
+	require.NoError(t, err)
+	require.Len(t, migrations, 3)
+
+	assert.Equal(t, uint64(1), migrations[0].Version)
+	assert.Equal(t, "initial", migrations[0].Name)
+	assert.Empty(t, migrations[0].Down)
+	assert.Equal(t, "DROP TABLE c", migrations[2].Down)
+
+	_, err = LoadMigrations(fstest.MapFS{"001_initial.down.sql": {Data: []byte("DROP TABLE a")}})
+	assert.Error(t, err)
+}
+
+func expectLocked(mock sqlmock.Sqlmock, version int64, dirty bool) {
+	mock.ExpectExec(`SELECT pg_advisory_lock`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	rows := sqlmock.NewRows([]string{"version", "dirty"})
+	if version > 0 {
+		rows.AddRow(version, dirty)
+	}
+	mock.ExpectQuery(`SELECT version, dirty FROM schema_migrations`).WillReturnRows(rows)
+}
+
+func expectApply(mock sqlmock.Sqlmock, query string, version int64) {
+	mock.ExpectBegin()
+	mock.ExpectExec(query).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`DELETE FROM schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 1))
+	if version > 0 {
+		mock.ExpectExec(`INSERT INTO schema_migrations`).WithArgs(version).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectCommit()
+}
+
+func TestMigrator(t *testing.T) {
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		run     func(m *Migrator) ([]Migration, error)
+		setup   func(mock sqlmock.Sqlmock)
+		want    []uint64
+		wantErr bool
+	}{
+		{
+			name: "up applies pending migrations",
+			run:  func(m *Migrator) ([]Migration, error) { return m.Up(ctx) },
+			setup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 1, false)
+				expectApply(mock, `CREATE TABLE b`, 2)
+				expectApply(mock, `CREATE TABLE c`, 3)
+			},
+			want: []uint64{2, 3},
+		},
+		{
+			name: "up on a dirty database",
+			run:  func(m *Migrator) ([]Migration, error) { return m.Up(ctx) },
+			setup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 2, true)
+			},
+			wantErr: true,
+		},
+		{
+			name: "failing migration stops up",
+			run:  func(m *Migrator) ([]Migration, error) { return m.Up(ctx) },
+			setup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 2, false)
+				mock.ExpectBegin()
+				mock.ExpectExec(`CREATE TABLE c`).WillReturnError(assert.AnError)
+				mock.ExpectRollback()
+			},
+			wantErr: true,
+		},
+		{
+			name: "down reverts the last migrations",
+			run:  func(m *Migrator) ([]Migration, error) { return m.Down(ctx, 2) },
+			setup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 3, false)
+				expectApply(mock, `DROP TABLE c`, 2)
+				expectApply(mock, `DROP TABLE b`, 1)
+			},
+			want: []uint64{3, 2},
+		},
+		{
+			name: "down past an irreversible migration",
+			run:  func(m *Migrator) ([]Migration, error) { return m.Down(ctx, 1) },
+			setup: func(mock sqlmock.Sqlmock) {
+				expectLocked(mock, 1, false)
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, mock, err := sqlmock.New()
+			require.NoError(t, err)
+			defer db.Close()
+
+			m, err := NewMigrator(db, testMigrations())
+			require.NoError(t, err)
+
+			tt.setup(mock)
+			mock.ExpectExec(`SELECT pg_advisory_unlock`).WillReturnResult(sqlmock.NewResult(0, 0))
+
+			migrations, err := tt.run(m)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			var versions []uint64
+			for _, migration := range migrations {
+				versions = append(versions, migration.Version)
+			}
+			assert.Equal(t, tt.want, versions)
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestMigratorStatus(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	defer db.Close()
+
+	m, err := NewMigrator(db, testMigrations())
+	require.NoError(t, err)
+
+	mock.ExpectExec(`CREATE TABLE IF NOT EXISTS schema_migrations`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(`SELECT version, dirty FROM schema_migrations`).
+		WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(2, false))
+
+	status, err := m.Status(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, uint64(2), status.Version)
+	assert.False(t, status.Dirty)
+	assert.Len(t, status.Applied, 2)
+	require.Len(t, status.Pending, 1)
+	assert.Equal(t, uint64(3), status.Pending[0].Version)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
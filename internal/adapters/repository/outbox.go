@@ -0,0 +1,146 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+// insertOutboxJobs adds unsent jobs given as arrays of their columns, ids follow the order of the arrays
+const insertOutboxJobs = `
+INSERT INTO outbox (job_id, payload)
+SELECT job_id, payload
+FROM unnest($1::text[], $2::jsonb[]) WITH ORDINALITY AS o(job_id, payload, n)
+ORDER BY n`
+
+// selectUnsentJobs locks the $1 oldest unsent jobs, jobs another relay locked are skipped instead of waited for
+const selectUnsentJobs = `
+SELECT id, payload FROM outbox
+WHERE sent_at IS NULL
+ORDER BY id
+LIMIT $1
+FOR UPDATE SKIP LOCKED`
+
+const markJobsSent = `UPDATE outbox SET sent_at = NOW() WHERE id = ANY($1)`
+
+// trimSentJobs drops the jobs sent more than $1 milliseconds ago
+const trimSentJobs = `DELETE FROM outbox WHERE sent_at < NOW() - $1::bigint * INTERVAL '1 millisecond'`
+
+// outboxStats counts the unsent jobs and returns how many milliseconds the oldest has waited
+const outboxStats = `
+SELECT COUNT(*), COALESCE(EXTRACT(EPOCH FROM NOW() - MIN(created_at)) * 1000, 0)::bigint
+FROM outbox
+WHERE sent_at IS NULL`
+
+// Outbox keeps jobs in the items database until the relay publishes them to a queue outside of it,
+// so the jobs of an item are written in its transaction
+type Outbox struct {
+	conn *sql.DB
+}
+
+// NewOutbox creates an outbox in the database conn
+func NewOutbox(conn *sql.DB) *Outbox {
+	return &Outbox{conn: conn}
+}
+
+// EnqueueReservationsTx writes the jobs to the outbox in the transaction tx
+func (o *Outbox) EnqueueReservationsTx(ctx context.Context, tx *sql.Tx, jobs []*domain.ReservationJob) error {
+	if len(jobs) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(jobs))
+	payloads := make([]string, len(jobs))
+	for i, job := range jobs {
+		// the job keeps its ID when it is published twice, the queues skip IDs they hold already
+		if job.ID == "" {
+			job.ID = uuid.New().String()
+		}
+		job.Status = domain.JobStatusPending
+
+		jobData, err := json.Marshal(job)
+		if err != nil {
+			return err
+		}
+		ids[i], payloads[i] = job.ID, string(jobData)
+	}
+
+	_, err := tx.ExecContext(ctx, insertOutboxJobs, pq.Array(ids), pq.Array(payloads))
+	return err
+}
+
+// RelayJobs publishes the oldest unsent jobs and marks them sent in one transaction,
+// the rows stay locked while publish runs so concurrent relays pick other jobs
+func (o *Outbox) RelayJobs(ctx context.Context, limit int, publish func(ctx context.Context, jobs []*domain.ReservationJob) error) (int, error) {
+	tx, err := o.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, wrapError(err, "error starting transaction")
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, selectUnsentJobs, limit)
+	if err != nil {
+		return 0, wrapError(err, "error selecting unsent jobs")
+	}
+	var ids []int64
+	var jobs []*domain.ReservationJob
+	for rows.Next() {
+		var id int64
+		var payload []byte
+		if err := rows.Scan(&id, &payload); err != nil {
+			rows.Close()
+			return 0, wrapError(err, "error selecting unsent jobs")
+		}
+		var job domain.ReservationJob
+		if err := json.Unmarshal(payload, &job); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+		jobs = append(jobs, &job)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, wrapError(err, "error selecting unsent jobs")
+	}
+	if len(jobs) == 0 {
+		return 0, nil
+	}
+
+	if err := publish(ctx, jobs); err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, markJobsSent, pq.Array(ids)); err != nil {
+		return 0, wrapError(err, "error marking jobs sent")
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, wrapError(err, "error committing sent jobs")
+	}
+	return len(jobs), nil
+}
+
+// TrimSentJobs drops the jobs sent more than maxAge ago, they are only kept to trace what was published
+func (o *Outbox) TrimSentJobs(ctx context.Context, maxAge time.Duration) (int, error) {
+	result, err := o.conn.ExecContext(ctx, trimSentJobs, maxAge.Milliseconds())
+	if err != nil {
+		return 0, wrapError(err, "error trimming sent jobs")
+	}
+	trimmed, err := result.RowsAffected()
+	return int(trimmed), err
+}
+
+// OutboxStats returns the number of unsent jobs and how long the oldest has waited
+func (o *Outbox) OutboxStats(ctx context.Context) (domain.OutboxStats, error) {
+	var stats domain.OutboxStats
+	var lagMS int64
+	if err := o.conn.QueryRowContext(ctx, outboxStats).Scan(&stats.Pending, &lagMS); err != nil {
+		return domain.OutboxStats{}, wrapError(err, "error getting outbox stats")
+	}
+	stats.Lag = time.Duration(lagMS) * time.Millisecond
+	return stats, nil
+}
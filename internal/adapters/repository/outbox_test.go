@@ -0,0 +1,144 @@
+package repository
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setupOutbox(t *testing.T) (*Outbox, sqlmock.Sqlmock) {
+	db, mock, err := sqlmock.New()
+	require.NoError(t, err)
+	return NewOutbox(db), mock
+}
+
+func outboxPayload(t *testing.T, job *domain.ReservationJob) []byte {
+	payload, err := json.Marshal(job)
+	require.NoError(t, err)
+	return payload
+}
+
+func TestOutboxEnqueueReservationsTx(t *testing.T) {
+	outbox, mock := setupOutbox(t)
+	ctx := context.Background()
+
+	mock.ExpectBegin()
+	mock.ExpectExec(`INSERT INTO outbox \(job_id, payload\)`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+
+	tx, err := outbox.conn.BeginTx(ctx, nil)
+	require.NoError(t, err)
+	jobs := []*domain.ReservationJob{{ID: "job-1", ItemID: 1}, {ItemID: 2}}
+	require.NoError(t, outbox.EnqueueReservationsTx(ctx, tx, jobs))
+	require.NoError(t, tx.Commit())
+
+	// jobs without an ID get one before they are written, a job published twice keeps it
+	assert.NotEmpty(t, jobs[1].ID)
+	assert.Equal(t, domain.JobStatusPending, jobs[0].Status)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOutboxRelayJobs(t *testing.T) {
+	jobs := []*domain.ReservationJob{
+		{ID: "job-1", ItemID: 1, JobType: domain.JobTypeAvailabilityCheck, Status: domain.JobStatusPending},
+		{ID: "job-2", ItemID: 2, JobType: domain.JobTypeAvailabilityCheck, Status: domain.JobStatusPending},
+	}
+
+	tests := []struct {
+		name       string
+		rows       [][]driver.Value
+		publishErr error
+		wantSent   int
+		wantErr    bool
+		wantCommit bool
+	}{
+		{
+			name:       "published jobs are marked sent",
+			rows:       [][]driver.Value{{int64(1), outboxPayload(t, jobs[0])}, {int64(2), outboxPayload(t, jobs[1])}},
+			wantSent:   2,
+			wantCommit: true,
+		},
+		{
+			name: "nothing to relay",
+		},
+		{
+			name:       "failed publish leaves the jobs unsent",
+			rows:       [][]driver.Value{{int64(1), outboxPayload(t, jobs[0])}},
+			publishErr: errors.New("redis is down"),
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			outbox, mock := setupOutbox(t)
+
+			rows := sqlmock.NewRows([]string{"id", "payload"})
+			for _, row := range tt.rows {
+				rows.AddRow(row...)
+			}
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT id, payload FROM outbox WHERE sent_at IS NULL ORDER BY id LIMIT \$1 FOR UPDATE SKIP LOCKED`).
+				WithArgs(10).
+				WillReturnRows(rows)
+			if tt.wantCommit {
+				mock.ExpectExec(`UPDATE outbox SET sent_at = NOW\(\) WHERE id = ANY\(\$1\)`).
+					WithArgs("{1,2}").
+					WillReturnResult(sqlmock.NewResult(0, 2))
+				mock.ExpectCommit()
+			} else {
+				mock.ExpectRollback()
+			}
+
+			var published []*domain.ReservationJob
+			sent, err := outbox.RelayJobs(context.Background(), 10, func(ctx context.Context, jobs []*domain.ReservationJob) error {
+				published = jobs
+				return tt.publishErr
+			})
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantSent, sent)
+			assert.Len(t, published, len(tt.rows))
+			for i, job := range published {
+				assert.Equal(t, jobs[i].ID, job.ID)
+			}
+			assert.NoError(t, mock.ExpectationsWereMet())
+		})
+	}
+}
+
+func TestOutboxStats(t *testing.T) {
+	outbox, mock := setupOutbox(t)
+	mock.ExpectQuery(`SELECT COUNT\(\*\), (.+) FROM outbox WHERE sent_at IS NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"count", "lag"}).AddRow(int64(3), int64(2500)))
+
+	stats, err := outbox.OutboxStats(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, domain.OutboxStats{Pending: 3, Lag: 2500 * time.Millisecond}, stats)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestOutboxTrimSentJobs(t *testing.T) {
+	outbox, mock := setupOutbox(t)
+	mock.ExpectExec(`DELETE FROM outbox WHERE sent_at <`).
+		WithArgs(int64(3600000)).
+		WillReturnResult(sqlmock.NewResult(0, 4))
+
+	trimmed, err := outbox.TrimSentJobs(context.Background(), time.Hour)
+	require.NoError(t, err)
+	assert.Equal(t, 4, trimmed)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -62,4 +62,6 @@ type Item struct {
 	Status        ItemStatus     `json:"status"`
 	CreatedAt     time.Time      `json:"created_at"`
 	UpdatedAt     time.Time      `json:"updated_at"`
+	OwnerID       string         `json:"owner_id"`
+	FailureReason sql.NullString `json:"failure_reason"`
 }
@@ -10,8 +10,9 @@ import (
 
 type Querier interface {
 	CreateItem(ctx context.Context, arg CreateItemParams) (Item, error)
+	FailItem(ctx context.Context, arg FailItemParams) (Item, error)
 	GetItem(ctx context.Context, id int32) (Item, error)
-	ListItems(ctx context.Context) ([]Item, error)
+	ListItems(ctx context.Context, ownerID string) ([]Item, error)
 	UpdateItemReservation(ctx context.Context, arg UpdateItemReservationParams) (Item, error)
 	UpdateItemStatus(ctx context.Context, arg UpdateItemStatusParams) (Item, error)
 }
@@ -2,19 +2,22 @@
 INSERT INTO items (
     name, 
     quantity, 
-    status
+    status,
+    owner_id
 ) VALUES (
-    $1, $2, $3
+    $1, $2, $3, $4
 ) RETURNING *;
 
 -- name: ListItems :many
 SELECT * FROM items 
+WHERE owner_id = $1
 ORDER BY created_at DESC;
 
 -- name: UpdateItemReservation :one
 UPDATE items 
 SET reservation_id = $2,
     status = $3,
+    failure_reason = NULL,
     updated_at = NOW()
 WHERE id = $1
 RETURNING *;
@@ -26,6 +29,15 @@ WHERE id = $1;
 -- name: UpdateItemStatus :one
 UPDATE items 
 SET status = $2,
+    failure_reason = NULL,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING *;
+
+-- name: FailItem :one
+UPDATE items 
+SET status = $2,
+    failure_reason = $3,
     updated_at = NOW()
 WHERE id = $1
 RETURNING *;
\ No newline at end of file
@@ -14,20 +14,59 @@ const createItem = `-- name: CreateItem :one
 INSERT INTO items (
     name, 
     quantity, 
-    status
+    status,
+    owner_id
 ) VALUES (
-    $1, $2, $3
-) RETURNING id, name, quantity, reservation_id, status, created_at, updated_at
+    $1, $2, $3, $4
+) RETURNING id, name, quantity, reservation_id, status, created_at, updated_at, owner_id, failure_reason
 `
 
 type CreateItemParams struct {
 	Name     string     `json:"name"`
 	Quantity int32      `json:"quantity"`
 	Status   ItemStatus `json:"status"`
+	OwnerID  string     `json:"owner_id"`
 }
 
 func (q *Queries) CreateItem(ctx context.Context, arg CreateItemParams) (Item, error) {
-	row := q.db.QueryRowContext(ctx, createItem, arg.Name, arg.Quantity, arg.Status)
+	row := q.db.QueryRowContext(ctx, createItem,
+		arg.Name,
+		arg.Quantity,
+		arg.Status,
+		arg.OwnerID,
+	)
+	var i Item
+	err := row.Scan(
+		&i.ID,
+		&i.Name,
+		&i.Quantity,
+		&i.ReservationID,
+		&i.Status,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.FailureReason,
+	)
+	return i, err
+}
+
+const failItem = `-- name: FailItem :one
+UPDATE items 
+SET status = $2,
+    failure_reason = $3,
+    updated_at = NOW()
+WHERE id = $1
+RETURNING id, name, quantity, reservation_id, status, created_at, updated_at, owner_id, failure_reason
+`
+
+type FailItemParams struct {
+	ID            int32          `json:"id"`
+	Status        ItemStatus     `json:"status"`
+	FailureReason sql.NullString `json:"failure_reason"`
+}
+
+func (q *Queries) FailItem(ctx context.Context, arg FailItemParams) (Item, error) {
+	row := q.db.QueryRowContext(ctx, failItem, arg.ID, arg.Status, arg.FailureReason)
 	var i Item
 	err := row.Scan(
 		&i.ID,
@@ -37,12 +76,14 @@ func (q *Queries) CreateItem(ctx context.Context, arg CreateItemParams) (Item, e
 		&i.Status,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.FailureReason,
 	)
 	return i, err
 }
 
 const getItem = `-- name: GetItem :one
-SELECT id, name, quantity, reservation_id, status, created_at, updated_at FROM items 
+SELECT id, name, quantity, reservation_id, status, created_at, updated_at, owner_id, failure_reason FROM items 
 WHERE id = $1
 `
 
@@ -57,17 +98,20 @@ func (q *Queries) GetItem(ctx context.Context, id int32) (Item, error) {
 		&i.Status,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.FailureReason,
 	)
 	return i, err
 }
 
 const listItems = `-- name: ListItems :many
-SELECT id, name, quantity, reservation_id, status, created_at, updated_at FROM items 
+SELECT id, name, quantity, reservation_id, status, created_at, updated_at, owner_id, failure_reason FROM items 
+WHERE owner_id = $1
 ORDER BY created_at DESC
 `
 
-func (q *Queries) ListItems(ctx context.Context) ([]Item, error) {
-	rows, err := q.db.QueryContext(ctx, listItems)
+func (q *Queries) ListItems(ctx context.Context, ownerID string) ([]Item, error) {
+	rows, err := q.db.QueryContext(ctx, listItems, ownerID)
 	if err != nil {
 		return nil, err
 	}
@@ -83,6 +127,8 @@ func (q *Queries) ListItems(ctx context.Context) ([]Item, error) {
 			&i.Status,
 			&i.CreatedAt,
 			&i.UpdatedAt,
+			&i.OwnerID,
+			&i.FailureReason,
 		); err != nil {
 			return nil, err
 		}
@@ -101,9 +147,10 @@ const updateItemReservation = `-- name: UpdateItemReservation :one
 UPDATE items 
 SET reservation_id = $2,
     status = $3,
+    failure_reason = NULL,
     updated_at = NOW()
 WHERE id = $1
-RETURNING id, name, quantity, reservation_id, status, created_at, updated_at
+RETURNING id, name, quantity, reservation_id, status, created_at, updated_at, owner_id, failure_reason
 `
 
 type UpdateItemReservationParams struct {
@@ -123,6 +170,8 @@ func (q *Queries) UpdateItemReservation(ctx context.Context, arg UpdateItemReser
 		&i.Status,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.FailureReason,
 	)
 	return i, err
 }
@@ -130,9 +179,10 @@ func (q *Queries) UpdateItemReservation(ctx context.Context, arg UpdateItemReser
 const updateItemStatus = `-- name: UpdateItemStatus :one
 UPDATE items 
 SET status = $2,
+    failure_reason = NULL,
     updated_at = NOW()
 WHERE id = $1
-RETURNING id, name, quantity, reservation_id, status, created_at, updated_at
+RETURNING id, name, quantity, reservation_id, status, created_at, updated_at, owner_id, failure_reason
 `
 
 type UpdateItemStatusParams struct {
@@ -151,6 +201,8 @@ func (q *Queries) UpdateItemStatus(ctx context.Context, arg UpdateItemStatusPara
 		&i.Status,
 		&i.CreatedAt,
 		&i.UpdatedAt,
+		&i.OwnerID,
+		&i.FailureReason,
 	)
 	return i, err
 }
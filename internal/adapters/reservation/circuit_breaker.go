@@ -0,0 +1,231 @@
+package reservation
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/a-berahman/shopping-cart/internal/core/ports"
+)
+
+// CircuitState is the state of a circuit breaker
+type CircuitState string
+
+const (
+	// CircuitClosed lets every call through
+	CircuitClosed CircuitState = "closed"
+	// CircuitOpen turns every call down until the cool down passed
+	CircuitOpen CircuitState = "open"
+	// CircuitHalfOpen lets one trial call through, its outcome closes or opens the circuit
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// CircuitBreaker stops calling the reservation service while it keeps failing, so the jobs don't
+// burn their attempts on a service that is down. calls it turns down fail with domain.ErrCircuitOpen.
+// permanent errors are answers of a working service, they don't count as failures
+type CircuitBreaker struct {
+	svc         ports.ReservationService
+	failureRate float64
+	coolDown    time.Duration
+	logger      *slog.Logger
+
+	mu    sync.Mutex
+	state CircuitState
+	// generation changes with the state, calls count only in the state they started in
+	generation int
+	// outcomes is a ring of the last calls, true for failed ones
+	outcomes  []bool
+	next      int
+	calls     int
+	failures  int
+	openUntil time.Time
+	// trial is set while the trial call of the half open circuit is in flight
+	trial bool
+	// changed is closed and replaced whenever calls may be let through again
+	changed chan struct{}
+}
+
+var _ ports.ReservationService = (*CircuitBreaker)(nil)
+var _ ports.ReservationCircuit = (*CircuitBreaker)(nil)
+
+// NewCircuitBreaker wraps svc in a closed circuit breaker
+func NewCircuitBreaker(svc ports.ReservationService, cfg config.CircuitBreakerConfig, logger *slog.Logger) *CircuitBreaker {
+	return &CircuitBreaker{
+		svc:         svc,
+		failureRate: cfg.FailureRate,
+		coolDown:    cfg.CoolDown,
+		logger:      logger,
+		state:       CircuitClosed,
+		outcomes:    make([]bool, max(cfg.WindowSize, 1)),
+		changed:     make(chan struct{}),
+	}
+}
+
+func (b *CircuitBreaker) CheckAvailability(ctx context.Context, itemName string, quantity int) (bool, error) {
+	generation, err := b.acquire()
+	if err != nil {
+		return false, err
+	}
+	available, err := b.svc.CheckAvailability(ctx, itemName, quantity)
+	b.record(ctx, generation, err)
+	return available, err
+}
+
+func (b *CircuitBreaker) ReserveItem(ctx context.Context, itemName string, quantity int) (string, error) {
+	generation, err := b.acquire()
+	if err != nil {
+		return "", err
+	}
+	reservationID, err := b.svc.ReserveItem(ctx, itemName, quantity)
+	b.record(ctx, generation, err)
+	return reservationID, err
+}
+
+// ReserveItems counts a batch as one call, the errors of its reservations are answers of the service
+func (b *CircuitBreaker) ReserveItems(ctx context.Context, requests []domain.ReservationRequest) ([]domain.ReservationResult, error) {
+	generation, err := b.acquire()
+	if err != nil {
+		return nil, err
+	}
+	results, err := b.svc.ReserveItems(ctx, requests)
+	b.record(ctx, generation, err)
+	return results, err
+}
+
+// State returns the state of the circuit, an open circuit whose cool down passed is half open
+func (b *CircuitBreaker) State() CircuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	return b.state
+}
+
+// Wait blocks until the circuit lets calls through or ctx is done. a half open circuit lets
+// the next call through once its trial call is over
+func (b *CircuitBreaker) Wait(ctx context.Context) error {
+	for {
+		b.mu.Lock()
+		b.advance()
+		if b.admits() {
+			b.mu.Unlock()
+			return nil
+		}
+		changed, open, openUntil := b.changed, b.state == CircuitOpen, b.openUntil
+		b.mu.Unlock()
+
+		// an open circuit turns half open once the cool down passed,
+		// a trial call in flight signals changed when it's over
+		coolDown := time.NewTimer(time.Until(openUntil))
+		if !open {
+			coolDown.Stop()
+		}
+		select {
+		case <-ctx.Done():
+			coolDown.Stop()
+			return ctx.Err()
+		case <-changed:
+		case <-coolDown.C:
+		}
+		coolDown.Stop()
+	}
+}
+
+// acquire lets a call through or turns it down, a half open circuit lets its trial call through.
+// it returns the generation the call started in
+func (b *CircuitBreaker) acquire() (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.advance()
+	if !b.admits() {
+		return 0, domain.ErrCircuitOpen
+	}
+	if b.state == CircuitHalfOpen {
+		b.trial = true
+	}
+	return b.generation, nil
+}
+
+// record counts the outcome of a call that started in generation. a call cut off by its caller says nothing
+// about the service, it only ends the trial so the next call can be the trial
+func (b *CircuitBreaker) record(ctx context.Context, generation int, err error) {
+	failed := err != nil && domain.ClassifyReservationError(err) != domain.ReservationErrorPermanent
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// calls that started before the state changed don't count
+	if generation != b.generation {
+		return
+	}
+	if err != nil && ctx.Err() != nil {
+		if b.state == CircuitHalfOpen {
+			b.trial = false
+			b.signal()
+		}
+		return
+	}
+
+	switch b.state {
+	case CircuitHalfOpen:
+		b.trial = false
+		if failed {
+			b.logger.Warn("reservation circuit opened again, the trial call failed", "cool_down", b.coolDown)
+			b.open()
+			return
+		}
+		b.close()
+	case CircuitClosed:
+		if b.calls == len(b.outcomes) && b.outcomes[b.next] {
+			b.failures--
+		}
+		b.outcomes[b.next] = failed
+		b.next = (b.next + 1) % len(b.outcomes)
+		b.calls = min(b.calls+1, len(b.outcomes))
+		if failed {
+			b.failures++
+		}
+		// the circuit opens once the window is full, a few failures of the first calls don't open it
+		if b.calls == len(b.outcomes) && float64(b.failures)/float64(b.calls) >= b.failureRate {
+			b.logger.Warn("reservation circuit opened", "failed_calls", b.failures, "calls", b.calls, "cool_down", b.coolDown)
+			b.open()
+		}
+	}
+}
+
+// admits reports whether the circuit lets the next call through
+func (b *CircuitBreaker) admits() bool {
+	return b.state == CircuitClosed || (b.state == CircuitHalfOpen && !b.trial)
+}
+
+// advance moves an open circuit whose cool down passed to half open
+func (b *CircuitBreaker) advance() {
+	if b.state == CircuitOpen && !time.Now().Before(b.openUntil) {
+		b.state = CircuitHalfOpen
+		b.generation++
+		b.logger.Info("reservation circuit half open, letting a trial call through")
+		b.signal()
+	}
+}
+
+func (b *CircuitBreaker) open() {
+	b.state = CircuitOpen
+	b.generation++
+	b.openUntil = time.Now().Add(b.coolDown)
+}
+
+func (b *CircuitBreaker) close() {
+	b.state = CircuitClosed
+	b.generation++
+	b.next, b.calls, b.failures = 0, 0, 0
+	clear(b.outcomes)
+	b.logger.Info("reservation circuit closed")
+	b.signal()
+}
+
+// signal wakes the callers of Wait
+func (b *CircuitBreaker) signal() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
@@ -0,0 +1,173 @@
+package reservation
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubService answers every call with err, it counts the calls that reached it
+type stubService struct {
+	mu    sync.Mutex
+	err   error
+	calls int
+}
+
+func (s *stubService) fail(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+func (s *stubService) called() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+func (s *stubService) CheckAvailability(ctx context.Context, itemName string, quantity int) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	return s.err == nil, s.err
+}
+
+func (s *stubService) ReserveItem(ctx context.Context, itemName string, quantity int) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return "", s.err
+	}
+	return "res123", nil
+}
+
+func (s *stubService) ReserveItems(ctx context.Context, requests []domain.ReservationRequest) ([]domain.ReservationResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return nil, s.err
+	}
+	return make([]domain.ReservationResult, len(requests)), nil
+}
+
+func newTestBreaker(svc *stubService, coolDown time.Duration) *CircuitBreaker {
+	return NewCircuitBreaker(svc, config.CircuitBreakerConfig{
+		Enabled:     true,
+		FailureRate: 0.5,
+		WindowSize:  4,
+		CoolDown:    coolDown,
+	}, slog.Default())
+}
+
+func TestCircuitBreakerOpens(t *testing.T) {
+	unavailable := domain.NewTransientReservationError("the reservation service is unavailable", nil)
+	notFound := domain.NewPermanentReservationError("the item is unknown to the reservation service", nil)
+
+	tests := []struct {
+		name      string
+		errs      []error
+		wantState CircuitState
+	}{
+		{name: "failure rate reached", errs: []error{nil, unavailable, nil, unavailable}, wantState: CircuitOpen},
+		{name: "failure rate not reached", errs: []error{nil, unavailable, nil, nil}, wantState: CircuitClosed},
+		{name: "window not full yet", errs: []error{unavailable, unavailable, unavailable}, wantState: CircuitClosed},
+		{name: "old failures leave the window", errs: []error{unavailable, nil, nil, nil, unavailable, nil, nil, nil, unavailable}, wantState: CircuitClosed},
+		{name: "permanent errors aren't failures", errs: []error{notFound, notFound, notFound, notFound}, wantState: CircuitClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{}
+			breaker := newTestBreaker(svc, time.Minute)
+
+			for _, err := range tt.errs {
+				svc.fail(err)
+				breaker.ReserveItem(context.Background(), "laptop", 1)
+			}
+			assert.Equal(t, tt.wantState, breaker.State())
+		})
+	}
+}
+
+func TestCircuitBreakerRejectsCallsWhileOpen(t *testing.T) {
+	svc := &stubService{}
+	breaker := newTestBreaker(svc, time.Minute)
+
+	svc.fail(domain.NewTransientReservationError("the reservation service is unavailable", nil))
+	for i := 0; i < 4; i++ {
+		breaker.CheckAvailability(context.Background(), "laptop", 1)
+	}
+	require.Equal(t, CircuitOpen, breaker.State())
+
+	// the open circuit doesn't call the service
+	_, err := breaker.ReserveItem(context.Background(), "laptop", 1)
+	assert.ErrorIs(t, err, domain.ErrCircuitOpen)
+	assert.Equal(t, domain.ReservationErrorTransient, domain.ClassifyReservationError(err))
+	assert.Equal(t, 4, svc.called())
+}
+
+func TestCircuitBreakerTrialCall(t *testing.T) {
+	tests := []struct {
+		name      string
+		trialErr  error
+		wantState CircuitState
+	}{
+		{name: "successful trial closes the circuit", trialErr: nil, wantState: CircuitClosed},
+		{name: "failed trial opens the circuit again", trialErr: domain.NewTransientReservationError("the reservation service is unavailable", nil), wantState: CircuitOpen},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &stubService{}
+			breaker := newTestBreaker(svc, 20*time.Millisecond)
+
+			svc.fail(domain.NewTransientReservationError("the reservation service is unavailable", nil))
+			for i := 0; i < 4; i++ {
+				breaker.ReserveItem(context.Background(), "laptop", 1)
+			}
+			require.Equal(t, CircuitOpen, breaker.State())
+
+			// after the cool down one trial call goes through
+			time.Sleep(30 * time.Millisecond)
+			assert.Equal(t, CircuitHalfOpen, breaker.State())
+
+			svc.fail(tt.trialErr)
+			breaker.ReserveItem(context.Background(), "laptop", 1)
+			assert.Equal(t, 5, svc.called())
+			assert.Equal(t, tt.wantState, breaker.State())
+		})
+	}
+}
+
+func TestCircuitBreakerWait(t *testing.T) {
+	svc := &stubService{}
+	breaker := newTestBreaker(svc, 50*time.Millisecond)
+
+	// a closed circuit doesn't wait
+	require.NoError(t, breaker.Wait(context.Background()))
+
+	svc.fail(domain.NewTransientReservationError("the reservation service is unavailable", nil))
+	for i := 0; i < 4; i++ {
+		breaker.ReserveItem(context.Background(), "laptop", 1)
+	}
+
+	// waiting on an open circuit is cut off by the context
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, breaker.Wait(ctx), context.DeadlineExceeded)
+
+	// the circuit lets a call through once the cool down passed
+	start := time.Now()
+	require.NoError(t, breaker.Wait(context.Background()))
+	assert.GreaterOrEqual(t, time.Since(start), 30*time.Millisecond)
+	assert.Equal(t, CircuitHalfOpen, breaker.State())
+}
@@ -4,11 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
 )
 
+// errInvalidRequest is the reason of requests that can't be built, sending them again won't help
+const errInvalidRequest = "the reservation request is invalid"
+
 type Service struct {
 	baseURL    string
 	httpClient *http.Client
@@ -24,6 +31,21 @@ type reservationResponse struct {
 	Available     bool   `json:"available"`
 }
 
+type batchReservationRequest struct {
+	Items []reservationRequest `json:"items"`
+}
+
+// batchReservationResponse has a result for every item of the request, in its order
+type batchReservationResponse struct {
+	Results []batchReservationResult `json:"results"`
+}
+
+// batchReservationResult is a reservation ID, or the error the reservation of the item failed with
+type batchReservationResult struct {
+	ReservationID string `json:"reservation_id"`
+	Error         string `json:"error,omitempty"`
+}
+
 func NewService(baseURL string) *Service {
 	return &Service{
 		baseURL: baseURL,
@@ -39,29 +61,29 @@ func (s *Service) CheckAvailability(ctx context.Context, itemName string, quanti
 		Quantity: quantity,
 	})
 	if err != nil {
-		return false, fmt.Errorf("error marshaling request: %w", err)
+		return false, domain.NewPermanentReservationError(errInvalidRequest, fmt.Errorf("error marshaling request: %w", err))
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET",
 		fmt.Sprintf("%s/availability", s.baseURL), bytes.NewBuffer(reqBody))
 	if err != nil {
-		return false, fmt.Errorf("error creating request: %w", err)
+		return false, domain.NewPermanentReservationError(errInvalidRequest, fmt.Errorf("error creating request: %w", err))
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return false, fmt.Errorf("error checking availability: %w", err)
+		return false, domain.NewTransientReservationError("the reservation service is unreachable", fmt.Errorf("error checking availability: %w", err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return false, statusError(resp, time.Now())
 	}
 
 	var response reservationResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return false, fmt.Errorf("error decoding response: %w", err)
+		return false, domain.NewTransientReservationError("the reservation service sent an invalid response", fmt.Errorf("error decoding response: %w", err))
 	}
 
 	return response.Available, nil
@@ -73,30 +95,126 @@ func (s *Service) ReserveItem(ctx context.Context, itemName string, quantity int
 		Quantity: quantity,
 	})
 	if err != nil {
-		return "", fmt.Errorf("error marshaling request: %w", err)
+		return "", domain.NewPermanentReservationError(errInvalidRequest, fmt.Errorf("error marshaling request: %w", err))
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST",
 		fmt.Sprintf("%s/reserve", s.baseURL), bytes.NewBuffer(reqBody))
 	if err != nil {
-		return "", fmt.Errorf("error creating request: %w", err)
+		return "", domain.NewPermanentReservationError(errInvalidRequest, fmt.Errorf("error creating request: %w", err))
 	}
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := s.httpClient.Do(req)
 	if err != nil {
-		return "", fmt.Errorf("error reserving item: %w", err)
+		return "", domain.NewTransientReservationError("the reservation service is unreachable", fmt.Errorf("error reserving item: %w", err))
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return "", statusError(resp, time.Now())
 	}
 
 	var response reservationResponse
 	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		return "", fmt.Errorf("error decoding response: %w", err)
+		return "", domain.NewTransientReservationError("the reservation service sent an invalid response", fmt.Errorf("error decoding response: %w", err))
 	}
 
 	return response.ReservationID, nil
 }
+
+// ReserveItems reserves the items in one call. the service answers every item on its own,
+// an item it couldn't reserve is a permanent error of that item
+func (s *Service) ReserveItems(ctx context.Context, requests []domain.ReservationRequest) ([]domain.ReservationResult, error) {
+	batch := batchReservationRequest{Items: make([]reservationRequest, len(requests))}
+	for i, request := range requests {
+		batch.Items[i] = reservationRequest{Item: request.ItemName, Quantity: request.Quantity}
+	}
+	reqBody, err := json.Marshal(batch)
+	if err != nil {
+		return nil, domain.NewPermanentReservationError(errInvalidRequest, fmt.Errorf("error marshaling request: %w", err))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		fmt.Sprintf("%s/reserve/batch", s.baseURL), bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, domain.NewPermanentReservationError(errInvalidRequest, fmt.Errorf("error creating request: %w", err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return nil, domain.NewTransientReservationError("the reservation service is unreachable", fmt.Errorf("error reserving items: %w", err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, statusError(resp, time.Now())
+	}
+
+	var response batchReservationResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, domain.NewTransientReservationError("the reservation service sent an invalid response", fmt.Errorf("error decoding response: %w", err))
+	}
+	if len(response.Results) != len(requests) {
+		return nil, domain.NewTransientReservationError("the reservation service sent an invalid response",
+			fmt.Errorf("got %d results for %d items", len(response.Results), len(requests)))
+	}
+
+	results := make([]domain.ReservationResult, len(requests))
+	for i, result := range response.Results {
+		if result.Error != "" {
+			results[i].Err = domain.NewPermanentReservationError("the reservation service rejected the reservation", errors.New(result.Error))
+			continue
+		}
+		results[i].ReservationID = result.ReservationID
+	}
+	return results, nil
+}
+
+// statusError classifies a response that isn't OK. rate limited calls are retried once the Retry-After
+// of the service passed, timeouts and server errors may pass and other client errors won't
+func statusError(resp *http.Response, now time.Time) error {
+	err := fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return domain.NewRateLimitedReservationError("the reservation service is busy",
+			retryAfter(resp.Header.Get("Retry-After"), now), err)
+	case resp.StatusCode == http.StatusRequestTimeout || resp.StatusCode >= http.StatusInternalServerError:
+		return domain.NewTransientReservationError("the reservation service is unavailable", err)
+	case resp.StatusCode == http.StatusNotFound:
+		return domain.NewPermanentReservationError("the item is unknown to the reservation service", err)
+	default:
+		return domain.NewPermanentReservationError("the reservation service rejected the reservation", err)
+	}
+}
+
+// retryAfter parses a Retry-After header, it's either seconds or a date. a missing or past one is zero
+func retryAfter(header string, now time.Time) time.Duration {
+	if seconds, err := strconv.Atoi(header); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+// Ping checks the service is reachable, any HTTP response counts because the service has no health route
+func (s *Service) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, s.baseURL, nil)
+	if err != nil {
+		return fmt.Errorf("error creating request: %w", err)
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("error reaching reservation service: %w", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -8,6 +8,7 @@ import (
 	"testing"
 	"time"
 
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -118,6 +119,7 @@ func TestReserveItem(t *testing.T) {
 		mockServer func() (http.HandlerFunc, *reservationResponse)
 		want       string
 		wantErr    bool
+		wantClass  domain.ReservationErrorClass
 	}{
 		{
 			name:     "successful reservation",
@@ -151,8 +153,36 @@ func TestReserveItem(t *testing.T) {
 					w.WriteHeader(http.StatusBadRequest)
 				}, nil
 			},
-			want:    "",
-			wantErr: true,
+			want:      "",
+			wantErr:   true,
+			wantClass: domain.ReservationErrorPermanent,
+		},
+		{
+			name:     "item not found",
+			itemName: "Unknown Item",
+			quantity: 1,
+			mockServer: func() (http.HandlerFunc, *reservationResponse) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNotFound)
+				}, nil
+			},
+			want:      "",
+			wantErr:   true,
+			wantClass: domain.ReservationErrorPermanent,
+		},
+		{
+			name:     "rate limited",
+			itemName: "Test Item",
+			quantity: 1,
+			mockServer: func() (http.HandlerFunc, *reservationResponse) {
+				return func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Retry-After", "5")
+					w.WriteHeader(http.StatusTooManyRequests)
+				}, nil
+			},
+			want:      "",
+			wantErr:   true,
+			wantClass: domain.ReservationErrorRateLimited,
 		},
 		{
 			name:     "server error",
@@ -163,8 +193,9 @@ func TestReserveItem(t *testing.T) {
 					w.WriteHeader(http.StatusInternalServerError)
 				}, nil
 			},
-			want:    "",
-			wantErr: true,
+			want:      "",
+			wantErr:   true,
+			wantClass: domain.ReservationErrorTransient,
 		},
 		{
 			name:     "timeout error",
@@ -175,8 +206,9 @@ func TestReserveItem(t *testing.T) {
 					time.Sleep(2 * time.Second) // Will trigger timeout
 				}, nil
 			},
-			want:    "",
-			wantErr: true,
+			want:      "",
+			wantErr:   true,
+			wantClass: domain.ReservationErrorTransient,
 		},
 	}
 
@@ -189,6 +221,7 @@ func TestReserveItem(t *testing.T) {
 			got, err := service.ReserveItem(context.Background(), tt.itemName, tt.quantity)
 			if tt.wantErr {
 				assert.Error(t, err)
+				assert.Equal(t, tt.wantClass, domain.ClassifyReservationError(err))
 				return
 			}
 
@@ -197,3 +230,132 @@ func TestReserveItem(t *testing.T) {
 		})
 	}
 }
+
+func TestReserveItems(t *testing.T) {
+	requests := []domain.ReservationRequest{
+		{ItemName: "laptop", Quantity: 1},
+		{ItemName: "unicorn", Quantity: 2},
+	}
+
+	tests := []struct {
+		name      string
+		handler   http.HandlerFunc
+		want      []string
+		wantErrs  []bool
+		wantClass domain.ReservationErrorClass
+	}{
+		{
+			name: "every item is answered on its own",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodPost, r.Method)
+				assert.Equal(t, "/reserve/batch", r.URL.Path)
+
+				var req batchReservationRequest
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+				assert.Equal(t, []reservationRequest{{Item: "laptop", Quantity: 1}, {Item: "unicorn", Quantity: 2}}, req.Items)
+
+				json.NewEncoder(w).Encode(batchReservationResponse{Results: []batchReservationResult{
+					{ReservationID: "res1"},
+					{Error: "item not found"},
+				}})
+			},
+			want:     []string{"res1", ""},
+			wantErrs: []bool{false, true},
+		},
+		{
+			name: "server error fails the batch",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			wantClass: domain.ReservationErrorTransient,
+		},
+		{
+			name: "results missing",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(batchReservationResponse{Results: []batchReservationResult{{ReservationID: "res1"}}})
+			},
+			wantClass: domain.ReservationErrorTransient,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := setupTestServer(tt.handler)
+			defer server.Close()
+
+			results, err := service.ReserveItems(context.Background(), requests)
+			if tt.wantClass != "" {
+				assert.Error(t, err)
+				assert.Equal(t, tt.wantClass, domain.ClassifyReservationError(err))
+				return
+			}
+
+			require.NoError(t, err)
+			require.Len(t, results, len(requests))
+			for i, result := range results {
+				assert.Equal(t, tt.want[i], result.ReservationID)
+				if tt.wantErrs[i] {
+					// the service answered for the item, asking again won't change its answer
+					assert.Equal(t, domain.ReservationErrorPermanent, domain.ClassifyReservationError(result.Err))
+				} else {
+					assert.NoError(t, result.Err)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header string
+		want   time.Duration
+	}{
+		{name: "seconds", header: "30", want: 30 * time.Second},
+		{name: "date", header: now.Add(time.Minute).Format(http.TimeFormat), want: time.Minute},
+		{name: "date in the past", header: now.Add(-time.Minute).Format(http.TimeFormat), want: 0},
+		{name: "missing", header: "", want: 0},
+		{name: "invalid", header: "soon", want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, retryAfter(tt.header, now))
+		})
+	}
+}
+
+func TestPing(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "reachable", status: http.StatusOK},
+		{name: "reachable without a root route", status: http.StatusNotFound},
+		{name: "broken", status: http.StatusBadGateway, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service, server := setupTestServer(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, http.MethodHead, r.Method)
+				w.WriteHeader(tt.status)
+			})
+			defer server.Close()
+
+			err := service.Ping(context.Background())
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	service, server := setupTestServer(func(w http.ResponseWriter, r *http.Request) {})
+	server.Close()
+	assert.Error(t, service.Ping(context.Background()))
+}
@@ -6,6 +6,8 @@ import (
 	"math/rand"
 	"sync"
 	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
 )
 
 // MockReservationService implements the ReservationService interface for demonstration
@@ -41,7 +43,7 @@ func (m *MockReservationService) CheckAvailability(ctx context.Context, itemName
 	m.simulateLatency()
 
 	if m.shouldFail() {
-		return false, fmt.Errorf("service temporarily unavailable")
+		return false, domain.NewTransientReservationError("service temporarily unavailable", nil)
 	}
 
 	m.mu.RLock()
@@ -60,19 +62,24 @@ func (m *MockReservationService) ReserveItem(ctx context.Context, itemName strin
 	m.simulateLatency()
 
 	if m.shouldFail() {
-		return "", fmt.Errorf("reservation failed: service temporarily unavailable")
+		return "", domain.NewTransientReservationError("reservation failed: service temporarily unavailable", nil)
 	}
 
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	return m.reserve(itemName, quantity)
+}
+
+// reserve takes quantity of the item from the inventory, the caller holds mu
+func (m *MockReservationService) reserve(itemName string, quantity int) (string, error) {
 	available, exists := m.inventory[itemName]
 	if !exists {
-		return "", fmt.Errorf("item not found")
+		return "", domain.NewPermanentReservationError("item not found", nil)
 	}
 
 	if available < quantity {
-		return "", fmt.Errorf("insufficient inventory")
+		return "", domain.NewPermanentReservationError("insufficient inventory", nil)
 	}
 
 	reservationID := fmt.Sprintf("RSV-%s-%d", itemName, time.Now().Unix()) // generate a reservation ID
@@ -83,6 +90,30 @@ func (m *MockReservationService) ReserveItem(ctx context.Context, itemName strin
 	return reservationID, nil
 }
 
+// ReserveItems reserves the items one after the other under one simulated latency, an item that can't be
+// reserved fails on its own like in ReserveItem
+func (m *MockReservationService) ReserveItems(ctx context.Context, requests []domain.ReservationRequest) ([]domain.ReservationResult, error) {
+	m.simulateLatency()
+
+	if m.shouldFail() {
+		return nil, domain.NewTransientReservationError("reservation failed: service temporarily unavailable", nil)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	results := make([]domain.ReservationResult, len(requests))
+	for i, request := range requests {
+		results[i].ReservationID, results[i].Err = m.reserve(request.ItemName, request.Quantity)
+	}
+	return results, nil
+}
+
+// Ping always succeeds, the mock lives in the process
+func (m *MockReservationService) Ping(ctx context.Context) error {
+	return nil
+}
+
 // simulateLatency simulates network latency
 func (m *MockReservationService) simulateLatency() {
 	if m.latencyRange > 0 {
@@ -4,6 +4,8 @@ import (
 	"context"
 	"strings"
 	"testing"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
 )
 
 func TestMockReservationService_CheckAvailability(t *testing.T) {
@@ -110,6 +112,10 @@ func TestMockReservationService_ReserveItem(t *testing.T) {
 				if err == nil || !strings.Contains(err.Error(), tt.errContains) {
 					t.Errorf("ReserveItem() error = %v, want error containing %v", err, tt.errContains)
 				}
+				// retrying won't make the item exist or the inventory grow
+				if class := domain.ClassifyReservationError(err); class != domain.ReservationErrorPermanent {
+					t.Errorf("ReserveItem() error class = %v, want %v", class, domain.ReservationErrorPermanent)
+				}
 				return
 			}
 			if got == "" {
@@ -119,6 +125,35 @@ func TestMockReservationService_ReserveItem(t *testing.T) {
 	}
 }
 
+func TestMockReservationService_ReserveItems(t *testing.T) {
+	svc := NewMockReservationService(MockConfig{
+		LatencyRange: 0,
+		FailureRate:  0,
+	})
+
+	results, err := svc.ReserveItems(context.Background(), []domain.ReservationRequest{
+		{ItemName: "laptop", Quantity: 5},
+		{ItemName: "nonexistent", Quantity: 1},
+		{ItemName: "laptop", Quantity: 10},
+	})
+	if err != nil {
+		t.Fatalf("ReserveItems() error = %v", err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("ReserveItems() returned %d results, want 3", len(results))
+	}
+	if results[0].Err != nil || results[0].ReservationID == "" {
+		t.Errorf("ReserveItems() result 0 = %+v, want a reservation", results[0])
+	}
+	// the first reservation took from the inventory the last one needed
+	wantErrs := map[int]string{1: "item not found", 2: "insufficient inventory"}
+	for i, want := range wantErrs {
+		if err := results[i].Err; err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("ReserveItems() result %d error = %v, want error containing %v", i, err, want)
+		}
+	}
+}
+
 func TestMockReservationService_WithFailureRate(t *testing.T) {
 	svc := NewMockReservationService(MockConfig{
 		LatencyRange: 0,
@@ -129,7 +164,11 @@ func TestMockReservationService_WithFailureRate(t *testing.T) {
 		t.Error("CheckAvailability() should fail when FailureRate is 1.0")
 	}
 
-	if _, err := svc.ReserveItem(context.Background(), "laptop", 1); err == nil {
+	_, err := svc.ReserveItem(context.Background(), "laptop", 1)
+	if err == nil {
 		t.Error("ReserveItem() should fail when FailureRate is 1.0")
 	}
+	if class := domain.ClassifyReservationError(err); class != domain.ReservationErrorTransient {
+		t.Errorf("ReserveItem() error class = %v, want %v", class, domain.ReservationErrorTransient)
+	}
 }
@@ -0,0 +1,180 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/a-berahman/shopping-cart/internal/adapters/rpc/cartpb"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/a-berahman/shopping-cart/internal/core/ports"
+)
+
+// Server exposes the cart service over gRPC, it is the gRPC counterpart of handler.Handler
+type Server struct {
+	cartpb.UnimplementedCartServiceServer
+
+	service       ports.CartService
+	watchInterval time.Duration
+}
+
+// NewServer creates a new gRPC server adapter, watchInterval is how often WatchItem polls the item
+func NewServer(service ports.CartService, watchInterval time.Duration) *Server {
+	return &Server{
+		service:       service,
+		watchInterval: watchInterval,
+	}
+}
+
+// Register registers the cart service on the gRPC server
+func (s *Server) Register(gs *grpc.Server) {
+	cartpb.RegisterCartServiceServer(gs, s)
+}
+
+func (s *Server) AddItem(ctx context.Context, req *cartpb.AddItemRequest) (*cartpb.AddItemResponse, error) {
+	// the domain owns the rules for a line, so both APIs accept the same input
+	line := domain.CartLine{Name: req.GetName(), Quantity: int(req.GetQuantity())}
+	if err := line.Validate(); err != nil {
+		return nil, toStatus(err)
+	}
+
+	item, err := s.service.AddItemToCart(ctx, line.Name, line.Quantity)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &cartpb.AddItemResponse{Item: toProtoItem(item)}, nil
+}
+
+func (s *Server) ListItems(ctx context.Context, _ *cartpb.ListItemsRequest) (*cartpb.ListItemsResponse, error) {
+	items, err := s.service.ListCartItems(ctx)
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	resp := &cartpb.ListItemsResponse{Items: make([]*cartpb.Item, len(items))}
+	for i := range items {
+		resp.Items[i] = toProtoItem(&items[i])
+	}
+	return resp, nil
+}
+
+func (s *Server) GetItem(ctx context.Context, req *cartpb.GetItemRequest) (*cartpb.GetItemResponse, error) {
+	item, err := s.service.GetCartItem(ctx, req.GetId())
+	if err != nil {
+		return nil, toStatus(err)
+	}
+
+	return &cartpb.GetItemResponse{Item: toProtoItem(item)}, nil
+}
+
+// WatchItem polls the item and streams every change of its status,
+// why polling? the worker updates the item in the database and there is no change feed to subscribe to
+func (s *Server) WatchItem(req *cartpb.WatchItemRequest, stream cartpb.CartService_WatchItemServer) error {
+	ctx := stream.Context()
+	ticker := time.NewTicker(s.watchInterval)
+	defer ticker.Stop()
+
+	var last *domain.Item
+	for {
+		item, err := s.service.GetCartItem(ctx, req.GetId())
+		if err != nil {
+			return toStatus(err)
+		}
+
+		if last == nil || last.Status != item.Status {
+			if err := stream.Send(&cartpb.WatchItemResponse{Item: toProtoItem(item)}); err != nil {
+				return err
+			}
+			last = item
+		}
+
+		if item.IsFinal() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return toStatus(ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+var domainCodes = map[domain.ErrorKind]codes.Code{
+	domain.ErrorKindNotFound:              codes.NotFound,
+	domain.ErrorKindInvalidTransition:     codes.FailedPrecondition,
+	domain.ErrorKindPolicyViolation:       codes.FailedPrecondition,
+	domain.ErrorKindDependencyUnavailable: codes.Unavailable,
+	domain.ErrorKindUnauthenticated:       codes.Unauthenticated,
+	domain.ErrorKindForbidden:             codes.PermissionDenied,
+	domain.ErrorKindInvalidInput:          codes.InvalidArgument,
+}
+
+// toStatus maps errors to gRPC status codes without leaking internal error details,
+// the violations of invalid input are attached as a BadRequest detail
+func toStatus(err error) error {
+	var domainErr *domain.Error
+	if errors.As(err, &domainErr) {
+		if code, ok := domainCodes[domainErr.Kind]; ok {
+			return withViolations(status.New(code, domainErr.Message), domainErr.Violations).Err()
+		}
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		return status.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return status.Error(codes.DeadlineExceeded, err.Error())
+	default:
+		return status.Error(codes.Internal, "internal error")
+	}
+}
+
+func withViolations(st *status.Status, violations []domain.FieldViolation) *status.Status {
+	if len(violations) == 0 {
+		return st
+	}
+	badRequest := &errdetails.BadRequest{}
+	for _, violation := range violations {
+		badRequest.FieldViolations = append(badRequest.FieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       violation.Field,
+			Description: violation.Message,
+		})
+	}
+	detailed, err := st.WithDetails(badRequest)
+	if err != nil {
+		return st
+	}
+	return detailed
+}
+
+var itemStatuses = map[domain.ItemStatus]cartpb.ItemStatus{
+	domain.StatusReservationPending:           cartpb.ItemStatus_ITEM_STATUS_PENDING,
+	domain.StatusReservationAvailabilityCheck: cartpb.ItemStatus_ITEM_STATUS_AVAILABILITY_CHECK,
+	domain.StatusReservationAvailable:         cartpb.ItemStatus_ITEM_STATUS_AVAILABLE,
+	domain.StatusReservationUnavailable:       cartpb.ItemStatus_ITEM_STATUS_UNAVAILABLE,
+	domain.StatusReservationReserved:          cartpb.ItemStatus_ITEM_STATUS_RESERVED,
+	domain.StatusReservationFailed:            cartpb.ItemStatus_ITEM_STATUS_FAILED,
+}
+
+func toProtoItem(item *domain.Item) *cartpb.Item {
+	out := &cartpb.Item{
+		Id:        item.ID,
+		Name:      item.Name,
+		Quantity:  int32(item.Quantity),
+		Status:    itemStatuses[item.Status],
+		CreatedAt: timestamppb.New(item.CreatedAt),
+		UpdatedAt: timestamppb.New(item.UpdatedAt),
+	}
+	if item.ReservationID != nil && *item.ReservationID != "" {
+		out.ReservationId = item.ReservationID
+	}
+	return out
+}
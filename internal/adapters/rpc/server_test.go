@@ -0,0 +1,227 @@
+package rpc
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/a-berahman/shopping-cart/internal/adapters/rpc/cartpb"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+type MockCartService struct {
+	mock.Mock
+}
+
+func (m *MockCartService) AddItemToCart(ctx context.Context, name string, quantity int) (*domain.Item, error) {
+	args := m.Called(ctx, name, quantity)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Item), args.Error(1)
+}
+
+func (m *MockCartService) AddItemsToCart(ctx context.Context, lines []domain.CartLine) ([]*domain.Item, error) {
+	args := m.Called(ctx, lines)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]*domain.Item), args.Error(1)
+}
+
+func (m *MockCartService) ListCartItems(ctx context.Context) ([]domain.Item, error) {
+	args := m.Called(ctx)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.Item), args.Error(1)
+}
+
+func (m *MockCartService) GetCartItem(ctx context.Context, id int64) (*domain.Item, error) {
+	args := m.Called(ctx, id)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).(*domain.Item), args.Error(1)
+}
+
+// setupTest starts the server on an in-memory listener and returns a connected client
+func setupTest(t *testing.T) (cartpb.CartServiceClient, *MockCartService) {
+	listener := bufconn.Listen(1024 * 1024)
+	mockService := new(MockCartService)
+
+	gs := grpc.NewServer()
+	NewServer(mockService, 10*time.Millisecond).Register(gs)
+	go gs.Serve(listener)
+	t.Cleanup(gs.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return listener.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	return cartpb.NewCartServiceClient(conn), mockService
+}
+
+func TestAddItem(t *testing.T) {
+	tests := []struct {
+		name               string
+		req                *cartpb.AddItemRequest
+		setupMock          func(*MockCartService)
+		expectedCode       codes.Code
+		expectedViolations []string
+	}{
+		{
+			name: "successful item addition",
+			req:  &cartpb.AddItemRequest{Name: "laptop", Quantity: 1},
+			setupMock: func(ms *MockCartService) {
+				ms.On("AddItemToCart", mock.Anything, "laptop", 1).
+					Return(&domain.Item{ID: 1, Name: "laptop", Quantity: 1, Status: domain.StatusReservationPending}, nil)
+			},
+			expectedCode: codes.OK,
+		},
+		{
+			name:               "invalid request - missing name",
+			req:                &cartpb.AddItemRequest{Quantity: 1},
+			setupMock:          func(_ *MockCartService) {},
+			expectedCode:       codes.InvalidArgument,
+			expectedViolations: []string{"name: is required"},
+		},
+		{
+			name:               "invalid request - short name and negative quantity",
+			req:                &cartpb.AddItemRequest{Name: "a", Quantity: -1},
+			setupMock:          func(_ *MockCartService) {},
+			expectedCode:       codes.InvalidArgument,
+			expectedViolations: []string{"name: must be at least 2 characters", "quantity: must be at least 1"},
+		},
+		{
+			name: "service error",
+			req:  &cartpb.AddItemRequest{Name: "laptop", Quantity: 1},
+			setupMock: func(ms *MockCartService) {
+				ms.On("AddItemToCart", mock.Anything, "laptop", 1).
+					Return(nil, errors.New("pq: connection refused"))
+			},
+			expectedCode: codes.Internal,
+		},
+		{
+			name: "dependency unavailable",
+			req:  &cartpb.AddItemRequest{Name: "laptop", Quantity: 1},
+			setupMock: func(ms *MockCartService) {
+				ms.On("AddItemToCart", mock.Anything, "laptop", 1).
+					Return(nil, domain.NewDependencyUnavailableError("queue_unavailable", "reservation queue is unavailable", errors.New("pq: timeout")))
+			},
+			expectedCode: codes.Unavailable,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, mockService := setupTest(t)
+			tt.setupMock(mockService)
+
+			resp, err := client.AddItem(context.Background(), tt.req)
+			assert.Equal(t, tt.expectedCode, status.Code(err))
+			if tt.expectedCode == codes.OK {
+				assert.Equal(t, int64(1), resp.GetItem().GetId())
+				assert.Equal(t, cartpb.ItemStatus_ITEM_STATUS_PENDING, resp.GetItem().GetStatus())
+			} else {
+				assert.NotContains(t, err.Error(), "pq:")
+			}
+			assert.Equal(t, tt.expectedViolations, fieldViolations(err))
+
+			mockService.AssertExpectations(t)
+		})
+	}
+}
+
+// fieldViolations returns the BadRequest details of a status error as "field: description"
+func fieldViolations(err error) []string {
+	var violations []string
+	for _, detail := range status.Convert(err).Details() {
+		if badRequest, ok := detail.(*errdetails.BadRequest); ok {
+			for _, violation := range badRequest.GetFieldViolations() {
+				violations = append(violations, violation.GetField()+": "+violation.GetDescription())
+			}
+		}
+	}
+	return violations
+}
+
+func TestListItems(t *testing.T) {
+	client, mockService := setupTest(t)
+	reservationID := "RSV-1"
+	mockService.On("ListCartItems", mock.Anything).Return([]domain.Item{
+		{ID: 1, Name: "laptop", Quantity: 1, Status: domain.StatusReservationPending},
+		{ID: 2, Name: "phone", Quantity: 2, Status: domain.StatusReservationReserved, ReservationID: &reservationID},
+	}, nil)
+
+	resp, err := client.ListItems(context.Background(), &cartpb.ListItemsRequest{})
+	require.NoError(t, err)
+	require.Len(t, resp.GetItems(), 2)
+	assert.Nil(t, resp.GetItems()[0].ReservationId)
+	assert.Equal(t, "RSV-1", resp.GetItems()[1].GetReservationId())
+	assert.Equal(t, cartpb.ItemStatus_ITEM_STATUS_RESERVED, resp.GetItems()[1].GetStatus())
+}
+
+func TestGetItem(t *testing.T) {
+	client, mockService := setupTest(t)
+	mockService.On("GetCartItem", mock.Anything, int64(1)).
+		Return(&domain.Item{ID: 1, Name: "laptop", Quantity: 1, Status: domain.StatusReservationPending}, nil)
+	mockService.On("GetCartItem", mock.Anything, int64(2)).Return(nil, domain.ErrItemNotFound)
+
+	resp, err := client.GetItem(context.Background(), &cartpb.GetItemRequest{Id: 1})
+	require.NoError(t, err)
+	assert.Equal(t, "laptop", resp.GetItem().GetName())
+
+	_, err = client.GetItem(context.Background(), &cartpb.GetItemRequest{Id: 2})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
+
+func TestWatchItem(t *testing.T) {
+	client, mockService := setupTest(t)
+
+	// the item is polled until it reaches a final status, unchanged polls are not sent
+	mockService.On("GetCartItem", mock.Anything, int64(1)).
+		Return(&domain.Item{ID: 1, Status: domain.StatusReservationPending}, nil).Twice()
+	mockService.On("GetCartItem", mock.Anything, int64(1)).
+		Return(&domain.Item{ID: 1, Status: domain.StatusReservationAvailable}, nil).Once()
+	mockService.On("GetCartItem", mock.Anything, int64(1)).
+		Return(&domain.Item{ID: 1, Status: domain.StatusReservationReserved}, nil).Once()
+
+	stream, err := client.WatchItem(context.Background(), &cartpb.WatchItemRequest{Id: 1})
+	require.NoError(t, err)
+
+	var statuses []cartpb.ItemStatus
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		statuses = append(statuses, resp.GetItem().GetStatus())
+	}
+
+	assert.Equal(t, []cartpb.ItemStatus{
+		cartpb.ItemStatus_ITEM_STATUS_PENDING,
+		cartpb.ItemStatus_ITEM_STATUS_AVAILABLE,
+		cartpb.ItemStatus_ITEM_STATUS_RESERVED,
+	}, statuses)
+	mockService.AssertExpectations(t)
+}
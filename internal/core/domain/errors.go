@@ -0,0 +1,178 @@
+package domain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrorKind is the category of a domain error, adapters map it to their own status codes
+type ErrorKind string
+
+const (
+	ErrorKindNotFound              ErrorKind = "NOT_FOUND"
+	ErrorKindInvalidTransition     ErrorKind = "INVALID_TRANSITION"
+	ErrorKindPolicyViolation       ErrorKind = "POLICY_VIOLATION"
+	ErrorKindDependencyUnavailable ErrorKind = "DEPENDENCY_UNAVAILABLE"
+	ErrorKindUnauthenticated       ErrorKind = "UNAUTHENTICATED"
+	ErrorKindForbidden             ErrorKind = "FORBIDDEN"
+	ErrorKindInvalidInput          ErrorKind = "INVALID_INPUT"
+)
+
+// Error is a typed domain error.
+// Code is a stable machine readable identifier and Message is safe to show to API users,
+// the underlying error is kept for logs only
+type Error struct {
+	Kind    ErrorKind
+	Code    string
+	Message string
+	// Violations are the invalid fields of an invalid input error
+	Violations []FieldViolation
+	Err        error
+}
+
+// FieldViolation is an invalid field of an input, Code is the rule it broke, e.g. "min"
+type FieldViolation struct {
+	Field   string
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Message, e.Err)
+	}
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches domain errors by kind and code, so a wrapped copy of a sentinel still matches it
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Kind == e.Kind && t.Code == e.Code
+}
+
+var (
+	// ErrItemNotFound is returned when an item doesn't exist, or belongs to someone else
+	ErrItemNotFound = NewNotFoundError("item_not_found", "item not found")
+	// ErrUnauthenticated is returned when there is no valid principal for the request
+	ErrUnauthenticated = &Error{Kind: ErrorKindUnauthenticated, Code: "unauthenticated", Message: "authentication is required"}
+	// ErrForbidden is returned when the principal lacks the role for an operation
+	ErrForbidden = &Error{Kind: ErrorKindForbidden, Code: "forbidden", Message: "you are not allowed to perform this operation"}
+)
+
+func NewNotFoundError(code, message string) *Error {
+	return &Error{Kind: ErrorKindNotFound, Code: code, Message: message}
+}
+
+func NewInvalidTransitionError(code, message string) *Error {
+	return &Error{Kind: ErrorKindInvalidTransition, Code: code, Message: message}
+}
+
+func NewPolicyViolationError(code, message string) *Error {
+	return &Error{Kind: ErrorKindPolicyViolation, Code: code, Message: message}
+}
+
+func NewInvalidInputError(violations ...FieldViolation) *Error {
+	return &Error{Kind: ErrorKindInvalidInput, Code: "validation_failed", Message: "the request has invalid fields", Violations: violations}
+}
+
+func NewDependencyUnavailableError(code, message string, err error) *Error {
+	return &Error{Kind: ErrorKindDependencyUnavailable, Code: code, Message: message, Err: err}
+}
+
+// KindOf returns the kind of the first domain error in the chain, or an empty kind
+func KindOf(err error) ErrorKind {
+	var domainErr *Error
+	if errors.As(err, &domainErr) {
+		return domainErr.Kind
+	}
+	return ""
+}
+
+// ReservationErrorClass tells whether a failed call to the reservation service is worth retrying
+type ReservationErrorClass string
+
+const (
+	// ReservationErrorTransient is a failure that may pass, like a timeout or an unavailable service
+	ReservationErrorTransient ReservationErrorClass = "TRANSIENT"
+	// ReservationErrorPermanent is a failure retrying won't fix, like an unknown item or too little inventory
+	ReservationErrorPermanent ReservationErrorClass = "PERMANENT"
+	// ReservationErrorRateLimited is a call the service turned down until RetryAfter passed
+	ReservationErrorRateLimited ReservationErrorClass = "RATE_LIMITED"
+)
+
+// ReservationError is an error of the reservation service, the adapters classify the errors they return
+// so the worker knows which to retry. Reason is safe to show to API users, the underlying error is kept for logs only
+type ReservationError struct {
+	Class  ReservationErrorClass
+	Reason string
+	// RetryAfter is how long the service asked to wait, only rate limited errors have it
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *ReservationError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %v", e.Reason, e.Err)
+	}
+	return e.Reason
+}
+
+func (e *ReservationError) Unwrap() error {
+	return e.Err
+}
+
+func NewTransientReservationError(reason string, err error) *ReservationError {
+	return &ReservationError{Class: ReservationErrorTransient, Reason: reason, Err: err}
+}
+
+func NewPermanentReservationError(reason string, err error) *ReservationError {
+	return &ReservationError{Class: ReservationErrorPermanent, Reason: reason, Err: err}
+}
+
+func NewRateLimitedReservationError(reason string, retryAfter time.Duration, err error) *ReservationError {
+	return &ReservationError{Class: ReservationErrorRateLimited, Reason: reason, RetryAfter: retryAfter, Err: err}
+}
+
+// ErrCircuitOpen is returned for calls a circuit breaker turned down, the reservation service wasn't called
+var ErrCircuitOpen = NewTransientReservationError("the reservation service is failing, calls are paused", nil)
+
+// ClassifyReservationError returns the class of the first reservation error in the chain,
+// errors nobody classified are transient so they keep being retried
+func ClassifyReservationError(err error) ReservationErrorClass {
+	var reservationErr *ReservationError
+	if errors.As(err, &reservationErr) {
+		return reservationErr.Class
+	}
+	return ReservationErrorTransient
+}
+
+// RetryAfterOf returns how long the reservation service asked to wait before the next call, or zero
+func RetryAfterOf(err error) time.Duration {
+	var reservationErr *ReservationError
+	if errors.As(err, &reservationErr) {
+		return reservationErr.RetryAfter
+	}
+	return 0
+}
+
+// FailureReason returns the reason shown on an item whose reservation failed with err
+func FailureReason(err error) string {
+	var reservationErr *ReservationError
+	var domainErr *Error
+	switch {
+	case errors.As(err, &reservationErr):
+		return reservationErr.Reason
+	case errors.Is(err, context.DeadlineExceeded):
+		return "the reservation service timed out"
+	case errors.As(err, &domainErr):
+		return domainErr.Message
+	default:
+		return "the reservation failed"
+	}
+}
@@ -1,7 +1,9 @@
 package domain
 
 import (
+	"fmt"
 	"time"
+	"unicode/utf8"
 )
 
 // ItemStatus is the status of an item
@@ -16,6 +18,34 @@ const (
 	StatusReservationFailed            ItemStatus = "FAILED"
 )
 
+// itemTransitions are the statuses an item can move to from each status,
+// FAILED can move on because the job behind it is retried
+var itemTransitions = map[ItemStatus][]ItemStatus{
+	StatusReservationPending:           {StatusReservationAvailabilityCheck, StatusReservationAvailable, StatusReservationUnavailable, StatusReservationFailed},
+	StatusReservationAvailabilityCheck: {StatusReservationAvailable, StatusReservationUnavailable, StatusReservationFailed},
+	StatusReservationAvailable:         {StatusReservationReserved, StatusReservationFailed},
+	StatusReservationFailed:            {StatusReservationAvailable, StatusReservationUnavailable, StatusReservationReserved, StatusReservationFailed},
+}
+
+// MaxBatchSize is the maximum number of items that can be added at once
+const MaxBatchSize = 100
+
+// ErrBatchTooLarge is returned when more than MaxBatchSize items are added at once
+var ErrBatchTooLarge = NewPolicyViolationError("batch_too_large",
+	fmt.Sprintf("a batch can't have more than %d items", MaxBatchSize))
+
+// TransitionTo moves the item to the given status if the reservation flow allows it
+func (i *Item) TransitionTo(status ItemStatus) error {
+	for _, next := range itemTransitions[i.Status] {
+		if next == status {
+			i.Status = status
+			return nil
+		}
+	}
+	return NewInvalidTransitionError("invalid_item_transition",
+		fmt.Sprintf("item can't move from %s to %s", i.Status, status))
+}
+
 // Add method to check if item can be shown as potentially available
 func (i *Item) IsAvailable() bool {
 	return i.Status == StatusReservationAvailable ||
@@ -23,6 +53,13 @@ func (i *Item) IsAvailable() bool {
 		i.Status == StatusReservationAvailabilityCheck
 }
 
+// IsFinal reports whether the reservation flow for the item is over
+func (i *Item) IsFinal() bool {
+	return i.Status == StatusReservationReserved ||
+		i.Status == StatusReservationUnavailable ||
+		i.Status == StatusReservationFailed
+}
+
 // Item is the domain object for an item
 type Item struct {
 	ID            int64      `json:"id"`
@@ -30,6 +67,46 @@ type Item struct {
 	Quantity      int        `json:"quantity"`
 	ReservationID *string    `json:"reservation_id,omitempty"`
 	Status        ItemStatus `json:"status"`
+	OwnerID       string     `json:"-"`
 	CreatedAt     time.Time  `json:"created_at"`
 	UpdatedAt     time.Time  `json:"updated_at"`
+	// FailureReason says why the reservation of a FAILED item failed
+	FailureReason *string `json:"failure_reason,omitempty"`
+}
+
+// CartLine is a name and quantity to add to the cart, used to add many items at once
+type CartLine struct {
+	Name     string
+	Quantity int
+}
+
+const (
+	minItemNameLength = 2
+	maxItemNameLength = 100
+)
+
+// Validate checks the line the same way for every API, it returns an invalid input error naming each bad field
+func (l CartLine) Validate() error {
+	var violations []FieldViolation
+	switch name := utf8.RuneCountInString(l.Name); {
+	case name == 0:
+		violations = append(violations, FieldViolation{Field: "name", Code: "required", Message: "is required"})
+	case name < minItemNameLength:
+		violations = append(violations, FieldViolation{Field: "name", Code: "min",
+			Message: fmt.Sprintf("must be at least %d characters", minItemNameLength)})
+	case name > maxItemNameLength:
+		violations = append(violations, FieldViolation{Field: "name", Code: "max",
+			Message: fmt.Sprintf("must be at most %d characters", maxItemNameLength)})
+	}
+	switch {
+	case l.Quantity == 0:
+		violations = append(violations, FieldViolation{Field: "quantity", Code: "required", Message: "is required"})
+	case l.Quantity < 1:
+		violations = append(violations, FieldViolation{Field: "quantity", Code: "min", Message: "must be at least 1"})
+	}
+
+	if len(violations) > 0 {
+		return NewInvalidInputError(violations...)
+	}
+	return nil
 }
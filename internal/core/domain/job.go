@@ -1,6 +1,10 @@
 package domain
 
-import "time"
+import (
+	"context"
+	"errors"
+	"time"
+)
 
 type JobType string
 
@@ -9,16 +13,82 @@ const (
 	JobTypeReservation       JobType = "RESERVATION"
 )
 
+// JobPriority is the lane a job waits in. interactive jobs serve a customer waiting for them and go first,
+// background jobs yield to the others but still get their share
+type JobPriority string
+
+const (
+	JobPriorityInteractive JobPriority = "INTERACTIVE"
+	JobPriorityNormal      JobPriority = "NORMAL"
+	JobPriorityBackground  JobPriority = "BACKGROUND"
+)
+
+// JobPriorities are all priorities, highest first
+var JobPriorities = []JobPriority{JobPriorityInteractive, JobPriorityNormal, JobPriorityBackground}
+
 // JobStatus is the status of a job
 type JobStatus string
 
 const (
-	JobStatusPending    JobStatus = "PENDING"
+	JobStatusPending JobStatus = "PENDING"
+	// JobStatusDelayed is a job waiting for its next attempt after a failure
+	JobStatusDelayed    JobStatus = "DELAYED"
 	JobStatusProcessing JobStatus = "PROCESSING"
 	JobStatusCompleted  JobStatus = "COMPLETED"
 	JobStatusFailed     JobStatus = "FAILED"
 )
 
+// JobStatuses are all statuses, every status is a queue operators can inspect
+var JobStatuses = []JobStatus{JobStatusPending, JobStatusDelayed, JobStatusProcessing, JobStatusCompleted, JobStatusFailed}
+
+var (
+	// ErrJobNotFound is returned when no queue holds a job with the ID
+	ErrJobNotFound = NewNotFoundError("job_not_found", "job not found")
+	// ErrJobNotFailed is returned when a job that didn't fail is retried
+	ErrJobNotFailed = NewInvalidTransitionError("job_not_failed", "only failed jobs can be retried")
+	// ErrJobProcessing is returned when a job a worker holds is discarded
+	ErrJobProcessing = NewInvalidTransitionError("job_processing", "a job can't be discarded while it is processed")
+	// ErrLeaseLost is returned when a worker extends the lease of a job the reaper already requeued
+	ErrLeaseLost = NewInvalidTransitionError("lease_lost", "the lease of the job expired and it was requeued")
+)
+
+// JobErrorClass groups the errors jobs fail with, so operators can replay the failures of one cause
+type JobErrorClass string
+
+const (
+	JobErrorClassTimeout JobErrorClass = "TIMEOUT"
+	// JobErrorClassLeaseExpired is a job whose worker died or hung while processing it
+	JobErrorClassLeaseExpired JobErrorClass = "LEASE_EXPIRED"
+	JobErrorClassUnknown      JobErrorClass = "UNKNOWN"
+)
+
+// maxJobHistory bounds the attempts a job remembers, a job replayed over and over would grow it forever
+const maxJobHistory = 20
+
+// ClassifyJobError returns the class of the error a job failed with, domain errors are classed by their kind
+// and reservation errors by their class
+func ClassifyJobError(err error) JobErrorClass {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return JobErrorClassTimeout
+	}
+	var reservationErr *ReservationError
+	if errors.As(err, &reservationErr) {
+		return JobErrorClass(reservationErr.Class)
+	}
+	if kind := KindOf(err); kind != "" {
+		return JobErrorClass(kind)
+	}
+	return JobErrorClassUnknown
+}
+
+// JobAttempt is a failed attempt of a job
+type JobAttempt struct {
+	Attempt    int           `json:"attempt"`
+	At         time.Time     `json:"at"`
+	Error      string        `json:"error"`
+	ErrorClass JobErrorClass `json:"error_class"`
+}
+
 // ReservationJob is the domain object for a reservation job
 type ReservationJob struct {
 	ID            string    `json:"id"`
@@ -30,9 +100,57 @@ type ReservationJob struct {
 	LastAttempted time.Time `json:"last_attempted"`
 	CreatedAt     time.Time `json:"created_at"`
 	JobType       JobType   `json:"job_type"`
+	// Priority is empty for jobs queued before priorities existed, they are normal
+	Priority JobPriority `json:"priority,omitempty"`
+	// LastError and ErrorClass describe the last failed attempt, History the failed attempts oldest first
+	LastError  string        `json:"last_error,omitempty"`
+	ErrorClass JobErrorClass `json:"error_class,omitempty"`
+	History    []JobAttempt  `json:"history,omitempty"`
+}
+
+// Lane returns the priority of the job, normal for jobs without one
+func (j *ReservationJob) Lane() JobPriority {
+	if j.Priority == "" {
+		return JobPriorityNormal
+	}
+	return j.Priority
 }
 
 // CanRetry is a business rules for jobs
 func (j *ReservationJob) CanRetry(maxAttempts int) bool {
 	return j.Status != JobStatusCompleted && j.Attempts < maxAttempts
 }
+
+// RecordFailure records a failed attempt, the job keeps its error as the last one
+func (j *ReservationJob) RecordFailure(message string, class JobErrorClass, at time.Time) {
+	j.LastError = message
+	j.ErrorClass = class
+	j.History = append(j.History, JobAttempt{Attempt: j.Attempts, At: at, Error: message, ErrorClass: class})
+	if len(j.History) > maxJobHistory {
+		j.History = j.History[len(j.History)-maxJobHistory:]
+	}
+}
+
+// DeadLetterFilter selects failed jobs to replay, zero fields match every job
+type DeadLetterFilter struct {
+	JobType    JobType
+	ItemID     int64
+	ErrorClass JobErrorClass
+	// FailedAfter and FailedBefore bound when the job failed
+	FailedAfter  time.Time
+	FailedBefore time.Time
+}
+
+// Matches reports whether the job has the type, item and error class of the filter,
+// the queue knows when a job failed and applies the time range
+func (f DeadLetterFilter) Matches(job *ReservationJob) bool {
+	return (f.JobType == "" || job.JobType == f.JobType) &&
+		(f.ItemID == 0 || job.ItemID == f.ItemID) &&
+		(f.ErrorClass == "" || job.ErrorClass == f.ErrorClass)
+}
+
+// OutboxStats describes the jobs waiting in the outbox to be published, Lag is how long the oldest has waited
+type OutboxStats struct {
+	Pending int64
+	Lag     time.Duration
+}
@@ -0,0 +1,13 @@
+package domain
+
+// ReservationRequest is an item and the quantity of it to reserve, many are reserved in one batched call
+type ReservationRequest struct {
+	ItemName string
+	Quantity int
+}
+
+// ReservationResult is the outcome of one reservation of a batch, its reservation ID or the error it failed with
+type ReservationResult struct {
+	ReservationID string
+	Err           error
+}
@@ -0,0 +1,18 @@
+package ports
+
+import (
+	"context"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+)
+
+// Outbox holds the jobs written in the transaction of their items until the relay publishes them to the queue
+type Outbox interface {
+	// RelayJobs hands up to limit unsent jobs to publish, oldest first, and marks them sent once it succeeded.
+	// it returns how many were sent. a relay that dies after publishing leaves them unsent, delivery is at least once
+	RelayJobs(ctx context.Context, limit int, publish func(ctx context.Context, jobs []*domain.ReservationJob) error) (int, error)
+	// TrimSentJobs drops the jobs sent more than maxAge ago and returns how many
+	TrimSentJobs(ctx context.Context, maxAge time.Duration) (int, error)
+	OutboxStats(ctx context.Context) (domain.OutboxStats, error)
+}
@@ -2,6 +2,7 @@ package ports
 
 import (
 	"context"
+	"time"
 
 	"github.com/a-berahman/shopping-cart/internal/core/domain"
 )
@@ -9,8 +10,33 @@ import (
 // Queue is the interface for the queue
 type Queue interface {
 	EnqueueReservation(ctx context.Context, job *domain.ReservationJob) error
+	EnqueueReservations(ctx context.Context, jobs []*domain.ReservationJob) error
+	// DequeueReservation waits for the next pending job, backends that keep items in order skip jobs
+	// whose item has another job processing or waiting for a retry
 	DequeueReservation(ctx context.Context) (*domain.ReservationJob, error)
 	CompleteJob(ctx context.Context, job *domain.ReservationJob) error
 	FailJob(ctx context.Context, job *domain.ReservationJob) error
-	RetryFailedJobs(ctx context.Context) error
+	// RequeueJob puts a job the worker holds back to be retried once delay passed, right away for zero
+	RequeueJob(ctx context.Context, job *domain.ReservationJob, delay time.Duration) error
+	// PromoteDueJobs moves delayed jobs whose delay passed to the pending queue and returns how many
+	PromoteDueJobs(ctx context.Context) (int, error)
+	// ExtendLease keeps a dequeued job leased to the worker, jobs whose lease expires are requeued
+	ExtendLease(ctx context.Context, job *domain.ReservationJob) error
+	// RequeueExpiredJobs puts jobs whose lease expired back to the pending queue and returns how many
+	RequeueExpiredJobs(ctx context.Context) (int, error)
+}
+
+// QueueAdmin lets operators inspect and repair the queue, the lists are addressed by job status
+type QueueAdmin interface {
+	QueueDepths(ctx context.Context) (map[domain.JobStatus]int64, error)
+	// ListJobs returns a page of jobs with the status, newest first, and the number of jobs with it
+	ListJobs(ctx context.Context, status domain.JobStatus, offset, limit int) ([]*domain.ReservationJob, int64, error)
+	GetJob(ctx context.Context, id string) (*domain.ReservationJob, error)
+	// RetryJob moves a failed job back to the pending queue with its attempts reset
+	RetryJob(ctx context.Context, id string) error
+	// RetryFailedJobs replays the failed jobs the filter matches and returns how many
+	RetryFailedJobs(ctx context.Context, filter domain.DeadLetterFilter) (int, error)
+	DiscardJob(ctx context.Context, id string) error
+	// PurgeJobs discards every job with the status and returns how many were discarded
+	PurgeJobs(ctx context.Context, status domain.JobStatus) (int64, error)
 }
@@ -9,8 +9,19 @@ import (
 // Repository is the interface for the repository
 type Repository interface {
 	CreateItem(ctx context.Context, item *domain.Item) error
-	ListItems(ctx context.Context) ([]domain.Item, error)
+	CreateItems(ctx context.Context, items []*domain.Item) error
+	ListItems(ctx context.Context, ownerID string) ([]domain.Item, error)
 	UpdateItemReservation(ctx context.Context, id int64, reservationID string) error
 	GetItem(ctx context.Context, id int64) (*domain.Item, error)
 	UpdateItemStatus(ctx context.Context, id int64, status domain.ItemStatus) error
+	// FailItem marks the item as failed, reason says why and is shown to its owner
+	FailItem(ctx context.Context, id int64, reason string) error
+}
+
+// JobRepository creates items together with their jobs in one transaction, so an item is never left
+// without the job that reserves it. repositories whose database also holds the queue offer it
+type JobRepository interface {
+	Repository
+	// CreateItemsWithJobs creates the items and enqueues the jobs newJobs builds for the created items
+	CreateItemsWithJobs(ctx context.Context, items []*domain.Item, newJobs func([]*domain.Item) []*domain.ReservationJob) error
 }
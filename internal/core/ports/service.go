@@ -6,14 +6,27 @@ import (
 	"github.com/a-berahman/shopping-cart/internal/core/domain"
 )
 
-// ReservationService is the interface for the reservation service
+// ReservationService is the interface for the reservation service, its errors are *domain.ReservationError
+// so the worker can tell the failures worth retrying from the permanent ones
 type ReservationService interface {
 	CheckAvailability(ctx context.Context, itemName string, quantity int) (bool, error)
 	ReserveItem(ctx context.Context, itemName string, quantity int) (string, error)
+	// ReserveItems reserves many items in one call, the results are in the order of the requests.
+	// an error fails the whole batch, a reservation of the batch that failed on its own has the error in its result
+	ReserveItems(ctx context.Context, requests []domain.ReservationRequest) ([]domain.ReservationResult, error)
+}
+
+// ReservationCircuit is a reservation service behind a circuit breaker, the worker waits until
+// the circuit lets calls through before it takes a job, instead of failing the job
+type ReservationCircuit interface {
+	// Wait blocks until the circuit lets calls through or ctx is done
+	Wait(ctx context.Context) error
 }
 
 // CartService is the interface for the cart service
 type CartService interface {
 	AddItemToCart(ctx context.Context, name string, quantity int) (*domain.Item, error)
+	AddItemsToCart(ctx context.Context, lines []domain.CartLine) ([]*domain.Item, error)
 	ListCartItems(ctx context.Context) ([]domain.Item, error)
+	GetCartItem(ctx context.Context, id int64) (*domain.Item, error)
 }
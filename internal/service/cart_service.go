@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/a-berahman/shopping-cart/internal/core/domain"
 	"github.com/a-berahman/shopping-cart/internal/core/ports"
@@ -29,12 +31,29 @@ func NewCartService(
 }
 
 func (s *CartService) AddItemToCart(ctx context.Context, name string, quantity int) (*domain.Item, error) {
+	principal, ok := domain.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, domain.ErrUnauthenticated
+	}
+	if err := (domain.CartLine{Name: name, Quantity: quantity}).Validate(); err != nil {
+		return nil, err
+	}
 
 	// first we create the item in pending state
 	item := &domain.Item{
 		Name:     name,
 		Quantity: quantity,
 		Status:   domain.StatusReservationPending,
+		OwnerID:  principal.Subject,
+	}
+
+	// the customer is waiting for the item they just added, its check goes ahead of bulk work.
+	// a repository sharing the database with the queue creates the job with the item, or neither
+	if jobRepo, ok := s.repo.(ports.JobRepository); ok {
+		if err := jobRepo.CreateItemsWithJobs(ctx, []*domain.Item{item}, availabilityCheckJobs(domain.JobPriorityInteractive)); err != nil {
+			return nil, err
+		}
+		return item, nil
 	}
 
 	if err := s.repo.CreateItem(ctx, item); err != nil {
@@ -42,27 +61,149 @@ func (s *CartService) AddItemToCart(ctx context.Context, name string, quantity i
 	}
 
 	// create and enqueue reservation job
-	job := &domain.ReservationJob{
-		ID:       uuid.New().String(),
-		ItemID:   item.ID,
-		ItemName: name,
-		Quantity: quantity,
-		JobType:  domain.JobTypeAvailabilityCheck,
-		Status:   domain.JobStatusPending,
-	}
+	job := newAvailabilityCheckJob(item, domain.JobPriorityInteractive)
 
 	// why enqueue? because we want to reserve the item in the background
 	// so that we can return the item to the user immediately
 	if err := s.queue.EnqueueReservation(ctx, job); err != nil {
 		// If enqueueing fails, we should mark the item as failed
-		item.Status = domain.StatusReservationFailed
-		_ = s.repo.UpdateItemStatus(ctx, item.ID, domain.StatusReservationFailed)
-		return nil, err
+		return nil, errQueueUnavailable(errors.Join(err, s.failItems(ctx, item)))
 	}
 
 	return item, nil
 }
 
+// AddItemsToCart adds many items at once, the items are created in one transaction and
+// their jobs are enqueued together, so a batch costs one round trip to each store
+func (s *CartService) AddItemsToCart(ctx context.Context, lines []domain.CartLine) ([]*domain.Item, error) {
+	principal, ok := domain.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, domain.ErrUnauthenticated
+	}
+	if len(lines) > domain.MaxBatchSize {
+		return nil, domain.ErrBatchTooLarge
+	}
+	if err := validateLines(lines); err != nil {
+		return nil, err
+	}
+
+	items := make([]*domain.Item, len(lines))
+	for i, line := range lines {
+		items[i] = &domain.Item{
+			Name:     line.Name,
+			Quantity: line.Quantity,
+			Status:   domain.StatusReservationPending,
+			OwnerID:  principal.Subject,
+		}
+	}
+
+	// a batch is bulk work, it yields to customers adding single items
+	newJobs := availabilityCheckJobs(domain.JobPriorityNormal)
+	if jobRepo, ok := s.repo.(ports.JobRepository); ok {
+		if err := jobRepo.CreateItemsWithJobs(ctx, items, newJobs); err != nil {
+			return nil, err
+		}
+		return items, nil
+	}
+
+	if err := s.repo.CreateItems(ctx, items); err != nil {
+		return nil, err
+	}
+
+	if err := s.queue.EnqueueReservations(ctx, newJobs(items)); err != nil {
+		// none of the jobs were enqueued, so none of the items will ever be reserved
+		return nil, errQueueUnavailable(errors.Join(err, s.failItems(ctx, items...)))
+	}
+
+	return items, nil
+}
+
+// ListCartItems lists the items of the caller only
 func (s *CartService) ListCartItems(ctx context.Context) ([]domain.Item, error) {
-	return s.repo.ListItems(ctx)
+	principal, ok := domain.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, domain.ErrUnauthenticated
+	}
+	return s.repo.ListItems(ctx, principal.Subject)
+}
+
+// GetCartItem returns an item of the caller, items of other users are reported as not found
+// so their IDs can't be probed
+func (s *CartService) GetCartItem(ctx context.Context, id int64) (*domain.Item, error) {
+	principal, ok := domain.PrincipalFromContext(ctx)
+	if !ok {
+		return nil, domain.ErrUnauthenticated
+	}
+
+	item, err := s.repo.GetItem(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if item.OwnerID != principal.Subject {
+		return nil, domain.ErrItemNotFound
+	}
+	return item, nil
+}
+
+// failItems marks items whose jobs couldn't be enqueued as failed. an item it can't mark stays pending
+// without a job, so the error is returned with the enqueue error instead of being dropped
+func (s *CartService) failItems(ctx context.Context, items ...*domain.Item) error {
+	reason := "the reservation couldn't be queued"
+	var errs []error
+	for _, item := range items {
+		if item.TransitionTo(domain.StatusReservationFailed) != nil {
+			continue
+		}
+		item.FailureReason = &reason
+		if err := s.repo.FailItem(ctx, item.ID, reason); err != nil {
+			errs = append(errs, fmt.Errorf("failed to mark item %d as failed: %w", item.ID, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func newAvailabilityCheckJob(item *domain.Item, priority domain.JobPriority) *domain.ReservationJob {
+	return &domain.ReservationJob{
+		ID:       uuid.New().String(),
+		ItemID:   item.ID,
+		ItemName: item.Name,
+		Quantity: item.Quantity,
+		JobType:  domain.JobTypeAvailabilityCheck,
+		Status:   domain.JobStatusPending,
+		Priority: priority,
+	}
+}
+
+// availabilityCheckJobs returns a func building the availability checks of items in the lane of priority
+func availabilityCheckJobs(priority domain.JobPriority) func(items []*domain.Item) []*domain.ReservationJob {
+	return func(items []*domain.Item) []*domain.ReservationJob {
+		jobs := make([]*domain.ReservationJob, len(items))
+		for i, item := range items {
+			jobs[i] = newAvailabilityCheckJob(item, priority)
+		}
+		return jobs
+	}
+}
+
+func errQueueUnavailable(err error) error {
+	return domain.NewDependencyUnavailableError("queue_unavailable", "reservation queue is unavailable", err)
+}
+
+// validateLines checks every line of a batch, the fields of its violations are named by the index of their line
+func validateLines(lines []domain.CartLine) error {
+	var violations []domain.FieldViolation
+	for i, line := range lines {
+		var invalid *domain.Error
+		if !errors.As(line.Validate(), &invalid) {
+			continue
+		}
+		for _, violation := range invalid.Violations {
+			violation.Field = fmt.Sprintf("items[%d].%s", i, violation.Field)
+			violations = append(violations, violation)
+		}
+	}
+	if len(violations) > 0 {
+		return domain.NewInvalidInputError(violations...)
+	}
+	return nil
 }
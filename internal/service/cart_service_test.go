@@ -25,8 +25,13 @@ func (m *MockRepository) CreateItem(ctx context.Context, item *domain.Item) erro
 	return args.Error(0)
 }
 
-func (m *MockRepository) ListItems(ctx context.Context) ([]domain.Item, error) {
-	args := m.Called(ctx)
+func (m *MockRepository) CreateItems(ctx context.Context, items []*domain.Item) error {
+	args := m.Called(ctx, items)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ListItems(ctx context.Context, ownerID string) ([]domain.Item, error) {
+	args := m.Called(ctx, ownerID)
 	if args.Get(0) == nil {
 		return nil, args.Error(1)
 	}
@@ -51,6 +56,27 @@ func (m *MockRepository) UpdateItemReservation(ctx context.Context, id int64, re
 	return args.Error(0)
 }
 
+func (m *MockRepository) FailItem(ctx context.Context, id int64, reason string) error {
+	args := m.Called(ctx, id, reason)
+	return args.Error(0)
+}
+
+// MockJobRepository is a repository that creates items with their jobs
+type MockJobRepository struct {
+	MockRepository
+}
+
+func (m *MockJobRepository) CreateItemsWithJobs(ctx context.Context, items []*domain.Item, newJobs func([]*domain.Item) []*domain.ReservationJob) error {
+	args := m.Called(ctx, items)
+	for i, item := range items {
+		item.ID = int64(i + 1)
+	}
+	if jobs, ok := args.Get(0).(*[]*domain.ReservationJob); ok {
+		*jobs = newJobs(items)
+	}
+	return args.Error(1)
+}
+
 type MockQueue struct {
 	mock.Mock
 }
@@ -60,6 +86,11 @@ func (m *MockQueue) EnqueueReservation(ctx context.Context, job *domain.Reservat
 	return args.Error(0)
 }
 
+func (m *MockQueue) EnqueueReservations(ctx context.Context, jobs []*domain.ReservationJob) error {
+	args := m.Called(ctx, jobs)
+	return args.Error(0)
+}
+
 func (m *MockQueue) CompleteJob(ctx context.Context, job *domain.ReservationJob) error {
 	args := m.Called(ctx, job)
 	return args.Error(0)
@@ -70,11 +101,26 @@ func (m *MockQueue) FailJob(ctx context.Context, job *domain.ReservationJob) err
 	return args.Error(0)
 }
 
-func (m *MockQueue) RetryFailedJobs(ctx context.Context) error {
+func (m *MockQueue) RequeueJob(ctx context.Context, job *domain.ReservationJob, delay time.Duration) error {
+	args := m.Called(ctx, job, delay)
+	return args.Error(0)
+}
+
+func (m *MockQueue) PromoteDueJobs(ctx context.Context) (int, error) {
 	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockQueue) ExtendLease(ctx context.Context, job *domain.ReservationJob) error {
+	args := m.Called(ctx, job)
 	return args.Error(0)
 }
 
+func (m *MockQueue) RequeueExpiredJobs(ctx context.Context) (int, error) {
+	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
 func (m *MockQueue) DequeueReservation(ctx context.Context) (*domain.ReservationJob, error) {
 	args := m.Called(ctx)
 	if args.Get(0) == nil {
@@ -97,6 +143,19 @@ func (m *MockReservationService) ReserveItem(ctx context.Context, itemName strin
 	return args.String(0), args.Error(1)
 }
 
+func (m *MockReservationService) ReserveItems(ctx context.Context, requests []domain.ReservationRequest) ([]domain.ReservationResult, error) {
+	args := m.Called(ctx, requests)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.ReservationResult), args.Error(1)
+}
+
+// userContext is the context of an authenticated request of user-1
+func userContext() context.Context {
+	return domain.ContextWithPrincipal(context.Background(), domain.Principal{Subject: "user-1"})
+}
+
 func TestAddItemToCart(t *testing.T) {
 	tests := []struct {
 		name          string
@@ -113,7 +172,7 @@ func TestAddItemToCart(t *testing.T) {
 			setupMocks: func(repo *MockRepository, queue *MockQueue, resSvc *MockReservationService) {
 				repo.On("CreateItem", mock.Anything, mock.MatchedBy(func(item *domain.Item) bool {
 					return item.Name == "Test Item" && item.Quantity == 1 &&
-						item.Status == domain.StatusReservationPending
+						item.Status == domain.StatusReservationPending && item.OwnerID == "user-1"
 				})).Run(func(args mock.Arguments) {
 					item := args.Get(1).(*domain.Item)
 					item.ID = 1
@@ -123,7 +182,7 @@ func TestAddItemToCart(t *testing.T) {
 
 				queue.On("EnqueueReservation", mock.Anything, mock.MatchedBy(func(job *domain.ReservationJob) bool {
 					return job.ItemName == "Test Item" && job.Quantity == 1 &&
-						job.Status == domain.JobStatusPending
+						job.Status == domain.JobStatusPending && job.Priority == domain.JobPriorityInteractive
 				})).Return(nil)
 			},
 			expectedItem: &domain.Item{
@@ -154,10 +213,45 @@ func TestAddItemToCart(t *testing.T) {
 					item.ID = 1
 				}).Return(nil)
 				queue.On("EnqueueReservation", mock.Anything, mock.Anything).Return(errors.New("queue error"))
-				repo.On("UpdateItemStatus", mock.Anything, int64(1), domain.StatusReservationFailed).Return(nil)
+				repo.On("FailItem", mock.Anything, int64(1), "the reservation couldn't be queued").Return(nil)
 			},
 			expectedItem:  nil,
-			expectedError: errors.New("queue error"),
+			expectedError: errors.New("reservation queue is unavailable: queue error"),
+		},
+		{
+			name:     "queue error and the item can't be marked failed",
+			itemName: "Test Item",
+			quantity: 1,
+			setupMocks: func(repo *MockRepository, queue *MockQueue, resSvc *MockReservationService) {
+				repo.On("CreateItem", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+					item := args.Get(1).(*domain.Item)
+					item.ID = 1
+				}).Return(nil)
+				queue.On("EnqueueReservation", mock.Anything, mock.Anything).Return(errors.New("queue error"))
+				repo.On("FailItem", mock.Anything, int64(1), "the reservation couldn't be queued").Return(errors.New("db error"))
+			},
+			expectedItem:  nil,
+			expectedError: errors.New("reservation queue is unavailable: queue error\nfailed to mark item 1 as failed: db error"),
+		},
+		{
+			name:     "invalid input",
+			itemName: "T",
+			quantity: 0,
+			setupMocks: func(repo *MockRepository, queue *MockQueue, resSvc *MockReservationService) {
+				// nothing is stored for an invalid line
+			},
+			expectedItem:  nil,
+			expectedError: domain.NewInvalidInputError(),
+		},
+		{
+			name:     "unauthenticated",
+			itemName: "Test Item",
+			quantity: 1,
+			setupMocks: func(repo *MockRepository, queue *MockQueue, resSvc *MockReservationService) {
+				// nothing is stored without an owner
+			},
+			expectedItem:  nil,
+			expectedError: domain.ErrUnauthenticated,
 		},
 	}
 
@@ -169,8 +263,13 @@ func TestAddItemToCart(t *testing.T) {
 			resSvc := new(MockReservationService)
 			tt.setupMocks(repo, queue, resSvc)
 
+			ctx := userContext()
+			if tt.expectedError == domain.ErrUnauthenticated {
+				ctx = context.Background()
+			}
+
 			service := NewCartService(repo, queue, resSvc)
-			item, err := service.AddItemToCart(context.Background(), tt.itemName, tt.quantity)
+			item, err := service.AddItemToCart(ctx, tt.itemName, tt.quantity)
 
 			if tt.expectedError != nil {
 				assert.Error(t, err)
@@ -218,7 +317,7 @@ func TestListCartItems(t *testing.T) {
 						Status:   domain.StatusReservationReserved,
 					},
 				}
-				repo.On("ListItems", mock.Anything).Return(items, nil)
+				repo.On("ListItems", mock.Anything, "user-1").Return(items, nil)
 			},
 			expectedItems: []domain.Item{
 				{
@@ -239,7 +338,7 @@ func TestListCartItems(t *testing.T) {
 		{
 			name: "repository error",
 			setupMocks: func(repo *MockRepository) {
-				repo.On("ListItems", mock.Anything).Return(nil, errors.New("db error"))
+				repo.On("ListItems", mock.Anything, "user-1").Return(nil, errors.New("db error"))
 			},
 			expectedItems: nil,
 			expectedError: errors.New("db error"),
@@ -254,7 +353,7 @@ func TestListCartItems(t *testing.T) {
 
 			service := NewCartService(repo, nil, nil)
 
-			items, err := service.ListCartItems(context.Background())
+			items, err := service.ListCartItems(userContext())
 
 			if tt.expectedError != nil {
 				assert.Error(t, err)
@@ -269,3 +368,152 @@ func TestListCartItems(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCartItem(t *testing.T) {
+	repo := new(MockRepository)
+	item := &domain.Item{ID: 1, Name: "Item 1", Quantity: 1, Status: domain.StatusReservationPending, OwnerID: "user-1"}
+	other := &domain.Item{ID: 3, Name: "Item 3", Quantity: 1, Status: domain.StatusReservationPending, OwnerID: "user-2"}
+	repo.On("GetItem", mock.Anything, int64(1)).Return(item, nil)
+	repo.On("GetItem", mock.Anything, int64(2)).Return(nil, domain.ErrItemNotFound)
+	repo.On("GetItem", mock.Anything, int64(3)).Return(other, nil)
+
+	service := NewCartService(repo, nil, nil)
+
+	got, err := service.GetCartItem(userContext(), 1)
+	assert.NoError(t, err)
+	assert.Equal(t, item, got)
+
+	_, err = service.GetCartItem(userContext(), 2)
+	assert.ErrorIs(t, err, domain.ErrItemNotFound)
+
+	// items of other users must look exactly like missing ones
+	_, err = service.GetCartItem(userContext(), 3)
+	assert.ErrorIs(t, err, domain.ErrItemNotFound)
+
+	_, err = service.GetCartItem(context.Background(), 1)
+	assert.ErrorIs(t, err, domain.ErrUnauthenticated)
+
+	repo.AssertExpectations(t)
+}
+
+func TestAddItemsToCart(t *testing.T) {
+	lines := []domain.CartLine{{Name: "Item 1", Quantity: 1}, {Name: "Item 2", Quantity: 2}}
+
+	tests := []struct {
+		name          string
+		lines         []domain.CartLine
+		setupMocks    func(*MockRepository, *MockQueue)
+		expectedError error
+		expectedKind  domain.ErrorKind
+	}{
+		{
+			name:  "successful batch",
+			lines: lines,
+			setupMocks: func(repo *MockRepository, queue *MockQueue) {
+				repo.On("CreateItems", mock.Anything, mock.MatchedBy(func(items []*domain.Item) bool {
+					return len(items) == 2 && items[0].Name == "Item 1" && items[1].Quantity == 2 &&
+						items[0].Status == domain.StatusReservationPending && items[1].OwnerID == "user-1"
+				})).Run(func(args mock.Arguments) {
+					for i, item := range args.Get(1).([]*domain.Item) {
+						item.ID = int64(i + 1)
+					}
+				}).Return(nil)
+				queue.On("EnqueueReservations", mock.Anything, mock.MatchedBy(func(jobs []*domain.ReservationJob) bool {
+					return len(jobs) == 2 && jobs[0].ItemID == 1 && jobs[1].ItemID == 2 &&
+						jobs[1].JobType == domain.JobTypeAvailabilityCheck && jobs[1].Priority == domain.JobPriorityNormal
+				})).Return(nil)
+			},
+		},
+		{
+			name:  "repository error",
+			lines: lines,
+			setupMocks: func(repo *MockRepository, queue *MockQueue) {
+				repo.On("CreateItems", mock.Anything, mock.Anything).Return(errors.New("db error"))
+			},
+			expectedError: errors.New("db error"),
+		},
+		{
+			name:  "queue error marks every item as failed",
+			lines: lines,
+			setupMocks: func(repo *MockRepository, queue *MockQueue) {
+				repo.On("CreateItems", mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+					for i, item := range args.Get(1).([]*domain.Item) {
+						item.ID = int64(i + 1)
+					}
+				}).Return(nil)
+				queue.On("EnqueueReservations", mock.Anything, mock.Anything).Return(errors.New("queue error"))
+				repo.On("FailItem", mock.Anything, int64(1), "the reservation couldn't be queued").Return(nil)
+				repo.On("FailItem", mock.Anything, int64(2), "the reservation couldn't be queued").Return(nil)
+			},
+			expectedError: errors.New("reservation queue is unavailable: queue error"),
+			expectedKind:  domain.ErrorKindDependencyUnavailable,
+		},
+		{
+			name:  "invalid lines",
+			lines: []domain.CartLine{{Name: "Item 1", Quantity: 1}, {Name: "", Quantity: 2}},
+			setupMocks: func(repo *MockRepository, queue *MockQueue) {
+				// the lines are checked before anything is stored
+			},
+			expectedError: domain.NewInvalidInputError(),
+			expectedKind:  domain.ErrorKindInvalidInput,
+		},
+		{
+			name:  "batch too large",
+			lines: make([]domain.CartLine, domain.MaxBatchSize+1),
+			setupMocks: func(repo *MockRepository, queue *MockQueue) {
+				// the policy is checked before anything is stored
+			},
+			expectedError: domain.ErrBatchTooLarge,
+			expectedKind:  domain.ErrorKindPolicyViolation,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := new(MockRepository)
+			queue := new(MockQueue)
+			tt.setupMocks(repo, queue)
+
+			service := NewCartService(repo, queue, nil)
+			items, err := service.AddItemsToCart(userContext(), tt.lines)
+
+			if tt.expectedError != nil {
+				assert.EqualError(t, err, tt.expectedError.Error())
+				assert.Nil(t, items)
+				assert.Equal(t, tt.expectedKind, domain.KindOf(err))
+			} else {
+				assert.NoError(t, err)
+				assert.Len(t, items, 2)
+			}
+
+			repo.AssertExpectations(t)
+			queue.AssertExpectations(t)
+		})
+	}
+}
+
+func TestAddItemsToCartWithJobRepository(t *testing.T) {
+	repo := new(MockJobRepository)
+	queue := new(MockQueue)
+	var jobs []*domain.ReservationJob
+	repo.On("CreateItemsWithJobs", mock.Anything, mock.Anything).Return(&jobs, nil)
+
+	// the jobs are created in the transaction of the items, the queue isn't called on its own
+	service := NewCartService(repo, queue, nil)
+	item, err := service.AddItemToCart(userContext(), "Item 1", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), item.ID)
+	if assert.Len(t, jobs, 1) {
+		assert.Equal(t, int64(1), jobs[0].ItemID)
+		assert.Equal(t, domain.JobTypeAvailabilityCheck, jobs[0].JobType)
+	}
+
+	items, err := service.AddItemsToCart(userContext(), []domain.CartLine{{Name: "Item 1", Quantity: 1}, {Name: "Item 2", Quantity: 2}})
+	assert.NoError(t, err)
+	assert.Len(t, items, 2)
+	assert.Len(t, jobs, 2)
+
+	repo.AssertExpectations(t)
+	queue.AssertNotCalled(t, "EnqueueReservation", mock.Anything, mock.Anything)
+	queue.AssertNotCalled(t, "EnqueueReservations", mock.Anything, mock.Anything)
+}
@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Backoff decides how long a failed job waits before its next attempt
+type Backoff interface {
+	// Delay returns the wait before the attempt after the given number of failed attempts
+	Delay(attempts int) time.Duration
+}
+
+// ExponentialBackoff doubles the delay with every failed attempt, from Base up to Max.
+// with full jitter the wait is random between zero and that delay, so jobs that failed
+// together during an outage don't retry together and hit the recovering service at once
+type ExponentialBackoff struct {
+	Base time.Duration
+	Max  time.Duration
+	// random returns a number in [0, 1), rand.Float64 unless a test pins it
+	random func() float64
+}
+
+// NewExponentialBackoff creates an exponential backoff with full jitter
+func NewExponentialBackoff(base, max time.Duration) *ExponentialBackoff {
+	return &ExponentialBackoff{Base: base, Max: max, random: rand.Float64}
+}
+
+func (b *ExponentialBackoff) Delay(attempts int) time.Duration {
+	delay := b.Base
+	for i := 1; i < attempts && delay < b.Max; i++ {
+		delay *= 2
+	}
+	if delay > b.Max {
+		delay = b.Max
+	}
+	return time.Duration(b.random() * float64(delay))
+}
@@ -0,0 +1,38 @@
+package worker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExponentialBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		random   float64
+		want     time.Duration
+	}{
+		{name: "first retry waits up to the base", attempts: 1, random: 0.999, want: 999 * time.Millisecond},
+		{name: "doubles every attempt", attempts: 3, random: 0.5, want: 2 * time.Second},
+		{name: "capped at the max", attempts: 20, random: 0.5, want: 5 * time.Second},
+		{name: "full jitter may not wait at all", attempts: 4, random: 0, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backoff := NewExponentialBackoff(time.Second, 10*time.Second)
+			backoff.random = func() float64 { return tt.random }
+			assert.Equal(t, tt.want, backoff.Delay(tt.attempts))
+		})
+	}
+
+	// without a pinned random every delay stays within the cap
+	backoff := NewExponentialBackoff(time.Second, 10*time.Second)
+	for attempts := 1; attempts < 50; attempts++ {
+		delay := backoff.Delay(attempts)
+		assert.GreaterOrEqual(t, delay, time.Duration(0))
+		assert.Less(t, delay, 10*time.Second)
+	}
+}
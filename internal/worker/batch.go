@@ -0,0 +1,136 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/a-berahman/shopping-cart/internal/core/ports"
+)
+
+// batcher sends the reservations of the jobs the pool processes at once in batched calls. a batch is sent
+// once it has size reservations or wait passed since its first one, every job then gets its own result
+type batcher struct {
+	svc  ports.ReservationService
+	size int
+	wait time.Duration
+
+	mu sync.Mutex
+	// current is the batch reservations join, nil until the next reservation starts one
+	current *batch
+}
+
+type batch struct {
+	// ctx is the context of the reservation that started the batch without its cancellation,
+	// a job that gives up mustn't cut off the reservations of the others
+	ctx   context.Context
+	calls []*batchCall
+	timer *time.Timer
+	// sent is set once the batch is taken to be sent, its reservations can't leave it from then on
+	sent bool
+}
+
+// batchCall is a reservation in a batch, its result is sent on done
+type batchCall struct {
+	request domain.ReservationRequest
+	done    chan domain.ReservationResult
+}
+
+func newBatcher(svc ports.ReservationService, size int, wait time.Duration) *batcher {
+	return &batcher{svc: svc, size: size, wait: wait}
+}
+
+// reserve reserves quantity of the item in the next batch and waits for its result. a reservation whose
+// ctx is done leaves its batch, once the batch was sent it may be made and reserve waits for its result
+func (b *batcher) reserve(ctx context.Context, itemName string, quantity int) (string, error) {
+	call := &batchCall{
+		request: domain.ReservationRequest{ItemName: itemName, Quantity: quantity},
+		done:    make(chan domain.ReservationResult, 1),
+	}
+
+	b.mu.Lock()
+	if b.current == nil {
+		current := &batch{ctx: context.WithoutCancel(ctx)}
+		current.timer = time.AfterFunc(b.wait, func() { b.flush(current) })
+		b.current = current
+	}
+	joined := b.current
+	joined.calls = append(joined.calls, call)
+	full := len(joined.calls) >= b.size
+	if full {
+		joined.timer.Stop()
+		joined.sent = true
+		b.current = nil
+	}
+	b.mu.Unlock()
+
+	if full {
+		b.send(joined)
+	}
+
+	select {
+	case result := <-call.done:
+		return result.ReservationID, result.Err
+	case <-ctx.Done():
+	}
+	if b.leave(joined, call) {
+		return "", ctx.Err()
+	}
+	result := <-call.done
+	return result.ReservationID, result.Err
+}
+
+// leave takes the reservation out of its batch unless the batch was sent, it reports whether it did.
+// a batch nobody is left in isn't sent
+func (b *batcher) leave(joined *batch, call *batchCall) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if joined.sent {
+		return false
+	}
+
+	// a batch that wasn't sent is the current one
+	joined.calls = slices.DeleteFunc(joined.calls, func(c *batchCall) bool { return c == call })
+	if len(joined.calls) == 0 {
+		joined.timer.Stop()
+		b.current = nil
+	}
+	return true
+}
+
+// flush sends the batch once its wait passed, unless it filled up and was sent meanwhile
+func (b *batcher) flush(current *batch) {
+	b.mu.Lock()
+	if b.current != current {
+		b.mu.Unlock()
+		return
+	}
+	current.sent = true
+	b.current = nil
+	b.mu.Unlock()
+
+	b.send(current)
+}
+
+// send reserves the batch in one call and hands every reservation its result
+func (b *batcher) send(current *batch) {
+	requests := make([]domain.ReservationRequest, len(current.calls))
+	for i, call := range current.calls {
+		requests[i] = call.request
+	}
+
+	results, err := b.svc.ReserveItems(current.ctx, requests)
+	if err == nil && len(results) != len(requests) {
+		err = fmt.Errorf("reservation service returned %d results for %d reservations", len(results), len(requests))
+	}
+	for i, call := range current.calls {
+		if err != nil {
+			call.done <- domain.ReservationResult{Err: err}
+			continue
+		}
+		call.done <- results[i]
+	}
+}
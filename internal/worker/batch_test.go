@@ -0,0 +1,101 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// heldBatchService records the items of every batch, a batch waits until release is closed
+type heldBatchService struct {
+	MockReservationService
+	release chan struct{}
+	mu      sync.Mutex
+	batches [][]string
+}
+
+func (s *heldBatchService) ReserveItems(ctx context.Context, requests []domain.ReservationRequest) ([]domain.ReservationResult, error) {
+	names := make([]string, len(requests))
+	results := make([]domain.ReservationResult, len(requests))
+	for i, request := range requests {
+		names[i] = request.ItemName
+		results[i].ReservationID = "RSV-" + request.ItemName
+	}
+	s.mu.Lock()
+	s.batches = append(s.batches, names)
+	s.mu.Unlock()
+
+	<-s.release
+	return results, nil
+}
+
+func (s *heldBatchService) sent() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.batches...)
+}
+
+func TestBatcherDropsCancelledReservations(t *testing.T) {
+	svc := &heldBatchService{release: make(chan struct{})}
+	close(svc.release)
+	reservations := newBatcher(svc, 3, 100*time.Millisecond)
+
+	// the laptop gives up before its batch is sent, it isn't reserved
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, err := reservations.reserve(ctx, "laptop", 1)
+		cancelled <- err
+	}()
+	require.Eventually(t, func() bool {
+		reservations.mu.Lock()
+		defer reservations.mu.Unlock()
+		return reservations.current != nil && len(reservations.current.calls) == 1
+	}, time.Second, 5*time.Millisecond)
+	cancel()
+	assert.ErrorIs(t, <-cancelled, context.Canceled)
+
+	id, err := reservations.reserve(context.Background(), "phone", 1)
+	require.NoError(t, err)
+	assert.Equal(t, "RSV-phone", id)
+	assert.Equal(t, [][]string{{"phone"}}, svc.sent())
+}
+
+func TestBatcherWaitsForSentReservations(t *testing.T) {
+	svc := &heldBatchService{release: make(chan struct{})}
+	reservations := newBatcher(svc, 2, time.Minute)
+
+	// the batch is full and on its way when the laptop gives up, the reservation made for it isn't lost
+	ctx, cancel := context.WithCancel(context.Background())
+	type result struct {
+		id  string
+		err error
+	}
+	results := make(chan result, 2)
+	for _, name := range []string{"laptop", "phone"} {
+		reserveCtx := context.Background()
+		if name == "laptop" {
+			reserveCtx = ctx
+		}
+		go func() {
+			id, err := reservations.reserve(reserveCtx, name, 1)
+			results <- result{id, err}
+		}()
+	}
+	require.Eventually(t, func() bool { return len(svc.sent()) == 1 }, time.Second, 5*time.Millisecond)
+	cancel()
+
+	select {
+	case r := <-results:
+		t.Fatalf("a reservation returned before its batch was answered: %v", r)
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(svc.release)
+	ids := []string{(<-results).id, (<-results).id}
+	assert.ElementsMatch(t, []string{"RSV-laptop", "RSV-phone"}, ids)
+}
@@ -0,0 +1,183 @@
+package worker
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/adapters/queue"
+	"github.com/a-berahman/shopping-cart/internal/adapters/reservation/mock"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/a-berahman/shopping-cart/internal/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryRepository keeps items in a map, the flows below only need what the service and worker call
+type memoryRepository struct {
+	mu    sync.Mutex
+	items map[int64]*domain.Item
+}
+
+func newMemoryRepository() *memoryRepository {
+	return &memoryRepository{items: make(map[int64]*domain.Item)}
+}
+
+func (r *memoryRepository) CreateItem(ctx context.Context, item *domain.Item) error {
+	return r.CreateItems(ctx, []*domain.Item{item})
+}
+
+func (r *memoryRepository) CreateItems(ctx context.Context, items []*domain.Item) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, item := range items {
+		item.ID = int64(len(r.items) + 1)
+		stored := *item
+		r.items[item.ID] = &stored
+	}
+	return nil
+}
+
+func (r *memoryRepository) ListItems(ctx context.Context, ownerID string) ([]domain.Item, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var items []domain.Item
+	for _, item := range r.items {
+		if item.OwnerID == ownerID {
+			items = append(items, *item)
+		}
+	}
+	return items, nil
+}
+
+func (r *memoryRepository) GetItem(ctx context.Context, id int64) (*domain.Item, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.items[id]
+	if !ok {
+		return nil, domain.ErrItemNotFound
+	}
+	stored := *item
+	return &stored, nil
+}
+
+func (r *memoryRepository) UpdateItemStatus(ctx context.Context, id int64, status domain.ItemStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.items[id]
+	if !ok {
+		return domain.ErrItemNotFound
+	}
+	item.Status = status
+	item.FailureReason = nil
+	return nil
+}
+
+func (r *memoryRepository) UpdateItemReservation(ctx context.Context, id int64, reservationID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.items[id]
+	if !ok {
+		return domain.ErrItemNotFound
+	}
+	item.ReservationID = &reservationID
+	item.Status = domain.StatusReservationReserved
+	item.FailureReason = nil
+	return nil
+}
+
+func (r *memoryRepository) FailItem(ctx context.Context, id int64, reason string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	item, ok := r.items[id]
+	if !ok {
+		return domain.ErrItemNotFound
+	}
+	item.Status = domain.StatusReservationFailed
+	item.FailureReason = &reason
+	return nil
+}
+
+func TestAddAndReserveFlow(t *testing.T) {
+	tests := []struct {
+		name       string
+		itemName   string
+		quantity   int
+		wantStatus domain.ItemStatus
+	}{
+		{name: "available item is reserved", itemName: "laptop", quantity: 2, wantStatus: domain.StatusReservationReserved},
+		{name: "too many of an item are unavailable", itemName: "phone", quantity: 50, wantStatus: domain.StatusReservationUnavailable},
+		{name: "unknown item is unavailable", itemName: "unicorn", quantity: 1, wantStatus: domain.StatusReservationUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// the whole flow runs in the process, the memory queue takes the place of redis
+			repo := newMemoryRepository()
+			jobs := queue.NewMemoryQueue(config.QueueConfig{VisibilityTimeout: time.Minute})
+			reservationSvc := mock.NewMockReservationService(mock.MockConfig{})
+			cartService := service.NewCartService(repo, jobs, reservationSvc)
+
+			worker := NewReservationWorker(jobs, reservationSvc, repo, Config{MaxAttempts: 3})
+			worker.Start(ctx)
+			defer worker.Stop()
+
+			userCtx := domain.ContextWithPrincipal(ctx, domain.Principal{Subject: "user-1"})
+			item, err := cartService.AddItemToCart(userCtx, tt.itemName, tt.quantity)
+			require.NoError(t, err)
+			assert.Equal(t, domain.StatusReservationPending, item.Status)
+
+			assert.Eventually(t, func() bool {
+				stored, err := cartService.GetCartItem(userCtx, item.ID)
+				return err == nil && stored.Status == tt.wantStatus
+			}, 2*time.Second, 10*time.Millisecond)
+
+			// every job the flow created completed
+			assert.Eventually(t, func() bool {
+				depths, err := jobs.QueueDepths(ctx)
+				return err == nil && depths[domain.JobStatusCompleted] > 0 &&
+					depths[domain.JobStatusPending] == 0 && depths[domain.JobStatusProcessing] == 0
+			}, 2*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestAddManyAndReserveFlow(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	repo := newMemoryRepository()
+	jobs := queue.NewMemoryQueue(config.QueueConfig{VisibilityTimeout: time.Minute})
+	reservationSvc := mock.NewMockReservationService(mock.MockConfig{})
+	cartService := service.NewCartService(repo, jobs, reservationSvc)
+
+	worker := NewReservationWorker(jobs, reservationSvc, repo, Config{MaxAttempts: 3})
+	worker.Start(ctx)
+	defer worker.Stop()
+
+	userCtx := domain.ContextWithPrincipal(ctx, domain.Principal{Subject: "user-1"})
+	items, err := cartService.AddItemsToCart(userCtx, []domain.CartLine{
+		{Name: "laptop", Quantity: 1},
+		{Name: "tablet", Quantity: 3},
+		{Name: "headphones", Quantity: 5},
+	})
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool {
+		listed, err := cartService.ListCartItems(userCtx)
+		if err != nil || len(listed) != len(items) {
+			return false
+		}
+		for _, item := range listed {
+			if item.Status != domain.StatusReservationReserved || item.ReservationID == nil {
+				return false
+			}
+		}
+		return true
+	}, 2*time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,53 @@
+package worker
+
+import "sync"
+
+// itemGate lets one worker of the pool at a time process the jobs of an item, the others wait their turn
+// in the order they took it. queues that hold an item while its job is in flight never hand out a second
+// job of it, the gate keeps the jobs of an item apart on the streams queue too
+type itemGate struct {
+	mu sync.Mutex
+	// turns holds the turns taken on every item in progress, the turn of the worker processing it first
+	turns map[int64][]chan struct{}
+}
+
+func newItemGate() *itemGate {
+	return &itemGate{turns: make(map[int64][]chan struct{})}
+}
+
+// take queues up for the item, the returned channel is closed once it is the caller's turn
+func (g *itemGate) take(item int64) <-chan struct{} {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	turn := make(chan struct{})
+	if len(g.turns[item]) == 0 {
+		close(turn)
+	}
+	g.turns[item] = append(g.turns[item], turn)
+	return turn
+}
+
+// leave gives up the turn on the item, a caller that gives up before its turn came leaves the line.
+// the next turn starts once the caller whose turn it is leaves
+func (g *itemGate) leave(item int64, turn <-chan struct{}) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	turns := g.turns[item]
+	for i, t := range turns {
+		if t != turn {
+			continue
+		}
+		turns = append(turns[:i:i], turns[i+1:]...)
+		if i == 0 && len(turns) > 0 {
+			close(turns[0])
+		}
+		break
+	}
+	if len(turns) == 0 {
+		delete(g.turns, item)
+		return
+	}
+	g.turns[item] = turns
+}
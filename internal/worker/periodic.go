@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"time"
+)
+
+// periodic runs a task every interval in its own goroutine until its context is done or it is stopped.
+// every worker instance runs the periodic tasks of its own, the queue and the outbox make sure
+// they don't step on each other
+type periodic struct {
+	interval time.Duration
+	task     func(ctx context.Context)
+
+	stop context.CancelFunc
+	done chan struct{}
+}
+
+// Start begins running the task in a separate goroutine
+func (p *periodic) Start(ctx context.Context) {
+	ctx, p.stop = context.WithCancel(ctx)
+	p.done = make(chan struct{})
+
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.task(ctx)
+			}
+		}
+	}()
+}
+
+// Stop stops running the task and waits for a run in progress, so it doesn't outlive
+// the connections closed after it. the run sees its context cancelled
+func (p *periodic) Stop() {
+	if p.stop == nil {
+		return
+	}
+	p.stop()
+	<-p.done
+}
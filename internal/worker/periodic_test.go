@@ -0,0 +1,36 @@
+package worker
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPeriodicStopWaitsForRun(t *testing.T) {
+	started := make(chan struct{})
+	var finished atomic.Bool
+	p := &periodic{interval: 5 * time.Millisecond, task: func(ctx context.Context) {
+		select {
+		case started <- struct{}{}:
+		default:
+			return
+		}
+		// a run takes a moment to wind down once it is cancelled, like a transaction rolling back
+		<-ctx.Done()
+		time.Sleep(20 * time.Millisecond)
+		finished.Store(true)
+	}}
+
+	p.Start(context.Background())
+	<-started
+	p.Stop()
+	assert.True(t, finished.Load(), "Stop returned before the run in progress")
+}
+
+func TestPeriodicStopWithoutStart(t *testing.T) {
+	p := &periodic{interval: time.Second, task: func(context.Context) {}}
+	p.Stop()
+}
@@ -0,0 +1,29 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/ports"
+)
+
+// Promoter moves delayed retries to the pending queue once their backoff passed,
+// the queue promotes a job only once
+type Promoter struct {
+	periodic
+	queue ports.Queue
+}
+
+// NewPromoter creates a promoter that looks for due jobs every interval
+func NewPromoter(queue ports.Queue, interval time.Duration) *Promoter {
+	p := &Promoter{queue: queue}
+	p.periodic = periodic{interval: interval, task: p.promote}
+	return p
+}
+
+func (p *Promoter) promote(ctx context.Context) {
+	if _, err := p.queue.PromoteDueJobs(ctx); err != nil {
+		log.Printf("Error promoting delayed jobs: %v", err)
+	}
+}
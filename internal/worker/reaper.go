@@ -0,0 +1,33 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/ports"
+)
+
+// Reaper requeues jobs whose lease expired, their worker crashed or hung mid-job.
+// the queue requeues a job only once, however many reapers see it expire
+type Reaper struct {
+	periodic
+	queue ports.Queue
+}
+
+// NewReaper creates a reaper that looks for expired leases every interval
+func NewReaper(queue ports.Queue, interval time.Duration) *Reaper {
+	r := &Reaper{queue: queue}
+	r.periodic = periodic{interval: interval, task: r.reap}
+	return r
+}
+
+func (r *Reaper) reap(ctx context.Context) {
+	requeued, err := r.queue.RequeueExpiredJobs(ctx)
+	if err != nil {
+		log.Printf("Error requeuing expired jobs: %v", err)
+	}
+	if requeued > 0 {
+		log.Printf("Requeued %d jobs whose lease expired", requeued)
+	}
+}
@@ -0,0 +1,78 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/adapters/queue"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReaperRecoversJobOfKilledWorker kills a worker mid-job and checks the reaper hands the job to another worker
+func TestReaperRecoversJobOfKilledWorker(t *testing.T) {
+	mr := miniredis.RunT(t)
+	now := time.Now()
+	mr.SetTime(now)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	redisQueue, err := queue.NewRedisQueue("redis://"+mr.Addr(), config.QueueConfig{VisibilityTimeout: time.Minute})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	job := &domain.ReservationJob{ID: "job-1", ItemID: 1, ItemName: "Test Item", Quantity: 1, JobType: domain.JobTypeReservation}
+	require.NoError(t, redisQueue.EnqueueReservation(ctx, job))
+
+	// the first worker hangs in the reservation service without heartbeats, as if it was killed.
+	// a stopped worker would put the job back, a killed one can't
+	started, killed := make(chan struct{}), make(chan struct{})
+	hangingSvc := new(MockReservationService)
+	hangingSvc.On("ReserveItem", mock.Anything, "Test Item", 1).Run(func(args mock.Arguments) {
+		close(started)
+		<-killed
+	}).Return("", context.Canceled)
+	repo := new(MockRepository)
+
+	hungWorker := NewReservationWorker(redisQueue, hangingSvc, repo, Config{})
+	hungWorker.Start(ctx)
+	t.Cleanup(hungWorker.Stop)
+	t.Cleanup(func() { close(killed) })
+	<-started
+
+	// the killed worker can't requeue or finish the job, it stays leased
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, int64(1), client.ZCard(ctx, queue.LeasesKey).Val())
+	assert.Equal(t, int64(0), client.LLen(ctx, queue.ReservationQueueKey).Val())
+
+	reaper := NewReaper(redisQueue, time.Hour)
+	reaper.reap(ctx)
+	assert.Equal(t, int64(1), client.ZCard(ctx, queue.LeasesKey).Val(), "the lease hasn't expired yet")
+
+	mr.SetTime(now.Add(2 * time.Minute))
+	reaper.reap(ctx)
+	assert.Equal(t, int64(0), client.ZCard(ctx, queue.LeasesKey).Val())
+
+	// a healthy worker picks the job up with the attempt of the killed worker counted
+	reservationSvc := new(MockReservationService)
+	reservationSvc.On("ReserveItem", mock.Anything, "Test Item", 1).Return("res-1", nil)
+	repo.On("UpdateItemReservation", mock.Anything, int64(1), "res-1").Return(nil)
+
+	worker := NewReservationWorker(redisQueue, reservationSvc, repo, Config{})
+	worker.Start(ctx)
+	require.Eventually(t, func() bool {
+		return client.ZCard(ctx, queue.CompletedSetKey).Val() == 1
+	}, time.Second, 10*time.Millisecond)
+	worker.Stop()
+
+	completed, _, err := redisQueue.ListJobs(ctx, domain.JobStatusCompleted, 0, 10)
+	require.NoError(t, err)
+	require.Len(t, completed, 1)
+	assert.Equal(t, "job-1", completed[0].ID)
+	assert.Equal(t, 1, completed[0].Attempts)
+	reservationSvc.AssertExpectations(t)
+}
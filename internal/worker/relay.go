@@ -0,0 +1,70 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/internal/core/ports"
+)
+
+// RelayConfig configures the outbox relay
+type RelayConfig struct {
+	// Interval is how often the outbox is checked for unsent jobs
+	Interval time.Duration
+	// BatchSize is how many jobs are published at once
+	BatchSize int
+	// Retention is how long sent jobs are kept, zero keeps them
+	Retention time.Duration
+}
+
+// Relay publishes the jobs of the outbox to the queue, the outbox hands each unsent job
+// to one relay at a time
+type Relay struct {
+	periodic
+	outbox ports.Outbox
+	queue  ports.Queue
+	cfg    RelayConfig
+}
+
+// NewRelay creates a relay that moves jobs from outbox to queue
+func NewRelay(outbox ports.Outbox, queue ports.Queue, cfg RelayConfig) *Relay {
+	r := &Relay{outbox: outbox, queue: queue, cfg: cfg}
+	r.periodic = periodic{interval: cfg.Interval, task: r.relay}
+	return r
+}
+
+// relay publishes batches until the outbox is drained, a backlog shouldn't wait a tick per batch
+func (r *Relay) relay(ctx context.Context) {
+	defer r.logLag(ctx)
+
+	for {
+		sent, err := r.outbox.RelayJobs(ctx, r.cfg.BatchSize, r.queue.EnqueueReservations)
+		if err != nil {
+			log.Printf("Error relaying outbox jobs: %v", err)
+			return
+		}
+		if sent < r.cfg.BatchSize {
+			break
+		}
+	}
+
+	if r.cfg.Retention > 0 {
+		if _, err := r.outbox.TrimSentJobs(ctx, r.cfg.Retention); err != nil {
+			log.Printf("Error trimming sent outbox jobs: %v", err)
+		}
+	}
+}
+
+// logLag logs the jobs still waiting in the outbox after a run, there are no metrics to export them to.
+// a drained outbox isn't logged, a backlog that stays is the relay falling behind
+func (r *Relay) logLag(ctx context.Context) {
+	stats, err := r.outbox.OutboxStats(ctx)
+	if err != nil {
+		log.Printf("Error getting outbox stats: %v", err)
+		return
+	}
+	if stats.Pending > 0 {
+		log.Printf("Outbox has %d unsent jobs, the oldest waited %s", stats.Pending, stats.Lag)
+	}
+}
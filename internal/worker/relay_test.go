@@ -0,0 +1,93 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/adapters/queue"
+	"github.com/a-berahman/shopping-cart/internal/core/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryOutbox hands out its unsent jobs in order, a failed publish leaves them unsent
+type memoryOutbox struct {
+	mu      sync.Mutex
+	unsent  []*domain.ReservationJob
+	sent    int
+	trimmed bool
+}
+
+func (o *memoryOutbox) RelayJobs(ctx context.Context, limit int, publish func(ctx context.Context, jobs []*domain.ReservationJob) error) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	batch := o.unsent[:min(limit, len(o.unsent))]
+	if len(batch) == 0 {
+		return 0, nil
+	}
+	if err := publish(ctx, batch); err != nil {
+		return 0, err
+	}
+	o.unsent = o.unsent[len(batch):]
+	o.sent += len(batch)
+	return len(batch), nil
+}
+
+func (o *memoryOutbox) TrimSentJobs(ctx context.Context, maxAge time.Duration) (int, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.trimmed = true
+	return 0, nil
+}
+
+func (o *memoryOutbox) OutboxStats(ctx context.Context) (domain.OutboxStats, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return domain.OutboxStats{Pending: int64(len(o.unsent))}, nil
+}
+
+// failingQueue fails to enqueue until it is fixed
+type failingQueue struct {
+	*queue.MemoryQueue
+	broken bool
+}
+
+func (q *failingQueue) EnqueueReservations(ctx context.Context, jobs []*domain.ReservationJob) error {
+	if q.broken {
+		return errors.New("queue is unavailable")
+	}
+	return q.MemoryQueue.EnqueueReservations(ctx, jobs)
+}
+
+func TestRelayDrainsOutbox(t *testing.T) {
+	ctx := context.Background()
+	outbox := &memoryOutbox{}
+	for i := 1; i <= 5; i++ {
+		outbox.unsent = append(outbox.unsent, &domain.ReservationJob{ID: fmt.Sprintf("job-%d", i), ItemID: int64(i)})
+	}
+	jobs := &failingQueue{MemoryQueue: queue.NewMemoryQueue(config.QueueConfig{VisibilityTimeout: time.Minute}), broken: true}
+	relay := NewRelay(outbox, jobs, RelayConfig{Interval: time.Hour, BatchSize: 2, Retention: time.Hour})
+
+	// nothing is lost while the queue is down, the jobs stay in the outbox for the next run
+	relay.relay(ctx)
+	stats, err := outbox.OutboxStats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), stats.Pending)
+
+	// one run publishes every batch in the order the jobs were written
+	jobs.broken = false
+	relay.relay(ctx)
+	assert.Equal(t, 5, outbox.sent)
+	assert.True(t, outbox.trimmed)
+	for i := 1; i <= 5; i++ {
+		job, err := jobs.DequeueReservation(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(i), job.ItemID)
+	}
+}
@@ -2,8 +2,10 @@ package worker
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/a-berahman/shopping-cart/internal/core/domain"
@@ -11,14 +13,49 @@ import (
 	"github.com/google/uuid"
 )
 
+// defaultMaxAttempts applies when Config leaves MaxAttempts zero
+const defaultMaxAttempts = 3
+
+// Config configures the worker
+type Config struct {
+	// MaxAttempts is how often a job is attempted before it fails for good
+	MaxAttempts int
+	// Backoff delays retries of failed jobs, nil retries right away
+	Backoff Backoff
+	// HeartbeatInterval is how often the lease of the job in progress is extended, zero disables heartbeats
+	HeartbeatInterval time.Duration
+	// Concurrency is how many workers of the pool process jobs at once, zero runs one
+	Concurrency int
+	// DrainTimeout is how long Stop waits for the jobs in progress, the jobs still running then are put back
+	DrainTimeout time.Duration
+	// BatchSize is how many reservations of the pool are sent in one call at most, one or zero sends them
+	// one by one. a batch is sent once it's full or BatchWait passed since its first reservation
+	BatchSize int
+	BatchWait time.Duration
+}
+
 // ReservationWorker is responsible for processing reservation jobs from the queue
 type ReservationWorker struct {
-	queue          ports.Queue
-	reservationSvc ports.ReservationService
-	repository     ports.Repository
-	maxRetries     int
-	retryDelay     time.Duration
-	shutdownCh     chan struct{}
+	queue             ports.Queue
+	reservationSvc    ports.ReservationService
+	repository        ports.Repository
+	maxRetries        int
+	backoff           Backoff
+	heartbeatInterval time.Duration
+	concurrency       int
+	drainTimeout      time.Duration
+	// circuit is the circuit breaker of reservationSvc, nil when it has none
+	circuit ports.ReservationCircuit
+	// batcher batches the reservations of the pool, nil when they are sent one by one
+	batcher *batcher
+	// items lets one worker of the pool at a time process the jobs of an item,
+	// dequeueMu makes the workers take their turns in the order they dequeued the jobs
+	items     *itemGate
+	dequeueMu sync.Mutex
+	// stopDequeue stops the dequeue loops, cancelJobs cancels the jobs in progress once the drain timed out
+	stopDequeue context.CancelFunc
+	cancelJobs  context.CancelFunc
+	running     sync.WaitGroup
 }
 
 // NewReservationWorker creates a new instance of ReservationWorker
@@ -26,73 +63,237 @@ func NewReservationWorker(
 	queue ports.Queue,
 	reservationSvc ports.ReservationService,
 	repository ports.Repository,
+	cfg Config,
 ) *ReservationWorker {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = defaultMaxAttempts
+	}
+	if cfg.Concurrency <= 0 {
+		cfg.Concurrency = 1
+	}
+
+	circuit, _ := reservationSvc.(ports.ReservationCircuit)
+	var reservations *batcher
+	if cfg.BatchSize > 1 {
+		reservations = newBatcher(reservationSvc, cfg.BatchSize, cfg.BatchWait)
+	}
+
 	return &ReservationWorker{
-		queue:          queue,
-		reservationSvc: reservationSvc,
-		repository:     repository,
-		maxRetries:     3,               // TODO: we nee to make this configurable
-		retryDelay:     time.Second * 5, // TODO: we nee to make this configurable
-		shutdownCh:     make(chan struct{}),
+		queue:             queue,
+		reservationSvc:    reservationSvc,
+		circuit:           circuit,
+		batcher:           reservations,
+		repository:        repository,
+		maxRetries:        cfg.MaxAttempts,
+		backoff:           cfg.Backoff,
+		heartbeatInterval: cfg.HeartbeatInterval,
+		concurrency:       cfg.Concurrency,
+		drainTimeout:      cfg.DrainTimeout,
+		items:             newItemGate(),
 	}
 }
 
-// Start begins processing jobs from the queue in a separate goroutine
+// Start begins processing jobs from the queue with the pool of workers, each in a separate goroutine
+// with its own dequeue loop, every worker processes one job at a time
 // Why a separate goroutine?
 // - So that we can handle shutdown gracefully
 // - So that we can log errors
 func (w *ReservationWorker) Start(ctx context.Context) {
-	go func() {
-		for {
-			select {
-			case <-ctx.Done(): // Stop processing if context is cancelled
-				return
-			case <-w.shutdownCh: // stop processing if shutdown signal is received
-				return
-			default:
-				if err := w.processNextJob(ctx); err != nil {
-					log.Printf("Error processing job: %v", err)
-				}
+	dequeueCtx, stopDequeue := context.WithCancel(ctx)
+	jobCtx, cancelJobs := context.WithCancel(ctx)
+	w.stopDequeue, w.cancelJobs = stopDequeue, cancelJobs
+
+	for id := 1; id <= w.concurrency; id++ {
+		w.running.Add(1)
+		go func() {
+			defer w.running.Done()
+			w.run(dequeueCtx, jobCtx, id)
+		}()
+	}
+}
+
+// run is the dequeue loop of the worker id of the pool, it dequeues jobs until dequeueCtx is done
+// and processes them with jobCtx
+func (w *ReservationWorker) run(dequeueCtx, jobCtx context.Context, id int) {
+	for dequeueCtx.Err() == nil {
+		job, turn, err := w.dequeue(dequeueCtx)
+		if err != nil {
+			// a dequeue cut off by Stop is no error
+			if dequeueCtx.Err() == nil {
+				logf(id, "Error processing job: %v", err)
 			}
+			continue
 		}
-	}()
+		if err := w.handleJob(jobCtx, id, job, turn); err != nil {
+			logf(id, "Error processing job %s: %v", job.ID, err)
+		}
+	}
 }
 
-// Stop signals the worker to stop processing jobs
+// Stop stops dequeuing and waits up to the drain timeout for the jobs in progress, the jobs still running
+// then are cancelled and put back on the queue. it returns once every worker of the pool exited
 func (w *ReservationWorker) Stop() {
-	close(w.shutdownCh)
+	if w.stopDequeue == nil {
+		return
+	}
+	w.stopDequeue()
+	defer w.cancelJobs()
+
+	drained := make(chan struct{})
+	go func() {
+		w.running.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		return
+	case <-time.After(w.drainTimeout):
+	}
+	log.Printf("Worker drain timed out after %s, putting unfinished jobs back", w.drainTimeout)
+	w.cancelJobs()
+	<-drained
 }
 
-// processNextJob processes the next job from the queue with retry logic
-func (w *ReservationWorker) processNextJob(ctx context.Context) error {
-	job, err := w.queue.DequeueReservation(ctx)
-	if err != nil {
-		return err
+// handleJob processes a dequeued job with retry logic once it's the turn of the job on its item.
+// a job ctx cancels before it's done is put back on the queue unfinished
+func (w *ReservationWorker) handleJob(ctx context.Context, id int, job *domain.ReservationJob, turn <-chan struct{}) error {
+	defer w.items.leave(job.ItemID, turn)
+	// the outcome of the job is filed even if ctx is cancelled meanwhile
+	queueCtx := context.WithoutCancel(ctx)
+
+	// wait until the jobs of its item dequeued before are done, the heartbeat keeps the reaper
+	// from requeuing it meanwhile and while it's processed
+	stopHeartbeat := w.heartbeat(ctx, id, job)
+	select {
+	case <-turn:
+	case <-ctx.Done():
+		stopHeartbeat()
+		return w.putBack(queueCtx, id, job)
 	}
 
 	// check if the job can be retried based on domain rules
 	if !job.CanRetry(w.maxRetries) {
-		log.Printf("Job %s exceeded maximum retry attempts", job.ID)
-		return w.queue.FailJob(ctx, job)
+		stopHeartbeat()
+		logf(id, "Job %s exceeded maximum retry attempts", job.ID)
+		return w.failJob(queueCtx, job, "the reservation was attempted too often")
 	}
 
 	// attempt to process the job
-	err = w.processJob(ctx, job)
+	err := w.processJob(ctx, job)
+	stopHeartbeat()
+	if err != nil && (ctx.Err() != nil || errors.Is(err, domain.ErrCircuitOpen)) {
+		// the job was cut off or the circuit opened before it was attempted, that's no failed attempt
+		return w.putBack(queueCtx, id, job)
+	}
 	if err != nil {
 		job.Attempts++
 		job.LastAttempted = time.Now()
+		job.RecordFailure(err.Error(), domain.ClassifyJobError(err), job.LastAttempted)
 
-		// if job can still be retried, requeue it
+		// retrying won't fix a permanent error, the job fails right away
+		if domain.ClassifyReservationError(err) == domain.ReservationErrorPermanent {
+			logf(id, "Job %s failed permanently: %v", job.ID, err)
+			return w.failJob(queueCtx, job, domain.FailureReason(err))
+		}
+
+		// if job can still be retried, requeue it after the backoff, so a slow service isn't hammered
 		if job.CanRetry(w.maxRetries) {
-			log.Printf("Retrying job %s, attempt %d of %d", job.ID, job.Attempts, w.maxRetries)
-			return w.queue.EnqueueReservation(ctx, job)
+			delay := w.retryDelay(job.Attempts, err)
+			logf(id, "Retrying job %s in %s, attempt %d of %d", job.ID, delay, job.Attempts, w.maxRetries)
+			return w.queue.RequeueJob(queueCtx, job, delay)
 		}
 
-		// job has exhausted all retries
-		return w.queue.FailJob(ctx, job)
+		// job has exhausted all retries, it is dead-lettered with its error for operators to replay
+		logf(id, "Job %s failed after %d attempts: %v", job.ID, job.Attempts, err)
+		return w.failJob(queueCtx, job, domain.FailureReason(err))
 	}
 
-	return w.queue.CompleteJob(ctx, job)
+	return w.queue.CompleteJob(queueCtx, job)
+}
+
+// retryDelay is how long a failed job waits before its next attempt, the backoff or the time
+// a rate limited service asked for, whichever is longer
+func (w *ReservationWorker) retryDelay(attempts int, err error) time.Duration {
+	var delay time.Duration
+	if w.backoff != nil {
+		delay = w.backoff.Delay(attempts)
+	}
+	return max(delay, domain.RetryAfterOf(err))
+}
+
+// failJob dead-letters the job and marks its item as failed for reason. only the final failure of a job
+// fails its item, the item keeps its status while the job is retried
+func (w *ReservationWorker) failJob(ctx context.Context, job *domain.ReservationJob, reason string) error {
+	var errs []error
+	if err := w.repository.FailItem(ctx, job.ItemID, reason); err != nil {
+		errs = append(errs, fmt.Errorf("failed to mark item %d as failed: %w", job.ItemID, err))
+	}
+	if err := w.queue.FailJob(ctx, job); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// putBack returns a job the worker didn't finish to the queue right away, it keeps its attempts
+func (w *ReservationWorker) putBack(ctx context.Context, id int, job *domain.ReservationJob) error {
+	logf(id, "Putting back unfinished job %s", job.ID)
+	return w.queue.RequeueJob(ctx, job, 0)
+}
+
+// dequeue takes the next job from the queue and a turn on its item, the workers of the pool dequeue one at a time.
+// while the circuit of the reservation service is open the jobs stay queued
+func (w *ReservationWorker) dequeue(ctx context.Context) (*domain.ReservationJob, <-chan struct{}, error) {
+	if w.circuit != nil {
+		if err := w.circuit.Wait(ctx); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	w.dequeueMu.Lock()
+	defer w.dequeueMu.Unlock()
+
+	job, err := w.queue.DequeueReservation(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return job, w.items.take(job.ItemID), nil
+}
+
+// heartbeat extends the lease of the job until the returned func is called.
+// a lost lease is only logged, the job was requeued and may be processed twice
+func (w *ReservationWorker) heartbeat(ctx context.Context, id int, job *domain.ReservationJob) (stop func()) {
+	if w.heartbeatInterval <= 0 {
+		return func() {}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ticker := time.NewTicker(w.heartbeatInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := w.queue.ExtendLease(ctx, job); err != nil {
+					logf(id, "Error extending lease of job %s: %v", job.ID, err)
+				}
+			}
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// logf logs for the worker id of the pool
+func logf(id int, format string, args ...interface{}) {
+	log.Printf("[worker %d] "+format, append([]interface{}{id}, args...)...)
 }
 
 func (w *ReservationWorker) processJob(ctx context.Context, job *domain.ReservationJob) error {
@@ -109,7 +310,6 @@ func (w *ReservationWorker) processJob(ctx context.Context, job *domain.Reservat
 func (w *ReservationWorker) processAvailabilityCheck(ctx context.Context, job *domain.ReservationJob) error {
 	available, err := w.reservationSvc.CheckAvailability(ctx, job.ItemName, job.Quantity)
 	if err != nil {
-		_ = w.repository.UpdateItemStatus(ctx, job.ItemID, domain.StatusReservationFailed)
 		return err
 	}
 
@@ -124,6 +324,8 @@ func (w *ReservationWorker) processAvailabilityCheck(ctx context.Context, job *d
 		Quantity: job.Quantity,
 		JobType:  domain.JobTypeReservation,
 		Status:   domain.JobStatusPending,
+		// the reservation is as urgent as the check it follows
+		Priority: job.Priority,
 	}
 
 	if err := w.repository.UpdateItemStatus(ctx, job.ItemID, domain.StatusReservationAvailable); err != nil {
@@ -134,11 +336,20 @@ func (w *ReservationWorker) processAvailabilityCheck(ctx context.Context, job *d
 }
 
 func (w *ReservationWorker) processReservation(ctx context.Context, job *domain.ReservationJob) error {
-	reservationID, err := w.reservationSvc.ReserveItem(ctx, job.ItemName, job.Quantity)
+	reservationID, err := w.reserveItem(ctx, job)
 	if err != nil {
-		_ = w.repository.UpdateItemStatus(ctx, job.ItemID, domain.StatusReservationFailed)
 		return err
 	}
 
-	return w.repository.UpdateItemReservation(ctx, job.ItemID, reservationID)
+	// a reservation that was made is recorded even if the job is cut off meanwhile, putting the job back
+	// would reserve the item again
+	return w.repository.UpdateItemReservation(context.WithoutCancel(ctx), job.ItemID, reservationID)
+}
+
+// reserveItem reserves the item of the job, in a batch with the reservations of the other workers when batching is on
+func (w *ReservationWorker) reserveItem(ctx context.Context, job *domain.ReservationJob) (string, error) {
+	if w.batcher == nil {
+		return w.reservationSvc.ReserveItem(ctx, job.ItemName, job.Quantity)
+	}
+	return w.batcher.reserve(ctx, job.ItemName, job.Quantity)
 }
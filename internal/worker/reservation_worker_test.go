@@ -2,11 +2,17 @@ package worker
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/a-berahman/shopping-cart/config"
+	"github.com/a-berahman/shopping-cart/internal/adapters/queue"
 	"github.com/a-berahman/shopping-cart/internal/core/domain"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type MockQueue struct {
@@ -26,6 +32,11 @@ func (m *MockQueue) DequeueReservation(ctx context.Context) (*domain.Reservation
 	return args.Get(0).(*domain.ReservationJob), args.Error(1)
 }
 
+func (m *MockQueue) EnqueueReservations(ctx context.Context, jobs []*domain.ReservationJob) error {
+	args := m.Called(ctx, jobs)
+	return args.Error(0)
+}
+
 func (m *MockQueue) CompleteJob(ctx context.Context, job *domain.ReservationJob) error {
 	args := m.Called(ctx, job)
 	return args.Error(0)
@@ -36,11 +47,86 @@ func (m *MockQueue) FailJob(ctx context.Context, job *domain.ReservationJob) err
 	return args.Error(0)
 }
 
-func (m *MockQueue) RetryFailedJobs(ctx context.Context) error {
+func (m *MockQueue) RequeueJob(ctx context.Context, job *domain.ReservationJob, delay time.Duration) error {
+	args := m.Called(ctx, job, delay)
+	return args.Error(0)
+}
+
+func (m *MockQueue) PromoteDueJobs(ctx context.Context) (int, error) {
 	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockQueue) ExtendLease(ctx context.Context, job *domain.ReservationJob) error {
+	args := m.Called(ctx, job)
 	return args.Error(0)
 }
 
+func (m *MockQueue) RequeueExpiredJobs(ctx context.Context) (int, error) {
+	args := m.Called(ctx)
+	return args.Int(0), args.Error(1)
+}
+
+// fakeQueue hands out its jobs in order and then blocks until the worker stops. the embedded mock
+// checks what the worker filed, settled receives the ID of every job completed, failed or requeued
+type fakeQueue struct {
+	MockQueue
+	jobs    chan *domain.ReservationJob
+	settled chan string
+}
+
+func newFakeQueue(jobs ...*domain.ReservationJob) *fakeQueue {
+	q := &fakeQueue{jobs: make(chan *domain.ReservationJob, len(jobs)), settled: make(chan string, len(jobs))}
+	for _, job := range jobs {
+		q.jobs <- job
+	}
+	return q
+}
+
+func (q *fakeQueue) DequeueReservation(ctx context.Context) (*domain.ReservationJob, error) {
+	select {
+	case job := <-q.jobs:
+		return job, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (q *fakeQueue) CompleteJob(ctx context.Context, job *domain.ReservationJob) error {
+	defer q.settle(job)
+	return q.MockQueue.CompleteJob(ctx, job)
+}
+
+func (q *fakeQueue) FailJob(ctx context.Context, job *domain.ReservationJob) error {
+	defer q.settle(job)
+	return q.MockQueue.FailJob(ctx, job)
+}
+
+func (q *fakeQueue) RequeueJob(ctx context.Context, job *domain.ReservationJob, delay time.Duration) error {
+	defer q.settle(job)
+	return q.MockQueue.RequeueJob(ctx, job, delay)
+}
+
+func (q *fakeQueue) settle(job *domain.ReservationJob) {
+	select {
+	case q.settled <- job.ID:
+	default:
+	}
+}
+
+// runJobs starts the worker on the jobs of the queue and stops it once every one was filed
+func runJobs(t *testing.T, worker *ReservationWorker, queue *fakeQueue) {
+	worker.Start(context.Background())
+	defer worker.Stop()
+	for range cap(queue.settled) {
+		select {
+		case <-queue.settled:
+		case <-time.After(time.Second):
+			t.Fatal("the worker didn't file every job")
+		}
+	}
+}
+
 type MockReservationService struct {
 	mock.Mock
 }
@@ -55,6 +141,14 @@ func (m *MockReservationService) ReserveItem(ctx context.Context, itemName strin
 	return args.String(0), args.Error(1)
 }
 
+func (m *MockReservationService) ReserveItems(ctx context.Context, requests []domain.ReservationRequest) ([]domain.ReservationResult, error) {
+	args := m.Called(ctx, requests)
+	if args.Get(0) == nil {
+		return nil, args.Error(1)
+	}
+	return args.Get(0).([]domain.ReservationResult), args.Error(1)
+}
+
 type MockRepository struct {
 	mock.Mock
 }
@@ -64,8 +158,13 @@ func (m *MockRepository) CreateItem(ctx context.Context, item *domain.Item) erro
 	return args.Error(0)
 }
 
-func (m *MockRepository) ListItems(ctx context.Context) ([]domain.Item, error) {
-	args := m.Called(ctx)
+func (m *MockRepository) CreateItems(ctx context.Context, items []*domain.Item) error {
+	args := m.Called(ctx, items)
+	return args.Error(0)
+}
+
+func (m *MockRepository) ListItems(ctx context.Context, ownerID string) ([]domain.Item, error) {
+	args := m.Called(ctx, ownerID)
 	return args.Get(0).([]domain.Item), args.Error(1)
 }
 
@@ -87,111 +186,564 @@ func (m *MockRepository) UpdateItemReservation(ctx context.Context, id int64, re
 	return args.Error(0)
 }
 
-func TestProcessNextJob(t *testing.T) {
+func (m *MockRepository) FailItem(ctx context.Context, id int64, reason string) error {
+	args := m.Called(ctx, id, reason)
+	return args.Error(0)
+}
+
+func TestWorkerProcessesJobs(t *testing.T) {
 	tests := []struct {
 		name       string
-		setupMocks func(*MockQueue, *MockReservationService, *MockRepository)
-		wantErr    bool
+		job        *domain.ReservationJob
+		setupMocks func(*MockQueue, *MockReservationService, *MockRepository, *domain.ReservationJob)
 	}{
 		{
 			name: "successful availability check",
-			setupMocks: func(queue *MockQueue, resSvc *MockReservationService, repo *MockRepository) {
-				job := &domain.ReservationJob{
-					ID:       "test-job",
-					ItemID:   1,
-					ItemName: "Test Item",
-					Quantity: 1,
-					JobType:  domain.JobTypeAvailabilityCheck,
-					Status:   domain.JobStatusPending,
-				}
-
-				queue.On("DequeueReservation", mock.Anything).Return(job, nil)
+			job: &domain.ReservationJob{
+				ID:       "test-job",
+				ItemID:   1,
+				ItemName: "Test Item",
+				Quantity: 1,
+				JobType:  domain.JobTypeAvailabilityCheck,
+				Status:   domain.JobStatusPending,
+				Priority: domain.JobPriorityInteractive,
+			},
+			setupMocks: func(queue *MockQueue, resSvc *MockReservationService, repo *MockRepository, job *domain.ReservationJob) {
 				resSvc.On("CheckAvailability", mock.Anything, "Test Item", 1).Return(true, nil)
 				repo.On("UpdateItemStatus", mock.Anything, int64(1), domain.StatusReservationAvailable).Return(nil)
 				queue.On("EnqueueReservation", mock.Anything, mock.MatchedBy(func(j *domain.ReservationJob) bool {
-					return j.JobType == domain.JobTypeReservation
+					return j.JobType == domain.JobTypeReservation && j.Priority == domain.JobPriorityInteractive
 				})).Return(nil)
 				queue.On("CompleteJob", mock.Anything, job).Return(nil)
 			},
-			wantErr: false,
 		},
 		{
 			name: "successful reservation",
-			setupMocks: func(queue *MockQueue, resSvc *MockReservationService, repo *MockRepository) {
-				job := &domain.ReservationJob{
-					ID:       "test-job",
-					ItemID:   1,
-					ItemName: "Test Item",
-					Quantity: 1,
-					JobType:  domain.JobTypeReservation,
-					Status:   domain.JobStatusPending,
-				}
-
-				queue.On("DequeueReservation", mock.Anything).Return(job, nil)
+			job: &domain.ReservationJob{
+				ID:       "test-job",
+				ItemID:   1,
+				ItemName: "Test Item",
+				Quantity: 1,
+				JobType:  domain.JobTypeReservation,
+				Status:   domain.JobStatusPending,
+			},
+			setupMocks: func(queue *MockQueue, resSvc *MockReservationService, repo *MockRepository, job *domain.ReservationJob) {
 				resSvc.On("ReserveItem", mock.Anything, "Test Item", 1).Return("res123", nil)
 				repo.On("UpdateItemReservation", mock.Anything, int64(1), "res123").Return(nil)
 				queue.On("CompleteJob", mock.Anything, job).Return(nil)
 			},
-			wantErr: false,
 		},
 		{
 			name: "item not available",
-			setupMocks: func(queue *MockQueue, resSvc *MockReservationService, repo *MockRepository) {
-				job := &domain.ReservationJob{
-					ID:       "test-job",
-					ItemID:   1,
-					ItemName: "Test Item",
-					Quantity: 1,
-					JobType:  domain.JobTypeAvailabilityCheck,
-					Status:   domain.JobStatusPending,
-				}
-
-				queue.On("DequeueReservation", mock.Anything).Return(job, nil)
+			job: &domain.ReservationJob{
+				ID:       "test-job",
+				ItemID:   1,
+				ItemName: "Test Item",
+				Quantity: 1,
+				JobType:  domain.JobTypeAvailabilityCheck,
+				Status:   domain.JobStatusPending,
+			},
+			setupMocks: func(queue *MockQueue, resSvc *MockReservationService, repo *MockRepository, job *domain.ReservationJob) {
 				resSvc.On("CheckAvailability", mock.Anything, "Test Item", 1).Return(false, nil)
 				repo.On("UpdateItemStatus", mock.Anything, int64(1), domain.StatusReservationUnavailable).Return(nil)
 				queue.On("CompleteJob", mock.Anything, job).Return(nil)
 			},
-			wantErr: false,
 		},
 		{
 			name: "retry exceeded",
-			setupMocks: func(queue *MockQueue, resSvc *MockReservationService, repo *MockRepository) {
-				job := &domain.ReservationJob{
-					ID:       "test-job",
-					ItemID:   1,
-					ItemName: "Test Item",
-					Quantity: 1,
-					JobType:  domain.JobTypeAvailabilityCheck,
-					Status:   domain.JobStatusPending,
-					Attempts: 3,
-				}
+			job: &domain.ReservationJob{
+				ID:       "test-job",
+				ItemID:   1,
+				ItemName: "Test Item",
+				Quantity: 1,
+				JobType:  domain.JobTypeAvailabilityCheck,
+				Status:   domain.JobStatusPending,
+				Attempts: 3,
+			},
+			setupMocks: func(queue *MockQueue, resSvc *MockReservationService, repo *MockRepository, job *domain.ReservationJob) {
+				repo.On("FailItem", mock.Anything, int64(1), "the reservation was attempted too often").Return(nil)
+				queue.On("FailJob", mock.Anything, job).Return(nil)
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queue := newFakeQueue(tt.job)
+			resSvc := new(MockReservationService)
+			repo := new(MockRepository)
+			tt.setupMocks(&queue.MockQueue, resSvc, repo, tt.job)
 
-				queue.On("DequeueReservation", mock.Anything).Return(job, nil)
+			runJobs(t, NewReservationWorker(queue, resSvc, repo, Config{}), queue)
+
+			queue.AssertExpectations(t)
+			resSvc.AssertExpectations(t)
+			repo.AssertExpectations(t)
+		})
+	}
+}
+
+// fixedBackoff always waits the same
+type fixedBackoff time.Duration
+
+func (b fixedBackoff) Delay(int) time.Duration {
+	return time.Duration(b)
+}
+
+func TestWorkerRetriesAfterBackoff(t *testing.T) {
+	tests := []struct {
+		name     string
+		attempts int
+		setup    func(*MockQueue, *MockRepository, *domain.ReservationJob)
+	}{
+		{
+			name:     "retry is delayed by the backoff",
+			attempts: 0,
+			setup: func(queue *MockQueue, repo *MockRepository, job *domain.ReservationJob) {
+				queue.On("RequeueJob", mock.Anything, job, 30*time.Second).Return(nil)
+			},
+		},
+		{
+			name:     "last attempt fails the job",
+			attempts: 4,
+			setup: func(queue *MockQueue, repo *MockRepository, job *domain.ReservationJob) {
+				repo.On("FailItem", mock.Anything, int64(1), "the reservation failed").Return(nil)
 				queue.On("FailJob", mock.Anything, job).Return(nil)
 			},
-			wantErr: false,
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			queue := new(MockQueue)
+			job := &domain.ReservationJob{
+				ID:       "test-job",
+				ItemID:   1,
+				ItemName: "Test Item",
+				Quantity: 1,
+				JobType:  domain.JobTypeReservation,
+				Status:   domain.JobStatusPending,
+				Attempts: tt.attempts,
+			}
+
+			queue := newFakeQueue(job)
 			resSvc := new(MockReservationService)
 			repo := new(MockRepository)
-			tt.setupMocks(queue, resSvc, repo)
+			resSvc.On("ReserveItem", mock.Anything, "Test Item", 1).Return("", assert.AnError)
+			tt.setup(&queue.MockQueue, repo, job)
+
+			runJobs(t, NewReservationWorker(queue, resSvc, repo, Config{MaxAttempts: 5, Backoff: fixedBackoff(30 * time.Second)}), queue)
+			queue.AssertExpectations(t)
+			repo.AssertExpectations(t)
+
+			// the failed attempt is recorded for the dead-letter queue
+			assert.Equal(t, assert.AnError.Error(), job.LastError)
+			assert.Equal(t, domain.JobErrorClassUnknown, job.ErrorClass)
+			require.Len(t, job.History, 1)
+			assert.Equal(t, tt.attempts+1, job.History[0].Attempt)
+		})
+	}
+}
 
-			worker := NewReservationWorker(queue, resSvc, repo)
-			err := worker.processNextJob(context.Background())
+func TestWorkerClassifiesErrors(t *testing.T) {
+	tests := []struct {
+		name      string
+		err       error
+		attempts  int
+		setup     func(*MockQueue, *MockRepository, *domain.ReservationJob)
+		wantClass domain.JobErrorClass
+	}{
+		{
+			name: "permanent error fails the job right away",
+			err:  domain.NewPermanentReservationError("item not found", nil),
+			setup: func(queue *MockQueue, repo *MockRepository, job *domain.ReservationJob) {
+				repo.On("FailItem", mock.Anything, int64(1), "item not found").Return(nil)
+				queue.On("FailJob", mock.Anything, job).Return(nil)
+			},
+			wantClass: domain.JobErrorClass(domain.ReservationErrorPermanent),
+		},
+		{
+			name: "transient error is retried and the item keeps its status",
+			err:  domain.NewTransientReservationError("the reservation service is unavailable", nil),
+			setup: func(queue *MockQueue, repo *MockRepository, job *domain.ReservationJob) {
+				queue.On("RequeueJob", mock.Anything, job, 30*time.Second).Return(nil)
+			},
+			wantClass: domain.JobErrorClass(domain.ReservationErrorTransient),
+		},
+		{
+			name: "rate limited error is retried after the time the service asked for",
+			err:  domain.NewRateLimitedReservationError("the reservation service is busy", 2*time.Minute, nil),
+			setup: func(queue *MockQueue, repo *MockRepository, job *domain.ReservationJob) {
+				queue.On("RequeueJob", mock.Anything, job, 2*time.Minute).Return(nil)
+			},
+			wantClass: domain.JobErrorClass(domain.ReservationErrorRateLimited),
+		},
+		{
+			name: "timeout is retried before the item fails",
+			err:  context.DeadlineExceeded,
+			setup: func(queue *MockQueue, repo *MockRepository, job *domain.ReservationJob) {
+				queue.On("RequeueJob", mock.Anything, job, 30*time.Second).Return(nil)
+			},
+			wantClass: domain.JobErrorClassTimeout,
+		},
+		{
+			name:     "timeout of the last attempt fails the item with its reason",
+			err:      domain.NewTransientReservationError("the reservation service is unreachable", context.DeadlineExceeded),
+			attempts: 2,
+			setup: func(queue *MockQueue, repo *MockRepository, job *domain.ReservationJob) {
+				repo.On("FailItem", mock.Anything, int64(1), "the reservation service is unreachable").Return(nil)
+				queue.On("FailJob", mock.Anything, job).Return(nil)
+			},
+			wantClass: domain.JobErrorClassTimeout,
+		},
+		{
+			name:     "job turned down by an open circuit is put back without an attempt",
+			err:      domain.ErrCircuitOpen,
+			attempts: 2,
+			setup: func(queue *MockQueue, repo *MockRepository, job *domain.ReservationJob) {
+				queue.On("RequeueJob", mock.Anything, job, time.Duration(0)).Return(nil)
+			},
+		},
+	}
 
-			if tt.wantErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			job := &domain.ReservationJob{
+				ID:       "test-job",
+				ItemID:   1,
+				ItemName: "Test Item",
+				Quantity: 1,
+				JobType:  domain.JobTypeReservation,
+				Status:   domain.JobStatusPending,
+				Attempts: tt.attempts,
 			}
 
+			queue := newFakeQueue(job)
+			resSvc := new(MockReservationService)
+			repo := new(MockRepository)
+			resSvc.On("ReserveItem", mock.Anything, "Test Item", 1).Return("", tt.err)
+			tt.setup(&queue.MockQueue, repo, job)
+
+			runJobs(t, NewReservationWorker(queue, resSvc, repo, Config{Backoff: fixedBackoff(30 * time.Second)}), queue)
+
+			// the mocks fail on any other call, so a retried job never touches its item
 			queue.AssertExpectations(t)
-			resSvc.AssertExpectations(t)
 			repo.AssertExpectations(t)
+			assert.Equal(t, tt.wantClass, job.ErrorClass)
+		})
+	}
+}
+
+// circuitReservationService is a reservation service behind a circuit that is open until open is closed
+type circuitReservationService struct {
+	MockReservationService
+	open chan struct{}
+}
+
+func (s *circuitReservationService) Wait(ctx context.Context) error {
+	select {
+	case <-s.open:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func TestWorkerWaitsWhileCircuitIsOpen(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobs := queue.NewMemoryQueue(config.QueueConfig{VisibilityTimeout: time.Minute})
+	require.NoError(t, jobs.EnqueueReservation(ctx, &domain.ReservationJob{
+		ID: "job-1", ItemID: 1, ItemName: "Test Item", Quantity: 1,
+		JobType: domain.JobTypeReservation, Status: domain.JobStatusPending,
+	}))
+
+	reservationSvc := &circuitReservationService{open: make(chan struct{})}
+	reservationSvc.On("ReserveItem", mock.Anything, "Test Item", 1).Return("res123", nil)
+	repo := new(MockRepository)
+	repo.On("UpdateItemReservation", mock.Anything, int64(1), "res123").Return(nil)
+
+	worker := NewReservationWorker(jobs, reservationSvc, repo, Config{})
+	worker.Start(ctx)
+	defer worker.Stop()
+
+	// the job stays queued while the circuit is open, it isn't failed
+	time.Sleep(50 * time.Millisecond)
+	depths, err := jobs.QueueDepths(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), depths[domain.JobStatusPending])
+
+	close(reservationSvc.open)
+	assert.Eventually(t, func() bool {
+		depths, err := jobs.QueueDepths(ctx)
+		return err == nil && depths[domain.JobStatusCompleted] == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+// recordingReservationService records when reservations start and end, the reservation of an item
+// in hold waits until its channel is closed
+type recordingReservationService struct {
+	MockReservationService
+	mu     sync.Mutex
+	events []string
+	hold   map[string]chan struct{}
+}
+
+func (s *recordingReservationService) ReserveItem(ctx context.Context, itemName string, quantity int) (string, error) {
+	s.record("start " + itemName)
+	if hold, ok := s.hold[itemName]; ok {
+		<-hold
+	}
+	s.record("end " + itemName)
+	return "reservation-" + itemName, nil
+}
+
+func (s *recordingReservationService) record(event string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.events = append(s.events, event)
+}
+
+func (s *recordingReservationService) recorded() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.events...)
+}
+
+func TestPoolKeepsItemOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// two jobs of item 1 with a job of item 2 between them
+	jobs := []*domain.ReservationJob{
+		{ID: "job-1", ItemID: 1, ItemName: "first", Quantity: 1, JobType: domain.JobTypeReservation},
+		{ID: "job-2", ItemID: 2, ItemName: "other", Quantity: 1, JobType: domain.JobTypeReservation},
+		{ID: "job-3", ItemID: 1, ItemName: "second", Quantity: 1, JobType: domain.JobTypeReservation},
+	}
+	queue := new(MockQueue)
+	for _, job := range jobs {
+		queue.On("DequeueReservation", mock.Anything).Return(job, nil).Once()
+	}
+	queue.On("DequeueReservation", mock.Anything).Return(nil, context.Canceled).Run(func(args mock.Arguments) {
+		<-args.Get(0).(context.Context).Done()
+	})
+	queue.On("CompleteJob", mock.Anything, mock.Anything).Return(nil)
+	repo := new(MockRepository)
+	repo.On("UpdateItemReservation", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	release := make(chan struct{})
+	resSvc := &recordingReservationService{hold: map[string]chan struct{}{"first": release}}
+
+	NewReservationWorker(queue, resSvc, repo, Config{Concurrency: 2}).Start(ctx)
+
+	// item 2 is reserved while item 1 is busy, the second job of item 1 waits for the first
+	require.Eventually(t, func() bool {
+		return len(resSvc.recorded()) == 3
+	}, time.Second, 5*time.Millisecond)
+	assert.ElementsMatch(t, []string{"start first", "start other", "end other"}, resSvc.recorded())
+
+	close(release)
+	require.Eventually(t, func() bool {
+		return len(resSvc.recorded()) == 6
+	}, time.Second, 5*time.Millisecond)
+	assert.Equal(t, []string{"end first", "start second", "end second"}, resSvc.recorded()[3:])
+}
+
+// slowReservationService takes latency for every reservation and records how many run at once
+type slowReservationService struct {
+	MockReservationService
+	latency  time.Duration
+	mu       sync.Mutex
+	inFlight int
+	peak     int
+}
+
+func (s *slowReservationService) ReserveItem(ctx context.Context, itemName string, quantity int) (string, error) {
+	s.mu.Lock()
+	s.inFlight++
+	s.peak = max(s.peak, s.inFlight)
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		s.inFlight--
+		s.mu.Unlock()
+	}()
+
+	select {
+	case <-time.After(s.latency):
+		return "reservation-" + itemName, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func TestPoolProcessesJobsConcurrently(t *testing.T) {
+	const workers = 4
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	jobs := queue.NewMemoryQueue(config.QueueConfig{VisibilityTimeout: time.Minute})
+	repo := newMemoryRepository()
+	items := make([]*domain.Item, workers)
+	for i := range items {
+		items[i] = &domain.Item{Name: fmt.Sprintf("item-%d", i), Quantity: 1, Status: domain.StatusReservationAvailable}
+	}
+	require.NoError(t, repo.CreateItems(ctx, items))
+	for _, item := range items {
+		require.NoError(t, jobs.EnqueueReservation(ctx, &domain.ReservationJob{
+			ItemID: item.ID, ItemName: item.Name, Quantity: 1, JobType: domain.JobTypeReservation,
+		}))
+	}
+
+	resSvc := &slowReservationService{latency: 200 * time.Millisecond}
+	started := time.Now()
+	worker := NewReservationWorker(jobs, resSvc, repo, Config{Concurrency: workers})
+	worker.Start(ctx)
+	defer worker.Stop()
+
+	// one job at a time would take the latency of every job
+	require.Eventually(t, func() bool {
+		depths, err := jobs.QueueDepths(ctx)
+		return err == nil && depths[domain.JobStatusCompleted] == workers
+	}, time.Second, 5*time.Millisecond)
+	assert.Less(t, time.Since(started), 2*resSvc.latency)
+	assert.Equal(t, workers, resSvc.peak)
+	for _, item := range items {
+		stored, err := repo.GetItem(ctx, item.ID)
+		require.NoError(t, err)
+		assert.Equal(t, domain.StatusReservationReserved, stored.Status)
+	}
+}
+
+func TestStopDrainsJobsInProgress(t *testing.T) {
+	tests := []struct {
+		name       string
+		latency    time.Duration
+		wantStatus domain.JobStatus
+	}{
+		{name: "job done within the drain timeout completes", latency: 50 * time.Millisecond, wantStatus: domain.JobStatusCompleted},
+		{name: "job outlasting the drain timeout is put back", latency: time.Hour, wantStatus: domain.JobStatusPending},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			jobs := queue.NewMemoryQueue(config.QueueConfig{VisibilityTimeout: time.Minute})
+			repo := newMemoryRepository()
+			item := &domain.Item{Name: "laptop", Quantity: 1, Status: domain.StatusReservationAvailable}
+			require.NoError(t, repo.CreateItem(ctx, item))
+			job := &domain.ReservationJob{ID: "job-1", ItemID: item.ID, ItemName: item.Name, Quantity: 1, JobType: domain.JobTypeReservation}
+			require.NoError(t, jobs.EnqueueReservation(ctx, job))
+
+			resSvc := &slowReservationService{latency: tt.latency}
+			worker := NewReservationWorker(jobs, resSvc, repo, Config{Concurrency: 2, DrainTimeout: 500 * time.Millisecond})
+			worker.Start(ctx)
+			require.Eventually(t, func() bool {
+				depths, err := jobs.QueueDepths(ctx)
+				return err == nil && depths[domain.JobStatusProcessing] == 1
+			}, time.Second, 5*time.Millisecond)
+
+			// Stop returns once the job is done or put back, and no job is taken meanwhile
+			worker.Stop()
+			require.NoError(t, jobs.EnqueueReservation(ctx, &domain.ReservationJob{ID: "job-2", ItemID: 99, JobType: domain.JobTypeReservation}))
+			time.Sleep(20 * time.Millisecond)
+
+			stored, err := jobs.GetJob(ctx, "job-1")
+			require.NoError(t, err)
+			assert.Equal(t, tt.wantStatus, stored.Status)
+			assert.Equal(t, 0, stored.Attempts, "a job cut off by the drain didn't fail")
+			next, err := jobs.GetJob(ctx, "job-2")
+			require.NoError(t, err)
+			assert.Equal(t, domain.JobStatusPending, next.Status)
+		})
+	}
+}
+
+// batchingReservationService records the size of every batch, it can't reserve unicorns
+type batchingReservationService struct {
+	MockReservationService
+	mu      sync.Mutex
+	batches []int
+}
+
+func (s *batchingReservationService) ReserveItems(ctx context.Context, requests []domain.ReservationRequest) ([]domain.ReservationResult, error) {
+	s.mu.Lock()
+	s.batches = append(s.batches, len(requests))
+	s.mu.Unlock()
+
+	results := make([]domain.ReservationResult, len(requests))
+	for i, request := range requests {
+		if request.ItemName == "unicorn" {
+			results[i].Err = domain.NewPermanentReservationError("item not found", nil)
+			continue
+		}
+		results[i].ReservationID = "RSV-" + request.ItemName
+	}
+	return results, nil
+}
+
+func (s *batchingReservationService) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]int(nil), s.batches...)
+}
+
+func TestPoolBatchesReservations(t *testing.T) {
+	tests := []struct {
+		name        string
+		itemNames   []string
+		batchWait   time.Duration
+		wantBatches []int
+	}{
+		{name: "full batch is sent right away", itemNames: []string{"laptop", "unicorn", "phone"}, batchWait: time.Minute, wantBatches: []int{3}},
+		{name: "batch is sent once its wait passed", itemNames: []string{"tablet"}, batchWait: 50 * time.Millisecond, wantBatches: []int{1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			repo := newMemoryRepository()
+			jobs := queue.NewMemoryQueue(config.QueueConfig{VisibilityTimeout: time.Minute})
+			for _, name := range tt.itemNames {
+				item := &domain.Item{Name: name, Quantity: 1, Status: domain.StatusReservationAvailable}
+				require.NoError(t, repo.CreateItem(ctx, item))
+				require.NoError(t, jobs.EnqueueReservation(ctx, &domain.ReservationJob{
+					ID: fmt.Sprintf("job-%d", item.ID), ItemID: item.ID, ItemName: name, Quantity: 1,
+					JobType: domain.JobTypeReservation, Status: domain.JobStatusPending,
+				}))
+			}
+
+			reservationSvc := &batchingReservationService{}
+			worker := NewReservationWorker(jobs, reservationSvc, repo, Config{Concurrency: 3, BatchSize: 3, BatchWait: tt.batchWait})
+			worker.Start(ctx)
+			defer worker.Stop()
+
+			assert.Eventually(t, func() bool {
+				for id := range tt.itemNames {
+					item, err := repo.GetItem(ctx, int64(id+1))
+					if err != nil || !item.IsFinal() {
+						return false
+					}
+				}
+				return true
+			}, 2*time.Second, 10*time.Millisecond)
+			assert.Equal(t, tt.wantBatches, reservationSvc.sizes())
+
+			// every job got the result of its own item
+			for id, name := range tt.itemNames {
+				item, err := repo.GetItem(ctx, int64(id+1))
+				require.NoError(t, err)
+				if name == "unicorn" {
+					assert.Equal(t, domain.StatusReservationFailed, item.Status)
+					require.NotNil(t, item.FailureReason)
+					assert.Equal(t, "item not found", *item.FailureReason)
+					continue
+				}
+				assert.Equal(t, domain.StatusReservationReserved, item.Status)
+				assert.Equal(t, "RSV-"+name, *item.ReservationID)
+			}
 		})
 	}
 }
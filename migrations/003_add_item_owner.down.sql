@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_items_owner_created_at;
+ALTER TABLE items DROP COLUMN IF EXISTS owner_id;
@@ -0,0 +1,5 @@
+-- existing items were created anonymously, new items must always name their owner
+ALTER TABLE items ADD COLUMN owner_id TEXT NOT NULL DEFAULT 'anonymous';
+ALTER TABLE items ALTER COLUMN owner_id DROP DEFAULT;
+
+CREATE INDEX idx_items_owner_created_at ON items(owner_id, created_at DESC);
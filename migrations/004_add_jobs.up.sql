@@ -0,0 +1,28 @@
+CREATE TYPE job_status AS ENUM (
+    'PENDING',
+    'DELAYED',
+    'PROCESSING',
+    'COMPLETED',
+    'FAILED'
+);
+
+-- the jobs of the postgres queue, payload is the serialized job and the columns are what workers
+-- and operators select jobs by
+CREATE TABLE jobs (
+    id TEXT PRIMARY KEY,
+    -- seq orders jobs that became due at the same time
+    seq BIGSERIAL,
+    item_id INTEGER NOT NULL,
+    job_type TEXT NOT NULL,
+    status job_status NOT NULL DEFAULT 'PENDING',
+    payload JSONB NOT NULL,
+    scheduled_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    lease_expires_at TIMESTAMPTZ,
+    finished_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_jobs_due ON jobs(scheduled_at, seq) WHERE status IN ('PENDING', 'DELAYED');
+CREATE INDEX idx_jobs_lease_expires_at ON jobs(lease_expires_at) WHERE status = 'PROCESSING';
+CREATE INDEX idx_jobs_status_finished_at ON jobs(status, finished_at);
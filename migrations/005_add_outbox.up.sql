@@ -0,0 +1,11 @@
+-- jobs written in the transaction of their items, the relay publishes them to the queue and marks them sent
+CREATE TABLE outbox (
+    id BIGSERIAL PRIMARY KEY,
+    job_id TEXT NOT NULL,
+    payload JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    sent_at TIMESTAMPTZ
+);
+
+CREATE INDEX idx_outbox_unsent ON outbox(id) WHERE sent_at IS NULL;
+CREATE INDEX idx_outbox_sent_at ON outbox(sent_at) WHERE sent_at IS NOT NULL;
@@ -0,0 +1,2 @@
+-- dequeues look up the unfinished jobs of an item, its jobs run one at a time
+CREATE INDEX idx_jobs_item_unfinished ON jobs(item_id, seq) WHERE status IN ('PENDING', 'DELAYED', 'PROCESSING');
@@ -0,0 +1,2 @@
+-- why the reservation of a failed item failed, shown to its owner
+ALTER TABLE items ADD COLUMN failure_reason TEXT;
@@ -0,0 +1,7 @@
+// Package migrations embeds the SQL migrations, so the binary can migrate the database on its own
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS